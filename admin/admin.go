@@ -0,0 +1,406 @@
+// Package admin is a gRPC service for operators to inspect and repair the
+// controller's state while it runs. It is written by hand and carries its
+// messages as JSON, so it needs no generated code.
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/faults"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+//go:generate counterfeiter -o adminfakes/fake_backend.go . Backend
+
+// Backend is what the admin commands work on. *controller.Controller
+// implements it directly, and Client over the admin service.
+type Backend interface {
+	Volumes(ctx context.Context) ([]*controller.LocalVolume, error)
+	Volume(ctx context.Context, volId string) (*controller.LocalVolume, error)
+	Snapshots(ctx context.Context) ([]*controller.LocalSnapshot, error)
+	GC(ctx context.Context, dryRun bool) ([]string, error)
+	ExportState(ctx context.Context) (*controller.State, error)
+	// ImportState replaces all the recorded volumes and snapshots with state.
+	ImportState(ctx context.Context, state *controller.State) error
+	Usage(ctx context.Context, refresh bool) ([]controller.LocalVolumeUsage, error)
+	ExportVolume(ctx context.Context, volId string, w io.Writer) error
+	ExportSnapshot(ctx context.Context, snapId string, w io.Writer) error
+	ImportVolume(ctx context.Context, name, pool string, r io.Reader) (*controller.LocalVolume, error)
+	Backups(ctx context.Context) ([]*controller.Backup, error)
+	UploadBackups(ctx context.Context) error
+}
+
+// FaultInjector is implemented by the backends of servers that run with
+// fault injection enabled. The admin client implements it whether or not
+// the server does.
+type FaultInjector interface {
+	Faults(ctx context.Context) (*faults.Config, error)
+	SetFaults(ctx context.Context, config *faults.Config) error
+}
+
+const ServiceName = "localcontrollerplugin.admin.v1.Admin"
+
+type VolumesRequest struct{}
+
+type VolumesResponse struct {
+	Volumes []*controller.LocalVolume `json:"volumes"`
+}
+
+type VolumeRequest struct {
+	VolumeId string `json:"volume_id"`
+}
+
+type VolumeResponse struct {
+	Volume *controller.LocalVolume `json:"volume"`
+}
+
+type SnapshotsRequest struct{}
+
+type SnapshotsResponse struct {
+	Snapshots []*controller.LocalSnapshot `json:"snapshots"`
+}
+
+type GCRequest struct {
+	DryRun bool `json:"dry_run"`
+}
+
+type GCResponse struct {
+	Paths []string `json:"paths"`
+}
+
+type ExportStateRequest struct{}
+
+type ExportStateResponse struct {
+	State *controller.State `json:"state"`
+}
+
+// ImportStateRequest replaces all the volume and snapshot state the server
+// records with State: anything not in it is forgotten, though its directory
+// is left for gc.
+type ImportStateRequest struct {
+	State *controller.State `json:"state"`
+}
+
+type ImportStateResponse struct{}
+
+type UsageRequest struct {
+	// Refresh measures the volumes again instead of returning the cached usage.
+	Refresh bool `json:"refresh"`
+}
+
+type UsageResponse struct {
+	Usage []controller.LocalVolumeUsage `json:"usage"`
+}
+
+// ArchiveChunkSize bounds the archive data carried by each message of the
+// streaming export and import methods.
+const ArchiveChunkSize = 1 << 20
+
+type ExportVolumeRequest struct {
+	VolumeId string `json:"volume_id"`
+}
+
+type ExportSnapshotRequest struct {
+	SnapshotId string `json:"snapshot_id"`
+}
+
+// ArchiveChunk is each message streamed back by the export methods.
+type ArchiveChunk struct {
+	Data []byte `json:"data"`
+}
+
+// ImportVolumeRequest is each message streamed to ImportVolume. Name and
+// Pool are only read from the first.
+type ImportVolumeRequest struct {
+	Name string `json:"name,omitempty"`
+	Pool string `json:"pool,omitempty"`
+	Data []byte `json:"data,omitempty"`
+}
+
+type ImportVolumeResponse struct {
+	Volume *controller.LocalVolume `json:"volume"`
+}
+
+type BackupsRequest struct{}
+
+type BackupsResponse struct {
+	Backups []*controller.Backup `json:"backups"`
+}
+
+type UploadBackupsRequest struct{}
+
+type UploadBackupsResponse struct{}
+
+type FaultsRequest struct{}
+
+type FaultsResponse struct {
+	Config *faults.Config `json:"config"`
+}
+
+type SetFaultsRequest struct {
+	Config *faults.Config `json:"config"`
+}
+
+type SetFaultsResponse struct{}
+
+// codec marshals the admin messages as JSON. It is not registered, so that
+// it does not replace the "json" codec of other services in the process:
+// the server forces it with ServerCodec and the client with every call.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (codec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (codec) Name() string                               { return "localcontrollerplugin-admin" }
+
+// ServerCodec is the option a server needs to serve the admin service; it
+// then serves nothing else.
+func ServerCodec() grpc.ServerOption {
+	return grpc.ForceServerCodec(codec{})
+}
+
+// CheckAddress refuses addresses the admin service must not be reached on.
+// It carries the whole state with neither authentication nor encryption, so
+// it is only served on a unix socket, unix:///path, which the server makes
+// accessible to its own user only, or on a loopback host:port.
+func CheckAddress(address string) error {
+	if strings.HasPrefix(address, "unix://") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("admin address %q is neither a unix socket nor a loopback address", address)
+	}
+	return nil
+}
+
+// RegisterAdminServer serves backend as the admin service on s.
+func RegisterAdminServer(s *grpc.Server, backend Backend) {
+	s.RegisterService(&serviceDesc, backend)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Backend)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Volumes", func() interface{} { return &VolumesRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				volumes, err := b.Volumes(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return &VolumesResponse{Volumes: volumes}, nil
+			}),
+		unaryMethod("Volume", func() interface{} { return &VolumeRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				volume, err := b.Volume(ctx, req.(*VolumeRequest).VolumeId)
+				if err != nil {
+					return nil, err
+				}
+				return &VolumeResponse{Volume: volume}, nil
+			}),
+		unaryMethod("Snapshots", func() interface{} { return &SnapshotsRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				snapshots, err := b.Snapshots(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return &SnapshotsResponse{Snapshots: snapshots}, nil
+			}),
+		unaryMethod("GC", func() interface{} { return &GCRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				paths, err := b.GC(ctx, req.(*GCRequest).DryRun)
+				if err != nil {
+					return nil, err
+				}
+				return &GCResponse{Paths: paths}, nil
+			}),
+		unaryMethod("ExportState", func() interface{} { return &ExportStateRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				state, err := b.ExportState(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return &ExportStateResponse{State: state}, nil
+			}),
+		unaryMethod("ImportState", func() interface{} { return &ImportStateRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				if err := b.ImportState(ctx, req.(*ImportStateRequest).State); err != nil {
+					return nil, err
+				}
+				return &ImportStateResponse{}, nil
+			}),
+		unaryMethod("Usage", func() interface{} { return &UsageRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				usage, err := b.Usage(ctx, req.(*UsageRequest).Refresh)
+				if err != nil {
+					return nil, err
+				}
+				return &UsageResponse{Usage: usage}, nil
+			}),
+		unaryMethod("Backups", func() interface{} { return &BackupsRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				backups, err := b.Backups(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return &BackupsResponse{Backups: backups}, nil
+			}),
+		unaryMethod("UploadBackups", func() interface{} { return &UploadBackupsRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				if err := b.UploadBackups(ctx); err != nil {
+					return nil, err
+				}
+				return &UploadBackupsResponse{}, nil
+			}),
+		unaryMethod("Faults", func() interface{} { return &FaultsRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				injector, ok := b.(FaultInjector)
+				if !ok {
+					return nil, errFaultInjectionDisabled
+				}
+				config, err := injector.Faults(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return &FaultsResponse{Config: config}, nil
+			}),
+		unaryMethod("SetFaults", func() interface{} { return &SetFaultsRequest{} },
+			func(ctx context.Context, b Backend, req interface{}) (interface{}, error) {
+				injector, ok := b.(FaultInjector)
+				if !ok {
+					return nil, errFaultInjectionDisabled
+				}
+				config := req.(*SetFaultsRequest).Config
+				if config == nil {
+					config = &faults.Config{}
+				}
+				if err := injector.SetFaults(ctx, config); err != nil {
+					return nil, err
+				}
+				return &SetFaultsResponse{}, nil
+			}),
+	},
+	Streams: []grpc.StreamDesc{
+		exportMethod("ExportVolume", func() interface{} { return &ExportVolumeRequest{} },
+			func(ctx context.Context, b Backend, req interface{}, w io.Writer) error {
+				return b.ExportVolume(ctx, req.(*ExportVolumeRequest).VolumeId, w)
+			}),
+		exportMethod("ExportSnapshot", func() interface{} { return &ExportSnapshotRequest{} },
+			func(ctx context.Context, b Backend, req interface{}, w io.Writer) error {
+				return b.ExportSnapshot(ctx, req.(*ExportSnapshotRequest).SnapshotId, w)
+			}),
+		{
+			StreamName:    "ImportVolume",
+			ClientStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				first := &ImportVolumeRequest{}
+				if err := stream.RecvMsg(first); err != nil {
+					return err
+				}
+				r := &chunkReader{stream: stream, pending: first.Data}
+				volume, err := srv.(Backend).ImportVolume(stream.Context(), first.Name, first.Pool, r)
+				if err != nil {
+					return err
+				}
+				return stream.SendMsg(&ImportVolumeResponse{Volume: volume})
+			},
+		},
+	},
+	Metadata: "admin.go",
+}
+
+// exportMethod builds the descriptor of a method that streams an archive
+// back in chunks of up to ArchiveChunkSize.
+func exportMethod(name string, newRequest func() interface{}, call func(context.Context, Backend, interface{}, io.Writer) error) grpc.StreamDesc {
+	return grpc.StreamDesc{
+		StreamName:    name,
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			req := newRequest()
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			w := bufio.NewWriterSize(&chunkWriter{stream: stream}, ArchiveChunkSize)
+			if err := call(stream.Context(), srv.(Backend), req, w); err != nil {
+				return err
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// chunkWriter sends what is written to it as ArchiveChunks.
+type chunkWriter struct {
+	stream grpc.Stream
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > ArchiveChunkSize {
+			n = ArchiveChunkSize
+		}
+		if err := w.stream.SendMsg(&ArchiveChunk{Data: p[:n]}); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// chunkReader reads the data of the ImportVolumeRequests received on stream,
+// ending with io.EOF when the client closes it.
+type chunkReader struct {
+	stream  grpc.Stream
+	pending []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		req := &ImportVolumeRequest{}
+		if err := r.stream.RecvMsg(req); err != nil {
+			return 0, err
+		}
+		r.pending = req.Data
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+var errFaultInjectionDisabled = grpc.Errorf(codes.FailedPrecondition, "Fault injection is not enabled on this server")
+
+// unaryMethod builds the descriptor of a method, running the server's
+// interceptors around call as generated code would.
+func unaryMethod(name string, newRequest func() interface{}, call func(context.Context, Backend, interface{}) (interface{}, error)) grpc.MethodDesc {
+	fullMethod := "/" + ServiceName + "/" + name
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(ctx, srv.(Backend), req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}, handler)
+		},
+	}
+}
@@ -0,0 +1,207 @@
+package admin_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/admin"
+	"code.cloudfoundry.org/local-controller-plugin/admin/adminfakes"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/faults"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Admin service", func() {
+	var (
+		backend *adminfakes.FakeBackend
+		served  admin.Backend
+		server  *grpc.Server
+		conn    *grpc.ClientConn
+		client  admin.Backend
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		backend = &adminfakes.FakeBackend{}
+		served = backend
+		ctx = context.Background()
+	})
+
+	JustBeforeEach(func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		server = grpc.NewServer(admin.ServerCodec())
+		admin.RegisterAdminServer(server, served)
+		go server.Serve(lis)
+
+		conn, err = admin.Dial(lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		client = admin.NewClient(conn)
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		server.Stop()
+	})
+
+	It("lists volumes", func() {
+		backend.VolumesReturns([]*controller.LocalVolume{{VolumeId: "default:vol", PublishedNodes: map[string]bool{"node": true}}}, nil)
+
+		volumes, err := client.Volumes(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumes).To(Equal([]*controller.LocalVolume{{VolumeId: "default:vol", PublishedNodes: map[string]bool{"node": true}}}))
+	})
+
+	It("passes the volume id and keeps the error's code", func() {
+		backend.VolumeReturns(nil, status.Errorf(codes.NotFound, "Volume %q does not exist", "default:vol"))
+
+		_, err := client.Volume(ctx, "default:vol")
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		Expect(status.Convert(err).Message()).To(ContainSubstring("default:vol"))
+
+		_, volId := backend.VolumeArgsForCall(0)
+		Expect(volId).To(Equal("default:vol"))
+	})
+
+	It("lists snapshots", func() {
+		backend.SnapshotsReturns([]*controller.LocalSnapshot{{SnapshotId: "default:snap", ReadyToUse: true}}, nil)
+
+		Expect(client.Snapshots(ctx)).To(Equal([]*controller.LocalSnapshot{{SnapshotId: "default:snap", ReadyToUse: true}}))
+	})
+
+	It("passes dry run to gc", func() {
+		backend.GCReturns([]string{"/root/_volumes/lost"}, nil)
+
+		Expect(client.GC(ctx, true)).To(Equal([]string{"/root/_volumes/lost"}))
+		_, dryRun := backend.GCArgsForCall(0)
+		Expect(dryRun).To(BeTrue())
+	})
+
+	It("exports and imports the state", func() {
+		state := controller.NewState()
+		state.Volumes["default:vol"] = &controller.LocalVolume{VolumeId: "default:vol", Pool: "default", Name: "vol"}
+		backend.ExportStateReturns(state, nil)
+
+		exported, err := client.ExportState(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exported).To(Equal(state))
+
+		Expect(client.ImportState(ctx, exported)).To(Succeed())
+		_, imported := backend.ImportStateArgsForCall(0)
+		Expect(imported).To(Equal(state))
+	})
+
+	It("passes refresh to usage", func() {
+		backend.UsageReturns([]controller.LocalVolumeUsage{{VolumeId: "default:vol", UsedBytes: 4096, UsedInodes: 2}}, nil)
+
+		Expect(client.Usage(ctx, true)).To(Equal([]controller.LocalVolumeUsage{{VolumeId: "default:vol", UsedBytes: 4096, UsedInodes: 2}}))
+		_, refresh := backend.UsageArgsForCall(0)
+		Expect(refresh).To(BeTrue())
+	})
+
+	It("lists and uploads backups", func() {
+		backend.BackupsReturns([]*controller.Backup{{BackupId: "backup/default:snap", SourceVolumeId: "default:vol"}}, nil)
+		Expect(client.Backups(ctx)).To(Equal([]*controller.Backup{{BackupId: "backup/default:snap", SourceVolumeId: "default:vol"}}))
+
+		backend.UploadBackupsReturns(status.Errorf(codes.Unavailable, "Backups cannot be uploaded"))
+		err := client.UploadBackups(ctx)
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+		Expect(backend.UploadBackupsCallCount()).To(Equal(1))
+	})
+
+	It("streams exported archives in chunks", func() {
+		archive := bytes.Repeat([]byte("0123456789abcdef"), 3*admin.ArchiveChunkSize/16+7)
+		backend.ExportVolumeStub = func(ctx context.Context, volId string, w io.Writer) error {
+			_, err := w.Write(archive)
+			return err
+		}
+		backend.ExportSnapshotReturns(status.Errorf(codes.FailedPrecondition, "Snapshot %q is not ready", "default:snap"))
+
+		exported := &bytes.Buffer{}
+		Expect(client.ExportVolume(ctx, "default:vol", exported)).To(Succeed())
+		Expect(exported.Bytes()).To(Equal(archive))
+		_, volId, _ := backend.ExportVolumeArgsForCall(0)
+		Expect(volId).To(Equal("default:vol"))
+
+		err := client.ExportSnapshot(ctx, "default:snap", &bytes.Buffer{})
+		Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+	})
+
+	It("streams imported archives in chunks", func() {
+		archive := bytes.Repeat([]byte("0123456789abcdef"), 3*admin.ArchiveChunkSize/16+7)
+		var imported []byte
+		backend.ImportVolumeStub = func(ctx context.Context, name, pool string, r io.Reader) (*controller.LocalVolume, error) {
+			var err error
+			imported, err = ioutil.ReadAll(r)
+			return &controller.LocalVolume{VolumeId: pool + ":" + name, CapacityBytes: 4096}, err
+		}
+
+		v, err := client.ImportVolume(ctx, "copy", "default", bytes.NewReader(archive))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v).To(Equal(&controller.LocalVolume{VolumeId: "default:copy", CapacityBytes: 4096}))
+		Expect(imported).To(Equal(archive))
+
+		backend.ImportVolumeStub = nil
+		backend.ImportVolumeReturns(nil, status.Errorf(codes.AlreadyExists, "Volume %q already exists", "default:copy"))
+		_, err = client.ImportVolume(ctx, "copy", "default", bytes.NewReader(archive))
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+	})
+
+	It("reports fault injection as disabled when the backend has none", func() {
+		_, err := client.(admin.FaultInjector).Faults(ctx)
+		Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+	})
+
+	Context("with fault injection", func() {
+		var injector *faults.Injector
+
+		BeforeEach(func() {
+			var err error
+			injector, err = faults.NewInjector(lagertest.NewTestLogger("admin"), &os_fake.FakeOs{}, faults.Config{})
+			Expect(err).NotTo(HaveOccurred())
+			served = struct {
+				admin.Backend
+				*faults.Injector
+			}{backend, injector}
+		})
+
+		It("sets and returns the faults", func() {
+			config := &faults.Config{Rules: []faults.Rule{{Method: "CreateVolume", Code: "UNAVAILABLE"}}}
+			Expect(client.(admin.FaultInjector).SetFaults(ctx, config)).To(Succeed())
+			Expect(injector.Config()).To(Equal(*config))
+			Expect(client.(admin.FaultInjector).Faults(ctx)).To(Equal(config))
+		})
+
+		It("refuses invalid faults", func() {
+			err := client.(admin.FaultInjector).SetFaults(ctx, &faults.Config{Rules: []faults.Rule{{Method: "CreateVolume", Code: "NOPE"}}})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+	})
+
+	It("leaves the json codec of other services alone", func() {
+		Expect(encoding.GetCodec("json")).To(BeNil())
+	})
+
+	It("is only reached on a unix socket or a loopback address", func() {
+		Expect(admin.CheckAddress("unix:///var/run/admin.sock")).To(Succeed())
+		Expect(admin.CheckAddress("127.0.0.1:9861")).To(Succeed())
+		Expect(admin.CheckAddress("[::1]:9861")).To(Succeed())
+		Expect(admin.CheckAddress("localhost:9861")).To(Succeed())
+		Expect(admin.CheckAddress("0.0.0.0:9861")).To(MatchError(ContainSubstring("neither a unix socket nor a loopback address")))
+		Expect(admin.CheckAddress("10.0.0.1:9861")).To(HaveOccurred())
+		Expect(admin.CheckAddress(":9861")).To(HaveOccurred())
+
+		_, err := admin.Dial("10.0.0.1:9861")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,990 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package adminfakes
+
+import (
+	"io"
+	"sync"
+
+	"code.cloudfoundry.org/local-controller-plugin/admin"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"golang.org/x/net/context"
+)
+
+type FakeBackend struct {
+	BackupsStub        func(context.Context) ([]*controller.Backup, error)
+	backupsMutex       sync.RWMutex
+	backupsArgsForCall []struct {
+		arg1 context.Context
+	}
+	backupsReturns struct {
+		result1 []*controller.Backup
+		result2 error
+	}
+	backupsReturnsOnCall map[int]struct {
+		result1 []*controller.Backup
+		result2 error
+	}
+	ExportSnapshotStub        func(context.Context, string, io.Writer) error
+	exportSnapshotMutex       sync.RWMutex
+	exportSnapshotArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 io.Writer
+	}
+	exportSnapshotReturns struct {
+		result1 error
+	}
+	exportSnapshotReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ExportStateStub        func(context.Context) (*controller.State, error)
+	exportStateMutex       sync.RWMutex
+	exportStateArgsForCall []struct {
+		arg1 context.Context
+	}
+	exportStateReturns struct {
+		result1 *controller.State
+		result2 error
+	}
+	exportStateReturnsOnCall map[int]struct {
+		result1 *controller.State
+		result2 error
+	}
+	ExportVolumeStub        func(context.Context, string, io.Writer) error
+	exportVolumeMutex       sync.RWMutex
+	exportVolumeArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 io.Writer
+	}
+	exportVolumeReturns struct {
+		result1 error
+	}
+	exportVolumeReturnsOnCall map[int]struct {
+		result1 error
+	}
+	GCStub        func(context.Context, bool) ([]string, error)
+	gCMutex       sync.RWMutex
+	gCArgsForCall []struct {
+		arg1 context.Context
+		arg2 bool
+	}
+	gCReturns struct {
+		result1 []string
+		result2 error
+	}
+	gCReturnsOnCall map[int]struct {
+		result1 []string
+		result2 error
+	}
+	ImportStateStub        func(context.Context, *controller.State) error
+	importStateMutex       sync.RWMutex
+	importStateArgsForCall []struct {
+		arg1 context.Context
+		arg2 *controller.State
+	}
+	importStateReturns struct {
+		result1 error
+	}
+	importStateReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ImportVolumeStub        func(context.Context, string, string, io.Reader) (*controller.LocalVolume, error)
+	importVolumeMutex       sync.RWMutex
+	importVolumeArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 io.Reader
+	}
+	importVolumeReturns struct {
+		result1 *controller.LocalVolume
+		result2 error
+	}
+	importVolumeReturnsOnCall map[int]struct {
+		result1 *controller.LocalVolume
+		result2 error
+	}
+	SnapshotsStub        func(context.Context) ([]*controller.LocalSnapshot, error)
+	snapshotsMutex       sync.RWMutex
+	snapshotsArgsForCall []struct {
+		arg1 context.Context
+	}
+	snapshotsReturns struct {
+		result1 []*controller.LocalSnapshot
+		result2 error
+	}
+	snapshotsReturnsOnCall map[int]struct {
+		result1 []*controller.LocalSnapshot
+		result2 error
+	}
+	UploadBackupsStub        func(context.Context) error
+	uploadBackupsMutex       sync.RWMutex
+	uploadBackupsArgsForCall []struct {
+		arg1 context.Context
+	}
+	uploadBackupsReturns struct {
+		result1 error
+	}
+	uploadBackupsReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UsageStub        func(context.Context, bool) ([]controller.LocalVolumeUsage, error)
+	usageMutex       sync.RWMutex
+	usageArgsForCall []struct {
+		arg1 context.Context
+		arg2 bool
+	}
+	usageReturns struct {
+		result1 []controller.LocalVolumeUsage
+		result2 error
+	}
+	usageReturnsOnCall map[int]struct {
+		result1 []controller.LocalVolumeUsage
+		result2 error
+	}
+	VolumeStub        func(context.Context, string) (*controller.LocalVolume, error)
+	volumeMutex       sync.RWMutex
+	volumeArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	volumeReturns struct {
+		result1 *controller.LocalVolume
+		result2 error
+	}
+	volumeReturnsOnCall map[int]struct {
+		result1 *controller.LocalVolume
+		result2 error
+	}
+	VolumesStub        func(context.Context) ([]*controller.LocalVolume, error)
+	volumesMutex       sync.RWMutex
+	volumesArgsForCall []struct {
+		arg1 context.Context
+	}
+	volumesReturns struct {
+		result1 []*controller.LocalVolume
+		result2 error
+	}
+	volumesReturnsOnCall map[int]struct {
+		result1 []*controller.LocalVolume
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeBackend) Backups(arg1 context.Context) ([]*controller.Backup, error) {
+	fake.backupsMutex.Lock()
+	ret, specificReturn := fake.backupsReturnsOnCall[len(fake.backupsArgsForCall)]
+	fake.backupsArgsForCall = append(fake.backupsArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.BackupsStub
+	fakeReturns := fake.backupsReturns
+	fake.recordInvocation("Backups", []interface{}{arg1})
+	fake.backupsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBackend) BackupsCallCount() int {
+	fake.backupsMutex.RLock()
+	defer fake.backupsMutex.RUnlock()
+	return len(fake.backupsArgsForCall)
+}
+
+func (fake *FakeBackend) BackupsCalls(stub func(context.Context) ([]*controller.Backup, error)) {
+	fake.backupsMutex.Lock()
+	defer fake.backupsMutex.Unlock()
+	fake.BackupsStub = stub
+}
+
+func (fake *FakeBackend) BackupsArgsForCall(i int) context.Context {
+	fake.backupsMutex.RLock()
+	defer fake.backupsMutex.RUnlock()
+	argsForCall := fake.backupsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeBackend) BackupsReturns(result1 []*controller.Backup, result2 error) {
+	fake.backupsMutex.Lock()
+	defer fake.backupsMutex.Unlock()
+	fake.BackupsStub = nil
+	fake.backupsReturns = struct {
+		result1 []*controller.Backup
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) BackupsReturnsOnCall(i int, result1 []*controller.Backup, result2 error) {
+	fake.backupsMutex.Lock()
+	defer fake.backupsMutex.Unlock()
+	fake.BackupsStub = nil
+	if fake.backupsReturnsOnCall == nil {
+		fake.backupsReturnsOnCall = make(map[int]struct {
+			result1 []*controller.Backup
+			result2 error
+		})
+	}
+	fake.backupsReturnsOnCall[i] = struct {
+		result1 []*controller.Backup
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) ExportSnapshot(arg1 context.Context, arg2 string, arg3 io.Writer) error {
+	fake.exportSnapshotMutex.Lock()
+	ret, specificReturn := fake.exportSnapshotReturnsOnCall[len(fake.exportSnapshotArgsForCall)]
+	fake.exportSnapshotArgsForCall = append(fake.exportSnapshotArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 io.Writer
+	}{arg1, arg2, arg3})
+	stub := fake.ExportSnapshotStub
+	fakeReturns := fake.exportSnapshotReturns
+	fake.recordInvocation("ExportSnapshot", []interface{}{arg1, arg2, arg3})
+	fake.exportSnapshotMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBackend) ExportSnapshotCallCount() int {
+	fake.exportSnapshotMutex.RLock()
+	defer fake.exportSnapshotMutex.RUnlock()
+	return len(fake.exportSnapshotArgsForCall)
+}
+
+func (fake *FakeBackend) ExportSnapshotCalls(stub func(context.Context, string, io.Writer) error) {
+	fake.exportSnapshotMutex.Lock()
+	defer fake.exportSnapshotMutex.Unlock()
+	fake.ExportSnapshotStub = stub
+}
+
+func (fake *FakeBackend) ExportSnapshotArgsForCall(i int) (context.Context, string, io.Writer) {
+	fake.exportSnapshotMutex.RLock()
+	defer fake.exportSnapshotMutex.RUnlock()
+	argsForCall := fake.exportSnapshotArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeBackend) ExportSnapshotReturns(result1 error) {
+	fake.exportSnapshotMutex.Lock()
+	defer fake.exportSnapshotMutex.Unlock()
+	fake.ExportSnapshotStub = nil
+	fake.exportSnapshotReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBackend) ExportSnapshotReturnsOnCall(i int, result1 error) {
+	fake.exportSnapshotMutex.Lock()
+	defer fake.exportSnapshotMutex.Unlock()
+	fake.ExportSnapshotStub = nil
+	if fake.exportSnapshotReturnsOnCall == nil {
+		fake.exportSnapshotReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.exportSnapshotReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBackend) ExportState(arg1 context.Context) (*controller.State, error) {
+	fake.exportStateMutex.Lock()
+	ret, specificReturn := fake.exportStateReturnsOnCall[len(fake.exportStateArgsForCall)]
+	fake.exportStateArgsForCall = append(fake.exportStateArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.ExportStateStub
+	fakeReturns := fake.exportStateReturns
+	fake.recordInvocation("ExportState", []interface{}{arg1})
+	fake.exportStateMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBackend) ExportStateCallCount() int {
+	fake.exportStateMutex.RLock()
+	defer fake.exportStateMutex.RUnlock()
+	return len(fake.exportStateArgsForCall)
+}
+
+func (fake *FakeBackend) ExportStateCalls(stub func(context.Context) (*controller.State, error)) {
+	fake.exportStateMutex.Lock()
+	defer fake.exportStateMutex.Unlock()
+	fake.ExportStateStub = stub
+}
+
+func (fake *FakeBackend) ExportStateArgsForCall(i int) context.Context {
+	fake.exportStateMutex.RLock()
+	defer fake.exportStateMutex.RUnlock()
+	argsForCall := fake.exportStateArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeBackend) ExportStateReturns(result1 *controller.State, result2 error) {
+	fake.exportStateMutex.Lock()
+	defer fake.exportStateMutex.Unlock()
+	fake.ExportStateStub = nil
+	fake.exportStateReturns = struct {
+		result1 *controller.State
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) ExportStateReturnsOnCall(i int, result1 *controller.State, result2 error) {
+	fake.exportStateMutex.Lock()
+	defer fake.exportStateMutex.Unlock()
+	fake.ExportStateStub = nil
+	if fake.exportStateReturnsOnCall == nil {
+		fake.exportStateReturnsOnCall = make(map[int]struct {
+			result1 *controller.State
+			result2 error
+		})
+	}
+	fake.exportStateReturnsOnCall[i] = struct {
+		result1 *controller.State
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) ExportVolume(arg1 context.Context, arg2 string, arg3 io.Writer) error {
+	fake.exportVolumeMutex.Lock()
+	ret, specificReturn := fake.exportVolumeReturnsOnCall[len(fake.exportVolumeArgsForCall)]
+	fake.exportVolumeArgsForCall = append(fake.exportVolumeArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 io.Writer
+	}{arg1, arg2, arg3})
+	stub := fake.ExportVolumeStub
+	fakeReturns := fake.exportVolumeReturns
+	fake.recordInvocation("ExportVolume", []interface{}{arg1, arg2, arg3})
+	fake.exportVolumeMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBackend) ExportVolumeCallCount() int {
+	fake.exportVolumeMutex.RLock()
+	defer fake.exportVolumeMutex.RUnlock()
+	return len(fake.exportVolumeArgsForCall)
+}
+
+func (fake *FakeBackend) ExportVolumeCalls(stub func(context.Context, string, io.Writer) error) {
+	fake.exportVolumeMutex.Lock()
+	defer fake.exportVolumeMutex.Unlock()
+	fake.ExportVolumeStub = stub
+}
+
+func (fake *FakeBackend) ExportVolumeArgsForCall(i int) (context.Context, string, io.Writer) {
+	fake.exportVolumeMutex.RLock()
+	defer fake.exportVolumeMutex.RUnlock()
+	argsForCall := fake.exportVolumeArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeBackend) ExportVolumeReturns(result1 error) {
+	fake.exportVolumeMutex.Lock()
+	defer fake.exportVolumeMutex.Unlock()
+	fake.ExportVolumeStub = nil
+	fake.exportVolumeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBackend) ExportVolumeReturnsOnCall(i int, result1 error) {
+	fake.exportVolumeMutex.Lock()
+	defer fake.exportVolumeMutex.Unlock()
+	fake.ExportVolumeStub = nil
+	if fake.exportVolumeReturnsOnCall == nil {
+		fake.exportVolumeReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.exportVolumeReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBackend) GC(arg1 context.Context, arg2 bool) ([]string, error) {
+	fake.gCMutex.Lock()
+	ret, specificReturn := fake.gCReturnsOnCall[len(fake.gCArgsForCall)]
+	fake.gCArgsForCall = append(fake.gCArgsForCall, struct {
+		arg1 context.Context
+		arg2 bool
+	}{arg1, arg2})
+	stub := fake.GCStub
+	fakeReturns := fake.gCReturns
+	fake.recordInvocation("GC", []interface{}{arg1, arg2})
+	fake.gCMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBackend) GCCallCount() int {
+	fake.gCMutex.RLock()
+	defer fake.gCMutex.RUnlock()
+	return len(fake.gCArgsForCall)
+}
+
+func (fake *FakeBackend) GCCalls(stub func(context.Context, bool) ([]string, error)) {
+	fake.gCMutex.Lock()
+	defer fake.gCMutex.Unlock()
+	fake.GCStub = stub
+}
+
+func (fake *FakeBackend) GCArgsForCall(i int) (context.Context, bool) {
+	fake.gCMutex.RLock()
+	defer fake.gCMutex.RUnlock()
+	argsForCall := fake.gCArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBackend) GCReturns(result1 []string, result2 error) {
+	fake.gCMutex.Lock()
+	defer fake.gCMutex.Unlock()
+	fake.GCStub = nil
+	fake.gCReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) GCReturnsOnCall(i int, result1 []string, result2 error) {
+	fake.gCMutex.Lock()
+	defer fake.gCMutex.Unlock()
+	fake.GCStub = nil
+	if fake.gCReturnsOnCall == nil {
+		fake.gCReturnsOnCall = make(map[int]struct {
+			result1 []string
+			result2 error
+		})
+	}
+	fake.gCReturnsOnCall[i] = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) ImportState(arg1 context.Context, arg2 *controller.State) error {
+	fake.importStateMutex.Lock()
+	ret, specificReturn := fake.importStateReturnsOnCall[len(fake.importStateArgsForCall)]
+	fake.importStateArgsForCall = append(fake.importStateArgsForCall, struct {
+		arg1 context.Context
+		arg2 *controller.State
+	}{arg1, arg2})
+	stub := fake.ImportStateStub
+	fakeReturns := fake.importStateReturns
+	fake.recordInvocation("ImportState", []interface{}{arg1, arg2})
+	fake.importStateMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBackend) ImportStateCallCount() int {
+	fake.importStateMutex.RLock()
+	defer fake.importStateMutex.RUnlock()
+	return len(fake.importStateArgsForCall)
+}
+
+func (fake *FakeBackend) ImportStateCalls(stub func(context.Context, *controller.State) error) {
+	fake.importStateMutex.Lock()
+	defer fake.importStateMutex.Unlock()
+	fake.ImportStateStub = stub
+}
+
+func (fake *FakeBackend) ImportStateArgsForCall(i int) (context.Context, *controller.State) {
+	fake.importStateMutex.RLock()
+	defer fake.importStateMutex.RUnlock()
+	argsForCall := fake.importStateArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBackend) ImportStateReturns(result1 error) {
+	fake.importStateMutex.Lock()
+	defer fake.importStateMutex.Unlock()
+	fake.ImportStateStub = nil
+	fake.importStateReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBackend) ImportStateReturnsOnCall(i int, result1 error) {
+	fake.importStateMutex.Lock()
+	defer fake.importStateMutex.Unlock()
+	fake.ImportStateStub = nil
+	if fake.importStateReturnsOnCall == nil {
+		fake.importStateReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.importStateReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBackend) ImportVolume(arg1 context.Context, arg2 string, arg3 string, arg4 io.Reader) (*controller.LocalVolume, error) {
+	fake.importVolumeMutex.Lock()
+	ret, specificReturn := fake.importVolumeReturnsOnCall[len(fake.importVolumeArgsForCall)]
+	fake.importVolumeArgsForCall = append(fake.importVolumeArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 io.Reader
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.ImportVolumeStub
+	fakeReturns := fake.importVolumeReturns
+	fake.recordInvocation("ImportVolume", []interface{}{arg1, arg2, arg3, arg4})
+	fake.importVolumeMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBackend) ImportVolumeCallCount() int {
+	fake.importVolumeMutex.RLock()
+	defer fake.importVolumeMutex.RUnlock()
+	return len(fake.importVolumeArgsForCall)
+}
+
+func (fake *FakeBackend) ImportVolumeCalls(stub func(context.Context, string, string, io.Reader) (*controller.LocalVolume, error)) {
+	fake.importVolumeMutex.Lock()
+	defer fake.importVolumeMutex.Unlock()
+	fake.ImportVolumeStub = stub
+}
+
+func (fake *FakeBackend) ImportVolumeArgsForCall(i int) (context.Context, string, string, io.Reader) {
+	fake.importVolumeMutex.RLock()
+	defer fake.importVolumeMutex.RUnlock()
+	argsForCall := fake.importVolumeArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeBackend) ImportVolumeReturns(result1 *controller.LocalVolume, result2 error) {
+	fake.importVolumeMutex.Lock()
+	defer fake.importVolumeMutex.Unlock()
+	fake.ImportVolumeStub = nil
+	fake.importVolumeReturns = struct {
+		result1 *controller.LocalVolume
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) ImportVolumeReturnsOnCall(i int, result1 *controller.LocalVolume, result2 error) {
+	fake.importVolumeMutex.Lock()
+	defer fake.importVolumeMutex.Unlock()
+	fake.ImportVolumeStub = nil
+	if fake.importVolumeReturnsOnCall == nil {
+		fake.importVolumeReturnsOnCall = make(map[int]struct {
+			result1 *controller.LocalVolume
+			result2 error
+		})
+	}
+	fake.importVolumeReturnsOnCall[i] = struct {
+		result1 *controller.LocalVolume
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) Snapshots(arg1 context.Context) ([]*controller.LocalSnapshot, error) {
+	fake.snapshotsMutex.Lock()
+	ret, specificReturn := fake.snapshotsReturnsOnCall[len(fake.snapshotsArgsForCall)]
+	fake.snapshotsArgsForCall = append(fake.snapshotsArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.SnapshotsStub
+	fakeReturns := fake.snapshotsReturns
+	fake.recordInvocation("Snapshots", []interface{}{arg1})
+	fake.snapshotsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBackend) SnapshotsCallCount() int {
+	fake.snapshotsMutex.RLock()
+	defer fake.snapshotsMutex.RUnlock()
+	return len(fake.snapshotsArgsForCall)
+}
+
+func (fake *FakeBackend) SnapshotsCalls(stub func(context.Context) ([]*controller.LocalSnapshot, error)) {
+	fake.snapshotsMutex.Lock()
+	defer fake.snapshotsMutex.Unlock()
+	fake.SnapshotsStub = stub
+}
+
+func (fake *FakeBackend) SnapshotsArgsForCall(i int) context.Context {
+	fake.snapshotsMutex.RLock()
+	defer fake.snapshotsMutex.RUnlock()
+	argsForCall := fake.snapshotsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeBackend) SnapshotsReturns(result1 []*controller.LocalSnapshot, result2 error) {
+	fake.snapshotsMutex.Lock()
+	defer fake.snapshotsMutex.Unlock()
+	fake.SnapshotsStub = nil
+	fake.snapshotsReturns = struct {
+		result1 []*controller.LocalSnapshot
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) SnapshotsReturnsOnCall(i int, result1 []*controller.LocalSnapshot, result2 error) {
+	fake.snapshotsMutex.Lock()
+	defer fake.snapshotsMutex.Unlock()
+	fake.SnapshotsStub = nil
+	if fake.snapshotsReturnsOnCall == nil {
+		fake.snapshotsReturnsOnCall = make(map[int]struct {
+			result1 []*controller.LocalSnapshot
+			result2 error
+		})
+	}
+	fake.snapshotsReturnsOnCall[i] = struct {
+		result1 []*controller.LocalSnapshot
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) UploadBackups(arg1 context.Context) error {
+	fake.uploadBackupsMutex.Lock()
+	ret, specificReturn := fake.uploadBackupsReturnsOnCall[len(fake.uploadBackupsArgsForCall)]
+	fake.uploadBackupsArgsForCall = append(fake.uploadBackupsArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.UploadBackupsStub
+	fakeReturns := fake.uploadBackupsReturns
+	fake.recordInvocation("UploadBackups", []interface{}{arg1})
+	fake.uploadBackupsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeBackend) UploadBackupsCallCount() int {
+	fake.uploadBackupsMutex.RLock()
+	defer fake.uploadBackupsMutex.RUnlock()
+	return len(fake.uploadBackupsArgsForCall)
+}
+
+func (fake *FakeBackend) UploadBackupsCalls(stub func(context.Context) error) {
+	fake.uploadBackupsMutex.Lock()
+	defer fake.uploadBackupsMutex.Unlock()
+	fake.UploadBackupsStub = stub
+}
+
+func (fake *FakeBackend) UploadBackupsArgsForCall(i int) context.Context {
+	fake.uploadBackupsMutex.RLock()
+	defer fake.uploadBackupsMutex.RUnlock()
+	argsForCall := fake.uploadBackupsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeBackend) UploadBackupsReturns(result1 error) {
+	fake.uploadBackupsMutex.Lock()
+	defer fake.uploadBackupsMutex.Unlock()
+	fake.UploadBackupsStub = nil
+	fake.uploadBackupsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBackend) UploadBackupsReturnsOnCall(i int, result1 error) {
+	fake.uploadBackupsMutex.Lock()
+	defer fake.uploadBackupsMutex.Unlock()
+	fake.UploadBackupsStub = nil
+	if fake.uploadBackupsReturnsOnCall == nil {
+		fake.uploadBackupsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.uploadBackupsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBackend) Usage(arg1 context.Context, arg2 bool) ([]controller.LocalVolumeUsage, error) {
+	fake.usageMutex.Lock()
+	ret, specificReturn := fake.usageReturnsOnCall[len(fake.usageArgsForCall)]
+	fake.usageArgsForCall = append(fake.usageArgsForCall, struct {
+		arg1 context.Context
+		arg2 bool
+	}{arg1, arg2})
+	stub := fake.UsageStub
+	fakeReturns := fake.usageReturns
+	fake.recordInvocation("Usage", []interface{}{arg1, arg2})
+	fake.usageMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBackend) UsageCallCount() int {
+	fake.usageMutex.RLock()
+	defer fake.usageMutex.RUnlock()
+	return len(fake.usageArgsForCall)
+}
+
+func (fake *FakeBackend) UsageCalls(stub func(context.Context, bool) ([]controller.LocalVolumeUsage, error)) {
+	fake.usageMutex.Lock()
+	defer fake.usageMutex.Unlock()
+	fake.UsageStub = stub
+}
+
+func (fake *FakeBackend) UsageArgsForCall(i int) (context.Context, bool) {
+	fake.usageMutex.RLock()
+	defer fake.usageMutex.RUnlock()
+	argsForCall := fake.usageArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBackend) UsageReturns(result1 []controller.LocalVolumeUsage, result2 error) {
+	fake.usageMutex.Lock()
+	defer fake.usageMutex.Unlock()
+	fake.UsageStub = nil
+	fake.usageReturns = struct {
+		result1 []controller.LocalVolumeUsage
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) UsageReturnsOnCall(i int, result1 []controller.LocalVolumeUsage, result2 error) {
+	fake.usageMutex.Lock()
+	defer fake.usageMutex.Unlock()
+	fake.UsageStub = nil
+	if fake.usageReturnsOnCall == nil {
+		fake.usageReturnsOnCall = make(map[int]struct {
+			result1 []controller.LocalVolumeUsage
+			result2 error
+		})
+	}
+	fake.usageReturnsOnCall[i] = struct {
+		result1 []controller.LocalVolumeUsage
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) Volume(arg1 context.Context, arg2 string) (*controller.LocalVolume, error) {
+	fake.volumeMutex.Lock()
+	ret, specificReturn := fake.volumeReturnsOnCall[len(fake.volumeArgsForCall)]
+	fake.volumeArgsForCall = append(fake.volumeArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.VolumeStub
+	fakeReturns := fake.volumeReturns
+	fake.recordInvocation("Volume", []interface{}{arg1, arg2})
+	fake.volumeMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBackend) VolumeCallCount() int {
+	fake.volumeMutex.RLock()
+	defer fake.volumeMutex.RUnlock()
+	return len(fake.volumeArgsForCall)
+}
+
+func (fake *FakeBackend) VolumeCalls(stub func(context.Context, string) (*controller.LocalVolume, error)) {
+	fake.volumeMutex.Lock()
+	defer fake.volumeMutex.Unlock()
+	fake.VolumeStub = stub
+}
+
+func (fake *FakeBackend) VolumeArgsForCall(i int) (context.Context, string) {
+	fake.volumeMutex.RLock()
+	defer fake.volumeMutex.RUnlock()
+	argsForCall := fake.volumeArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBackend) VolumeReturns(result1 *controller.LocalVolume, result2 error) {
+	fake.volumeMutex.Lock()
+	defer fake.volumeMutex.Unlock()
+	fake.VolumeStub = nil
+	fake.volumeReturns = struct {
+		result1 *controller.LocalVolume
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) VolumeReturnsOnCall(i int, result1 *controller.LocalVolume, result2 error) {
+	fake.volumeMutex.Lock()
+	defer fake.volumeMutex.Unlock()
+	fake.VolumeStub = nil
+	if fake.volumeReturnsOnCall == nil {
+		fake.volumeReturnsOnCall = make(map[int]struct {
+			result1 *controller.LocalVolume
+			result2 error
+		})
+	}
+	fake.volumeReturnsOnCall[i] = struct {
+		result1 *controller.LocalVolume
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) Volumes(arg1 context.Context) ([]*controller.LocalVolume, error) {
+	fake.volumesMutex.Lock()
+	ret, specificReturn := fake.volumesReturnsOnCall[len(fake.volumesArgsForCall)]
+	fake.volumesArgsForCall = append(fake.volumesArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.VolumesStub
+	fakeReturns := fake.volumesReturns
+	fake.recordInvocation("Volumes", []interface{}{arg1})
+	fake.volumesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBackend) VolumesCallCount() int {
+	fake.volumesMutex.RLock()
+	defer fake.volumesMutex.RUnlock()
+	return len(fake.volumesArgsForCall)
+}
+
+func (fake *FakeBackend) VolumesCalls(stub func(context.Context) ([]*controller.LocalVolume, error)) {
+	fake.volumesMutex.Lock()
+	defer fake.volumesMutex.Unlock()
+	fake.VolumesStub = stub
+}
+
+func (fake *FakeBackend) VolumesArgsForCall(i int) context.Context {
+	fake.volumesMutex.RLock()
+	defer fake.volumesMutex.RUnlock()
+	argsForCall := fake.volumesArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeBackend) VolumesReturns(result1 []*controller.LocalVolume, result2 error) {
+	fake.volumesMutex.Lock()
+	defer fake.volumesMutex.Unlock()
+	fake.VolumesStub = nil
+	fake.volumesReturns = struct {
+		result1 []*controller.LocalVolume
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) VolumesReturnsOnCall(i int, result1 []*controller.LocalVolume, result2 error) {
+	fake.volumesMutex.Lock()
+	defer fake.volumesMutex.Unlock()
+	fake.VolumesStub = nil
+	if fake.volumesReturnsOnCall == nil {
+		fake.volumesReturnsOnCall = make(map[int]struct {
+			result1 []*controller.LocalVolume
+			result2 error
+		})
+	}
+	fake.volumesReturnsOnCall[i] = struct {
+		result1 []*controller.LocalVolume
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBackend) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.backupsMutex.RLock()
+	defer fake.backupsMutex.RUnlock()
+	fake.exportSnapshotMutex.RLock()
+	defer fake.exportSnapshotMutex.RUnlock()
+	fake.exportStateMutex.RLock()
+	defer fake.exportStateMutex.RUnlock()
+	fake.exportVolumeMutex.RLock()
+	defer fake.exportVolumeMutex.RUnlock()
+	fake.gCMutex.RLock()
+	defer fake.gCMutex.RUnlock()
+	fake.importStateMutex.RLock()
+	defer fake.importStateMutex.RUnlock()
+	fake.importVolumeMutex.RLock()
+	defer fake.importVolumeMutex.RUnlock()
+	fake.snapshotsMutex.RLock()
+	defer fake.snapshotsMutex.RUnlock()
+	fake.uploadBackupsMutex.RLock()
+	defer fake.uploadBackupsMutex.RUnlock()
+	fake.usageMutex.RLock()
+	defer fake.usageMutex.RUnlock()
+	fake.volumeMutex.RLock()
+	defer fake.volumeMutex.RUnlock()
+	fake.volumesMutex.RLock()
+	defer fake.volumesMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeBackend) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ admin.Backend = new(FakeBackend)
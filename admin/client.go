@@ -0,0 +1,193 @@
+package admin
+
+import (
+	"io"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/faults"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type client struct {
+	conn *grpc.ClientConn
+}
+
+// Dial connects to the admin service at address, which CheckAddress must
+// accept. The connection is not encrypted; see CheckAddress.
+func Dial(address string) (*grpc.ClientConn, error) {
+	if err := CheckAddress(address); err != nil {
+		return nil, err
+	}
+	return grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
+// NewClient returns a Backend that calls the admin service over conn. It is
+// also a FaultInjector.
+func NewClient(conn *grpc.ClientConn) Backend {
+	return &client{conn: conn}
+}
+
+func (c *client) invoke(ctx context.Context, method string, req, resp interface{}) error {
+	return c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp, grpc.ForceCodec(codec{}))
+}
+
+func (c *client) Volumes(ctx context.Context) ([]*controller.LocalVolume, error) {
+	resp := &VolumesResponse{}
+	if err := c.invoke(ctx, "Volumes", &VolumesRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Volumes, nil
+}
+
+func (c *client) Volume(ctx context.Context, volId string) (*controller.LocalVolume, error) {
+	resp := &VolumeResponse{}
+	if err := c.invoke(ctx, "Volume", &VolumeRequest{VolumeId: volId}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Volume, nil
+}
+
+func (c *client) Snapshots(ctx context.Context) ([]*controller.LocalSnapshot, error) {
+	resp := &SnapshotsResponse{}
+	if err := c.invoke(ctx, "Snapshots", &SnapshotsRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Snapshots, nil
+}
+
+func (c *client) GC(ctx context.Context, dryRun bool) ([]string, error) {
+	resp := &GCResponse{}
+	if err := c.invoke(ctx, "GC", &GCRequest{DryRun: dryRun}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Paths, nil
+}
+
+func (c *client) ExportState(ctx context.Context) (*controller.State, error) {
+	resp := &ExportStateResponse{}
+	if err := c.invoke(ctx, "ExportState", &ExportStateRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.State, nil
+}
+
+func (c *client) ImportState(ctx context.Context, state *controller.State) error {
+	return c.invoke(ctx, "ImportState", &ImportStateRequest{State: state}, &ImportStateResponse{})
+}
+
+func (c *client) Usage(ctx context.Context, refresh bool) ([]controller.LocalVolumeUsage, error) {
+	resp := &UsageResponse{}
+	if err := c.invoke(ctx, "Usage", &UsageRequest{Refresh: refresh}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Usage, nil
+}
+
+func (c *client) Backups(ctx context.Context) ([]*controller.Backup, error) {
+	resp := &BackupsResponse{}
+	if err := c.invoke(ctx, "Backups", &BackupsRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Backups, nil
+}
+
+func (c *client) UploadBackups(ctx context.Context) error {
+	return c.invoke(ctx, "UploadBackups", &UploadBackupsRequest{}, &UploadBackupsResponse{})
+}
+
+func (c *client) ExportVolume(ctx context.Context, volId string, w io.Writer) error {
+	return c.export(ctx, "ExportVolume", &ExportVolumeRequest{VolumeId: volId}, w)
+}
+
+func (c *client) ExportSnapshot(ctx context.Context, snapId string, w io.Writer) error {
+	return c.export(ctx, "ExportSnapshot", &ExportSnapshotRequest{SnapshotId: snapId}, w)
+}
+
+// export writes the chunks streamed back by method to w.
+func (c *client) export(ctx context.Context, method string, req interface{}, w io.Writer) error {
+	// a failed write abandons the stream
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.newStream(ctx, &grpc.StreamDesc{ServerStreams: true}, method)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		chunk := &ArchiveChunk{}
+		if err := stream.RecvMsg(chunk); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// ImportVolume streams r to the server in chunks of up to ArchiveChunkSize.
+func (c *client) ImportVolume(ctx context.Context, name, pool string, r io.Reader) (*controller.LocalVolume, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.newStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "ImportVolume")
+	if err != nil {
+		return nil, err
+	}
+
+	req := &ImportVolumeRequest{Name: name, Pool: pool}
+	buf := make([]byte, ArchiveChunkSize)
+	for first := true; ; first = false {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 || first {
+			req.Data = buf[:n]
+			// io.EOF means the server has stopped reading; RecvMsg says why
+			if err := stream.SendMsg(req); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			req = &ImportVolumeRequest{}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	resp := &ImportVolumeResponse{}
+	if err := stream.RecvMsg(resp); err != nil {
+		return nil, err
+	}
+	return resp.Volume, nil
+}
+
+func (c *client) newStream(ctx context.Context, desc *grpc.StreamDesc, method string) (grpc.ClientStream, error) {
+	return c.conn.NewStream(ctx, desc, "/"+ServiceName+"/"+method, grpc.ForceCodec(codec{}))
+}
+
+func (c *client) Faults(ctx context.Context) (*faults.Config, error) {
+	resp := &FaultsResponse{}
+	if err := c.invoke(ctx, "Faults", &FaultsRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Config, nil
+}
+
+func (c *client) SetFaults(ctx context.Context, config *faults.Config) error {
+	return c.invoke(ctx, "SetFaults", &SetFaultsRequest{Config: config}, &SetFaultsResponse{})
+}
@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// binding is a service binding as the broker records it.
+type binding struct {
+	InstanceId string `json:"instance_id"`
+	VolumeId   string `json:"volume_id"`
+}
+
+// bindings records the service bindings, so that a volume stays published
+// to the node until its last binding is deleted. With a path they are kept
+// in a file, written like the controller's state file; without one they are
+// lost on restart.
+type bindings struct {
+	path     string
+	bindings map[string]binding
+}
+
+func loadBindings(path string) (*bindings, error) {
+	b := &bindings{path: path, bindings: map[string]binding{}}
+	if path == "" {
+		return b, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &b.bindings); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *bindings) get(bindingId string) (binding, bool) {
+	bound, ok := b.bindings[bindingId]
+	return bound, ok
+}
+
+// others reports whether a binding other than bindingId holds the volume.
+func (b *bindings) others(bindingId, volId string) bool {
+	for id, bound := range b.bindings {
+		if id != bindingId && bound.VolumeId == volId {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bindings) add(bindingId string, bound binding) error {
+	b.bindings[bindingId] = bound
+	if err := b.save(); err != nil {
+		delete(b.bindings, bindingId)
+		return err
+	}
+	return nil
+}
+
+func (b *bindings) remove(bindingId string) error {
+	bound := b.bindings[bindingId]
+	delete(b.bindings, bindingId)
+	if err := b.save(); err != nil {
+		b.bindings[bindingId] = bound
+		return err
+	}
+	return nil
+}
+
+func (b *bindings) save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(b.bindings)
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
@@ -0,0 +1,457 @@
+// Package broker serves the Open Service Broker API v2 in front of the
+// controller, so that Cloud Foundry apps can get local volumes as a service.
+// Provisioning creates a volume named by the instance id in the plan's pool,
+// and binding publishes it to the configured node until its last binding is
+// deleted.
+package broker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// APIVersionHeader carries the OSB API version the platform speaks; only 2.x is served.
+const APIVersionHeader = "X-Broker-API-Version"
+
+// ContainerDirRoot is where bound volumes are mounted in the app's container
+// unless the binding asks for another directory.
+const ContainerDirRoot = "/var/vcap/data"
+
+// Controller is the part of the controller the broker drives.
+type Controller interface {
+	CreateVolume(ctx context.Context, in *CreateVolumeRequest) (*CreateVolumeResponse, error)
+	DeleteVolume(ctx context.Context, in *DeleteVolumeRequest) (*DeleteVolumeResponse, error)
+	ControllerPublishVolume(ctx context.Context, in *ControllerPublishVolumeRequest) (*ControllerPublishVolumeResponse, error)
+	ControllerUnpublishVolume(ctx context.Context, in *ControllerUnpublishVolumeRequest) (*ControllerUnpublishVolumeResponse, error)
+	Volume(ctx context.Context, volId string) (*controller.LocalVolume, error)
+	VolumePath(ctx context.Context, volId string) (string, error)
+}
+
+type ProvisionRequest struct {
+	ServiceId        string                 `json:"service_id"`
+	PlanId           string                 `json:"plan_id"`
+	OrganizationGuid string                 `json:"organization_guid"`
+	SpaceGuid        string                 `json:"space_guid"`
+	Parameters       map[string]interface{} `json:"parameters"`
+}
+
+type BindRequest struct {
+	ServiceId  string                 `json:"service_id"`
+	PlanId     string                 `json:"plan_id"`
+	AppGuid    string                 `json:"app_guid"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+type BindResponse struct {
+	Credentials  map[string]interface{} `json:"credentials"`
+	VolumeMounts []VolumeMount          `json:"volume_mounts"`
+}
+
+// VolumeMount is a Cloud Foundry volume mount for a shared device.
+type VolumeMount struct {
+	Driver       string       `json:"driver"`
+	ContainerDir string       `json:"container_dir"`
+	Mode         string       `json:"mode"`
+	DeviceType   string       `json:"device_type"`
+	Device       SharedDevice `json:"device"`
+}
+
+// SharedDevice is the volume to mount. Its mount config holds the volume's
+// pool and path, under PoolKey and PathKey, and its publish context.
+type SharedDevice struct {
+	VolumeId    string                 `json:"volume_id"`
+	MountConfig map[string]interface{} `json:"mount_config"`
+}
+
+// The mount config entries that tell the volume driver where the volume is.
+const (
+	PoolKey = "pool"
+	PathKey = "path"
+)
+
+type ErrorResponse struct {
+	Description string `json:"description"`
+}
+
+type catalogResponse struct {
+	Services []catalogService `json:"services"`
+}
+
+type catalogService struct {
+	ID             string        `json:"id"`
+	Name           string        `json:"name"`
+	Description    string        `json:"description"`
+	Bindable       bool          `json:"bindable"`
+	PlanUpdateable bool          `json:"plan_updateable"`
+	Tags           []string      `json:"tags,omitempty"`
+	Requires       []string      `json:"requires"`
+	Plans          []catalogPlan `json:"plans"`
+}
+
+type catalogPlan struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Free        bool   `json:"free"`
+}
+
+type broker struct {
+	logger      lager.Logger
+	controller  Controller
+	config      Config
+	defaultPool string
+
+	// lock serializes bindings, so that a volume is unpublished only once
+	// its last binding is gone
+	lock     sync.Mutex
+	bindings *bindings
+}
+
+// New returns the broker's HTTP handler, with the bindings recorded in
+// config's bindings path. config must be valid for pools, the controller's
+// configuration.
+func New(logger lager.Logger, controller Controller, config Config, pools controller.Config) (http.Handler, error) {
+	bindings, err := loadBindings(config.BindingsPath)
+	if err != nil {
+		return nil, err
+	}
+	return &broker{
+		logger:      logger.Session("broker"),
+		controller:  controller,
+		config:      config,
+		defaultPool: pools.DefaultPool,
+		bindings:    bindings,
+	}, nil
+}
+
+func (b *broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="local-controller-plugin"`)
+		respond(w, http.StatusUnauthorized, ErrorResponse{Description: "Not authorized"})
+		return
+	}
+	if version := r.Header.Get(APIVersionHeader); !strings.HasPrefix(version, "2.") {
+		respond(w, http.StatusPreconditionFailed, ErrorResponse{Description: fmt.Sprintf("Unsupported API version %q", version)})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path.Clean(r.URL.Path), "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "v2" && parts[1] == "catalog":
+		b.route(w, r, map[string]func(){
+			http.MethodGet: func() { b.catalog(w) },
+		})
+	case len(parts) == 3 && parts[0] == "v2" && parts[1] == "service_instances":
+		b.route(w, r, map[string]func(){
+			http.MethodPut:    func() { b.provision(w, r, parts[2]) },
+			http.MethodDelete: func() { b.deprovision(w, r, parts[2]) },
+		})
+	case len(parts) == 5 && parts[0] == "v2" && parts[1] == "service_instances" && parts[3] == "service_bindings":
+		b.route(w, r, map[string]func(){
+			http.MethodPut:    func() { b.bind(w, r, parts[2], parts[4]) },
+			http.MethodDelete: func() { b.unbind(w, r, parts[2], parts[4]) },
+		})
+	default:
+		respond(w, http.StatusNotFound, ErrorResponse{Description: "Not found"})
+	}
+}
+
+func (b *broker) route(w http.ResponseWriter, r *http.Request, handlers map[string]func()) {
+	handler, ok := handlers[r.Method]
+	if !ok {
+		respond(w, http.StatusMethodNotAllowed, ErrorResponse{Description: fmt.Sprintf("Method %s not allowed", r.Method)})
+		return
+	}
+	handler()
+}
+
+func (b *broker) authorized(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(b.config.Username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(b.config.Password)) == 1
+}
+
+func (b *broker) catalog(w http.ResponseWriter) {
+	catalog := catalogResponse{Services: []catalogService{}}
+	for _, s := range b.config.Services {
+		service := catalogService{
+			ID:          s.ID,
+			Name:        s.Name,
+			Description: s.Description,
+			Bindable:    true,
+			Tags:        s.Tags,
+			Requires:    []string{"volume_mount"},
+			Plans:       []catalogPlan{},
+		}
+		for _, p := range s.Plans {
+			service.Plans = append(service.Plans, catalogPlan{ID: p.ID, Name: p.Name, Description: p.Description, Free: true})
+		}
+		catalog.Services = append(catalog.Services, service)
+	}
+	respond(w, http.StatusOK, catalog)
+}
+
+func (b *broker) provision(w http.ResponseWriter, r *http.Request, instanceId string) {
+	logger := b.logger.Session("provision", lager.Data{"instance_id": instanceId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	var req ProvisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond(w, http.StatusBadRequest, ErrorResponse{Description: "Invalid request body: " + err.Error()})
+		return
+	}
+	plan, ok := b.config.plan(req.ServiceId, req.PlanId)
+	if !ok {
+		respond(w, http.StatusBadRequest, ErrorResponse{Description: fmt.Sprintf("Unknown service %q or plan %q", req.ServiceId, req.PlanId)})
+		return
+	}
+	if len(req.Parameters) > 0 {
+		respond(w, http.StatusBadRequest, ErrorResponse{Description: "Provisioning takes no parameters"})
+		return
+	}
+
+	existing, err := b.volume(r.Context(), plan, instanceId)
+	if err != nil {
+		b.fail(logger, w, err)
+		return
+	}
+
+	createReq := &CreateVolumeRequest{
+		Name:               instanceId,
+		VolumeCapabilities: []*VolumeCapability{plan.capability()},
+		Parameters:         plan.Parameters,
+	}
+	if plan.CapacityBytes > 0 {
+		createReq.CapacityRange = &CapacityRange{RequiredBytes: plan.CapacityBytes}
+	}
+	resp, err := b.controller.CreateVolume(r.Context(), createReq)
+	if err != nil {
+		b.fail(logger, w, err)
+		return
+	}
+
+	logger.Info("provisioned", lager.Data{"volume_id": resp.GetVolume().GetVolumeId()})
+	if existing != nil {
+		respond(w, http.StatusOK, struct{}{})
+		return
+	}
+	respond(w, http.StatusCreated, struct{}{})
+}
+
+func (b *broker) deprovision(w http.ResponseWriter, r *http.Request, instanceId string) {
+	logger := b.logger.Session("deprovision", lager.Data{"instance_id": instanceId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	plan, ok := b.config.plan(r.URL.Query().Get("service_id"), r.URL.Query().Get("plan_id"))
+	if !ok {
+		respond(w, http.StatusBadRequest, ErrorResponse{Description: "Unknown service_id or plan_id"})
+		return
+	}
+
+	volume, err := b.volume(r.Context(), plan, instanceId)
+	if err != nil {
+		b.fail(logger, w, err)
+		return
+	}
+	if volume == nil {
+		respond(w, http.StatusGone, struct{}{})
+		return
+	}
+
+	if _, err := b.controller.DeleteVolume(r.Context(), &DeleteVolumeRequest{VolumeId: volume.VolumeId}); err != nil {
+		b.fail(logger, w, err)
+		return
+	}
+	respond(w, http.StatusOK, struct{}{})
+}
+
+func (b *broker) bind(w http.ResponseWriter, r *http.Request, instanceId, bindingId string) {
+	logger := b.logger.Session("bind", lager.Data{"instance_id": instanceId, "binding_id": bindingId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	var req BindRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond(w, http.StatusBadRequest, ErrorResponse{Description: "Invalid request body: " + err.Error()})
+		return
+	}
+	plan, ok := b.config.plan(req.ServiceId, req.PlanId)
+	if !ok {
+		respond(w, http.StatusBadRequest, ErrorResponse{Description: fmt.Sprintf("Unknown service %q or plan %q", req.ServiceId, req.PlanId)})
+		return
+	}
+
+	containerDir := path.Join(ContainerDirRoot, instanceId)
+	readonly := false
+	for key, value := range req.Parameters {
+		var ok bool
+		switch key {
+		case "mount":
+			containerDir, ok = value.(string)
+			ok = ok && path.IsAbs(containerDir)
+		case "readonly":
+			readonly, ok = value.(bool)
+		}
+		if !ok {
+			respond(w, http.StatusBadRequest, ErrorResponse{Description: fmt.Sprintf("Invalid parameter %q: bindings take an absolute \"mount\" path and a boolean \"readonly\"", key)})
+			return
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	volume, err := b.volume(r.Context(), plan, instanceId)
+	if err != nil {
+		b.fail(logger, w, err)
+		return
+	}
+	if volume == nil {
+		respond(w, http.StatusNotFound, ErrorResponse{Description: fmt.Sprintf("Service instance %q does not exist", instanceId)})
+		return
+	}
+	bound, exists := b.bindings.get(bindingId)
+	if exists && bound.VolumeId != volume.VolumeId {
+		respond(w, http.StatusConflict, ErrorResponse{Description: fmt.Sprintf("Binding %q is for service instance %q", bindingId, bound.InstanceId)})
+		return
+	}
+
+	resp, err := b.controller.ControllerPublishVolume(r.Context(), &ControllerPublishVolumeRequest{
+		VolumeId:         volume.VolumeId,
+		NodeId:           b.config.NodeId,
+		VolumeCapability: plan.capability(),
+		Readonly:         readonly,
+	})
+	if err != nil {
+		b.fail(logger, w, err)
+		return
+	}
+	volumePath, err := b.controller.VolumePath(r.Context(), volume.VolumeId)
+	if err != nil {
+		b.fail(logger, w, err)
+		return
+	}
+	if !exists {
+		if err := b.bindings.add(bindingId, binding{InstanceId: instanceId, VolumeId: volume.VolumeId}); err != nil {
+			b.fail(logger, w, err)
+			return
+		}
+	}
+
+	mountConfig := map[string]interface{}{PoolKey: volume.Pool, PathKey: volumePath}
+	for k, v := range resp.GetPublishContext() {
+		mountConfig[k] = v
+	}
+	mode := "rw"
+	if readonly {
+		mode = "r"
+	}
+	code := http.StatusCreated
+	if exists {
+		code = http.StatusOK
+	}
+	respond(w, code, BindResponse{
+		Credentials: map[string]interface{}{},
+		VolumeMounts: []VolumeMount{{
+			Driver:       b.config.Driver,
+			ContainerDir: containerDir,
+			Mode:         mode,
+			DeviceType:   "shared",
+			Device:       SharedDevice{VolumeId: volume.VolumeId, MountConfig: mountConfig},
+		}},
+	})
+}
+
+func (b *broker) unbind(w http.ResponseWriter, r *http.Request, instanceId, bindingId string) {
+	logger := b.logger.Session("unbind", lager.Data{"instance_id": instanceId, "binding_id": bindingId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	plan, ok := b.config.plan(r.URL.Query().Get("service_id"), r.URL.Query().Get("plan_id"))
+	if !ok {
+		respond(w, http.StatusBadRequest, ErrorResponse{Description: "Unknown service_id or plan_id"})
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	bound, ok := b.bindings.get(bindingId)
+	if !ok || bound.VolumeId != b.volumeId(plan, instanceId) {
+		respond(w, http.StatusGone, struct{}{})
+		return
+	}
+
+	if !b.bindings.others(bindingId, bound.VolumeId) {
+		if _, err := b.controller.ControllerUnpublishVolume(r.Context(), &ControllerUnpublishVolumeRequest{VolumeId: bound.VolumeId, NodeId: b.config.NodeId}); err != nil {
+			b.fail(logger, w, err)
+			return
+		}
+	}
+	if err := b.bindings.remove(bindingId); err != nil {
+		b.fail(logger, w, err)
+		return
+	}
+	respond(w, http.StatusOK, struct{}{})
+}
+
+// volume returns the volume provisioned for an instance of plan, or nil if
+// there is none.
+func (b *broker) volume(ctx context.Context, plan Plan, instanceId string) (*controller.LocalVolume, error) {
+	volume, err := b.controller.Volume(ctx, b.volumeId(plan, instanceId))
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	return volume, err
+}
+
+// volumeId is the id of the volume of an instance of plan, named by the
+// instance id in the plan's pool.
+func (b *broker) volumeId(plan Plan, instanceId string) string {
+	pool := plan.Parameters[controller.PoolParameter]
+	if pool == "" {
+		pool = b.defaultPool
+	}
+	return controller.VolumeID(pool, instanceId)
+}
+
+// fail responds with the HTTP status closest to the controller's error code.
+func (b *broker) fail(logger lager.Logger, w http.ResponseWriter, err error) {
+	logger.Error("failed", err)
+
+	code := http.StatusInternalServerError
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.OutOfRange:
+		code = http.StatusBadRequest
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.AlreadyExists:
+		code = http.StatusConflict
+	case codes.FailedPrecondition, codes.ResourceExhausted:
+		code = http.StatusUnprocessableEntity
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	}
+	respond(w, code, ErrorResponse{Description: status.Convert(err).Message()})
+}
+
+func respond(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
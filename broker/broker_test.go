@@ -0,0 +1,317 @@
+package broker_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/broker"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Broker", func() {
+	var (
+		root   string
+		cs     *controller.Controller
+		server *httptest.Server
+		config broker.Config
+		pools  controller.Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "broker")
+		Expect(err).NotTo(HaveOccurred())
+
+		pools = controller.DefaultConfig(filepath.Join(root, "default"))
+		pools.Pools = append(pools.Pools, controller.PoolConfig{Name: "other", Root: filepath.Join(root, "other")})
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), controller.NewSubvolumes(), controller.NewMemoryRegistry(), pools)
+		cs.SetLogger(lagertest.NewTestLogger("controller"))
+		Expect(cs.Recover()).To(Succeed())
+
+		config, err = broker.ParseConfig([]byte(`{
+			"username": "admin",
+			"password": "secret",
+			"driver": "localdriver",
+			"node_id": "cell-1",
+			"bindings_path": "` + filepath.Join(root, "bindings.json") + `",
+			"services": [{
+				"id": "service-id",
+				"name": "local-volume",
+				"description": "Local volumes",
+				"plans": [
+					{"id": "small-id", "name": "small", "description": "1 KiB", "capacity_bytes": 1024},
+					{"id": "large-id", "name": "large", "description": "1 MiB", "capacity_bytes": 1048576},
+					{"id": "other-id", "name": "other", "description": "1 KiB elsewhere", "capacity_bytes": 1024, "parameters": {"pool": "other"}}
+				]
+			}]
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.ValidatePools(pools)).To(Succeed())
+		handler, err := broker.New(lagertest.NewTestLogger("broker"), cs, config, pools)
+		Expect(err).NotTo(HaveOccurred())
+		server = httptest.NewServer(handler)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(root)
+	})
+
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth("admin", "secret")
+		req.Header.Set(broker.APIVersionHeader, "2.16")
+
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+
+		var decoded map[string]interface{}
+		Expect(json.NewDecoder(resp.Body).Decode(&decoded)).To(Succeed())
+		return resp.StatusCode, decoded
+	}
+
+	provision := func(instanceId, planId string) int {
+		code, _ := call("PUT", "/v2/service_instances/"+instanceId, `{"service_id": "service-id", "plan_id": "`+planId+`", "organization_guid": "org", "space_guid": "space"}`)
+		return code
+	}
+
+	Describe("the catalog", func() {
+		It("lists the bindable services and their plans", func() {
+			code, catalog := call("GET", "/v2/catalog", "")
+			Expect(code).To(Equal(http.StatusOK))
+
+			data, err := json.Marshal(catalog)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(MatchJSON(`{"services": [{
+				"id": "service-id",
+				"name": "local-volume",
+				"description": "Local volumes",
+				"bindable": true,
+				"plan_updateable": false,
+				"requires": ["volume_mount"],
+				"plans": [
+					{"id": "small-id", "name": "small", "description": "1 KiB", "free": true},
+					{"id": "large-id", "name": "large", "description": "1 MiB", "free": true},
+					{"id": "other-id", "name": "other", "description": "1 KiB elsewhere", "free": true}
+				]
+			}]}`))
+		})
+	})
+
+	It("requires basic auth", func() {
+		resp, err := http.Get(server.URL + "/v2/catalog")
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(resp.Header.Get("WWW-Authenticate")).To(ContainSubstring("Basic"))
+	})
+
+	It("requires API version 2", func() {
+		req, err := http.NewRequest("GET", server.URL+"/v2/catalog", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth("admin", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusPreconditionFailed))
+	})
+
+	Describe("provisioning", func() {
+		It("creates a volume named by the instance with the plan's capacity", func() {
+			Expect(provision("instance-1", "small-id")).To(Equal(http.StatusCreated))
+
+			volume, err := cs.Volume(context.Background(), "default:instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.CapacityBytes).To(Equal(int64(1024)))
+		})
+
+		It("succeeds with 200 when repeated and conflicts with another plan", func() {
+			Expect(provision("instance-1", "small-id")).To(Equal(http.StatusCreated))
+			Expect(provision("instance-1", "small-id")).To(Equal(http.StatusOK))
+			Expect(provision("instance-1", "large-id")).To(Equal(http.StatusConflict))
+		})
+
+		It("rejects an unknown plan", func() {
+			code, body := call("PUT", "/v2/service_instances/instance-1", `{"service_id": "service-id", "plan_id": "nope"}`)
+			Expect(code).To(Equal(http.StatusBadRequest))
+			Expect(body["description"]).To(ContainSubstring("nope"))
+		})
+
+		It("keeps instances of plans in different pools apart", func() {
+			Expect(provision("instance-1", "other-id")).To(Equal(http.StatusCreated))
+			_, err := cs.Volume(context.Background(), "other:instance-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			// the instance is only looked for in its plan's pool
+			Expect(provision("instance-1", "small-id")).To(Equal(http.StatusCreated))
+			code, _ := call("DELETE", "/v2/service_instances/instance-1?service_id=service-id&plan_id=other-id", "")
+			Expect(code).To(Equal(http.StatusOK))
+			_, err = cs.Volume(context.Background(), "default:instance-1")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("deletes the volume on deprovision, and reports a second one as gone", func() {
+			Expect(provision("instance-1", "small-id")).To(Equal(http.StatusCreated))
+
+			code, _ := call("DELETE", "/v2/service_instances/instance-1?service_id=service-id&plan_id=small-id", "")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(cs.Volumes(context.Background())).To(BeEmpty())
+
+			code, _ = call("DELETE", "/v2/service_instances/instance-1?service_id=service-id&plan_id=small-id", "")
+			Expect(code).To(Equal(http.StatusGone))
+		})
+	})
+
+	Describe("binding", func() {
+		BeforeEach(func() {
+			Expect(provision("instance-1", "small-id")).To(Equal(http.StatusCreated))
+		})
+
+		It("publishes the volume to the node and returns its volume mount", func() {
+			code, body := call("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1",
+				`{"service_id": "service-id", "plan_id": "small-id", "app_guid": "app", "parameters": {"mount": "/data", "readonly": true}}`)
+			Expect(code).To(Equal(http.StatusCreated))
+
+			data, err := json.Marshal(body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(MatchJSON(`{
+				"credentials": {},
+				"volume_mounts": [{
+					"driver": "localdriver",
+					"container_dir": "/data",
+					"mode": "r",
+					"device_type": "shared",
+					"device": {"volume_id": "default:instance-1", "mount_config": {"pool": "default", "path": "` + filepath.Join(root, "default", controller.VolumesRootDir, "instance-1") + `"}}
+				}]
+			}`))
+
+			volume, err := cs.Volume(context.Background(), "default:instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.PublishedNodes).To(Equal(map[string]bool{"cell-1": true}))
+		})
+
+		It("mounts read-write under the default directory, and answers 200 when repeated", func() {
+			bind := `{"service_id": "service-id", "plan_id": "small-id", "app_guid": "app"}`
+			code, body := call("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1", bind)
+			Expect(code).To(Equal(http.StatusCreated))
+			mount := body["volume_mounts"].([]interface{})[0].(map[string]interface{})
+			Expect(mount["container_dir"]).To(Equal("/var/vcap/data/instance-1"))
+			Expect(mount["mode"]).To(Equal("rw"))
+
+			code, _ = call("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1", bind)
+			Expect(code).To(Equal(http.StatusOK))
+		})
+
+		It("rejects invalid parameters", func() {
+			code, _ := call("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1",
+				`{"service_id": "service-id", "plan_id": "small-id", "parameters": {"mount": "relative"}}`)
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("fails for an instance that does not exist", func() {
+			code, _ := call("PUT", "/v2/service_instances/nope/service_bindings/binding-1", `{"service_id": "service-id", "plan_id": "small-id"}`)
+			Expect(code).To(Equal(http.StatusNotFound))
+		})
+
+		It("keeps the volume published until its last binding is deleted, across restarts", func() {
+			bind := `{"service_id": "service-id", "plan_id": "small-id", "app_guid": "app"}`
+			code, _ := call("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1", bind)
+			Expect(code).To(Equal(http.StatusCreated))
+			code, _ = call("PUT", "/v2/service_instances/instance-1/service_bindings/binding-2", bind)
+			Expect(code).To(Equal(http.StatusCreated))
+
+			handler, err := broker.New(lagertest.NewTestLogger("broker"), cs, config, pools)
+			Expect(err).NotTo(HaveOccurred())
+			server.Config.Handler = handler
+
+			code, _ = call("DELETE", "/v2/service_instances/instance-1/service_bindings/binding-1?service_id=service-id&plan_id=small-id", "")
+			Expect(code).To(Equal(http.StatusOK))
+			volume, err := cs.Volume(context.Background(), "default:instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.PublishedNodes).To(HaveKey("cell-1"))
+
+			code, _ = call("DELETE", "/v2/service_instances/instance-1/service_bindings/binding-2?service_id=service-id&plan_id=small-id", "")
+			Expect(code).To(Equal(http.StatusOK))
+			volume, err = cs.Volume(context.Background(), "default:instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.PublishedNodes).To(BeEmpty())
+		})
+
+		It("refuses a binding id already bound to another instance", func() {
+			Expect(provision("instance-2", "small-id")).To(Equal(http.StatusCreated))
+			code, _ := call("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1", `{"service_id": "service-id", "plan_id": "small-id"}`)
+			Expect(code).To(Equal(http.StatusCreated))
+			code, _ = call("PUT", "/v2/service_instances/instance-2/service_bindings/binding-1", `{"service_id": "service-id", "plan_id": "small-id"}`)
+			Expect(code).To(Equal(http.StatusConflict))
+		})
+
+		It("unpublishes on unbind, and reports a second one as gone", func() {
+			code, _ := call("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1", `{"service_id": "service-id", "plan_id": "small-id"}`)
+			Expect(code).To(Equal(http.StatusCreated))
+
+			code, _ = call("DELETE", "/v2/service_instances/instance-1/service_bindings/binding-1?service_id=service-id&plan_id=small-id", "")
+			Expect(code).To(Equal(http.StatusOK))
+			volume, err := cs.Volume(context.Background(), "default:instance-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.PublishedNodes).To(BeEmpty())
+
+			code, _ = call("DELETE", "/v2/service_instances/instance-1/service_bindings/binding-1?service_id=service-id&plan_id=small-id", "")
+			Expect(code).To(Equal(http.StatusGone))
+		})
+	})
+
+	Describe("ParseConfig", func() {
+		It("rejects a config without credentials or services", func() {
+			_, err := broker.ParseConfig([]byte(`{"driver": "localdriver", "services": []}`))
+			Expect(err).To(MatchError("username and password are required"))
+			_, err = broker.ParseConfig([]byte(`{"username": "a", "password": "b", "driver": "localdriver"}`))
+			Expect(err).To(MatchError("no services configured"))
+		})
+
+		It("rejects a config without a node id", func() {
+			_, err := broker.ParseConfig([]byte(`{"username": "a", "password": "b", "driver": "d", "services": [
+				{"id": "s", "name": "s", "plans": [{"id": "p", "name": "p"}]}]}`))
+			Expect(err).To(MatchError("no node id configured"))
+		})
+
+		It("rejects plans for unknown pools, or with access modes their pool refuses", func() {
+			images := controller.Config{
+				Pools:       []controller.PoolConfig{{Name: "images", Root: "/images", Backend: controller.BackendImage}},
+				DefaultPool: "images",
+			}
+			config, err := broker.ParseConfig([]byte(`{"username": "a", "password": "b", "driver": "d", "node_id": "n", "services": [
+				{"id": "s", "name": "s", "plans": [{"id": "p", "name": "p"}]}]}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.ValidatePools(images)).To(MatchError(`plan "p": pool "images" does not support access mode MULTI_NODE_MULTI_WRITER`))
+
+			config.Services[0].Plans[0].AccessMode = "SINGLE_NODE_WRITER"
+			Expect(config.ValidatePools(images)).To(Succeed())
+
+			config.Services[0].Plans[0].Parameters = map[string]string{controller.PoolParameter: "fast"}
+			Expect(config.ValidatePools(images)).To(MatchError(`plan "p": pool "fast" is not configured`))
+		})
+
+		It("rejects duplicate ids and unknown access modes", func() {
+			_, err := broker.ParseConfig([]byte(`{"username": "a", "password": "b", "driver": "d", "node_id": "n", "services": [
+				{"id": "x", "name": "s", "plans": [{"id": "x", "name": "p"}]}]}`))
+			Expect(err).To(MatchError(`duplicate id "x"`))
+			_, err = broker.ParseConfig([]byte(`{"username": "a", "password": "b", "driver": "d", "node_id": "n", "services": [
+				{"id": "s", "name": "s", "plans": [{"id": "p", "name": "p", "access_mode": "SOMETIMES"}]}]}`))
+			Expect(err).To(MatchError(`plan "p": unknown access mode "SOMETIMES"`))
+		})
+	})
+})
@@ -0,0 +1,148 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// DefaultAccessMode is what volumes are created and published with when a
+// plan names none: apps on one cell share the volume.
+const DefaultAccessMode = "MULTI_NODE_MULTI_WRITER"
+
+type Config struct {
+	// Username and Password are the basic auth credentials the platform calls the broker with.
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Driver is the volume driver the platform mounts bound volumes with.
+	Driver string `json:"driver"`
+	// NodeId is the node bound volumes are published to, the host the
+	// driver mounts them on.
+	NodeId string `json:"node_id"`
+	// BindingsPath is the file the bindings are recorded in; they are kept
+	// in memory if empty.
+	BindingsPath string    `json:"bindings_path"`
+	Services     []Service `json:"services"`
+}
+
+type Service struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Plans       []Plan   `json:"plans"`
+}
+
+type Plan struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// CapacityBytes is the capacity requested for the plan's volumes; 0 leaves it to the pool.
+	CapacityBytes int64 `json:"capacity_bytes"`
+	// Parameters are passed to CreateVolume, e.g. {"pool": "fast"}.
+	Parameters map[string]string `json:"parameters"`
+	// AccessMode is the CSI access mode name volumes are created and published with; DefaultAccessMode if empty.
+	AccessMode string `json:"access_mode"`
+}
+
+func ParseConfig(data []byte) (Config, error) {
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, err
+	}
+	return config, config.Validate()
+}
+
+func (c Config) Validate() error {
+	if c.Username == "" || c.Password == "" {
+		return fmt.Errorf("username and password are required")
+	}
+	if c.Driver == "" {
+		return fmt.Errorf("no volume driver configured")
+	}
+	if len(c.Services) == 0 {
+		return fmt.Errorf("no services configured")
+	}
+	if c.NodeId == "" {
+		return fmt.Errorf("no node id configured")
+	}
+
+	ids := map[string]bool{}
+	for _, s := range c.Services {
+		if s.ID == "" || s.Name == "" {
+			return fmt.Errorf("services need an id and a name")
+		}
+		if ids[s.ID] {
+			return fmt.Errorf("duplicate id %q", s.ID)
+		}
+		ids[s.ID] = true
+
+		if len(s.Plans) == 0 {
+			return fmt.Errorf("service %q: no plans configured", s.Name)
+		}
+		for _, p := range s.Plans {
+			if p.ID == "" || p.Name == "" {
+				return fmt.Errorf("service %q: plans need an id and a name", s.Name)
+			}
+			if ids[p.ID] {
+				return fmt.Errorf("duplicate id %q", p.ID)
+			}
+			ids[p.ID] = true
+
+			if p.CapacityBytes < 0 {
+				return fmt.Errorf("plan %q: capacity_bytes must not be negative", p.Name)
+			}
+			if _, ok := VolumeCapability_AccessMode_Mode_value[p.AccessMode]; p.AccessMode != "" && !ok {
+				return fmt.Errorf("plan %q: unknown access mode %q", p.Name, p.AccessMode)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidatePools checks that every plan's pool is configured in pools and
+// accepts the plan's access mode; image pools, for one, only take single-node
+// modes.
+func (c Config) ValidatePools(pools controller.Config) error {
+	for _, s := range c.Services {
+		for _, p := range s.Plans {
+			name := p.Parameters[controller.PoolParameter]
+			pool, ok := pools.Pool(name)
+			if !ok {
+				return fmt.Errorf("plan %q: pool %q is not configured", p.Name, name)
+			}
+			if !pool.Supports([]*VolumeCapability{p.capability()}) {
+				return fmt.Errorf("plan %q: pool %q does not support access mode %s", p.Name, pool.Name, p.capability().GetAccessMode().GetMode())
+			}
+		}
+	}
+	return nil
+}
+
+// plan returns the plan planId of service serviceId.
+func (c Config) plan(serviceId, planId string) (Plan, bool) {
+	for _, s := range c.Services {
+		if s.ID != serviceId {
+			continue
+		}
+		for _, p := range s.Plans {
+			if p.ID == planId {
+				return p, true
+			}
+		}
+	}
+	return Plan{}, false
+}
+
+func (p Plan) capability() *VolumeCapability {
+	mode := p.AccessMode
+	if mode == "" {
+		mode = DefaultAccessMode
+	}
+	return &VolumeCapability{
+		AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}},
+		AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_Mode(VolumeCapability_AccessMode_Mode_value[mode])},
+	}
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/ioutilshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/local-controller-plugin/admin"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/faults"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/status"
+)
+
+// adminCommands are the subcommands that inspect and repair the state instead
+// of running the server. Each takes the words of its name followed by flags.
+var adminCommands = map[string]func(*adminCommand) error{
+	"volumes list":     listVolumes,
+	"volumes show":     showVolume,
+	"volumes usage":    volumeUsage,
+	"volumes export":   exportVolume,
+	"volumes import":   importVolume,
+	"snapshots list":   listSnapshots,
+	"snapshots export": exportSnapshot,
+	"backups list":     listBackups,
+	"backups upload":   uploadBackups,
+	"gc":               gc,
+	"state export":     exportState,
+	"state import":     importState,
+	"faults show":      showFaults,
+	"faults set":       setFaults,
+	"faults clear":     clearFaults,
+}
+
+// readOnlyCommands only inspect the state, so offline they load it without
+// setting up the pools' filesystems or saving it.
+var readOnlyCommands = map[string]bool{
+	"volumes list":     true,
+	"volumes show":     true,
+	"volumes usage":    true,
+	"volumes export":   true,
+	"snapshots list":   true,
+	"snapshots export": true,
+	"backups list":     true,
+	"state export":     true,
+}
+
+const adminUsage = `usage: localcontrollerplugin <command> [flags] [args]
+
+commands:
+  volumes list
+  volumes show <volume-id>
+  volumes usage
+  volumes export <volume-id>
+  volumes import -name <name> <file|->
+  snapshots list
+  snapshots export <snapshot-id>
+  backups list
+  backups upload
+  gc
+  state export
+  state import <file|->
+  faults show
+  faults set <file|->
+  faults clear
+  client <rpc>
+
+With -adminAddr the command calls a running server, on a unix socket or a
+loopback address. Otherwise it works on the state file and storage root
+directly, and the server must be stopped. state import replaces every
+recorded volume and snapshot with those in the file.
+The faults commands need a server started with -enableFaultInjection.
+See client -h for calling the CSI RPCs.
+`
+
+type adminCommand struct {
+	flags   *flag.FlagSet
+	backend admin.Backend
+	ctx     context.Context
+	stdout  io.Writer
+	stdin   io.Reader
+
+	adminAddress  string
+	configPath    string
+	mountPathRoot string
+	statePath     string
+	timeout       time.Duration
+	json          bool
+	dryRun        bool
+	refresh       bool
+	output        string
+	name          string
+	pool          string
+}
+
+// isAdminCommand reports whether args start with the first word of an admin
+// command rather than with the server's flags.
+func isAdminCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	for name := range adminCommands {
+		if strings.SplitN(name, " ", 2)[0] == args[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// runAdmin runs the admin command named by args and returns the exit status.
+func runAdmin(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var name string
+	var run func(*adminCommand) error
+	for words := 1; words <= 2 && words <= len(args); words++ {
+		name = strings.Join(args[:words], " ")
+		if run = adminCommands[name]; run != nil {
+			args = args[words:]
+			break
+		}
+	}
+	if run == nil {
+		fmt.Fprint(stderr, adminUsage)
+		return 2
+	}
+
+	cmd := &adminCommand{
+		flags:  flag.NewFlagSet(name, flag.ContinueOnError),
+		stdout: stdout,
+		stdin:  stdin,
+	}
+	cmd.flags.SetOutput(stderr)
+	cmd.flags.StringVar(&cmd.adminAddress, "adminAddr", "", "unix:///path or loopback host:port of a running server's admin endpoint")
+	cmd.flags.StringVar(&cmd.configPath, "configPath", "", "path to a JSON file describing the storage pools (offline)")
+	cmd.flags.StringVar(&cmd.mountPathRoot, "mountPathRoot", "", "root directory of the default storage pool (offline)")
+	cmd.flags.StringVar(&cmd.statePath, "statePath", "", "path of the controller's state file (offline)")
+	// archives of large volumes take a while to stream, and backups to upload
+	timeout := time.Minute
+	if strings.HasSuffix(name, " export") || strings.HasSuffix(name, " import") || name == "backups upload" {
+		timeout = time.Hour
+	}
+	cmd.flags.DurationVar(&cmd.timeout, "timeout", timeout, "how long to wait for the command to complete")
+	switch name {
+	case "gc":
+		cmd.flags.BoolVar(&cmd.dryRun, "dryRun", false, "list what would be removed without removing it")
+	case "volumes usage":
+		cmd.flags.BoolVar(&cmd.json, "json", false, "print JSON instead of a table")
+		cmd.flags.BoolVar(&cmd.refresh, "refresh", false, "measure the volumes again instead of showing the server's cached usage (always done offline)")
+	case "state export":
+		cmd.flags.StringVar(&cmd.output, "o", "", "file to write the state to (stdout if empty)")
+	case "volumes export", "snapshots export":
+		cmd.flags.StringVar(&cmd.output, "o", "", "file to write the tar.gz archive to (stdout if empty)")
+	case "volumes import":
+		cmd.flags.StringVar(&cmd.name, "name", "", "name of the volume to create")
+		cmd.flags.StringVar(&cmd.pool, "pool", "", "pool to create the volume in (the default pool if empty)")
+	case "state import", "backups upload", "faults show", "faults set", "faults clear":
+		// print JSON or a short report
+	default:
+		cmd.flags.BoolVar(&cmd.json, "json", false, "print JSON instead of a table")
+	}
+	if err := cmd.flags.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.timeout)
+	defer cancel()
+	cmd.ctx = ctx
+
+	readOnly := readOnlyCommands[name] || (name == "gc" && cmd.dryRun)
+	err := cmd.connect(readOnly, name == "state import", func() error { return run(cmd) })
+	if err != nil {
+		if s, ok := status.FromError(err); ok {
+			err = errors.New(s.Message())
+		}
+		fmt.Fprintf(stderr, "%s: %s\n", name, err.Error())
+		return 1
+	}
+	return 0
+}
+
+// connect sets up the backend, online or offline, for the duration of run.
+// Offline, a read-only command only loads the state, and a state that fails
+// to recover is only acceptable to commands that replace it.
+func (cmd *adminCommand) connect(readOnly, replacesState bool, run func() error) error {
+	if cmd.adminAddress != "" {
+		conn, err := admin.Dial(cmd.adminAddress)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		cmd.backend = admin.NewClient(conn)
+		return run()
+	}
+
+	if cmd.statePath == "" {
+		return errors.New("either -adminAddr or -statePath is required")
+	}
+	config, err := loadConfig(cmd.configPath, cmd.mountPathRoot)
+	if err != nil {
+		return err
+	}
+
+	registry := controller.NewFileRegistry(&osshim.OsShim{}, &ioutilshim.IoutilShim{}, cmd.statePath)
+	cs := controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), controller.NewSubvolumes(), registry, config)
+	logger := lager.NewLogger("localcontrollerplugin-admin")
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.ERROR))
+	cs.SetLogger(logger)
+
+	load := cs.Recover
+	if readOnly {
+		load = cs.Load
+	}
+	if err := load(); err != nil && !replacesState {
+		return fmt.Errorf("cannot load %s: %s", cmd.statePath, err.Error())
+	}
+	cmd.backend = cs
+	return run()
+}
+
+func (cmd *adminCommand) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(cmd.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func listVolumes(cmd *adminCommand) error {
+	volumes, err := cmd.backend.Volumes(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		return cmd.printJSON(&admin.VolumesResponse{Volumes: volumes})
+	}
+
+	w := tabwriter.NewWriter(cmd.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPOOL\tCAPACITY\tSTATUS\tPUBLISHED TO\tSOURCE SNAPSHOT")
+	for _, v := range volumes {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			v.VolumeId, v.Pool, v.CapacityBytes, volumeStatus(v), strings.Join(publishedNodes(v), ","), v.SourceSnapshotId)
+	}
+	return w.Flush()
+}
+
+func showVolume(cmd *adminCommand) error {
+	if cmd.flags.NArg() != 1 {
+		return errors.New("a volume id is required")
+	}
+
+	v, err := cmd.backend.Volume(cmd.ctx, cmd.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		return cmd.printJSON(&admin.VolumeResponse{Volume: v})
+	}
+
+	w := tabwriter.NewWriter(cmd.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", v.VolumeId)
+	fmt.Fprintf(w, "Pool:\t%s\n", v.Pool)
+	fmt.Fprintf(w, "Name:\t%s\n", v.Name)
+	fmt.Fprintf(w, "Capacity:\t%d\n", v.CapacityBytes)
+	fmt.Fprintf(w, "Status:\t%s\n", volumeStatus(v))
+	fmt.Fprintf(w, "Published to:\t%s\n", strings.Join(publishedNodes(v), ","))
+	fmt.Fprintf(w, "Source snapshot:\t%s\n", v.SourceSnapshotId)
+	if len(v.Layers) > 0 {
+		fmt.Fprintf(w, "Layers:\t%s\n", strings.Join(v.Layers, ","))
+	}
+	return w.Flush()
+}
+
+func volumeUsage(cmd *adminCommand) error {
+	// offline there is no cache to show
+	usage, err := cmd.backend.Usage(cmd.ctx, cmd.refresh || cmd.adminAddress == "")
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		return cmd.printJSON(&admin.UsageResponse{Usage: usage})
+	}
+
+	w := tabwriter.NewWriter(cmd.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCAPACITY\tUSED BYTES\tUSED INODES\tUPDATED\tSOFT LIMIT")
+	for _, u := range usage {
+		softLimit := ""
+		if u.AboveSoftLimit {
+			softLimit = "exceeded"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\n",
+			u.VolumeId, u.CapacityBytes, u.UsedBytes, u.UsedInodes, u.UpdatedAt.UTC().Format(time.RFC3339), softLimit)
+	}
+	return w.Flush()
+}
+
+func listSnapshots(cmd *adminCommand) error {
+	snapshots, err := cmd.backend.Snapshots(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		return cmd.printJSON(&admin.SnapshotsResponse{Snapshots: snapshots})
+	}
+
+	w := tabwriter.NewWriter(cmd.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSOURCE VOLUME\tSIZE\tCREATED\tREADY\tBACKED UP")
+	for _, s := range snapshots {
+		backedUp := ""
+		if s.BackedUpAt != 0 {
+			backedUp = time.Unix(0, s.BackedUpAt).UTC().Format(time.RFC3339)
+		} else if s.Backup {
+			backedUp = "pending"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%t\t%s\n",
+			s.SnapshotId, s.SourceVolumeId, s.SizeBytes, time.Unix(0, s.CreatedAt).UTC().Format(time.RFC3339), s.ReadyToUse, backedUp)
+	}
+	return w.Flush()
+}
+
+func listBackups(cmd *adminCommand) error {
+	backups, err := cmd.backend.Backups(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		return cmd.printJSON(&admin.BackupsResponse{Backups: backups})
+	}
+
+	w := tabwriter.NewWriter(cmd.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSOURCE VOLUME\tSIZE\tCREATED\tUPLOADED")
+	for _, b := range backups {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+			b.BackupId, b.SourceVolumeId, b.SizeBytes, time.Unix(0, b.CreatedAt).UTC().Format(time.RFC3339), time.Unix(0, b.UploadedAt).UTC().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func uploadBackups(cmd *adminCommand) error {
+	if err := cmd.backend.UploadBackups(cmd.ctx); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.stdout, "uploaded pending backups and applied the retention policy")
+	return nil
+}
+
+func exportVolume(cmd *adminCommand) error {
+	if cmd.flags.NArg() != 1 {
+		return errors.New("a volume id is required")
+	}
+	return cmd.writeArchive(func(w io.Writer) error {
+		return cmd.backend.ExportVolume(cmd.ctx, cmd.flags.Arg(0), w)
+	})
+}
+
+func exportSnapshot(cmd *adminCommand) error {
+	if cmd.flags.NArg() != 1 {
+		return errors.New("a snapshot id is required")
+	}
+	return cmd.writeArchive(func(w io.Writer) error {
+		return cmd.backend.ExportSnapshot(cmd.ctx, cmd.flags.Arg(0), w)
+	})
+}
+
+// writeArchive runs export on -o, or stdout. A file is removed again when
+// the export fails, rather than leaving a truncated archive.
+func (cmd *adminCommand) writeArchive(export func(io.Writer) error) error {
+	if cmd.output == "" {
+		return export(cmd.stdout)
+	}
+
+	f, err := os.OpenFile(cmd.output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = export(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(cmd.output)
+	}
+	return err
+}
+
+func importVolume(cmd *adminCommand) error {
+	if cmd.flags.NArg() != 1 {
+		return errors.New("an archive to import, or - for stdin, is required")
+	}
+	if cmd.name == "" {
+		return errors.New("-name is required")
+	}
+
+	var r io.Reader = cmd.stdin
+	if path := cmd.flags.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	v, err := cmd.backend.ImportVolume(cmd.ctx, cmd.name, cmd.pool, r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.stdout, "imported %s with capacity %d\n", v.VolumeId, v.CapacityBytes)
+	return nil
+}
+
+func gc(cmd *adminCommand) error {
+	paths, err := cmd.backend.GC(cmd.ctx, cmd.dryRun)
+	for _, path := range paths {
+		if cmd.dryRun {
+			fmt.Fprintf(cmd.stdout, "would remove %s\n", path)
+		} else {
+			fmt.Fprintf(cmd.stdout, "removed %s\n", path)
+		}
+	}
+	return err
+}
+
+func exportState(cmd *adminCommand) error {
+	state, err := cmd.backend.ExportState(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	if cmd.output == "" {
+		return cmd.printJSON(state)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cmd.output, append(data, '\n'), 0600)
+}
+
+func importState(cmd *adminCommand) error {
+	if cmd.flags.NArg() != 1 {
+		return errors.New("a file to import, or - for stdin, is required")
+	}
+
+	var data []byte
+	var err error
+	if path := cmd.flags.Arg(0); path == "-" {
+		data, err = ioutil.ReadAll(cmd.stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	state := controller.NewState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("invalid state: %s", err.Error())
+	}
+	if err := cmd.backend.ImportState(cmd.ctx, state); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.stdout, "imported %d volumes and %d snapshots\n", len(state.Volumes), len(state.Snapshots))
+	return nil
+}
+
+func (cmd *adminCommand) faultInjector() (admin.FaultInjector, error) {
+	injector, ok := cmd.backend.(admin.FaultInjector)
+	if !ok {
+		return nil, errors.New("fault injection needs -adminAddr of a running server")
+	}
+	return injector, nil
+}
+
+func showFaults(cmd *adminCommand) error {
+	injector, err := cmd.faultInjector()
+	if err != nil {
+		return err
+	}
+	config, err := injector.Faults(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	return cmd.printJSON(config)
+}
+
+func setFaults(cmd *adminCommand) error {
+	if cmd.flags.NArg() != 1 {
+		return errors.New("a file of faults, or - for stdin, is required")
+	}
+	injector, err := cmd.faultInjector()
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if path := cmd.flags.Arg(0); path == "-" {
+		data, err = ioutil.ReadAll(cmd.stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	config, err := faults.ParseConfig(data)
+	if err != nil {
+		return fmt.Errorf("invalid faults: %s", err.Error())
+	}
+	if err := injector.SetFaults(cmd.ctx, &config); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.stdout, "injecting %d rules\n", len(config.Rules))
+	return nil
+}
+
+func clearFaults(cmd *adminCommand) error {
+	injector, err := cmd.faultInjector()
+	if err != nil {
+		return err
+	}
+	if err := injector.SetFaults(cmd.ctx, &faults.Config{}); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.stdout, "cleared faults")
+	return nil
+}
+
+// faultInjectingBackend serves the faults commands alongside the others
+// when the server runs with fault injection enabled.
+type faultInjectingBackend struct {
+	*controller.Controller
+	*faults.Injector
+}
+
+func volumeStatus(v *controller.LocalVolume) string {
+	switch {
+	case v.Missing:
+		return "missing"
+	case v.Incomplete:
+		return "incomplete"
+	default:
+		return "ready"
+	}
+}
+
+func publishedNodes(v *controller.LocalVolume) []string {
+	nodes := []string{}
+	for node := range v.PublishedNodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
@@ -0,0 +1,258 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+var _ = Describe("Admin commands", func() {
+	var (
+		stateDir  string
+		statePath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		stateDir, err = ioutil.TempDir("", "local-controller-plugin")
+		Expect(err).NotTo(HaveOccurred())
+		statePath = filepath.Join(stateDir, "state.json")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(stateDir)
+	})
+
+	run := func(args ...string) *gexec.Session {
+		session, err := gexec.Start(exec.Command(driverPath, args...), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		return session.Wait(10)
+	}
+
+	Context("offline", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(statePath, []byte(`{
+				"volumes": {"default:vol": {"volume_id": "default:vol", "pool": "default", "name": "vol", "capacity_bytes": 1024, "published_nodes": {"node-1": true}}},
+				"snapshots": {"default:snap": {"snapshot_id": "default:snap", "pool": "default", "name": "snap", "source_volume_id": "default:vol", "ready_to_use": true}}
+			}`), 0600)).To(Succeed())
+		})
+
+		It("lists volumes as a table", func() {
+			session := run("volumes", "list", "-statePath", statePath, "-mountPathRoot", stateDir)
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(`ID\s+POOL\s+CAPACITY\s+STATUS\s+PUBLISHED TO`))
+			Expect(session.Out).To(gbytes.Say(`default:vol\s+default\s+1024\s+ready\s+node-1`))
+		})
+
+		It("shows a volume as JSON", func() {
+			session := run("volumes", "show", "-json", "-statePath", statePath, "-mountPathRoot", stateDir, "default:vol")
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out.Contents()).To(MatchJSON(`{"volume": {"volume_id": "default:vol", "pool": "default", "name": "vol", "capacity_bytes": 1024, "published_nodes": {"node-1": true}}}`))
+		})
+
+		It("measures volume usage", func() {
+			Expect(os.MkdirAll(filepath.Join(stateDir, "_volumes", "vol"), 0750)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(stateDir, "_volumes", "vol", "data"), make([]byte, 4096), 0600)).To(Succeed())
+
+			session := run("volumes", "usage", "-statePath", statePath, "-mountPathRoot", stateDir)
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(`ID\s+CAPACITY\s+USED BYTES\s+USED INODES\s+UPDATED\s+SOFT LIMIT`))
+			Expect(session.Out).To(gbytes.Say(`default:vol\s+1024\s+\d+\s+2\s+\S+\s+exceeded`))
+		})
+
+		It("lists snapshots", func() {
+			session := run("snapshots", "list", "-statePath", statePath, "-mountPathRoot", stateDir)
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(`default:snap\s+default:vol\s+0\s+\S+\s+true`))
+		})
+
+		It("removes unrecorded directories with gc", func() {
+			orphan := filepath.Join(stateDir, "_volumes", "lost")
+			Expect(os.MkdirAll(orphan, 0700)).To(Succeed())
+
+			session := run("gc", "-dryRun", "-statePath", statePath, "-mountPathRoot", stateDir)
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("would remove " + orphan))
+			Expect(orphan).To(BeADirectory())
+
+			session = run("gc", "-statePath", statePath, "-mountPathRoot", stateDir)
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("removed " + orphan))
+			Expect(orphan).NotTo(BeADirectory())
+		})
+
+		It("exports and imports the state, even over a broken state file", func() {
+			exported := filepath.Join(stateDir, "exported.json")
+			session := run("state", "export", "-o", exported, "-statePath", statePath, "-mountPathRoot", stateDir)
+			Expect(session).To(gexec.Exit(0))
+
+			Expect(ioutil.WriteFile(statePath, []byte(`{"volumes": {"wrong": {"volume_id": "default:vol"}}}`), 0600)).To(Succeed())
+			session = run("volumes", "list", "-statePath", statePath, "-mountPathRoot", stateDir)
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("cannot load"))
+
+			session = run("state", "import", "-statePath", statePath, "-mountPathRoot", stateDir, exported)
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("imported 1 volumes and 1 snapshots"))
+			Expect(ioutil.ReadFile(statePath)).To(ContainSubstring(`"default:snap"`))
+		})
+
+		It("requires a state file", func() {
+			session := run("volumes", "list")
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("either -adminAddr or -statePath is required"))
+		})
+
+		It("leaves the state file alone for read-only commands", func() {
+			before, err := ioutil.ReadFile(statePath)
+			Expect(err).NotTo(HaveOccurred())
+			root := filepath.Join(stateDir, "root")
+
+			for _, command := range []string{"volumes list", "volumes show default:vol", "snapshots list", "state export", "gc -dryRun"} {
+				words := strings.Fields(command)
+				args := append([]string{}, words[:1]...)
+				if len(words) > 1 {
+					args = append(args, words[1])
+				}
+				args = append(args, "-statePath", statePath, "-mountPathRoot", root)
+				if len(words) > 2 {
+					args = append(args, words[2:]...)
+				}
+				Expect(run(args...)).To(gexec.Exit(0), command)
+			}
+			Expect(ioutil.ReadFile(statePath)).To(Equal(before))
+			Expect(root).NotTo(BeAnExistingFile())
+		})
+
+		It("prints usage for an unknown command", func() {
+			session := run("volumes", "frobnicate")
+			Expect(session).To(gexec.Exit(2))
+			Expect(session.Err).To(gbytes.Say("usage: localcontrollerplugin"))
+
+			session = run("frobnicate")
+			Expect(session).To(gexec.Exit(2))
+			Expect(session.Err).To(gbytes.Say(`unknown command "frobnicate"`))
+		})
+	})
+
+	Context("against a running server", func() {
+		var server *gexec.Session
+
+		BeforeEach(func() {
+			var err error
+			server, err = gexec.Start(exec.Command(driverPath,
+				"-listenAddr", "127.0.0.1:9863",
+				"-adminAddr", "127.0.0.1:9864",
+				"-mountPathRoot", stateDir,
+				"-statePath", statePath,
+			), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(server).Should(gbytes.Say("recovered"))
+
+			conn, err := grpc.Dial("127.0.0.1:9863", grpc.WithInsecure())
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+			Eventually(func() error {
+				_, err := NewControllerClient(conn).CreateVolume(context.Background(), &CreateVolumeRequest{Name: "vol", VolumeCapabilities: mountCapabilities})
+				return err
+			}, 5).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			server.Kill().Wait()
+		})
+
+		It("lists volumes as JSON", func() {
+			session := run("volumes", "list", "-json", "-adminAddr", "127.0.0.1:9864")
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out.Contents()).To(MatchJSON(`{"volumes": [{"volume_id": "default:vol", "pool": "default", "name": "vol", "capacity_bytes": 0, "published_nodes": {}}]}`))
+		})
+
+		It("shows the volume usage the server measured", func() {
+			session := run("volumes", "usage", "-json", "-refresh", "-adminAddr", "127.0.0.1:9864")
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(`"volume_id": "default:vol"`))
+			Expect(session.Out).To(gbytes.Say(`"used_inodes": 1`))
+		})
+
+		It("exports a volume and imports the archive as another volume", func() {
+			Expect(ioutil.WriteFile(filepath.Join(stateDir, "_volumes", "vol", "data"), []byte("hello"), 0640)).To(Succeed())
+			archive := filepath.Join(stateDir, "vol.tar.gz")
+
+			session := run("volumes", "export", "-adminAddr", "127.0.0.1:9864", "-o", archive, "default:vol")
+			Expect(session).To(gexec.Exit(0))
+			Expect(archive).To(BeARegularFile())
+
+			session = run("volumes", "import", "-adminAddr", "127.0.0.1:9864", "-name", "copy", archive)
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("imported default:copy with capacity 0"))
+			Expect(ioutil.ReadFile(filepath.Join(stateDir, "_volumes", "copy", "data"))).To(Equal([]byte("hello")))
+
+			session = run("volumes", "import", "-adminAddr", "127.0.0.1:9864", "-name", "copy", archive)
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say(`volumes import: Volume "default:copy" already exists`))
+		})
+
+		It("reports errors from the server", func() {
+			session := run("volumes", "show", "-adminAddr", "127.0.0.1:9864", "default:nope")
+			Expect(session).To(gexec.Exit(1))
+			Expect(strings.TrimSpace(string(session.Err.Contents()))).To(Equal(`volumes show: Volume "default:nope" does not exist`))
+		})
+	})
+
+	Context("on a unix socket", func() {
+		var (
+			server *gexec.Session
+			socket string
+		)
+
+		BeforeEach(func() {
+			socket = filepath.Join(stateDir, "admin.sock")
+			var err error
+			server, err = gexec.Start(exec.Command(driverPath,
+				"-listenAddr", "127.0.0.1:9869",
+				"-adminAddr", "unix://"+socket,
+				"-mountPathRoot", stateDir,
+				"-statePath", statePath,
+			), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(server).Should(gbytes.Say("started"))
+		})
+
+		AfterEach(func() {
+			server.Kill().Wait()
+		})
+
+		It("makes the socket accessible to the server's user only", func() {
+			info, err := os.Stat(socket)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+			session := run("volumes", "list", "-json", "-adminAddr", "unix://"+socket)
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out.Contents()).To(MatchJSON(`{"volumes": []}`))
+		})
+	})
+
+	It("refuses to serve or call the admin service on a non-loopback address", func() {
+		server, err := gexec.Start(exec.Command(driverPath, "-listenAddr", "127.0.0.1:9869", "-adminAddr", "0.0.0.0:9870", "-mountPathRoot", stateDir), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(server, 5).Should(gexec.Exit())
+		Expect(server.ExitCode()).NotTo(Equal(0))
+		Expect(server.Out).To(gbytes.Say("invalid-admin-address"))
+
+		session := run("volumes", "list", "-adminAddr", "0.0.0.0:9870")
+		Expect(session).To(gexec.Exit(1))
+		Expect(session.Err).To(gbytes.Say("neither a unix socket nor a loopback address"))
+	})
+})
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+type clientRPC struct {
+	newRequest func() proto.Message
+	call       func(context.Context, *grpc.ClientConn, proto.Message) (proto.Message, error)
+}
+
+// clientRPCs are the Identity and Controller RPCs the client command calls.
+var clientRPCs = map[string]clientRPC{
+	"GetPluginInfo": {
+		func() proto.Message { return &GetPluginInfoRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewIdentityClient(conn).GetPluginInfo(ctx, req.(*GetPluginInfoRequest))
+		},
+	},
+	"GetPluginCapabilities": {
+		func() proto.Message { return &GetPluginCapabilitiesRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewIdentityClient(conn).GetPluginCapabilities(ctx, req.(*GetPluginCapabilitiesRequest))
+		},
+	},
+	"Probe": {
+		func() proto.Message { return &ProbeRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewIdentityClient(conn).Probe(ctx, req.(*ProbeRequest))
+		},
+	},
+	"CreateVolume": {
+		func() proto.Message { return &CreateVolumeRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).CreateVolume(ctx, req.(*CreateVolumeRequest))
+		},
+	},
+	"DeleteVolume": {
+		func() proto.Message { return &DeleteVolumeRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).DeleteVolume(ctx, req.(*DeleteVolumeRequest))
+		},
+	},
+	"ControllerPublishVolume": {
+		func() proto.Message { return &ControllerPublishVolumeRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).ControllerPublishVolume(ctx, req.(*ControllerPublishVolumeRequest))
+		},
+	},
+	"ControllerUnpublishVolume": {
+		func() proto.Message { return &ControllerUnpublishVolumeRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).ControllerUnpublishVolume(ctx, req.(*ControllerUnpublishVolumeRequest))
+		},
+	},
+	"ValidateVolumeCapabilities": {
+		func() proto.Message { return &ValidateVolumeCapabilitiesRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).ValidateVolumeCapabilities(ctx, req.(*ValidateVolumeCapabilitiesRequest))
+		},
+	},
+	"ListVolumes": {
+		func() proto.Message { return &ListVolumesRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).ListVolumes(ctx, req.(*ListVolumesRequest))
+		},
+	},
+	"GetCapacity": {
+		func() proto.Message { return &GetCapacityRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).GetCapacity(ctx, req.(*GetCapacityRequest))
+		},
+	},
+	"ControllerGetCapabilities": {
+		func() proto.Message { return &ControllerGetCapabilitiesRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).ControllerGetCapabilities(ctx, req.(*ControllerGetCapabilitiesRequest))
+		},
+	},
+	"CreateSnapshot": {
+		func() proto.Message { return &CreateSnapshotRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).CreateSnapshot(ctx, req.(*CreateSnapshotRequest))
+		},
+	},
+	"DeleteSnapshot": {
+		func() proto.Message { return &DeleteSnapshotRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).DeleteSnapshot(ctx, req.(*DeleteSnapshotRequest))
+		},
+	},
+	"ListSnapshots": {
+		func() proto.Message { return &ListSnapshotsRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).ListSnapshots(ctx, req.(*ListSnapshotsRequest))
+		},
+	},
+	"ControllerExpandVolume": {
+		func() proto.Message { return &ControllerExpandVolumeRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).ControllerExpandVolume(ctx, req.(*ControllerExpandVolumeRequest))
+		},
+	},
+	"ControllerGetVolume": {
+		func() proto.Message { return &ControllerGetVolumeRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).ControllerGetVolume(ctx, req.(*ControllerGetVolumeRequest))
+		},
+	},
+	"ControllerModifyVolume": {
+		func() proto.Message { return &ControllerModifyVolumeRequest{} },
+		func(ctx context.Context, conn *grpc.ClientConn, req proto.Message) (proto.Message, error) {
+			return NewControllerClient(conn).ControllerModifyVolume(ctx, req.(*ControllerModifyVolumeRequest))
+		},
+	},
+}
+
+const clientUsage = `usage: localcontrollerplugin client [flags] <rpc>
+
+Calls an Identity or Controller RPC and prints the response as JSON. The
+request is read from -request, a JSON file using the CSI field names, and
+the other flags set or replace its fields.
+
+rpcs:
+`
+
+// keyValues collects repeated key=value flags into a map.
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	pairs := []string{}
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (kv keyValues) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+		kv[parts[0]] = parts[1]
+	}
+	return nil
+}
+
+// stringList collects repeated flags in order.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, " ") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+type clientCommand struct {
+	endpoint    string
+	requestPath string
+	timeout     time.Duration
+
+	name             string
+	volumeId         string
+	nodeId           string
+	snapshotId       string
+	sourceVolumeId   string
+	sourceSnapshotId string
+	requiredBytes    int64
+	limitBytes       int64
+	capabilities     stringList
+	parameters       keyValues
+	secrets          keyValues
+	volumeContext    keyValues
+	requisite        stringList
+	preferred        stringList
+	topology         keyValues
+	readonly         bool
+	maxEntries       int
+	startingToken    string
+}
+
+// runClient calls the RPC named by args and returns the exit status. An RPC
+// error is printed as JSON too, with its status code, and exits with 1.
+func runClient(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cmd := &clientCommand{
+		parameters:    keyValues{},
+		secrets:       keyValues{},
+		volumeContext: keyValues{},
+		topology:      keyValues{},
+	}
+
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		names := []string{}
+		for name := range clientRPCs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(stderr, "%s  %s\n\nflags:\n", clientUsage, strings.Join(names, "\n  "))
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&cmd.endpoint, "endpoint", "127.0.0.1:9860", "host:port or unix:///path of the plugin")
+	flags.StringVar(&cmd.requestPath, "request", "", "JSON file holding the request, - for stdin")
+	flags.DurationVar(&cmd.timeout, "timeout", time.Minute, "deadline for the RPC")
+	flags.StringVar(&cmd.name, "name", "", "name of the volume or snapshot")
+	flags.StringVar(&cmd.volumeId, "volumeId", "", "volume id")
+	flags.StringVar(&cmd.nodeId, "nodeId", "", "node id")
+	flags.StringVar(&cmd.snapshotId, "snapshotId", "", "snapshot id")
+	flags.StringVar(&cmd.sourceVolumeId, "sourceVolumeId", "", "volume to snapshot, list snapshots of, or clone")
+	flags.StringVar(&cmd.sourceSnapshotId, "sourceSnapshotId", "", "snapshot to create the volume from")
+	flags.Int64Var(&cmd.requiredBytes, "requiredBytes", 0, "required bytes of the capacity range")
+	flags.Int64Var(&cmd.limitBytes, "limitBytes", 0, "limit bytes of the capacity range")
+	flags.Var(&cmd.capabilities, "capability", "volume capability as mount|block,<access mode>[,<fs type>], repeatable")
+	flags.Var(cmd.parameters, "param", "parameter as key=value, repeatable")
+	flags.Var(cmd.secrets, "secret", "secret as key=value, repeatable")
+	flags.Var(cmd.volumeContext, "volumeContext", "volume context entry as key=value, repeatable")
+	flags.Var(&cmd.requisite, "requisite", "requisite topology as key=value[,key=value], repeatable")
+	flags.Var(&cmd.preferred, "preferred", "preferred topology as key=value[,key=value], repeatable")
+	flags.Var(cmd.topology, "topology", "accessible topology of GetCapacity as key=value, repeatable")
+	flags.BoolVar(&cmd.readonly, "readonly", false, "publish the volume read-only")
+	flags.IntVar(&cmd.maxEntries, "maxEntries", 0, "maximum entries to list")
+	flags.StringVar(&cmd.startingToken, "startingToken", "", "token to continue a listing from")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	rpcName := flags.Arg(0)
+	rpc, ok := clientRPCs[rpcName]
+	if !ok {
+		fmt.Fprintf(stderr, "client: unknown rpc %q\n", rpcName)
+		return 2
+	}
+
+	req, err := cmd.request(rpc.newRequest(), flags, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "client: %s: %s\n", rpcName, err.Error())
+		return 2
+	}
+
+	conn, err := grpc.Dial(cmd.endpoint, grpc.WithInsecure())
+	if err != nil {
+		fmt.Fprintf(stderr, "client: %s\n", err.Error())
+		return 1
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.timeout)
+	defer cancel()
+
+	marshaler := &jsonpb.Marshaler{OrigName: true, Indent: "  "}
+	resp, err := rpc.call(ctx, conn, req)
+	if err != nil {
+		s := status.Convert(err)
+		fmt.Fprintf(stdout, "{\n  \"error\": {\n    \"code\": %q,\n    \"message\": %q\n  }\n}\n", s.Code().String(), s.Message())
+		return 1
+	}
+	if err := marshaler.Marshal(stdout, resp); err != nil {
+		fmt.Fprintf(stderr, "client: %s\n", err.Error())
+		return 1
+	}
+	fmt.Fprintln(stdout)
+	return 0
+}
+
+// request reads the -request file into req and applies the flags that were
+// given on the command line. A flag for a field req does not have is an error.
+func (cmd *clientCommand) request(req proto.Message, flags *flag.FlagSet, stdin io.Reader) (proto.Message, error) {
+	if cmd.requestPath != "" {
+		var r io.Reader = stdin
+		if cmd.requestPath != "-" {
+			f, err := os.Open(cmd.requestPath)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		if err := jsonpb.Unmarshal(r, req); err != nil {
+			return nil, fmt.Errorf("invalid request: %s", err.Error())
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if err == nil {
+			err = cmd.apply(req, f.Name)
+		}
+	})
+	return req, err
+}
+
+func (cmd *clientCommand) apply(req proto.Message, flagName string) error {
+	msg := reflect.ValueOf(req).Elem()
+	set := func(field string, value interface{}) error {
+		f := msg.FieldByName(field)
+		if !f.IsValid() {
+			return fmt.Errorf("-%s does not apply", flagName)
+		}
+		f.Set(reflect.ValueOf(value))
+		return nil
+	}
+	has := func(field string) bool { return msg.FieldByName(field).IsValid() }
+
+	switch flagName {
+	case "endpoint", "request", "timeout":
+		return nil
+	case "name":
+		return set("Name", cmd.name)
+	case "volumeId":
+		return set("VolumeId", cmd.volumeId)
+	case "nodeId":
+		return set("NodeId", cmd.nodeId)
+	case "snapshotId":
+		return set("SnapshotId", cmd.snapshotId)
+	case "sourceVolumeId":
+		if has("SourceVolumeId") {
+			return set("SourceVolumeId", cmd.sourceVolumeId)
+		}
+		return set("VolumeContentSource", &VolumeContentSource{Type: &VolumeContentSource_Volume{
+			Volume: &VolumeContentSource_VolumeSource{VolumeId: cmd.sourceVolumeId},
+		}})
+	case "sourceSnapshotId":
+		return set("VolumeContentSource", &VolumeContentSource{Type: &VolumeContentSource_Snapshot{
+			Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: cmd.sourceSnapshotId},
+		}})
+	case "requiredBytes", "limitBytes":
+		return set("CapacityRange", &CapacityRange{RequiredBytes: cmd.requiredBytes, LimitBytes: cmd.limitBytes})
+	case "capability":
+		caps := []*VolumeCapability{}
+		for _, c := range cmd.capabilities {
+			capability, err := parseCapability(c)
+			if err != nil {
+				return err
+			}
+			caps = append(caps, capability)
+		}
+		if has("VolumeCapability") {
+			if len(caps) != 1 {
+				return errors.New("-capability must be given once")
+			}
+			return set("VolumeCapability", caps[0])
+		}
+		return set("VolumeCapabilities", caps)
+	case "param":
+		return set("Parameters", map[string]string(cmd.parameters))
+	case "secret":
+		return set("Secrets", map[string]string(cmd.secrets))
+	case "volumeContext":
+		return set("VolumeContext", map[string]string(cmd.volumeContext))
+	case "requisite", "preferred":
+		requirement := &TopologyRequirement{}
+		for _, t := range cmd.requisite {
+			topology, err := parseTopology(t)
+			if err != nil {
+				return err
+			}
+			requirement.Requisite = append(requirement.Requisite, topology)
+		}
+		for _, t := range cmd.preferred {
+			topology, err := parseTopology(t)
+			if err != nil {
+				return err
+			}
+			requirement.Preferred = append(requirement.Preferred, topology)
+		}
+		return set("AccessibilityRequirements", requirement)
+	case "topology":
+		return set("AccessibleTopology", &Topology{Segments: cmd.topology})
+	case "readonly":
+		return set("Readonly", cmd.readonly)
+	case "maxEntries":
+		return set("MaxEntries", int32(cmd.maxEntries))
+	case "startingToken":
+		return set("StartingToken", cmd.startingToken)
+	}
+	return fmt.Errorf("-%s is not handled", flagName)
+}
+
+// parseCapability parses mount|block,<access mode>[,<fs type>], with the
+// access mode named as in the CSI spec, e.g. SINGLE_NODE_WRITER.
+func parseCapability(value string) (*VolumeCapability, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid capability %q", value)
+	}
+
+	mode, ok := VolumeCapability_AccessMode_Mode_value[strings.ToUpper(parts[1])]
+	if !ok {
+		return nil, fmt.Errorf("unknown access mode %q", parts[1])
+	}
+	capability := &VolumeCapability{AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_Mode(mode)}}
+
+	switch parts[0] {
+	case "mount":
+		mount := &VolumeCapability_MountVolume{}
+		if len(parts) == 3 {
+			mount.FsType = parts[2]
+		}
+		capability.AccessType = &VolumeCapability_Mount{Mount: mount}
+	case "block":
+		if len(parts) == 3 {
+			return nil, fmt.Errorf("block capability %q cannot have a filesystem type", value)
+		}
+		capability.AccessType = &VolumeCapability_Block{Block: &VolumeCapability_BlockVolume{}}
+	default:
+		return nil, fmt.Errorf("unknown access type %q", parts[0])
+	}
+	return capability, nil
+}
+
+func parseTopology(value string) (*Topology, error) {
+	segments := keyValues{}
+	if err := segments.Set(value); err != nil {
+		return nil, err
+	}
+	return &Topology{Segments: segments}, nil
+}
@@ -0,0 +1,99 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("Client command", func() {
+	var (
+		stateDir string
+		server   *gexec.Session
+	)
+
+	BeforeEach(func() {
+		var err error
+		stateDir, err = ioutil.TempDir("", "local-controller-plugin")
+		Expect(err).NotTo(HaveOccurred())
+
+		server, err = gexec.Start(exec.Command(driverPath,
+			"-listenAddr", "127.0.0.1:9865",
+			"-mountPathRoot", stateDir,
+		), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(server).Should(gbytes.Say("recovered"))
+	})
+
+	AfterEach(func() {
+		server.Kill().Wait()
+		os.RemoveAll(stateDir)
+	})
+
+	client := func(stdin string, args ...string) *gexec.Session {
+		command := exec.Command(driverPath, append([]string{"client", "-endpoint", "127.0.0.1:9865"}, args...)...)
+		command.Stdin = bytes.NewBufferString(stdin)
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		return session.Wait(10)
+	}
+
+	It("prints the response as JSON", func() {
+		session := client("", "GetPluginInfo")
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out.Contents()).To(MatchJSON(`{"name": "org.cloudfoundry.code.local-controller-plugin", "vendor_version": "0.1.0"}`))
+	})
+
+	It("builds the request from flags", func() {
+		session := client("", "-name", "vol", "-requiredBytes", "1024", "-capability", "mount,single_node_writer,ext4", "-param", "pool=default", "CreateVolume")
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`"capacity_bytes": "1024"`))
+		Expect(session.Out).To(gbytes.Say(`"volume_id": "default:vol"`))
+
+		session = client("", "-volumeId", "default:vol", "-nodeId", "node-1", "-capability", "mount,SINGLE_NODE_WRITER", "-readonly", "ControllerPublishVolume")
+		Expect(session).To(gexec.Exit(0))
+
+		session = client("", "-maxEntries", "1", "ListVolumes")
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`"published_node_ids": \[\s+"node-1"`))
+	})
+
+	It("reads the request from a JSON file, with flags taking precedence", func() {
+		path := filepath.Join(stateDir, "request.json")
+		Expect(ioutil.WriteFile(path, []byte(`{"name": "from-file", "capacity_range": {"required_bytes": 10}, "volume_capabilities": [{"mount": {}, "access_mode": {"mode": "SINGLE_NODE_WRITER"}}]}`), 0600)).To(Succeed())
+
+		session := client("", "-request", path, "-name", "from-flag", "CreateVolume")
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`"capacity_bytes": "10"`))
+		Expect(session.Out).To(gbytes.Say(`"volume_id": "default:from-flag"`))
+
+		session = client(`{"volume_id": "default:from-flag"}`, "-request", "-", "DeleteVolume")
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out.Contents()).To(MatchJSON(`{}`))
+	})
+
+	It("prints an RPC error as JSON and exits 1", func() {
+		session := client("", "-volumeId", "default:nope", "ControllerGetVolume")
+		Expect(session).To(gexec.Exit(1))
+		Expect(session.Out.Contents()).To(MatchJSON(`{"error": {"code": "NotFound", "message": "Volume \"default:nope\" does not exist"}}`))
+	})
+
+	It("rejects flags the RPC has no field for", func() {
+		session := client("", "-nodeId", "node-1", "CreateVolume")
+		Expect(session).To(gexec.Exit(2))
+		Expect(session.Err).To(gbytes.Say("CreateVolume: -nodeId does not apply"))
+	})
+
+	It("rejects unknown RPCs", func() {
+		session := client("", "FormatDisk")
+		Expect(session).To(gexec.Exit(2))
+		Expect(session.Err).To(gbytes.Say(`unknown rpc "FormatDisk"`))
+	})
+})
@@ -0,0 +1,46 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/local-controller-plugin/conformance"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("The built plugin", func() {
+	var (
+		root    string
+		session *gexec.Session
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "conformance")
+		Expect(err).NotTo(HaveOccurred())
+
+		session, err = gexec.Start(exec.Command(driverPath,
+			"-listenAddr", "unix://"+filepath.Join(root, "csi.sock"),
+			"-mountPathRoot", root,
+		), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session).Should(gbytes.Say("started"))
+	})
+
+	AfterEach(func() {
+		session.Kill().Wait()
+		os.RemoveAll(root)
+	})
+
+	conformance.Describe("over a unix socket", func() conformance.Config {
+		return conformance.Config{
+			Address: "unix://" + filepath.Join(root, "csi.sock"),
+			NodeId:  "node-1",
+		}
+	})
+})
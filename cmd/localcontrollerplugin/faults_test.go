@@ -0,0 +1,121 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/local-controller-plugin/faults"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Fault injection", func() {
+	var (
+		stateDir string
+		server   *gexec.Session
+		conn     *grpc.ClientConn
+		args     []string
+	)
+
+	BeforeEach(func() {
+		var err error
+		stateDir, err = ioutil.TempDir("", "local-controller-plugin")
+		Expect(err).NotTo(HaveOccurred())
+		args = []string{
+			"-listenAddr", "127.0.0.1:9866",
+			"-adminAddr", "127.0.0.1:9867",
+			"-mountPathRoot", stateDir,
+			"-statePath", filepath.Join(stateDir, "state.json"),
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		server, err = gexec.Start(exec.Command(driverPath, args...), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(server).Should(gbytes.Say("recovered"))
+
+		conn, err = grpc.Dial("127.0.0.1:9866", grpc.WithInsecure())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		server.Kill().Wait()
+		os.RemoveAll(stateDir)
+	})
+
+	run := func(args ...string) *gexec.Session {
+		session, err := gexec.Start(exec.Command(driverPath, args...), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		return session.Wait(10)
+	}
+
+	createVolume := func() error {
+		_, err := NewControllerClient(conn).CreateVolume(context.Background(), &CreateVolumeRequest{Name: "vol", VolumeCapabilities: mountCapabilities})
+		return err
+	}
+
+	Context("enabled from the admin commands", func() {
+		BeforeEach(func() {
+			args = append(args, "-enableFaultInjection")
+		})
+
+		It("fails the chosen RPC until the faults are cleared", func() {
+			faultsFile := filepath.Join(stateDir, "faults.json")
+			Expect(ioutil.WriteFile(faultsFile, []byte(`{"rules": [{"method": "CreateVolume", "code": "UNAVAILABLE"}]}`), 0600)).To(Succeed())
+			session := run("faults", "set", "-adminAddr", "127.0.0.1:9867", faultsFile)
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("injecting 1 rules"))
+
+			Expect(status.Code(createVolume())).To(Equal(codes.Unavailable))
+
+			session = run("faults", "clear", "-adminAddr", "127.0.0.1:9867")
+			Expect(session).To(gexec.Exit(0))
+			Expect(createVolume()).To(Succeed())
+		})
+
+		It("reports itself in GetPluginInfo's manifest", func() {
+			resp, err := NewIdentityClient(conn).GetPluginInfo(context.Background(), &GetPluginInfoRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetManifest()).To(HaveKeyWithValue(faults.ManifestEnabledKey, "enabled"))
+		})
+	})
+
+	Context("configured to crash during a registry write", func() {
+		BeforeEach(func() {
+			faultsFile := filepath.Join(stateDir, "faults.json")
+			Expect(ioutil.WriteFile(faultsFile, []byte(`{"crash_on_registry_write": 1}`), 0600)).To(Succeed())
+			args = append(args, "-faultsPath", faultsFile)
+		})
+
+		It("exits, leaving the saved state intact", func() {
+			Expect(createVolume()).NotTo(Succeed())
+			Eventually(server).Should(gexec.Exit(faults.CrashExitCode))
+
+			Expect(filepath.Join(stateDir, "state.json")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(stateDir, "state.json.tmp")).To(BeAnExistingFile())
+		})
+	})
+
+	Context("when not enabled", func() {
+		It("refuses the faults commands and adds nothing to the manifest", func() {
+			session := run("faults", "show", "-adminAddr", "127.0.0.1:9867")
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("Fault injection is not enabled on this server"))
+
+			resp, err := NewIdentityClient(conn).GetPluginInfo(context.Background(), &GetPluginInfoRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetManifest()).NotTo(HaveKey(faults.ManifestEnabledKey))
+		})
+	})
+})
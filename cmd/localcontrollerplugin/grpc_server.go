@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/local-controller-plugin/admin"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// flusher is implemented by handlers that persist state which should be
+// written out once the server has drained.
+type flusher interface {
+	Flush() error
+}
+
+type grpcServerRunner struct {
+	logger        lager.Logger
+	listenAddress string
+	handler       interface{}
+	register      func(*grpc.Server)
+	drainTimeout  time.Duration
+	opts          []grpc.ServerOption
+	inFlight      *inFlightRequests
+	// socketMode, if set, is given to a unix socket once it is listening.
+	socketMode os.FileMode
+}
+
+// newGRPCServer is like ifrit's grpc_server.NewGRPCServer but accepts server
+// options, so that interceptors can be installed, and always registers the
+// CSI services with RegisterServices. On a signal it stops accepting requests
+// and waits up to drainTimeout for the in-flight ones before cancelling them,
+// then as long again for the cancelled ones to return before flushing.
+func newGRPCServer(logger lager.Logger, listenAddress string, handler interface{}, drainTimeout time.Duration, opts ...grpc.ServerOption) ifrit.Runner {
+	return &grpcServerRunner{
+		logger:        logger,
+		listenAddress: listenAddress,
+		handler:       handler,
+		register:      func(s *grpc.Server) { RegisterServices(s, handler) },
+		drainTimeout:  drainTimeout,
+		opts:          opts,
+		inFlight:      &inFlightRequests{requests: map[uint64]string{}},
+	}
+}
+
+// newAdminServer serves the admin service for backend, draining on a signal
+// like the CSI server. A unix socket is accessible to the server's user only.
+func newAdminServer(logger lager.Logger, listenAddress string, backend admin.Backend, drainTimeout time.Duration, opts ...grpc.ServerOption) ifrit.Runner {
+	return &grpcServerRunner{
+		logger:        logger.Session("admin"),
+		listenAddress: listenAddress,
+		register:      func(s *grpc.Server) { admin.RegisterAdminServer(s, backend) },
+		drainTimeout:  drainTimeout,
+		opts:          append(opts, admin.ServerCodec()),
+		inFlight:      &inFlightRequests{requests: map[uint64]string{}},
+		socketMode:    0600,
+	}
+}
+
+func (s *grpcServerRunner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	lis, err := listen(s.listenAddress)
+	if err != nil {
+		return err
+	}
+	if path := strings.TrimPrefix(s.listenAddress, "unix://"); path != s.listenAddress && s.socketMode != 0 {
+		if err := os.Chmod(path, s.socketMode); err != nil {
+			lis.Close()
+			return err
+		}
+	}
+
+	server := grpc.NewServer(append(s.opts, grpc.ChainUnaryInterceptor(s.inFlight.intercept), grpc.ChainStreamInterceptor(s.inFlight.interceptStream))...)
+	s.register(server)
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- server.Serve(lis)
+	}()
+
+	close(ready)
+
+	select {
+	case <-signals:
+	case err = <-errCh:
+		server.Stop()
+		return err
+	}
+
+	return s.drain(server)
+}
+
+// listen accepts host:port or unix:///path, the usual form of a CSI
+// endpoint. A socket left behind by an earlier process is removed first.
+func listen(address string) (net.Listener, error) {
+	if path := strings.TrimPrefix(address, "unix://"); path != address {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+func (s *grpcServerRunner) drain(server *grpc.Server) error {
+	logger := s.logger.Session("shutdown")
+	logger.Info("draining", lager.Data{"in_flight": s.inFlight.methods(), "timeout": s.drainTimeout.String()})
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		logger.Info("drained")
+	case <-time.After(s.drainTimeout):
+		// registry writes replace the state file atomically, so a cancelled
+		// request leaves the last saved state intact
+		logger.Info("drain-timed-out", lager.Data{"cancelled": s.inFlight.methods()})
+		server.Stop()
+		// Stop does not wait for the handlers, which return once they notice
+		// their cancellation; flushing before then could miss their changes
+		if !s.inFlight.wait(s.drainTimeout) {
+			logger.Info("cancelled-requests-still-running", lager.Data{"in_flight": s.inFlight.methods()})
+		}
+	}
+	return s.flush(logger)
+}
+
+// flush writes out the handler's state, giving up after the drain timeout in
+// case a handler that is still running holds it up.
+func (s *grpcServerRunner) flush(logger lager.Logger) error {
+	f, ok := s.handler.(flusher)
+	if !ok {
+		return nil
+	}
+
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- f.Flush()
+	}()
+
+	select {
+	case err := <-flushed:
+		if err != nil {
+			logger.Error("flush-failed", err)
+			return err
+		}
+		logger.Info("flushed")
+	case <-time.After(s.drainTimeout):
+		logger.Info("flush-timed-out")
+	}
+	return nil
+}
+
+// inFlightRequests records the methods of the requests being handled, so that
+// shutdown can report what it cancelled.
+type inFlightRequests struct {
+	lock     sync.Mutex
+	next     uint64
+	requests map[uint64]string
+	// idle is closed once the last request in flight is done
+	idle chan struct{}
+}
+
+func (r *inFlightRequests) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	defer r.add(info.FullMethod)()
+	return handler(ctx, req)
+}
+
+func (r *inFlightRequests) interceptStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	defer r.add(info.FullMethod)()
+	return handler(srv, stream)
+}
+
+// add records a request for method and returns the func that removes it.
+func (r *inFlightRequests) add(method string) func() {
+	r.lock.Lock()
+	id := r.next
+	r.next++
+	if len(r.requests) == 0 {
+		r.idle = make(chan struct{})
+	}
+	r.requests[id] = method
+	r.lock.Unlock()
+
+	return func() {
+		r.lock.Lock()
+		delete(r.requests, id)
+		if len(r.requests) == 0 {
+			close(r.idle)
+		}
+		r.lock.Unlock()
+	}
+}
+
+// wait returns true once no request is in flight, or false if some still are
+// after timeout.
+func (r *inFlightRequests) wait(timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		r.lock.Lock()
+		if len(r.requests) == 0 {
+			r.lock.Unlock()
+			return true
+		}
+		idle := r.idle
+		r.lock.Unlock()
+
+		select {
+		case <-idle:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func (r *inFlightRequests) methods() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	methods := []string{}
+	for _, m := range r.requests {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
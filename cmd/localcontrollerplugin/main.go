@@ -1,29 +1,166 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
 
 	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/ioutilshim"
 	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagerflags"
+	"code.cloudfoundry.org/local-controller-plugin/admin"
+	"code.cloudfoundry.org/local-controller-plugin/broker"
 	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/docker"
+	"code.cloudfoundry.org/local-controller-plugin/faults"
+	"code.cloudfoundry.org/local-controller-plugin/interceptors"
+	"code.cloudfoundry.org/local-controller-plugin/metrics"
+	"code.cloudfoundry.org/local-controller-plugin/tracing"
 	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tedsuo/ifrit"
-	"github.com/tedsuo/ifrit/grpc_server"
+	"github.com/tedsuo/ifrit/grouper"
+	"github.com/tedsuo/ifrit/http_server"
 	"github.com/tedsuo/ifrit/sigmon"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
 
 var atAddress = flag.String(
 	"listenAddr",
 	"0.0.0.0:9860",
-	"host:port to serve on",
+	"host:port or unix:///path to serve on",
+)
+
+var metricsAddress = flag.String(
+	"metricsAddr",
+	"",
+	"host:port to serve Prometheus metrics on (disabled if empty)",
+)
+
+var adminAddress = flag.String(
+	"adminAddr",
+	"",
+	"unix:///path or loopback host:port to serve the admin commands on (disabled if empty)",
+)
+
+var brokerAddress = flag.String(
+	"brokerAddr",
+	"",
+	"host:port to serve the Open Service Broker API on (disabled if empty)",
+)
+
+var brokerConfigPath = flag.String(
+	"brokerConfigPath",
+	"",
+	"path to a JSON file with the broker's credentials, volume driver and catalog (required with -brokerAddr)",
+)
+
+var dockerSocket = flag.String(
+	"dockerSocket",
+	"",
+	"path of a unix socket to serve the Docker volume plugin protocol on, e.g. /run/docker/plugins/local.sock (disabled if empty)",
+)
+
+var mode = flag.String(
+	"mode",
+	"controller",
+	"\"controller\" to serve the Controller service, or \"combined\" to also serve the Node service, publishing volumes on this host",
+)
+
+var nodeID = flag.String(
+	"nodeId",
+	"",
+	"node id the Node service reports in combined mode (defaults to the hostname)",
+)
+
+var drainTimeout = flag.Duration(
+	"drainTimeout",
+	30*time.Second,
+	"how long to wait for in-flight requests on shutdown before cancelling them",
+)
+
+var reconcileInterval = flag.Duration(
+	"reconcileInterval",
+	10*time.Minute,
+	"how often to compare the volume directories with the state file after the check at startup (0 to check only at startup)",
+)
+
+var usageRefreshInterval = flag.Duration(
+	"usageRefreshInterval",
+	time.Minute,
+	"how often to measure the data in each volume after the measurement at startup (0 to disable)",
+)
+
+var backupInterval = flag.Duration(
+	"backupInterval",
+	time.Minute,
+	"how often to upload pending snapshot backups and apply the retention policy, when the config has backups (0 to disable)",
+)
+
+var otlpEndpoint = flag.String(
+	"otlpEndpoint",
+	"",
+	"host:port of an OTLP/gRPC collector to export request traces to (disabled if empty)",
+)
+
+var otlpInsecure = flag.Bool(
+	"otlpInsecure",
+	false,
+	"export traces to -otlpEndpoint without TLS",
+)
+
+var enableFaultInjection = flag.Bool(
+	"enableFaultInjection",
+	false,
+	"let the admin commands make controller RPCs and state file writes fail (for testing COs only)",
+)
+
+var faultsPath = flag.String(
+	"faultsPath",
+	"",
+	"path to a JSON file of faults to inject from startup; implies -enableFaultInjection",
+)
+
+var configPath = flag.String(
+	"configPath",
+	"",
+	"path to a JSON file describing the storage pools",
+)
+
+var mountPathRoot = flag.String(
+	"mountPathRoot",
+	"",
+	"root directory of the default storage pool, required when no -configPath is given",
+)
+
+var statePath = flag.String(
+	"statePath",
+	"",
+	"path of the file the controller persists its volumes to (kept in memory if empty)",
 )
 
 ////CreateVolume will have been defined under controller.
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		os.Exit(runClient(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+	if isAdminCommand(os.Args[1:]) {
+		os.Exit(runAdmin(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	parseCommandLine()
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", flag.Arg(0), adminUsage)
+		os.Exit(2)
+	}
 
 	logger, _ := lagerflags.NewFromConfig("local-contoller-plugin", lagerflags.ConfigFromFlags())
 	logger.Info("starting")
@@ -31,13 +168,152 @@ func main() {
 
 	listenAddress := *atAddress
 
-	controller := controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, "")
-	server := grpc_server.NewGRPCServer(listenAddress, nil, controller, RegisterServices)
+	config, err := loadConfig(*configPath, *mountPathRoot)
+	if err != nil {
+		logger.Fatal("invalid-config", err)
+	}
 
-	monitor := ifrit.Invoke(sigmon.New(server))
+	var injector *faults.Injector
+	if *enableFaultInjection || *faultsPath != "" {
+		injector, err = newInjector(logger, *faultsPath)
+		if err != nil {
+			logger.Fatal("invalid-faults", err)
+		}
+		logger.Info("fault-injection-enabled", lager.Data{"faults": injector.Config()})
+	}
+
+	registry := controller.NewMemoryRegistry()
+	if *statePath != "" {
+		var ioutilShim ioutilshim.Ioutil = &ioutilshim.IoutilShim{}
+		if injector != nil {
+			ioutilShim = injector.Ioutil(ioutilShim)
+		}
+		registry = controller.NewFileRegistry(&osshim.OsShim{}, ioutilShim, *statePath)
+	}
+
+	controller := controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), controller.NewSubvolumes(), registry, config)
+	var unaryInterceptors []grpc.UnaryServerInterceptor
+	shutdownTracing := func(context.Context) error { return nil }
+	if *otlpEndpoint != "" {
+		shutdownTracing, err = tracing.Setup(context.Background(), *otlpEndpoint, *otlpInsecure)
+		if err != nil {
+			logger.Fatal("tracing-setup-failed", err)
+		}
+		unaryInterceptors = append(unaryInterceptors, tracing.UnaryServerInterceptor())
+	}
+
+	members := grouper.Members{}
+	if *metricsAddress != "" {
+		registry := prometheus.NewRegistry()
+		m := metrics.New(registry, controller)
+		unaryInterceptors = append(unaryInterceptors, m.UnaryServerInterceptor())
+		members = append(members, grouper.Member{
+			Name:   "metrics-server",
+			Runner: http_server.New(*metricsAddress, promhttp.HandlerFor(registry, promhttp.HandlerOpts{})),
+		})
+	}
+	unaryInterceptors = append(unaryInterceptors, interceptors.Chain(logger)...)
+	// after logging and metrics, so that injected failures show up in both
+	if injector != nil {
+		unaryInterceptors = append(unaryInterceptors, injector.UnaryServerInterceptor())
+	}
+
+	var handler interface{} = controller
+	switch *mode {
+	case "controller":
+	case "combined":
+		n, err := newNode(logger, controller, config)
+		if err != nil {
+			logger.Fatal("node-setup-failed", err)
+		}
+		handler = combinedServer{controller, n}
+	default:
+		logger.Fatal("invalid-mode", fmt.Errorf("unknown mode %q", *mode))
+	}
+
+	server := newGRPCServer(logger, listenAddress, handler, *drainTimeout, grpc.ChainUnaryInterceptor(unaryInterceptors...))
+	members = append(grouper.Members{{Name: "grpc-server", Runner: server}}, members...)
+	if *adminAddress != "" {
+		if err := admin.CheckAddress(*adminAddress); err != nil {
+			logger.Fatal("invalid-admin-address", err)
+		}
+		var backend admin.Backend = controller
+		if injector != nil {
+			backend = faultInjectingBackend{controller, injector}
+		}
+		members = append(members, grouper.Member{
+			Name:   "admin-server",
+			Runner: newAdminServer(logger, *adminAddress, backend, *drainTimeout, grpc.ChainUnaryInterceptor(interceptors.AdminChain(logger)...)),
+		})
+	}
+	if *brokerAddress != "" {
+		brokerConfig, err := loadBrokerConfig(*brokerConfigPath, config)
+		if err != nil {
+			logger.Fatal("invalid-broker-config", err)
+		}
+		handler, err := broker.New(logger, controller, brokerConfig, config)
+		if err != nil {
+			logger.Fatal("loading-bindings-failed", err)
+		}
+		members = append(members, grouper.Member{
+			Name:   "broker",
+			Runner: http_server.New(*brokerAddress, handler),
+		})
+	}
+	if *dockerSocket != "" {
+		// a socket left behind by an earlier process would fail the listen
+		if err := os.Remove(*dockerSocket); err != nil && !os.IsNotExist(err) {
+			logger.Fatal("removing-docker-socket-failed", err)
+		}
+		members = append(members, grouper.Member{
+			Name:   "docker-plugin",
+			Runner: http_server.NewUnixServer(*dockerSocket, docker.New(logger, controller)),
+		})
+	}
+	if *reconcileInterval > 0 {
+		reconcile := func(context.Context) error {
+			_, err := controller.Reconcile()
+			return err
+		}
+		members = append(members, grouper.Member{
+			Name:   "reconciler",
+			Runner: newPeriodicRunner(logger.Session("reconciler"), "reconcile", *reconcileInterval, reconcile),
+		})
+	}
+
+	if *usageRefreshInterval > 0 {
+		members = append(members, grouper.Member{
+			Name:   "usage-refresher",
+			Runner: newPeriodicRunner(logger.Session("usage-refresher"), "refresh-usage", *usageRefreshInterval, controller.RefreshUsage),
+		})
+	}
+
+	if config.Backups != nil && *backupInterval > 0 {
+		members = append(members, grouper.Member{
+			Name:   "backup-uploader",
+			Runner: newPeriodicRunner(logger.Session("backup-uploader"), "upload-backups", *backupInterval, controller.UploadBackups),
+		})
+	}
+
+	monitor := ifrit.Invoke(sigmon.New(grouper.NewOrdered(os.Interrupt, members)))
 	logger.Info("started")
 
-	err := <-monitor.Wait()
+	// the server is already answering Probe with Ready=false while the state loads
+	if err := controller.Recover(); err != nil {
+		logger.Error("recovery-failed", err)
+	} else if _, err := controller.Reconcile(); err != nil {
+		logger.Error("reconcile-failed", err)
+	} else if *usageRefreshInterval > 0 {
+		if err := controller.RefreshUsage(context.Background()); err != nil {
+			logger.Error("refresh-usage-failed", err)
+		}
+	}
+
+	err = <-monitor.Wait()
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Error("tracing-shutdown-failed", err)
+	}
 
 	if err != nil {
 		logger.Fatal("exited-with-failure", err)
@@ -49,7 +325,52 @@ func parseCommandLine() {
 	flag.Parse()
 }
 
+func loadConfig(configPath, mountPathRoot string) (controller.Config, error) {
+	if configPath == "" {
+		config := controller.DefaultConfig(mountPathRoot)
+		return config, config.Validate()
+	}
+
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return controller.Config{}, err
+	}
+	return controller.ParseConfig(data)
+}
+
+func loadBrokerConfig(path string, pools controller.Config) (broker.Config, error) {
+	if path == "" {
+		return broker.Config{}, errors.New("-brokerConfigPath is required with -brokerAddr")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return broker.Config{}, err
+	}
+	config, err := broker.ParseConfig(data)
+	if err != nil {
+		return broker.Config{}, err
+	}
+	return config, config.ValidatePools(pools)
+}
+
+func newInjector(logger lager.Logger, faultsPath string) (*faults.Injector, error) {
+	config := faults.Config{}
+	if faultsPath != "" {
+		data, err := ioutil.ReadFile(faultsPath)
+		if err != nil {
+			return nil, err
+		}
+		if config, err = faults.ParseConfig(data); err != nil {
+			return nil, err
+		}
+	}
+	return faults.NewInjector(logger, &osshim.OsShim{}, config)
+}
+
 func RegisterServices(s *grpc.Server, srv interface{}) {
 	RegisterControllerServer(s, srv.(ControllerServer))
 	RegisterIdentityServer(s, srv.(IdentityServer))
+	if ns, ok := srv.(NodeServer); ok {
+		RegisterNodeServer(s, ns)
+	}
 }
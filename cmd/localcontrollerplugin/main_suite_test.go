@@ -1,6 +1,7 @@
 package main_test
 
 import (
+	. "github.com/container-storage-interface/spec/lib/go/csi"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gexec"
@@ -15,6 +16,11 @@ func TestNfsV3Driver(t *testing.T) {
 
 var driverPath string
 
+var mountCapabilities = []*VolumeCapability{{
+	AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}},
+	AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+}}
+
 var _ = BeforeSuite(func() {
 	var err error
 	driverPath, err = Build("code.cloudfoundry.org/local-controller-plugin/cmd/localcontrollerplugin")
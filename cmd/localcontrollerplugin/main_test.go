@@ -1,23 +1,37 @@
 package main_test
 
 import (
+	"io/ioutil"
 	"net"
+	"net/http"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"syscall"
 
+	. "github.com/container-storage-interface/spec/lib/go/csi"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var _ = Describe("Main", func() {
 	var (
 		session *gexec.Session
 		command *exec.Cmd
+		root    string
 		err     error
 	)
 
 	BeforeEach(func() {
-		command = exec.Command(driverPath)
+		root, err = ioutil.TempDir("", "local-controller-plugin")
+		Expect(err).NotTo(HaveOccurred())
+		command = exec.Command(driverPath, "-mountPathRoot", root)
 	})
 
 	JustBeforeEach(func() {
@@ -27,6 +41,7 @@ var _ = Describe("Main", func() {
 
 	AfterEach(func() {
 		session.Kill().Wait()
+		os.RemoveAll(root)
 	})
 
 	Context("with a driver path", func() {
@@ -37,5 +52,244 @@ var _ = Describe("Main", func() {
 			}, 5).ShouldNot(HaveOccurred())
 		})
 
+		Context("with a metrics address", func() {
+			BeforeEach(func() {
+				command = exec.Command(driverPath, "-mountPathRoot", root, "-metricsAddr", "127.0.0.1:9861")
+			})
+
+			It("serves Prometheus metrics", func() {
+				Eventually(func() (string, error) {
+					resp, err := http.Get("http://127.0.0.1:9861/metrics")
+					if err != nil {
+						return "", err
+					}
+					defer resp.Body.Close()
+					body, err := ioutil.ReadAll(resp.Body)
+					return string(body), err
+				}, 5).Should(ContainSubstring("local_controller_volumes 0"))
+			})
+		})
+
+		Context("with a broker address", func() {
+			var configDir string
+
+			BeforeEach(func() {
+				configDir, err = ioutil.TempDir("", "local-controller-plugin")
+				Expect(err).NotTo(HaveOccurred())
+				brokerConfig := filepath.Join(configDir, "broker.json")
+				Expect(ioutil.WriteFile(brokerConfig, []byte(`{
+					"username": "admin", "password": "secret", "driver": "localdriver", "node_id": "cell-1",
+					"services": [{"id": "service-id", "name": "local-volume", "plans": [{"id": "plan-id", "name": "default"}]}]
+				}`), 0600)).To(Succeed())
+				command = exec.Command(driverPath, "-mountPathRoot", configDir, "-brokerAddr", "127.0.0.1:9868", "-brokerConfigPath", brokerConfig)
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(configDir)
+			})
+
+			It("serves the service broker's catalog", func() {
+				req, err := http.NewRequest("GET", "http://127.0.0.1:9868/v2/catalog", nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.SetBasicAuth("admin", "secret")
+				req.Header.Set("X-Broker-API-Version", "2.16")
+				Eventually(func() (string, error) {
+					resp, err := http.DefaultClient.Do(req)
+					if err != nil {
+						return "", err
+					}
+					defer resp.Body.Close()
+					body, err := ioutil.ReadAll(resp.Body)
+					return string(body), err
+				}, 5).Should(ContainSubstring(`"name":"local-volume"`))
+			})
+		})
+
+		Context("with a Docker socket", func() {
+			var socketDir string
+
+			BeforeEach(func() {
+				socketDir, err = ioutil.TempDir("", "local-controller-plugin")
+				Expect(err).NotTo(HaveOccurred())
+				command = exec.Command(driverPath, "-mountPathRoot", socketDir, "-dockerSocket", filepath.Join(socketDir, "local.sock"))
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(socketDir)
+			})
+
+			It("serves the Docker volume plugin protocol there", func() {
+				client := &http.Client{Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return net.Dial("unix", filepath.Join(socketDir, "local.sock"))
+					},
+				}}
+				Eventually(func() (string, error) {
+					resp, err := client.Post("http://docker/Plugin.Activate", "application/json", nil)
+					if err != nil {
+						return "", err
+					}
+					defer resp.Body.Close()
+					body, err := ioutil.ReadAll(resp.Body)
+					return string(body), err
+				}, 5).Should(MatchJSON(`{"Implements": ["VolumeDriver"]}`))
+			})
+		})
+
+		Context("in combined mode", func() {
+			var socketDir string
+
+			BeforeEach(func() {
+				socketDir, err = ioutil.TempDir("", "local-controller-plugin")
+				Expect(err).NotTo(HaveOccurred())
+				command = exec.Command(driverPath, "-mountPathRoot", socketDir, "-listenAddr", "unix://"+filepath.Join(socketDir, "csi.sock"), "-mode", "combined", "-nodeId", "node-1")
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(socketDir)
+			})
+
+			It("serves the Node service too", func() {
+				conn, err := grpc.Dial("unix://"+filepath.Join(socketDir, "csi.sock"), grpc.WithInsecure())
+				Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+
+				var resp *NodeGetInfoResponse
+				Eventually(func() error {
+					resp, err = NewNodeClient(conn).NodeGetInfo(context.Background(), &NodeGetInfoRequest{})
+					return err
+				}, 5).Should(Succeed())
+				Expect(resp.GetNodeId()).To(Equal("node-1"))
+			})
+		})
+
+		Context("without a storage root", func() {
+			BeforeEach(func() {
+				command = exec.Command(driverPath)
+			})
+
+			It("exits with an error instead of using the working directory", func() {
+				Eventually(session, 5).Should(gexec.Exit())
+				Expect(session.ExitCode()).NotTo(Equal(0))
+				Expect(session.Out).To(gbytes.Say(`invalid-config.*pool \\"default\\": root not configured`))
+			})
+		})
+
+		Context("with an unknown mode", func() {
+			BeforeEach(func() {
+				command = exec.Command(driverPath, "-mountPathRoot", root, "-mode", "sometimes")
+			})
+
+			It("exits with an error", func() {
+				Eventually(session, 5).Should(gexec.Exit())
+				Expect(session.ExitCode()).NotTo(Equal(0))
+				Expect(session.Out).To(gbytes.Say("invalid-mode"))
+			})
+		})
+
+		Context("when signalled during a slow request", func() {
+			var (
+				stateDir  string
+				statePath string
+				fifo      string
+				conn      *grpc.ClientConn
+				result    chan error
+			)
+
+			BeforeEach(func() {
+				stateDir, err = ioutil.TempDir("", "local-controller-plugin")
+				Expect(err).NotTo(HaveOccurred())
+				statePath = filepath.Join(stateDir, "state.json")
+
+				// the registry writes the state to a temporary file first; as a
+				// fifo, that write blocks CreateVolume until the test reads it
+				fifo = statePath + ".tmp"
+				Expect(syscall.Mkfifo(fifo, 0600)).To(Succeed())
+
+				command = exec.Command(driverPath,
+					"-listenAddr", "127.0.0.1:9862",
+					"-mountPathRoot", stateDir,
+					"-statePath", statePath,
+				)
+			})
+
+			JustBeforeEach(func() {
+				Eventually(session).Should(gbytes.Say("started"))
+
+				conn, err = grpc.Dial("127.0.0.1:9862", grpc.WithInsecure())
+				Expect(err).NotTo(HaveOccurred())
+				result = make(chan error, 1)
+				go func() {
+					_, err := NewControllerClient(conn).CreateVolume(context.Background(), &CreateVolumeRequest{Name: "vol", VolumeCapabilities: mountCapabilities})
+					result <- err
+				}()
+				Eventually(session).Should(gbytes.Say("creating-volume"))
+
+				session.Signal(syscall.SIGTERM)
+				Eventually(session).Should(gbytes.Say("draining"))
+			})
+
+			AfterEach(func() {
+				conn.Close()
+				os.RemoveAll(stateDir)
+			})
+
+			It("stops accepting requests, waits for it, and flushes the state", func() {
+				Eventually(func() error {
+					c, err := net.Dial("tcp", "127.0.0.1:9862")
+					if err == nil {
+						c.Close()
+					}
+					return err
+				}, 5).Should(HaveOccurred())
+				Consistently(session.Exited, "500ms").ShouldNot(BeClosed())
+
+				data, err := ioutil.ReadFile(fifo)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(ContainSubstring("default:vol"))
+
+				Eventually(result, 5).Should(Receive(BeNil()))
+				Eventually(session, 5).Should(gexec.Exit(0))
+				Expect(session).To(gbytes.Say("drained"))
+
+				// the fifo was renamed over the state file, so only the flush
+				// leaves a regular file holding the volume
+				info, err := os.Stat(statePath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().IsRegular()).To(BeTrue())
+				Expect(ioutil.ReadFile(statePath)).To(ContainSubstring("default:vol"))
+			})
+
+			Context("when it outlasts the drain timeout", func() {
+				BeforeEach(func() {
+					command.Args = append(command.Args, "-drainTimeout", "1s")
+				})
+
+				It("cancels it, logs what was cancelled, and flushes once it returns", func() {
+					Eventually(session, 5).Should(gbytes.Say(`drain-timed-out.*"cancelled":\["/csi.v1.Controller/CreateVolume"\]`))
+
+					var createErr error
+					Eventually(result, 5).Should(Receive(&createErr))
+					Expect(status.Code(createErr)).To(Equal(codes.Unavailable))
+					Consistently(session.Exited, "200ms").ShouldNot(BeClosed())
+
+					_, err := ioutil.ReadFile(fifo)
+					Expect(err).NotTo(HaveOccurred())
+					Eventually(session, 5).Should(gexec.Exit(0))
+					Expect(session).To(gbytes.Say("flushed"))
+
+					info, err := os.Stat(statePath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Mode().IsRegular()).To(BeTrue())
+					Expect(ioutil.ReadFile(statePath)).To(ContainSubstring("default:vol"))
+				})
+
+				It("gives up on a request that does not return", func() {
+					Eventually(session, 5).Should(gexec.Exit(0))
+					Expect(session).To(gbytes.Say(`cancelled-requests-still-running.*"in_flight":\["/csi.v1.Controller/CreateVolume"\]`))
+					Expect(session).To(gbytes.Say("flush-timed-out"))
+				})
+			})
+		})
 	})
 })
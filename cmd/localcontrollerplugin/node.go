@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/node"
+)
+
+// combinedServer serves the Node service next to the controller's.
+type combinedServer struct {
+	*controller.Controller
+	*node.Node
+}
+
+func newNode(logger lager.Logger, cs *controller.Controller, config controller.Config) (*node.Node, error) {
+	nodeId := *nodeID
+	if nodeId == "" {
+		var err error
+		if nodeId, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	logger.Info("serving-node", lager.Data{"node_id": nodeId})
+	return node.NewNode(logger, &osshim.OsShim{}, node.NewMounter(), cs, node.Config{
+		NodeId:   nodeId,
+		Segments: config.Nodes[nodeId],
+	}), nil
+}
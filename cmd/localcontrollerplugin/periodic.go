@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/net/context"
+)
+
+type periodicRunner struct {
+	logger   lager.Logger
+	name     string
+	interval time.Duration
+	fn       func(ctx context.Context) error
+}
+
+// newPeriodicRunner runs fn every interval until signalled, cancelling a run
+// in progress. Failures are logged as name-failed and retried on the next
+// tick.
+func newPeriodicRunner(logger lager.Logger, name string, interval time.Duration, fn func(ctx context.Context) error) ifrit.Runner {
+	return &periodicRunner{logger: logger, name: name, interval: interval, fn: fn}
+}
+
+func (r *periodicRunner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.fn(ctx); err != nil && ctx.Err() == nil {
+					r.logger.Error(r.name+"-failed", err)
+				}
+			}
+		}
+	}()
+
+	close(ready)
+
+	<-signals
+	cancel()
+	<-done
+	return nil
+}
@@ -0,0 +1,687 @@
+// Package conformance checks a CSI controller plugin against the parts of the
+// CSI spec a CO relies on: idempotency, error codes for missing arguments and
+// missing volumes, capability consistency, pagination and snapshots.
+//
+// The checks are Ginkgo specs that Describe registers in the calling suite,
+// so a suite can run them against any endpoint it starts. RPCs whose
+// capability the plugin does not advertise are skipped.
+package conformance
+
+import (
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Config describes the plugin under test.
+type Config struct {
+	// Address is the plugin's endpoint, host:port or unix:///path.
+	Address string
+	// Parameters are passed to CreateVolume and GetCapacity.
+	Parameters map[string]string
+	// NodeId is the node volumes are published to.
+	NodeId string
+	// VolumeSize is the capacity requested for volumes, 0 to leave it to the plugin.
+	VolumeSize int64
+	// Timeout bounds each RPC; a minute if 0.
+	Timeout time.Duration
+}
+
+// pluginName is the domain name notation the spec asks plugin names to follow.
+var pluginName = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9._]{0,61}[a-zA-Z0-9])?$`)
+
+var nameCounter int64
+
+// uniqueName keeps the names of different specs apart on a shared endpoint.
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("conformance-%s-%d-%d", prefix, time.Now().UnixNano(), atomic.AddInt64(&nameCounter, 1))
+}
+
+func mountCapability() *VolumeCapability {
+	return &VolumeCapability{
+		AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}},
+		AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func expectCode(err error, code codes.Code) {
+	ExpectWithOffset(1, status.Code(err)).To(Equal(code), "unexpected error: %v", err)
+}
+
+// Describe registers the conformance specs under text. config is called
+// before each spec, after the enclosing BeforeEach blocks have run.
+func Describe(text string, config func() Config) bool {
+	return ginkgo.Describe(text, func() {
+		var (
+			cfg          Config
+			conn         *grpc.ClientConn
+			identity     IdentityClient
+			controller   ControllerClient
+			capabilities map[ControllerServiceCapability_RPC_Type]bool
+			r            *resources
+			ctx          context.Context
+			cancel       context.CancelFunc
+		)
+
+		ginkgo.BeforeEach(func() {
+			cfg = config()
+			if cfg.Timeout == 0 {
+				cfg.Timeout = time.Minute
+			}
+			ctx, cancel = context.WithTimeout(context.Background(), cfg.Timeout)
+
+			var err error
+			conn, err = grpc.Dial(cfg.Address, grpc.WithInsecure())
+			Expect(err).NotTo(HaveOccurred())
+			identity = NewIdentityClient(conn)
+			controller = NewControllerClient(conn)
+			r = &resources{controller: controller, published: map[string]string{}}
+
+			resp, err := controller.ControllerGetCapabilities(ctx, &ControllerGetCapabilitiesRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			capabilities = map[ControllerServiceCapability_RPC_Type]bool{}
+			for _, c := range resp.GetCapabilities() {
+				capabilities[c.GetRpc().GetType()] = true
+			}
+		})
+
+		ginkgo.AfterEach(func() {
+			r.cleanup(ctx)
+			cancel()
+			conn.Close()
+		})
+
+		requireCapability := func(c ControllerServiceCapability_RPC_Type) {
+			if !capabilities[c] {
+				ginkgo.Skip(fmt.Sprintf("the plugin does not advertise %s", c))
+			}
+		}
+
+		createVolumeRequest := func(name string) *CreateVolumeRequest {
+			req := &CreateVolumeRequest{
+				Name:               name,
+				VolumeCapabilities: []*VolumeCapability{mountCapability()},
+				Parameters:         cfg.Parameters,
+			}
+			if cfg.VolumeSize > 0 {
+				req.CapacityRange = &CapacityRange{RequiredBytes: cfg.VolumeSize}
+			}
+			return req
+		}
+
+		createVolume := func(prefix string) *Volume {
+			resp, err := r.createVolume(ctx, createVolumeRequest(uniqueName(prefix)))
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			return resp.GetVolume()
+		}
+
+		// missingVolumeId is the id of a volume that existed, so that it has
+		// the form of the plugin's ids without naming a volume.
+		missingVolumeId := func() string {
+			requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+			volId := createVolume("missing").GetVolumeId()
+			_, err := controller.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: volId})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			return volId
+		}
+
+		// readySnapshot creates a snapshot of the volume and waits until it
+		// can be restored, repeating the idempotent CreateSnapshot.
+		readySnapshot := func(sourceVolId string) *Snapshot {
+			req := &CreateSnapshotRequest{Name: uniqueName("snap"), SourceVolumeId: sourceVolId}
+			var snapshot *Snapshot
+			EventuallyWithOffset(1, func() (bool, error) {
+				resp, err := r.createSnapshot(ctx, req)
+				snapshot = resp.GetSnapshot()
+				return snapshot.GetReadyToUse(), err
+			}, cfg.Timeout, time.Second/10).Should(BeTrue())
+			return snapshot
+		}
+
+		ginkgo.Describe("Identity", func() {
+			ginkgo.It("reports a name in domain name notation and a vendor version", func() {
+				resp, err := identity.GetPluginInfo(ctx, &GetPluginInfoRequest{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetName()).To(MatchRegexp(pluginName.String()))
+				Expect(resp.GetVendorVersion()).NotTo(BeEmpty())
+			})
+
+			ginkgo.It("advertises the controller service it serves", func() {
+				resp, err := identity.GetPluginCapabilities(ctx, &GetPluginCapabilitiesRequest{})
+				Expect(err).NotTo(HaveOccurred())
+
+				services := []PluginCapability_Service_Type{}
+				for _, c := range resp.GetCapabilities() {
+					if c.GetService() != nil {
+						services = append(services, c.GetService().GetType())
+					}
+				}
+				Expect(services).To(ContainElement(PluginCapability_Service_CONTROLLER_SERVICE))
+			})
+
+			ginkgo.It("answers Probe", func() {
+				_, err := identity.Probe(ctx, &ProbeRequest{})
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		ginkgo.Describe("ControllerGetCapabilities", func() {
+			ginkgo.It("lists known capabilities, each once", func() {
+				resp, err := controller.ControllerGetCapabilities(ctx, &ControllerGetCapabilitiesRequest{})
+				Expect(err).NotTo(HaveOccurred())
+
+				seen := map[ControllerServiceCapability_RPC_Type]bool{}
+				for _, c := range resp.GetCapabilities() {
+					t := c.GetRpc().GetType()
+					Expect(t).NotTo(Equal(ControllerServiceCapability_RPC_UNKNOWN))
+					Expect(seen).NotTo(HaveKey(t), "%s is listed twice", t)
+					seen[t] = true
+				}
+			})
+
+			ginkgo.It("advertises the capabilities others depend on", func() {
+				if capabilities[ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES] {
+					Expect(capabilities).To(HaveKey(ControllerServiceCapability_RPC_LIST_VOLUMES))
+				}
+				if capabilities[ControllerServiceCapability_RPC_VOLUME_CONDITION] {
+					Expect(capabilities[ControllerServiceCapability_RPC_LIST_VOLUMES] || capabilities[ControllerServiceCapability_RPC_GET_VOLUME]).To(BeTrue(),
+						"VOLUME_CONDITION needs LIST_VOLUMES or GET_VOLUME")
+				}
+				if capabilities[ControllerServiceCapability_RPC_LIST_SNAPSHOTS] {
+					Expect(capabilities).To(HaveKey(ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT))
+				}
+			})
+
+			ginkgo.It("implements the RPCs of the advertised capabilities", func() {
+				calls := map[ControllerServiceCapability_RPC_Type][]func() error{
+					ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME: {
+						func() error { _, err := controller.CreateVolume(ctx, &CreateVolumeRequest{}); return err },
+						func() error { _, err := controller.DeleteVolume(ctx, &DeleteVolumeRequest{}); return err },
+					},
+					ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME: {
+						func() error {
+							_, err := controller.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{})
+							return err
+						},
+						func() error {
+							_, err := controller.ControllerUnpublishVolume(ctx, &ControllerUnpublishVolumeRequest{})
+							return err
+						},
+					},
+					ControllerServiceCapability_RPC_LIST_VOLUMES: {
+						func() error { _, err := controller.ListVolumes(ctx, &ListVolumesRequest{}); return err },
+					},
+					ControllerServiceCapability_RPC_GET_CAPACITY: {
+						func() error { _, err := controller.GetCapacity(ctx, &GetCapacityRequest{}); return err },
+					},
+					ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT: {
+						func() error { _, err := controller.CreateSnapshot(ctx, &CreateSnapshotRequest{}); return err },
+						func() error { _, err := controller.DeleteSnapshot(ctx, &DeleteSnapshotRequest{}); return err },
+					},
+					ControllerServiceCapability_RPC_LIST_SNAPSHOTS: {
+						func() error { _, err := controller.ListSnapshots(ctx, &ListSnapshotsRequest{}); return err },
+					},
+					ControllerServiceCapability_RPC_EXPAND_VOLUME: {
+						func() error {
+							_, err := controller.ControllerExpandVolume(ctx, &ControllerExpandVolumeRequest{})
+							return err
+						},
+					},
+					ControllerServiceCapability_RPC_GET_VOLUME: {
+						func() error { _, err := controller.ControllerGetVolume(ctx, &ControllerGetVolumeRequest{}); return err },
+					},
+					ControllerServiceCapability_RPC_MODIFY_VOLUME: {
+						func() error {
+							_, err := controller.ControllerModifyVolume(ctx, &ControllerModifyVolumeRequest{})
+							return err
+						},
+					},
+				}
+
+				_, err := controller.ValidateVolumeCapabilities(ctx, &ValidateVolumeCapabilitiesRequest{})
+				Expect(status.Code(err)).NotTo(Equal(codes.Unimplemented), "ValidateVolumeCapabilities is required")
+				for c, rpcs := range calls {
+					if !capabilities[c] {
+						continue
+					}
+					for _, call := range rpcs {
+						Expect(status.Code(call())).NotTo(Equal(codes.Unimplemented), "%s is advertised", c)
+					}
+				}
+			})
+		})
+
+		ginkgo.Describe("CreateVolume", func() {
+			ginkgo.BeforeEach(func() {
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+			})
+
+			ginkgo.It("fails with InvalidArgument without a name or capabilities", func() {
+				req := createVolumeRequest("")
+				_, err := r.createVolume(ctx, req)
+				expectCode(err, codes.InvalidArgument)
+
+				req = createVolumeRequest(uniqueName("vol"))
+				req.VolumeCapabilities = nil
+				_, err = r.createVolume(ctx, req)
+				expectCode(err, codes.InvalidArgument)
+			})
+
+			ginkgo.It("returns the same volume when repeated", func() {
+				req := createVolumeRequest(uniqueName("vol"))
+				first, err := r.createVolume(ctx, req)
+				Expect(err).NotTo(HaveOccurred())
+				second, err := r.createVolume(ctx, req)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(second.GetVolume().GetVolumeId()).To(Equal(first.GetVolume().GetVolumeId()))
+				Expect(second.GetVolume().GetCapacityBytes()).To(Equal(first.GetVolume().GetCapacityBytes()))
+			})
+
+			ginkgo.It("provides at least the required capacity", func() {
+				if cfg.VolumeSize == 0 {
+					ginkgo.Skip("no volume size is configured")
+				}
+				Expect(createVolume("vol").GetCapacityBytes()).To(BeNumerically(">=", cfg.VolumeSize))
+			})
+
+			ginkgo.It("fails with AlreadyExists when repeated with an incompatible capacity", func() {
+				req := createVolumeRequest(uniqueName("vol"))
+				first, err := r.createVolume(ctx, req)
+				Expect(err).NotTo(HaveOccurred())
+				size := first.GetVolume().GetCapacityBytes()
+				if size == 0 {
+					ginkgo.Skip("the plugin does not report capacity")
+				}
+
+				req.CapacityRange = &CapacityRange{RequiredBytes: size * 2, LimitBytes: size * 2}
+				_, err = r.createVolume(ctx, req)
+				expectCode(err, codes.AlreadyExists)
+			})
+		})
+
+		ginkgo.Describe("DeleteVolume", func() {
+			ginkgo.BeforeEach(func() {
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+			})
+
+			ginkgo.It("fails with InvalidArgument without a volume id", func() {
+				_, err := controller.DeleteVolume(ctx, &DeleteVolumeRequest{})
+				expectCode(err, codes.InvalidArgument)
+			})
+
+			ginkgo.It("succeeds when repeated and for a missing volume", func() {
+				volId := createVolume("vol").GetVolumeId()
+				_, err := controller.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: volId})
+				Expect(err).NotTo(HaveOccurred())
+				_, err = controller.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: volId})
+				Expect(err).NotTo(HaveOccurred())
+
+				if capabilities[ControllerServiceCapability_RPC_GET_VOLUME] {
+					_, err = controller.ControllerGetVolume(ctx, &ControllerGetVolumeRequest{VolumeId: volId})
+					expectCode(err, codes.NotFound)
+				}
+			})
+		})
+
+		ginkgo.Describe("ValidateVolumeCapabilities", func() {
+			ginkgo.It("fails with InvalidArgument without a volume id or capabilities", func() {
+				_, err := controller.ValidateVolumeCapabilities(ctx, &ValidateVolumeCapabilitiesRequest{
+					VolumeCapabilities: []*VolumeCapability{mountCapability()},
+				})
+				expectCode(err, codes.InvalidArgument)
+
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+				_, err = controller.ValidateVolumeCapabilities(ctx, &ValidateVolumeCapabilitiesRequest{
+					VolumeId: createVolume("vol").GetVolumeId(),
+				})
+				expectCode(err, codes.InvalidArgument)
+			})
+
+			ginkgo.It("fails with NotFound for a missing volume", func() {
+				_, err := controller.ValidateVolumeCapabilities(ctx, &ValidateVolumeCapabilitiesRequest{
+					VolumeId:           missingVolumeId(),
+					VolumeCapabilities: []*VolumeCapability{mountCapability()},
+				})
+				expectCode(err, codes.NotFound)
+			})
+
+			ginkgo.It("confirms the capability a volume was created with", func() {
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+				resp, err := controller.ValidateVolumeCapabilities(ctx, &ValidateVolumeCapabilitiesRequest{
+					VolumeId:           createVolume("vol").GetVolumeId(),
+					VolumeCapabilities: []*VolumeCapability{mountCapability()},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetConfirmed()).NotTo(BeNil(), resp.GetMessage())
+			})
+		})
+
+		ginkgo.Describe("ControllerPublishVolume", func() {
+			var volId string
+
+			ginkgo.BeforeEach(func() {
+				requireCapability(ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+				volId = createVolume("vol").GetVolumeId()
+			})
+
+			ginkgo.It("fails with InvalidArgument without a volume id, node id or capability", func() {
+				_, err := controller.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{NodeId: cfg.NodeId, VolumeCapability: mountCapability()})
+				expectCode(err, codes.InvalidArgument)
+				_, err = controller.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: volId, VolumeCapability: mountCapability()})
+				expectCode(err, codes.InvalidArgument)
+				_, err = controller.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: volId, NodeId: cfg.NodeId})
+				expectCode(err, codes.InvalidArgument)
+			})
+
+			ginkgo.It("fails with NotFound for a missing volume", func() {
+				_, err := controller.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{
+					VolumeId:         missingVolumeId(),
+					NodeId:           cfg.NodeId,
+					VolumeCapability: mountCapability(),
+				})
+				expectCode(err, codes.NotFound)
+			})
+
+			ginkgo.It("returns the same publish context when repeated", func() {
+				first, err := r.publish(ctx, volId, cfg.NodeId)
+				Expect(err).NotTo(HaveOccurred())
+				second, err := r.publish(ctx, volId, cfg.NodeId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(second.GetPublishContext()).To(Equal(first.GetPublishContext()))
+			})
+
+			ginkgo.It("unpublishes, also when repeated", func() {
+				_, err := controller.ControllerUnpublishVolume(ctx, &ControllerUnpublishVolumeRequest{NodeId: cfg.NodeId})
+				expectCode(err, codes.InvalidArgument)
+
+				_, err = r.publish(ctx, volId, cfg.NodeId)
+				Expect(err).NotTo(HaveOccurred())
+				for i := 0; i < 2; i++ {
+					_, err = controller.ControllerUnpublishVolume(ctx, &ControllerUnpublishVolumeRequest{VolumeId: volId, NodeId: cfg.NodeId})
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+		})
+
+		ginkgo.Describe("ListVolumes", func() {
+			ginkgo.BeforeEach(func() {
+				requireCapability(ControllerServiceCapability_RPC_LIST_VOLUMES)
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+			})
+
+			listAll := func(maxEntries int32) map[string]*ListVolumesResponse_Entry {
+				entries := map[string]*ListVolumesResponse_Entry{}
+				token := ""
+				for pages := 0; ; pages++ {
+					ExpectWithOffset(1, pages).To(BeNumerically("<", 1000), "too many pages")
+					resp, err := controller.ListVolumes(ctx, &ListVolumesRequest{MaxEntries: maxEntries, StartingToken: token})
+					ExpectWithOffset(1, err).NotTo(HaveOccurred())
+					if maxEntries > 0 {
+						ExpectWithOffset(1, len(resp.GetEntries())).To(BeNumerically("<=", maxEntries))
+					}
+					for _, e := range resp.GetEntries() {
+						volId := e.GetVolume().GetVolumeId()
+						ExpectWithOffset(1, entries).NotTo(HaveKey(volId), "%s is listed twice", volId)
+						entries[volId] = e
+					}
+					if token = resp.GetNextToken(); token == "" {
+						return entries
+					}
+				}
+			}
+
+			ginkgo.It("pages through every volume exactly once", func() {
+				volIds := []string{}
+				for i := 0; i < 3; i++ {
+					volIds = append(volIds, createVolume("vol").GetVolumeId())
+				}
+
+				entries := listAll(1)
+				for _, volId := range volIds {
+					Expect(entries).To(HaveKey(volId))
+				}
+				Expect(listAll(0)).To(HaveLen(len(entries)))
+			})
+
+			ginkgo.It("fails with Aborted for an invalid starting token", func() {
+				_, err := controller.ListVolumes(ctx, &ListVolumesRequest{StartingToken: "conformance-invalid-token"})
+				expectCode(err, codes.Aborted)
+			})
+
+			ginkgo.It("fails with InvalidArgument for negative max entries", func() {
+				_, err := controller.ListVolumes(ctx, &ListVolumesRequest{MaxEntries: -1})
+				expectCode(err, codes.InvalidArgument)
+			})
+
+			ginkgo.It("lists the nodes a volume is published to", func() {
+				requireCapability(ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES)
+				requireCapability(ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
+				volId := createVolume("vol").GetVolumeId()
+				_, err := r.publish(ctx, volId, cfg.NodeId)
+				Expect(err).NotTo(HaveOccurred())
+
+				entries := listAll(0)
+				Expect(entries).To(HaveKey(volId))
+				Expect(entries[volId].GetStatus().GetPublishedNodeIds()).To(ContainElement(cfg.NodeId))
+			})
+		})
+
+		ginkgo.Describe("ControllerGetVolume", func() {
+			ginkgo.BeforeEach(func() {
+				requireCapability(ControllerServiceCapability_RPC_GET_VOLUME)
+			})
+
+			ginkgo.It("fails with InvalidArgument without a volume id", func() {
+				_, err := controller.ControllerGetVolume(ctx, &ControllerGetVolumeRequest{})
+				expectCode(err, codes.InvalidArgument)
+			})
+
+			ginkgo.It("fails with NotFound for a missing volume", func() {
+				_, err := controller.ControllerGetVolume(ctx, &ControllerGetVolumeRequest{VolumeId: missingVolumeId()})
+				expectCode(err, codes.NotFound)
+			})
+
+			ginkgo.It("returns the volume", func() {
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+				volume := createVolume("vol")
+				resp, err := controller.ControllerGetVolume(ctx, &ControllerGetVolumeRequest{VolumeId: volume.GetVolumeId()})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetVolume().GetVolumeId()).To(Equal(volume.GetVolumeId()))
+				Expect(resp.GetVolume().GetCapacityBytes()).To(Equal(volume.GetCapacityBytes()))
+			})
+		})
+
+		ginkgo.Describe("ControllerExpandVolume", func() {
+			ginkgo.BeforeEach(func() {
+				requireCapability(ControllerServiceCapability_RPC_EXPAND_VOLUME)
+			})
+
+			ginkgo.It("fails with InvalidArgument without a volume id or capacity range", func() {
+				_, err := controller.ControllerExpandVolume(ctx, &ControllerExpandVolumeRequest{CapacityRange: &CapacityRange{RequiredBytes: 1 << 20}})
+				expectCode(err, codes.InvalidArgument)
+
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+				_, err = controller.ControllerExpandVolume(ctx, &ControllerExpandVolumeRequest{VolumeId: createVolume("vol").GetVolumeId()})
+				expectCode(err, codes.InvalidArgument)
+			})
+
+			ginkgo.It("fails with NotFound for a missing volume", func() {
+				_, err := controller.ControllerExpandVolume(ctx, &ControllerExpandVolumeRequest{
+					VolumeId:      missingVolumeId(),
+					CapacityRange: &CapacityRange{RequiredBytes: 1 << 20},
+				})
+				expectCode(err, codes.NotFound)
+			})
+
+			ginkgo.It("grows a volume to at least the required capacity", func() {
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+				volume := createVolume("vol")
+				required := volume.GetCapacityBytes() + 1<<20
+
+				resp, err := controller.ControllerExpandVolume(ctx, &ControllerExpandVolumeRequest{
+					VolumeId:         volume.GetVolumeId(),
+					CapacityRange:    &CapacityRange{RequiredBytes: required},
+					VolumeCapability: mountCapability(),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetCapacityBytes()).To(BeNumerically(">=", required))
+			})
+		})
+
+		ginkgo.Describe("GetCapacity", func() {
+			ginkgo.It("reports a capacity that is not negative", func() {
+				requireCapability(ControllerServiceCapability_RPC_GET_CAPACITY)
+				resp, err := controller.GetCapacity(ctx, &GetCapacityRequest{
+					VolumeCapabilities: []*VolumeCapability{mountCapability()},
+					Parameters:         cfg.Parameters,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetAvailableCapacity()).To(BeNumerically(">=", 0))
+			})
+		})
+
+		ginkgo.Describe("CreateSnapshot", func() {
+			var volId string
+
+			ginkgo.BeforeEach(func() {
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+				volId = createVolume("vol").GetVolumeId()
+			})
+
+			ginkgo.It("fails with InvalidArgument without a name or source volume", func() {
+				_, err := r.createSnapshot(ctx, &CreateSnapshotRequest{SourceVolumeId: volId})
+				expectCode(err, codes.InvalidArgument)
+				_, err = r.createSnapshot(ctx, &CreateSnapshotRequest{Name: uniqueName("snap")})
+				expectCode(err, codes.InvalidArgument)
+			})
+
+			ginkgo.It("fails with NotFound for a missing source volume", func() {
+				_, err := r.createSnapshot(ctx, &CreateSnapshotRequest{Name: uniqueName("snap"), SourceVolumeId: missingVolumeId()})
+				expectCode(err, codes.NotFound)
+			})
+
+			ginkgo.It("describes the snapshot and returns the same one when repeated", func() {
+				snapshot := readySnapshot(volId)
+				Expect(snapshot.GetSnapshotId()).NotTo(BeEmpty())
+				Expect(snapshot.GetSourceVolumeId()).To(Equal(volId))
+				Expect(snapshot.GetCreationTime()).NotTo(BeNil())
+
+				resp, err := r.createSnapshot(ctx, &CreateSnapshotRequest{Name: r.snapshotNames[snapshot.GetSnapshotId()], SourceVolumeId: volId})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetSnapshot().GetSnapshotId()).To(Equal(snapshot.GetSnapshotId()))
+			})
+
+			ginkgo.It("fails with AlreadyExists for the same name with another source", func() {
+				snapshot := readySnapshot(volId)
+				_, err := r.createSnapshot(ctx, &CreateSnapshotRequest{
+					Name:           r.snapshotNames[snapshot.GetSnapshotId()],
+					SourceVolumeId: createVolume("other").GetVolumeId(),
+				})
+				expectCode(err, codes.AlreadyExists)
+			})
+
+			ginkgo.It("restores into a new volume that reports its source", func() {
+				snapshot := readySnapshot(volId)
+				req := createVolumeRequest(uniqueName("restored"))
+				req.VolumeContentSource = &VolumeContentSource{Type: &VolumeContentSource_Snapshot{
+					Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: snapshot.GetSnapshotId()},
+				}}
+				resp, err := r.createVolume(ctx, req)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetVolume().GetContentSource().GetSnapshot().GetSnapshotId()).To(Equal(snapshot.GetSnapshotId()))
+			})
+		})
+
+		ginkgo.Describe("DeleteSnapshot", func() {
+			ginkgo.BeforeEach(func() {
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+			})
+
+			ginkgo.It("fails with InvalidArgument without a snapshot id", func() {
+				_, err := controller.DeleteSnapshot(ctx, &DeleteSnapshotRequest{})
+				expectCode(err, codes.InvalidArgument)
+			})
+
+			ginkgo.It("succeeds when repeated and for a missing snapshot", func() {
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+				snapId := readySnapshot(createVolume("vol").GetVolumeId()).GetSnapshotId()
+				for i := 0; i < 2; i++ {
+					_, err := controller.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: snapId})
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+		})
+
+		ginkgo.Describe("ListSnapshots", func() {
+			var (
+				volId     string
+				snapshots []string
+			)
+
+			ginkgo.BeforeEach(func() {
+				requireCapability(ControllerServiceCapability_RPC_LIST_SNAPSHOTS)
+				requireCapability(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+				volId = createVolume("vol").GetVolumeId()
+				snapshots = []string{}
+				for i := 0; i < 3; i++ {
+					snapshots = append(snapshots, readySnapshot(volId).GetSnapshotId())
+				}
+				// a snapshot of another volume, for the source filter to leave out
+				readySnapshot(createVolume("other").GetVolumeId())
+			})
+
+			ids := func(resp *ListSnapshotsResponse) []string {
+				ids := []string{}
+				for _, e := range resp.GetEntries() {
+					ids = append(ids, e.GetSnapshot().GetSnapshotId())
+				}
+				return ids
+			}
+
+			ginkgo.It("filters by snapshot id, finding nothing for a missing one", func() {
+				resp, err := controller.ListSnapshots(ctx, &ListSnapshotsRequest{SnapshotId: snapshots[1]})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ids(resp)).To(Equal([]string{snapshots[1]}))
+
+				_, err = controller.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: snapshots[1]})
+				Expect(err).NotTo(HaveOccurred())
+				resp, err = controller.ListSnapshots(ctx, &ListSnapshotsRequest{SnapshotId: snapshots[1]})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetEntries()).To(BeEmpty())
+			})
+
+			ginkgo.It("pages through the snapshots of a source volume exactly once", func() {
+				listed := []string{}
+				token := ""
+				for pages := 0; ; pages++ {
+					Expect(pages).To(BeNumerically("<", 10), "too many pages")
+					resp, err := controller.ListSnapshots(ctx, &ListSnapshotsRequest{SourceVolumeId: volId, MaxEntries: 1, StartingToken: token})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(len(resp.GetEntries())).To(BeNumerically("<=", 1))
+					listed = append(listed, ids(resp)...)
+					if token = resp.GetNextToken(); token == "" {
+						break
+					}
+				}
+				Expect(listed).To(ConsistOf(snapshots))
+			})
+
+			ginkgo.It("fails with Aborted for an invalid starting token", func() {
+				_, err := controller.ListSnapshots(ctx, &ListSnapshotsRequest{StartingToken: "conformance-invalid-token"})
+				expectCode(err, codes.Aborted)
+			})
+		})
+	})
+}
@@ -0,0 +1,54 @@
+package conformance_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/conformance"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+var _ = Describe("An in-process controller", func() {
+	var (
+		root   string
+		server *grpc.Server
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "conformance")
+		Expect(err).NotTo(HaveOccurred())
+
+		cs := controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), controller.NewSubvolumes(), controller.NewMemoryRegistry(), controller.DefaultConfig(root))
+		cs.SetLogger(lagertest.NewTestLogger("conformance"))
+		Expect(cs.Recover()).To(Succeed())
+
+		lis, err := net.Listen("unix", filepath.Join(root, "csi.sock"))
+		Expect(err).NotTo(HaveOccurred())
+		server = grpc.NewServer()
+		RegisterControllerServer(server, cs)
+		RegisterIdentityServer(server, cs)
+		go server.Serve(lis)
+	})
+
+	AfterEach(func() {
+		server.Stop()
+		os.RemoveAll(root)
+	})
+
+	conformance.Describe("conformance", func() conformance.Config {
+		return conformance.Config{
+			Address: "unix://" + filepath.Join(root, "csi.sock"),
+			NodeId:  "node-1",
+		}
+	})
+})
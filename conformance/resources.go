@@ -0,0 +1,69 @@
+package conformance
+
+import (
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+// resources records what a spec creates, so that it can be removed again
+// however the spec ends.
+type resources struct {
+	controller    ControllerClient
+	volumes       []string
+	snapshots     []string
+	snapshotNames map[string]string
+	// published maps volume ids to the node they were published to
+	published map[string]string
+}
+
+func (r *resources) createVolume(ctx context.Context, req *CreateVolumeRequest) (*CreateVolumeResponse, error) {
+	resp, err := r.controller.CreateVolume(ctx, req)
+	if err == nil {
+		r.volumes = append(r.volumes, resp.GetVolume().GetVolumeId())
+	}
+	return resp, err
+}
+
+func (r *resources) createSnapshot(ctx context.Context, req *CreateSnapshotRequest) (*CreateSnapshotResponse, error) {
+	resp, err := r.controller.CreateSnapshot(ctx, req)
+	if err == nil {
+		snapId := resp.GetSnapshot().GetSnapshotId()
+		if r.snapshotNames == nil {
+			r.snapshotNames = map[string]string{}
+		}
+		if _, ok := r.snapshotNames[snapId]; !ok {
+			r.snapshots = append(r.snapshots, snapId)
+			r.snapshotNames[snapId] = req.GetName()
+		}
+	}
+	return resp, err
+}
+
+func (r *resources) publish(ctx context.Context, volId, nodeId string) (*ControllerPublishVolumeResponse, error) {
+	resp, err := r.controller.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{
+		VolumeId:         volId,
+		NodeId:           nodeId,
+		VolumeCapability: mountCapability(),
+	})
+	if err == nil {
+		r.published[volId] = nodeId
+	}
+	return resp, err
+}
+
+// cleanup removes snapshots before the volumes they may depend on.
+func (r *resources) cleanup(ctx context.Context) {
+	for volId, nodeId := range r.published {
+		_, err := r.controller.ControllerUnpublishVolume(ctx, &ControllerUnpublishVolumeRequest{VolumeId: volId, NodeId: nodeId})
+		Expect(err).NotTo(HaveOccurred(), "unpublishing %s", volId)
+	}
+	for _, snapId := range r.snapshots {
+		_, err := r.controller.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: snapId})
+		Expect(err).NotTo(HaveOccurred(), "deleting snapshot %s", snapId)
+	}
+	for _, volId := range r.volumes {
+		_, err := r.controller.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: volId})
+		Expect(err).NotTo(HaveOccurred(), "deleting volume %s", volId)
+	}
+}
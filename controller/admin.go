@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// The methods in this file back the operator's admin commands. They return
+// copies, so callers may hold on to the results without cs.lock.
+
+// SetLogger replaces the logger the controller was created with, so that
+// the admin commands can keep the controller's logging off their output.
+func (cs *Controller) SetLogger(logger lager.Logger) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.logger = logger
+}
+
+// Volumes returns the recorded volumes sorted by id.
+func (cs *Controller) Volumes(ctx context.Context) ([]*LocalVolume, error) {
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	volumes := []*LocalVolume{}
+	for _, v := range cs.volumes {
+		volumes = append(volumes, copyVolume(v))
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].VolumeId < volumes[j].VolumeId })
+	return volumes, nil
+}
+
+// Volume returns the recorded volume with the given id.
+func (cs *Controller) Volume(ctx context.Context, volId string) (*LocalVolume, error) {
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	v, ok := cs.volumes[volId]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "Volume %q does not exist", volId)
+	}
+	return copyVolume(v), nil
+}
+
+// Snapshots returns the recorded snapshots sorted by id.
+func (cs *Controller) Snapshots(ctx context.Context) ([]*LocalSnapshot, error) {
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	snapshots := []*LocalSnapshot{}
+	for _, s := range cs.sortedSnapshots() {
+		snapshot := *s
+		snapshots = append(snapshots, &snapshot)
+	}
+	return snapshots, nil
+}
+
+// GC removes the volume and snapshot directories that have no registry entry
+// and no operation in progress, and everything Reconcile has quarantined. It
+// returns the paths removed, or with dryRun the paths it would remove.
+func (cs *Controller) GC(ctx context.Context, dryRun bool) ([]string, error) {
+	logger := cs.session(ctx, "gc")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	cs.lock.Lock()
+	garbage := map[string]string{}
+	for _, name := range cs.poolOrder {
+		pool := cs.pools[name]
+		kinds := []struct {
+			path      string
+			operation func(string) string
+			recorded  func(string) bool
+		}{
+			{
+				path:      cs.volumePath(pool, "*"),
+				operation: volumeOperation,
+				recorded:  func(id string) bool { _, ok := cs.volumes[id]; return ok },
+			},
+			{
+				path:      cs.snapshotPath(pool, "*"),
+				operation: snapshotOperation,
+				recorded:  func(id string) bool { _, ok := cs.snapshots[id]; return ok },
+			},
+			{
+				path:      cs.poolPath(pool, QuarantineRootDir, "*"),
+				operation: func(string) string { return "" },
+				recorded:  func(string) bool { return false },
+			},
+		}
+
+		for _, kind := range kinds {
+			paths, err := cs.filepath.Glob(kind.path)
+			if err != nil {
+				cs.lock.Unlock()
+				return nil, grpc.Errorf(codes.Internal, "Failed to list %s: %s", filepath.Dir(kind.path), err.Error())
+			}
+			for _, path := range paths {
+				dirName := filepath.Base(path)
+				if strings.HasPrefix(dirName, ".") {
+					continue
+				}
+				id := volumeID(pool.Name, dirName)
+				key := kind.operation(id)
+				if kind.recorded(id) || cs.operations[key] {
+					continue
+				}
+				garbage[path] = key
+			}
+		}
+	}
+
+	paths := []string{}
+	for path, key := range garbage {
+		paths = append(paths, path)
+		if !dryRun && key != "" {
+			// keeps CreateVolume and CreateSnapshot off the directory while it goes
+			cs.beginOperation(key)
+		}
+	}
+	cs.lock.Unlock()
+	sort.Strings(paths)
+
+	if dryRun {
+		return paths, nil
+	}
+
+	defer func() {
+		cs.lock.Lock()
+		defer cs.lock.Unlock()
+		for _, key := range garbage {
+			if key != "" {
+				cs.endOperation(key)
+			}
+		}
+	}()
+
+	removed := []string{}
+	for _, path := range paths {
+		logger.Info("removing", lager.Data{"path": path})
+		if err := cs.removeTree(ctx, path); err != nil {
+			return removed, operationError(ctx, logger, "remove "+path, err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// ExportState returns a copy of the recorded volumes and snapshots.
+func (cs *Controller) ExportState(ctx context.Context) (*State, error) {
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	state := NewState()
+	for id, v := range cs.volumes {
+		state.Volumes[id] = copyVolume(v)
+	}
+	for id, s := range cs.snapshots {
+		snapshot := *s
+		state.Snapshots[id] = &snapshot
+	}
+	return state, nil
+}
+
+// ImportState replaces the recorded volumes and snapshots with state and
+// saves it. It is allowed when recovery failed, to repair a broken state
+// file, but not while an operation is in progress.
+func (cs *Controller) ImportState(ctx context.Context, state *State) error {
+	logger := cs.session(ctx, "import-state")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if state.Volumes == nil {
+		state.Volumes = map[string]*LocalVolume{}
+	}
+	if state.Snapshots == nil {
+		state.Snapshots = map[string]*LocalSnapshot{}
+	}
+	for _, v := range state.Volumes {
+		if v.PublishedNodes == nil {
+			v.PublishedNodes = map[string]bool{}
+		}
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if len(cs.operations) > 0 {
+		return grpc.Errorf(codes.FailedPrecondition, "Cannot import state while %d operations are in progress", len(cs.operations))
+	}
+	if err := cs.checkConsistency(state.Volumes, state.Snapshots); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "Inconsistent state: %s", err.Error())
+	}
+
+	volumes, snapshots := cs.volumes, cs.snapshots
+	cs.volumes, cs.snapshots = state.Volumes, state.Snapshots
+	if err := cs.saveState(ctx, logger); err != nil {
+		cs.volumes, cs.snapshots = volumes, snapshots
+		return err
+	}
+
+	logger.Info("imported", lager.Data{"volumes": len(state.Volumes), "snapshots": len(state.Snapshots)})
+	cs.recoveryErr = nil
+	cs.ready = true
+	return nil
+}
+
+func copyVolume(v *LocalVolume) *LocalVolume {
+	volume := *v
+	volume.PublishedNodes = map[string]bool{}
+	for node := range v.PublishedNodes {
+		volume.PublishedNodes[node] = true
+	}
+	return &volume
+}
@@ -0,0 +1,166 @@
+package controller_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Admin", func() {
+	var (
+		root     string
+		registry controller.Registry
+		cs       *controller.Controller
+		ctx      context.Context
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "admin")
+		Expect(err).NotTo(HaveOccurred())
+		ctx = context.Background()
+		vc := []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}}}
+
+		registry = controller.NewMemoryRegistry()
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), controller.NewSubvolumes(), registry, controller.DefaultConfig(root))
+		Expect(cs.Recover()).To(Succeed())
+
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol-b", VolumeCapabilities: vc})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol-a", VolumeCapabilities: vc})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cs.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: "default:vol-a", NodeId: "node-1", VolumeCapability: vc[0]})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol-a"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	Describe("Volumes", func() {
+		It("returns copies of the volumes sorted by id", func() {
+			volumes, err := cs.Volumes(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volumes).To(HaveLen(2))
+			Expect(volumes[0].VolumeId).To(Equal("default:vol-a"))
+			Expect(volumes[0].PublishedNodes).To(Equal(map[string]bool{"node-1": true}))
+			Expect(volumes[1].VolumeId).To(Equal("default:vol-b"))
+
+			volumes[0].PublishedNodes["node-2"] = true
+			volume, err := cs.Volume(ctx, "default:vol-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.PublishedNodes).To(Equal(map[string]bool{"node-1": true}))
+		})
+
+		It("reports an unknown volume as not found", func() {
+			_, err := cs.Volume(ctx, "default:nope")
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+		})
+	})
+
+	Describe("VolumePath", func() {
+		It("returns the volume's directory", func() {
+			path, err := cs.VolumePath(ctx, "default:vol-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal(filepath.Join(root, controller.VolumesRootDir, "vol-a")))
+			Expect(path).To(BeADirectory())
+		})
+
+		It("reports an unknown volume as not found", func() {
+			_, err := cs.VolumePath(ctx, "default:nope")
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+		})
+	})
+
+	Describe("Snapshots", func() {
+		It("returns the snapshots", func() {
+			snapshots, err := cs.Snapshots(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(snapshots).To(HaveLen(1))
+			Expect(snapshots[0].SnapshotId).To(Equal("default:snap"))
+			Expect(snapshots[0].ReadyToUse).To(BeTrue())
+		})
+	})
+
+	Describe("GC", func() {
+		var orphans []string
+
+		BeforeEach(func() {
+			orphans = []string{
+				filepath.Join(root, controller.QuarantineRootDir, "old-1"),
+				filepath.Join(root, controller.SnapshotsRootDir, "lost-snap"),
+				filepath.Join(root, controller.VolumesRootDir, "lost-vol"),
+			}
+			for _, orphan := range orphans {
+				Expect(os.MkdirAll(filepath.Join(orphan, "data"), 0700)).To(Succeed())
+			}
+		})
+
+		It("lists the unrecorded directories on a dry run", func() {
+			Expect(cs.GC(ctx, true)).To(Equal(orphans))
+			for _, orphan := range orphans {
+				Expect(orphan).To(BeADirectory())
+			}
+		})
+
+		It("removes the unrecorded directories and keeps the recorded ones", func() {
+			Expect(cs.GC(ctx, false)).To(Equal(orphans))
+			for _, orphan := range orphans {
+				Expect(orphan).NotTo(BeADirectory())
+			}
+			Expect(filepath.Join(root, controller.VolumesRootDir, "vol-a")).To(BeADirectory())
+			Expect(filepath.Join(root, controller.SnapshotsRootDir, "snap")).To(BeADirectory())
+		})
+	})
+
+	Describe("ExportState and ImportState", func() {
+		It("round trips the state", func() {
+			state, err := cs.ExportState(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state.Volumes).To(HaveKey("default:vol-a"))
+			Expect(state.Snapshots).To(HaveKey("default:snap"))
+
+			delete(state.Volumes, "default:vol-b")
+			Expect(cs.ImportState(ctx, state)).To(Succeed())
+
+			Expect(cs.Volumes(ctx)).To(HaveLen(1))
+			saved, err := registry.Load()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved.Volumes).NotTo(HaveKey("default:vol-b"))
+		})
+
+		It("refuses an inconsistent state", func() {
+			state := controller.NewState()
+			state.Volumes["default:vol"] = &controller.LocalVolume{VolumeId: "default:vol", Pool: "other", Name: "vol"}
+
+			err := cs.ImportState(ctx, state)
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(cs.Volumes(ctx)).To(HaveLen(2))
+		})
+
+		It("repairs a state that failed to recover", func() {
+			Expect(registry.Save(&controller.State{Volumes: map[string]*controller.LocalVolume{
+				"wrong-id": {VolumeId: "default:vol", Pool: "default", Name: "vol"},
+			}})).To(Succeed())
+			broken := controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), controller.NewSubvolumes(), registry, controller.DefaultConfig(root))
+			Expect(broken.Recover()).NotTo(Succeed())
+			_, err := broken.Volumes(ctx)
+			Expect(status.Code(err)).To(Equal(codes.Unavailable))
+
+			Expect(broken.ImportState(ctx, controller.NewState())).To(Succeed())
+			Expect(broken.Volumes(ctx)).To(BeEmpty())
+		})
+	})
+})
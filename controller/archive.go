@@ -0,0 +1,661 @@
+package controller
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// ArchiveVersion is the version of the archive format ExportVolume and
+// ExportSnapshot write, and the only one ImportVolume reads.
+const ArchiveVersion = 1
+
+// An archive is a gzipped tar of the metadata, then the files under the data
+// directory, then a manifest of their SHA-256 checksums in sha256sum format.
+const (
+	ArchiveMetadataName = "metadata.json"
+	ArchiveDataDir      = "data"
+	ArchiveManifestName = "MANIFEST.sha256"
+)
+
+// maxArchiveMetadataBytes bounds the metadata ImportVolume reads into memory.
+const maxArchiveMetadataBytes = 1 << 20
+
+// ArchiveMetadata describes the volume or snapshot an archive holds.
+type ArchiveMetadata struct {
+	Version       int    `json:"version"`
+	VolumeId      string `json:"volume_id,omitempty"`
+	SnapshotId    string `json:"snapshot_id,omitempty"`
+	CapacityBytes int64  `json:"capacity_bytes"`
+	// Backend is BackendImage for the archive of an image volume, which can
+	// only be imported into an image pool.
+	Backend    string    `json:"backend,omitempty"`
+	FsType     string    `json:"fs_type,omitempty"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// archiveError reports an archive that cannot be imported as it stands.
+type archiveError struct {
+	message string
+}
+
+func (e *archiveError) Error() string { return e.message }
+
+func invalidArchive(format string, args ...interface{}) error {
+	return &archiveError{message: fmt.Sprintf(format, args...)}
+}
+
+// ExportVolume writes the volume's files to w as an archive. The files are
+// read as they are, so a volume that is being written to is better exported
+// through a snapshot.
+func (cs *Controller) ExportVolume(ctx context.Context, volId string, w io.Writer) error {
+	logger := cs.session(ctx, "export-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return err
+	}
+
+	cs.lock.Lock()
+	localVol, ok := cs.volumes[volId]
+	if !ok {
+		cs.lock.Unlock()
+		return grpc.Errorf(codes.NotFound, "Volume %q does not exist", volId)
+	}
+	if localVol.Incomplete {
+		cs.lock.Unlock()
+		return grpc.Errorf(codes.FailedPrecondition, "Volume %q is incomplete", volId)
+	}
+	if len(localVol.Layers) > 0 {
+		cs.lock.Unlock()
+		return grpc.Errorf(codes.FailedPrecondition, "Volume %q is an overlay clone, which only holds its changes", volId)
+	}
+	pool := cs.pools[localVol.Pool]
+	metadata := &ArchiveMetadata{
+		Version:       ArchiveVersion,
+		VolumeId:      volId,
+		CapacityBytes: localVol.CapacityBytes,
+		Backend:       archiveBackend(pool),
+		FsType:        localVol.FsType,
+	}
+	dir := cs.volumePath(pool, localVol.Name)
+	cs.lock.Unlock()
+
+	return cs.export(ctx, logger, volumeOperation(volId), metadata, dir, w)
+}
+
+// ExportSnapshot writes the snapshot's files to w as an archive.
+func (cs *Controller) ExportSnapshot(ctx context.Context, snapId string, w io.Writer) error {
+	logger := cs.session(ctx, "export-snapshot")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return err
+	}
+
+	cs.lock.Lock()
+	snapshot, ok := cs.snapshots[snapId]
+	if !ok {
+		cs.lock.Unlock()
+		return grpc.Errorf(codes.NotFound, "Snapshot %q does not exist", snapId)
+	}
+	if !snapshot.ReadyToUse {
+		cs.lock.Unlock()
+		return grpc.Errorf(codes.FailedPrecondition, "Snapshot %q is not ready to use", snapId)
+	}
+	if len(snapshot.Layers) > 0 {
+		cs.lock.Unlock()
+		return grpc.Errorf(codes.FailedPrecondition, "Snapshot %q holds the changes of an overlay volume only", snapId)
+	}
+	pool := cs.pools[snapshot.Pool]
+	metadata := &ArchiveMetadata{
+		Version:       ArchiveVersion,
+		SnapshotId:    snapId,
+		CapacityBytes: snapshot.SizeBytes,
+		Backend:       archiveBackend(pool),
+		FsType:        snapshot.FsType,
+	}
+	dir := cs.snapshotPath(pool, snapshot.Name)
+	cs.lock.Unlock()
+
+	return cs.export(ctx, logger, snapshotOperation(snapId), metadata, dir, w)
+}
+
+func archiveBackend(pool *Pool) string {
+	if pool.Backend == BackendImage {
+		return BackendImage
+	}
+	return ""
+}
+
+// export writes the archive of dir while holding operation, so that the
+// volume or snapshot is not deleted underneath it.
+func (cs *Controller) export(ctx context.Context, logger lager.Logger, operation string, metadata *ArchiveMetadata, dir string, w io.Writer) error {
+	cs.lock.Lock()
+	if err := cs.beginOperation(operation); err != nil {
+		cs.lock.Unlock()
+		return err
+	}
+	cs.lock.Unlock()
+
+	metadata.ExportedAt = time.Now().UTC()
+	logger.Info("exporting", lager.Data{"path": dir})
+	err := traced(ctx, "write-archive", func() error {
+		return writeArchive(ctx, w, metadata, dir)
+	}, attribute.String("path", dir))
+
+	cs.lock.Lock()
+	cs.endOperation(operation)
+	cs.lock.Unlock()
+
+	if err != nil {
+		return archiveOperationError(ctx, logger, "export archive", err)
+	}
+	return nil
+}
+
+// ImportVolume creates the volume name in the pool, or the default pool,
+// from an archive written by ExportVolume or ExportSnapshot. The volume has
+// the capacity recorded in the archive. It is recorded as incomplete while
+// the files are extracted, and removed again if the archive turns out to be
+// invalid: entries outside the data directory, or reached through a symlink
+// in it, are refused, as are checksums that do not match the manifest.
+func (cs *Controller) ImportVolume(ctx context.Context, name, poolName string, r io.Reader) (*LocalVolume, error) {
+	logger := cs.session(ctx, "import-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+	if err := checkVolumeName(name); err != nil {
+		return nil, err
+	}
+	if poolName == "" {
+		poolName = cs.defaultPool
+	}
+	pool, ok := cs.pools[poolName]
+	if !ok {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Unknown pool %q", poolName)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Invalid archive: %s", err.Error())
+	}
+	archive := tar.NewReader(gz)
+	metadata, err := readArchiveMetadata(archive)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Invalid archive: %s", err.Error())
+	}
+
+	localVol, dir, err := cs.reserveImport(ctx, logger, pool, name, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("importing", lager.Data{"volume_id": localVol.VolumeId, "volume": metadata.VolumeId, "snapshot": metadata.SnapshotId})
+	err = traced(ctx, "extract-archive", func() error {
+		if err := cs.prepareCopy(ctx, pool, localVol, dir, localVol.CapacityBytes); err != nil {
+			return err
+		}
+		return extractArchive(ctx, archive, dir, metadata.CapacityBytes)
+	}, attribute.String("path", dir))
+
+	if err != nil {
+		// ctx may be done, and nothing of a failed import is worth keeping
+		if removeErr := cs.removeTree(context.Background(), dir); removeErr != nil {
+			logger.Error("remove-directory-failed", removeErr)
+		}
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.endOperation(volumeOperation(localVol.VolumeId))
+
+	if err != nil {
+		delete(cs.volumes, localVol.VolumeId)
+		// saveState logs its own failure; the import's is the one to return
+		cs.saveState(ctx, logger)
+		return nil, archiveOperationError(ctx, logger, "import archive", err)
+	}
+
+	localVol.Incomplete = false
+	if err := cs.saveState(ctx, logger); err != nil {
+		localVol.Incomplete = true
+		return nil, err
+	}
+	return copyVolume(localVol), nil
+}
+
+// reserveImport records the volume as incomplete and begins its operation.
+func (cs *Controller) reserveImport(ctx context.Context, logger lager.Logger, pool *Pool, name string, metadata *ArchiveMetadata) (*LocalVolume, string, error) {
+	volId := volumeID(pool.Name, name)
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if _, ok := cs.volumes[volId]; ok {
+		return nil, "", grpc.Errorf(codes.AlreadyExists, "Volume %q already exists", volId)
+	}
+	if (metadata.Backend == BackendImage) != (pool.Backend == BackendImage) {
+		return nil, "", grpc.Errorf(codes.InvalidArgument, "The archive cannot be imported into pool %q, which has a different backend", pool.Name)
+	}
+	if err := cs.checkCapacity(pool, metadata.CapacityBytes); err != nil {
+		return nil, "", err
+	}
+
+	var projectId uint32
+	if pool.quotasEnabled {
+		projectId = cs.nextProjectId()
+	}
+	if err := cs.beginOperation(volumeOperation(volId)); err != nil {
+		return nil, "", err
+	}
+
+	localVol := &LocalVolume{
+		VolumeId:       volId,
+		Pool:           pool.Name,
+		Name:           name,
+		CapacityBytes:  metadata.CapacityBytes,
+		PublishedNodes: map[string]bool{},
+		Incomplete:     true,
+		ProjectId:      projectId,
+		FsType:         metadata.FsType,
+	}
+	cs.volumes[volId] = localVol
+	if err := cs.saveState(ctx, logger); err != nil {
+		delete(cs.volumes, volId)
+		cs.endOperation(volumeOperation(volId))
+		return nil, "", err
+	}
+	return localVol, cs.volumePath(pool, name), nil
+}
+
+// archiveOperationError is operationError for archives, which cannot be
+// resumed, and whose own faults are the caller's.
+func archiveOperationError(ctx context.Context, logger lager.Logger, action string, err error) error {
+	if _, ok := err.(*archiveError); ok && ctx.Err() == nil {
+		logger.Info("invalid-archive", lager.Data{"error": err.Error()})
+		return grpc.Errorf(codes.InvalidArgument, "Invalid archive: %s", err.Error())
+	}
+	switch ctx.Err() {
+	case context.Canceled:
+		return grpc.Errorf(codes.Canceled, "Request cancelled while trying to %s", action)
+	case context.DeadlineExceeded:
+		return grpc.Errorf(codes.DeadlineExceeded, "Deadline exceeded while trying to %s", action)
+	}
+	logger.Error("operation-failed", err, lager.Data{"operation": action})
+	return grpc.Errorf(codes.Internal, "Failed to %s: %s", action, err.Error())
+}
+
+func writeArchive(ctx context.Context, w io.Writer, metadata *ArchiveMetadata, dir string) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeArchiveEntry(archive, ArchiveMetadataName, data, metadata.ExportedAt); err != nil {
+		return err
+	}
+
+	manifest := &bytes.Buffer{}
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		link := ""
+		switch {
+		case info.IsDir(), info.Mode().IsRegular():
+		case info.Mode()&os.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		default:
+			// devices, sockets and pipes have no content to carry over
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(ArchiveDataDir, filepath.ToSlash(rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		sum, err := archiveFile(ctx, archive, p, header.Size)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(manifest, "%s  %s\n", sum, header.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeArchiveEntry(archive, ArchiveManifestName, manifest.Bytes(), metadata.ExportedAt); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeArchiveEntry(archive *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data)), ModTime: modTime}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := archive.Write(data)
+	return err
+}
+
+// archiveFile writes the size bytes of the file at p that its header
+// announced, and returns their checksum.
+func archiveFile(ctx context.Context, archive io.Writer, p string, size int64) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	out := io.MultiWriter(archive, hash)
+	for size > 0 {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		chunk := int64(copyChunkSize)
+		if size < chunk {
+			chunk = size
+		}
+		n, err := io.CopyN(out, f, chunk)
+		size -= n
+		if err == io.EOF {
+			return "", fmt.Errorf("%s shrank while it was exported", p)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func readArchiveMetadata(archive *tar.Reader) (*ArchiveMetadata, error) {
+	header, err := archive.Next()
+	if err != nil {
+		return nil, err
+	}
+	if header.Name != ArchiveMetadataName {
+		return nil, fmt.Errorf("the archive starts with %s rather than %s", header.Name, ArchiveMetadataName)
+	}
+	if header.Size > maxArchiveMetadataBytes {
+		return nil, fmt.Errorf("%s is too large", ArchiveMetadataName)
+	}
+
+	metadata := &ArchiveMetadata{}
+	if err := json.NewDecoder(archive).Decode(metadata); err != nil {
+		return nil, fmt.Errorf("%s: %s", ArchiveMetadataName, err.Error())
+	}
+	if metadata.Version != ArchiveVersion {
+		return nil, fmt.Errorf("archive version %d is not supported", metadata.Version)
+	}
+	if metadata.CapacityBytes < 0 {
+		return nil, fmt.Errorf("capacity %d is negative", metadata.CapacityBytes)
+	}
+	return metadata, nil
+}
+
+// extractArchive extracts the data directory of archive into dir and checks
+// it against the manifest. The directories get their modes once their
+// contents are in place.
+func extractArchive(ctx context.Context, archive *tar.Reader, dir string, capacity int64) error {
+	sums := map[string]string{}
+	symlinks := map[string]bool{}
+	dirs := []dirMode{}
+	var manifest map[string]string
+	var total int64
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return invalidArchive("%s", err.Error())
+		}
+		if manifest != nil {
+			return invalidArchive("%s follows the manifest", header.Name)
+		}
+		if header.Name == ArchiveManifestName {
+			if manifest, err = readManifest(archive); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rel, err := archiveDataPath(header.Name)
+		if err != nil {
+			return err
+		}
+		// a symlink extracted earlier must not lead an entry out of dir
+		for p := rel; p != "."; p = path.Dir(p) {
+			if symlinks[p] {
+				return invalidArchive("%s is reached through the symlink %s", header.Name, path.Join(ArchiveDataDir, p))
+			}
+		}
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+		name := path.Join(ArchiveDataDir, rel)
+		if rel == "." && header.Typeflag != tar.TypeDir {
+			return invalidArchive("%s must be a directory", header.Name)
+		}
+		conflict, err := typeConflict(target, header.FileInfo().Mode())
+		if err != nil {
+			return err
+		}
+		if conflict {
+			return invalidArchive("%s replaces an entry of another type", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMode{path: target, mode: header.FileInfo().Mode().Perm()})
+		case tar.TypeReg:
+			total += header.Size
+			if capacity > 0 && total > capacity {
+				return invalidArchive("the files hold more than the capacity of %d bytes", capacity)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			sum, err := extractFile(ctx, archive, target, header)
+			if err != nil {
+				return err
+			}
+			sums[name] = sum
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+			symlinks[rel] = true
+		default:
+			return invalidArchive("%s has unsupported type %q", header.Name, header.Typeflag)
+		}
+	}
+
+	if manifest == nil {
+		return invalidArchive("the archive has no %s", ArchiveManifestName)
+	}
+	for name, sum := range sums {
+		if manifest[name] != sum {
+			return invalidArchive("the checksum of %s does not match the manifest", name)
+		}
+	}
+	for name := range manifest {
+		if _, ok := sums[name]; !ok {
+			return invalidArchive("%s is in the manifest but not in the archive", name)
+		}
+	}
+
+	return applyDirModes(dirs)
+}
+
+type dirMode struct {
+	path string
+	mode os.FileMode
+}
+
+// typeConflict reports whether target already holds something of another
+// type than mode, which an extracted entry must not replace.
+func typeConflict(target string, mode os.FileMode) (bool, error) {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.Mode()&os.ModeType != mode&os.ModeType, nil
+}
+
+// applyDirModes sets the modes of dirs, deepest first, skipping any path that
+// is no longer a directory rather than following it.
+func applyDirModes(dirs []dirMode) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Lstat(dirs[i].path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			continue
+		}
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveDataPath returns the path of an entry relative to the data
+// directory, refusing entries outside it.
+func archiveDataPath(name string) (string, error) {
+	if name != ArchiveDataDir && !strings.HasPrefix(name, ArchiveDataDir+"/") {
+		return "", invalidArchive("%s is outside the %s directory", name, ArchiveDataDir)
+	}
+	rel := path.Clean(strings.TrimPrefix(strings.TrimPrefix(name, ArchiveDataDir), "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+		return "", invalidArchive("%s escapes the volume", name)
+	}
+	return rel, nil
+}
+
+func extractFile(ctx context.Context, archive io.Reader, target string, header *tar.Header) (string, error) {
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	buf := make([]byte, 32<<10)
+	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		n, readErr := archive.Read(buf)
+		if n > 0 {
+			hash.Write(buf[:n])
+			if _, err = out.Write(buf[:n]); err != nil {
+				break
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			err = invalidArchive("%s: %s", header.Name, readErr.Error())
+			break
+		}
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func readManifest(r io.Reader) (map[string]string, error) {
+	manifest := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(parts) != 2 || len(parts[0]) != sha256.Size*2 {
+			return nil, invalidArchive("%s has a malformed line: %q", ArchiveManifestName, scanner.Text())
+		}
+		manifest[parts[1]] = parts[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, invalidArchive("%s: %s", ArchiveManifestName, err.Error())
+	}
+	return manifest, nil
+}
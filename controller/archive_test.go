@@ -0,0 +1,315 @@
+package controller_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/controller/controllerfakes"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Archives", func() {
+	var (
+		root    string
+		cs      *controller.Controller
+		ctx     context.Context
+		vc      []*VolumeCapability
+		modTime time.Time
+	)
+
+	volumePath := func(name string) string {
+		return filepath.Join(root, "default", controller.VolumesRootDir, name)
+	}
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "archives")
+		Expect(err).NotTo(HaveOccurred())
+		ctx = context.Background()
+		vc = []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}}}
+		modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		images := &controllerfakes.FakeImages{}
+		images.CreateStub = func(ctx context.Context, path string, size int64, fsType string) error {
+			return ioutil.WriteFile(path, []byte("image"), 0600)
+		}
+		config := controller.Config{
+			Pools: []controller.PoolConfig{
+				{Name: "default", Root: filepath.Join(root, "default")},
+				{Name: "small", Root: filepath.Join(root, "small"), CapacityBytes: 1024},
+				{Name: "images", Root: filepath.Join(root, "images"), Backend: controller.BackendImage},
+			},
+			DefaultPool: "default",
+		}
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), images, controller.NewSubvolumes(), controller.NewMemoryRegistry(), config)
+		cs.SetLogger(lagertest.NewTestLogger("archives"))
+		Expect(cs.Recover()).To(Succeed())
+
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 1 << 20}})
+		Expect(err).NotTo(HaveOccurred())
+		vol := volumePath("vol")
+		Expect(os.MkdirAll(filepath.Join(vol, "dir", "sub"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(vol, "dir", "sub", "data"), []byte("hello"), 0640)).To(Succeed())
+		Expect(os.Chtimes(filepath.Join(vol, "dir", "sub", "data"), modTime, modTime)).To(Succeed())
+		Expect(os.Symlink("sub/data", filepath.Join(vol, "dir", "link"))).To(Succeed())
+		Expect(os.Chmod(filepath.Join(vol, "dir"), 0550)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				os.Chmod(path, 0755)
+			}
+			return nil
+		})
+		os.RemoveAll(root)
+	})
+
+	export := func(volId string) []byte {
+		archive := &bytes.Buffer{}
+		ExpectWithOffset(1, cs.ExportVolume(ctx, volId, archive)).To(Succeed())
+		return archive.Bytes()
+	}
+
+	It("exports a volume and imports it as another, keeping modes, times and symlinks", func() {
+		archive := export("default:vol")
+
+		v, err := cs.ImportVolume(ctx, "copy", "", bytes.NewReader(archive))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v.VolumeId).To(Equal("default:copy"))
+		Expect(v.CapacityBytes).To(Equal(int64(1 << 20)))
+		Expect(v.Incomplete).To(BeFalse())
+
+		copied := volumePath("copy")
+		Expect(ioutil.ReadFile(filepath.Join(copied, "dir", "sub", "data"))).To(Equal([]byte("hello")))
+		info, err := os.Stat(filepath.Join(copied, "dir", "sub", "data"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+		Expect(info.ModTime().Equal(modTime)).To(BeTrue())
+		Expect(os.Readlink(filepath.Join(copied, "dir", "link"))).To(Equal("sub/data"))
+		info, err = os.Stat(filepath.Join(copied, "dir"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0550)))
+
+		resp, err := cs.ControllerGetVolume(ctx, &ControllerGetVolumeRequest{VolumeId: "default:copy"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetStatus().GetVolumeCondition().GetAbnormal()).To(BeFalse())
+	})
+
+	It("writes the metadata first and a manifest of checksums last", func() {
+		gz, err := gzip.NewReader(bytes.NewReader(export("default:vol")))
+		Expect(err).NotTo(HaveOccurred())
+		archive := tar.NewReader(gz)
+
+		names := []string{}
+		contents := map[string]string{}
+		for {
+			header, err := archive.Next()
+			if err != nil {
+				break
+			}
+			names = append(names, header.Name)
+			data, err := ioutil.ReadAll(archive)
+			Expect(err).NotTo(HaveOccurred())
+			contents[header.Name] = string(data)
+		}
+		Expect(names).To(Equal([]string{"metadata.json", "data/", "data/dir/", "data/dir/link", "data/dir/sub/", "data/dir/sub/data", "MANIFEST.sha256"}))
+		Expect(contents["metadata.json"]).To(ContainSubstring(`"volume_id": "default:vol"`))
+		sum := sha256.Sum256([]byte("hello"))
+		Expect(contents["MANIFEST.sha256"]).To(Equal(hex.EncodeToString(sum[:]) + "  data/dir/sub/data\n"))
+	})
+
+	It("exports snapshots", func() {
+		_, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+		archive := &bytes.Buffer{}
+		Expect(cs.ExportSnapshot(ctx, "default:snap", archive)).To(Succeed())
+
+		_, err = cs.ImportVolume(ctx, "restored", "default", archive)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.ReadFile(filepath.Join(volumePath("restored"), "dir", "sub", "data"))).To(Equal([]byte("hello")))
+	})
+
+	It("refuses to export what is missing or holds only overlay changes", func() {
+		Expect(status.Code(cs.ExportVolume(ctx, "default:nope", &bytes.Buffer{}))).To(Equal(codes.NotFound))
+		Expect(status.Code(cs.ExportSnapshot(ctx, "default:nope", &bytes.Buffer{}))).To(Equal(codes.NotFound))
+
+		_, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:                "clone",
+			VolumeCapabilities:  vc,
+			Parameters:          map[string]string{controller.CloneParameter: controller.CloneOverlay},
+			VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: "default:snap"}}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Code(cs.ExportVolume(ctx, "default:clone", &bytes.Buffer{}))).To(Equal(codes.FailedPrecondition))
+	})
+
+	It("refuses names that are taken, pools without room and pools of another backend", func() {
+		archive := export("default:vol")
+		_, err := cs.ImportVolume(ctx, "vol", "default", bytes.NewReader(archive))
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+		_, err = cs.ImportVolume(ctx, "copy", "small", bytes.NewReader(archive))
+		Expect(status.Code(err)).To(Equal(codes.OutOfRange))
+		_, err = cs.ImportVolume(ctx, "copy", "images", bytes.NewReader(archive))
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		_, err = cs.ImportVolume(ctx, "copy", "nope", bytes.NewReader(archive))
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		_, err = cs.ImportVolume(ctx, "a/b", "default", bytes.NewReader(archive))
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+
+	Describe("importing a crafted archive", func() {
+		type entry struct {
+			name     string
+			typeflag byte
+			body     string
+			link     string
+		}
+
+		craft := func(entries []entry, manifest map[string]string) []byte {
+			buf := &bytes.Buffer{}
+			gz := gzip.NewWriter(buf)
+			archive := tar.NewWriter(gz)
+			write := func(header *tar.Header, body string) {
+				Expect(archive.WriteHeader(header)).To(Succeed())
+				_, err := archive.Write([]byte(body))
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			metadata := fmt.Sprintf(`{"version": %d, "volume_id": "default:elsewhere", "capacity_bytes": 4096}`, controller.ArchiveVersion)
+			write(&tar.Header{Name: controller.ArchiveMetadataName, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(metadata))}, metadata)
+			lines := ""
+			for _, e := range entries {
+				size := int64(len(e.body))
+				if e.typeflag != tar.TypeReg {
+					size = 0
+				}
+				write(&tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.link, Mode: 0644, Size: size}, e.body[:size])
+				if e.typeflag == tar.TypeReg {
+					sum := sha256.Sum256([]byte(e.body))
+					lines += hex.EncodeToString(sum[:]) + "  " + e.name + "\n"
+				}
+			}
+			if manifest != nil {
+				lines = ""
+				for name, sum := range manifest {
+					lines += sum + "  " + name + "\n"
+				}
+			}
+			write(&tar.Header{Name: controller.ArchiveManifestName, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(lines))}, lines)
+
+			Expect(archive.Close()).To(Succeed())
+			Expect(gz.Close()).To(Succeed())
+			return buf.Bytes()
+		}
+
+		expectRefused := func(archive []byte, message string) {
+			_, err := cs.ImportVolume(ctx, "crafted", "default", bytes.NewReader(archive))
+			ExpectWithOffset(1, status.Code(err)).To(Equal(codes.InvalidArgument))
+			ExpectWithOffset(1, err.Error()).To(ContainSubstring(message))
+			ExpectWithOffset(1, volumePath("crafted")).NotTo(BeADirectory())
+			_, err = cs.Volume(ctx, "default:crafted")
+			ExpectWithOffset(1, status.Code(err)).To(Equal(codes.NotFound))
+		}
+
+		It("imports a well-formed one", func() {
+			_, err := cs.ImportVolume(ctx, "crafted", "default", bytes.NewReader(craft([]entry{{name: "data/file", typeflag: tar.TypeReg, body: "hello"}}, nil)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.ReadFile(filepath.Join(volumePath("crafted"), "file"))).To(Equal([]byte("hello")))
+		})
+
+		It("refuses entries that escape the volume", func() {
+			for _, name := range []string{"data/../escaped", "data/dir/../../escaped", "../escaped", "/escaped", "elsewhere"} {
+				expectRefused(craft([]entry{{name: name, typeflag: tar.TypeReg, body: "evil"}}, nil), name)
+			}
+			Expect(filepath.Join(root, "default", controller.VolumesRootDir, "escaped")).NotTo(BeAnExistingFile())
+		})
+
+		It("refuses entries reached through a symlink", func() {
+			outside := filepath.Join(root, "outside")
+			Expect(os.Mkdir(outside, 0755)).To(Succeed())
+			expectRefused(craft([]entry{
+				{name: "data/link", typeflag: tar.TypeSymlink, link: outside},
+				{name: "data/link/file", typeflag: tar.TypeReg, body: "evil"},
+			}, nil), "through the symlink data/link")
+			Expect(filepath.Join(outside, "file")).NotTo(BeAnExistingFile())
+		})
+
+		It("refuses a symlink in place of the volume directory", func() {
+			outside := filepath.Join(root, "outside")
+			Expect(os.Mkdir(outside, 0755)).To(Succeed())
+			expectRefused(craft([]entry{
+				{name: "data", typeflag: tar.TypeSymlink, link: outside},
+				{name: "data/file", typeflag: tar.TypeReg, body: "evil"},
+			}, nil), "data must be a directory")
+			Expect(filepath.Join(outside, "file")).NotTo(BeAnExistingFile())
+		})
+
+		It("refuses entries that replace one of another type", func() {
+			outside := filepath.Join(root, "outside")
+			Expect(os.Mkdir(outside, 0755)).To(Succeed())
+			expectRefused(craft([]entry{
+				{name: "data/dir/", typeflag: tar.TypeDir},
+				{name: "data/dir", typeflag: tar.TypeSymlink, link: outside},
+			}, nil), "data/dir replaces an entry of another type")
+			info, err := os.Stat(outside)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		})
+
+		It("refuses hard links and devices", func() {
+			expectRefused(craft([]entry{{name: "data/hard", typeflag: tar.TypeLink, link: "/etc/passwd"}}, nil), "unsupported type")
+			expectRefused(craft([]entry{{name: "data/dev", typeflag: tar.TypeChar}}, nil), "unsupported type")
+		})
+
+		It("refuses checksums that do not match the manifest", func() {
+			expectRefused(craft([]entry{{name: "data/file", typeflag: tar.TypeReg, body: "hello"}}, map[string]string{
+				"data/file": hex.EncodeToString(make([]byte, sha256.Size)),
+			}), "checksum of data/file")
+
+			sum := sha256.Sum256([]byte("hello"))
+			expectRefused(craft([]entry{{name: "data/file", typeflag: tar.TypeReg, body: "hello"}}, map[string]string{
+				"data/file":  hex.EncodeToString(sum[:]),
+				"data/other": hex.EncodeToString(sum[:]),
+			}), "data/other is in the manifest")
+		})
+
+		It("refuses files larger than the capacity", func() {
+			expectRefused(craft([]entry{{name: "data/file", typeflag: tar.TypeReg, body: string(make([]byte, 8192))}}, nil), "capacity")
+		})
+
+		It("refuses what is not an archive", func() {
+			_, err := cs.ImportVolume(ctx, "crafted", "default", bytes.NewReader([]byte("hello")))
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+			buf := &bytes.Buffer{}
+			gz := gzip.NewWriter(buf)
+			archive := tar.NewWriter(gz)
+			Expect(archive.WriteHeader(&tar.Header{Name: "data/file", Typeflag: tar.TypeReg, Mode: 0644})).To(Succeed())
+			Expect(archive.Close()).To(Succeed())
+			Expect(gz.Close()).To(Succeed())
+			_, err = cs.ImportVolume(ctx, "crafted", "default", buf)
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(err.Error()).To(ContainSubstring("rather than metadata.json"))
+		})
+	})
+})
@@ -0,0 +1,678 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BackupParameter is the CreateSnapshot parameter that, set to "true", has
+// the snapshot uploaded to the object store of Config.Backups once it is
+// ready. UploadBackups does the uploading.
+const BackupParameter = "backup"
+
+// BackupIdPrefix begins the id of a backup, which is followed by the id of
+// the snapshot it was uploaded from. CreateVolume restores a backup named as
+// its content source. Pool names cannot contain "/", so a backup id never
+// names a local snapshot.
+const BackupIdPrefix = "backup/"
+
+// BackupVersion is the version of the manifests UploadBackups writes, and
+// the only one restores read.
+const BackupVersion = 1
+
+// DefaultBackupChunkBytes is the size files are split into when
+// BackupConfig.ChunkBytes is 0.
+const DefaultBackupChunkBytes = 8 << 20
+
+// The objects of the backups, below BackupConfig.Prefix: a manifest for each
+// backup, named after its snapshot, and the chunks of the files, named after
+// their SHA-256 checksum so that backups share the chunks they have in common.
+const (
+	backupManifestsDir = "backups/"
+	backupChunksDir    = "chunks/"
+)
+
+type BackupConfig struct {
+	// Endpoint is the URL of the S3-compatible service, e.g. "https://s3.eu-west-1.amazonaws.com".
+	Endpoint string `json:"endpoint"`
+	// Region signs the requests; empty means DefaultS3Region.
+	Region string `json:"region"`
+	Bucket string `json:"bucket"`
+	// Prefix begins the keys of the objects; plugins that share a bucket need prefixes of their own.
+	Prefix string `json:"prefix"`
+	// AccessKeyId and SecretAccessKey sign the requests; empty means AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+	AccessKeyId     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	// ChunkBytes is the size files are split into; 0 means DefaultBackupChunkBytes.
+	ChunkBytes int64           `json:"chunk_bytes"`
+	Retention  BackupRetention `json:"retention"`
+}
+
+// BackupRetention decides which backups UploadBackups deletes. A backup is
+// deleted once either limit is passed; with neither set, backups are kept.
+type BackupRetention struct {
+	// KeepLast is how many of the newest backups of each volume are kept; 0 means no limit.
+	KeepLast int `json:"keep_last"`
+	// KeepDays is how many days after its snapshot was taken a backup is kept; 0 means no limit.
+	KeepDays int `json:"keep_days"`
+}
+
+func (c *BackupConfig) validate() error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("backups: endpoint %q is not an http or https URL", c.Endpoint)
+	}
+	if c.Bucket == "" || strings.Contains(c.Bucket, "/") {
+		return fmt.Errorf("backups: invalid bucket %q", c.Bucket)
+	}
+	if c.ChunkBytes < 0 {
+		return fmt.Errorf("backups: chunk_bytes must not be negative")
+	}
+	if c.Retention.KeepLast < 0 || c.Retention.KeepDays < 0 {
+		return fmt.Errorf("backups: keep_last and keep_days must not be negative")
+	}
+	return nil
+}
+
+func (c *BackupConfig) chunkBytes() int64 {
+	if c.ChunkBytes == 0 {
+		return DefaultBackupChunkBytes
+	}
+	return c.ChunkBytes
+}
+
+func (c *BackupConfig) key(dir, name string) string {
+	prefix := c.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + dir + name
+}
+
+// Backup is the manifest of a snapshot uploaded to the object store.
+type Backup struct {
+	Version        int    `json:"version"`
+	BackupId       string `json:"backup_id"`
+	SnapshotId     string `json:"snapshot_id"`
+	SourceVolumeId string `json:"source_volume_id"`
+	SizeBytes      int64  `json:"size_bytes"`
+	// Backend is BackendImage for the backup of an image volume, which can
+	// only be restored into an image pool.
+	Backend string `json:"backend,omitempty"`
+	FsType  string `json:"fs_type,omitempty"`
+	// CreatedAt is when the snapshot was taken and UploadedAt when the
+	// upload completed, in nanoseconds since the Unix epoch.
+	CreatedAt  int64        `json:"created_at"`
+	UploadedAt int64        `json:"uploaded_at"`
+	Files      []BackupFile `json:"files,omitempty"`
+}
+
+// BackupFile is a directory, regular file or symlink of a backup. Path is
+// relative to the snapshot's directory, with slashes, and a regular file's
+// content is the concatenation of its chunks.
+type BackupFile struct {
+	Path    string      `json:"path"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime int64       `json:"mod_time"`
+	Size    int64       `json:"size,omitempty"`
+	Link    string      `json:"link,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+// backupError reports a backup whose manifest or chunks cannot be restored.
+type backupError struct {
+	message string
+}
+
+func (e *backupError) Error() string { return e.message }
+
+func damagedBackup(format string, args ...interface{}) error {
+	return &backupError{message: fmt.Sprintf(format, args...)}
+}
+
+var errBackupsNotConfigured = grpc.Errorf(codes.FailedPrecondition, "Backups are not configured")
+
+// requestedBackup returns whether the CreateSnapshot parameters ask for the
+// snapshot to be backed up.
+func requestedBackup(parameters map[string]string) (bool, error) {
+	switch parameters[BackupParameter] {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("Parameter %s must be true or false, not %q", BackupParameter, parameters[BackupParameter])
+	}
+}
+
+// backupSnapshotId returns the id of the snapshot a backup id was made from.
+func backupSnapshotId(backupId string) (string, bool) {
+	if !strings.HasPrefix(backupId, BackupIdPrefix) {
+		return "", false
+	}
+	snapId := strings.TrimPrefix(backupId, BackupIdPrefix)
+	if _, _, ok := splitVolumeID(snapId); !ok || strings.Contains(snapId, "/") {
+		return "", false
+	}
+	return snapId, true
+}
+
+func isBackupId(id string) bool {
+	return strings.HasPrefix(id, BackupIdPrefix)
+}
+
+// UploadBackups uploads the snapshots created with BackupParameter that have
+// not been uploaded yet, then deletes the backups the retention policy no
+// longer keeps, along with the chunks no remaining backup uses. A snapshot
+// whose upload fails is retried on the next call.
+func (cs *Controller) UploadBackups(ctx context.Context) error {
+	logger := cs.session(ctx, "upload-backups")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return err
+	}
+	if cs.objects == nil {
+		return errBackupsNotConfigured
+	}
+
+	cs.uploadLock.Lock()
+	defer cs.uploadLock.Unlock()
+
+	cs.lock.Lock()
+	pending := []string{}
+	for _, s := range cs.sortedSnapshots() {
+		if s.Backup && s.ReadyToUse && s.BackedUpAt == 0 {
+			pending = append(pending, s.SnapshotId)
+		}
+	}
+	cs.lock.Unlock()
+
+	var firstErr error
+	for _, snapId := range pending {
+		if err := cs.uploadBackup(ctx, logger, snapId); err != nil {
+			logger.Error("upload-backup-failed", err, lager.Data{"snapshot_id": snapId})
+			if firstErr == nil {
+				firstErr = backupsError(ctx, "upload the backup of "+snapId, err)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return backupsError(ctx, "upload backups", ctx.Err())
+	}
+	if err := cs.pruneBackups(ctx, logger); err != nil {
+		logger.Error("prune-backups-failed", err)
+		if firstErr == nil {
+			firstErr = backupsError(ctx, "prune backups", err)
+		}
+	}
+	return firstErr
+}
+
+// backupsError reports a failure to reach the object store as Unavailable,
+// as it is usually temporary, and leaves errors that have a code alone.
+func backupsError(ctx context.Context, action string, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch ctx.Err() {
+	case context.Canceled:
+		return grpc.Errorf(codes.Canceled, "Request cancelled while trying to %s", action)
+	case context.DeadlineExceeded:
+		return grpc.Errorf(codes.DeadlineExceeded, "Deadline exceeded while trying to %s", action)
+	}
+	return grpc.Errorf(codes.Unavailable, "Failed to %s: %s", action, err.Error())
+}
+
+// uploadBackup uploads the snapshot's chunks, then its manifest, while
+// holding its operation so that it is not deleted underneath the upload.
+func (cs *Controller) uploadBackup(ctx context.Context, logger lager.Logger, snapId string) error {
+	cs.lock.Lock()
+	snapshot, ok := cs.snapshots[snapId]
+	if !ok || snapshot.BackedUpAt != 0 {
+		cs.lock.Unlock()
+		return nil
+	}
+	if err := cs.beginOperation(snapshotOperation(snapId)); err != nil {
+		cs.lock.Unlock()
+		return err
+	}
+	pool := cs.pools[snapshot.Pool]
+	backup := &Backup{
+		Version:        BackupVersion,
+		BackupId:       BackupIdPrefix + snapId,
+		SnapshotId:     snapId,
+		SourceVolumeId: snapshot.SourceVolumeId,
+		SizeBytes:      snapshot.SizeBytes,
+		Backend:        archiveBackend(pool),
+		FsType:         snapshot.FsType,
+		CreatedAt:      snapshot.CreatedAt,
+	}
+	dir := cs.snapshotPath(pool, snapshot.Name)
+	cs.lock.Unlock()
+
+	logger.Info("uploading-backup", lager.Data{"snapshot_id": snapId, "path": dir})
+	err := traced(ctx, "upload-backup", func() error {
+		if err := cs.uploadFiles(ctx, backup, dir); err != nil {
+			return err
+		}
+		backup.UploadedAt = time.Now().UnixNano()
+		data, err := json.Marshal(backup)
+		if err != nil {
+			return err
+		}
+		return cs.objects.Put(ctx, cs.backups.key(backupManifestsDir, snapId+".json"), data)
+	}, attribute.String("path", dir))
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.endOperation(snapshotOperation(snapId))
+
+	if err != nil {
+		return err
+	}
+	snapshot.BackedUpAt = backup.UploadedAt
+	if err := cs.saveState(ctx, logger); err != nil {
+		snapshot.BackedUpAt = 0
+		return err
+	}
+	return nil
+}
+
+// uploadFiles walks dir into backup.Files, uploading the chunks of the
+// regular files that the object store does not have yet.
+func (cs *Controller) uploadFiles(ctx context.Context, backup *Backup, dir string) error {
+	uploaded := map[string]bool{}
+	buf := make([]byte, cs.backups.chunkBytes())
+
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		file := BackupFile{Path: filepath.ToSlash(rel), Mode: info.Mode(), ModTime: info.ModTime().UnixNano()}
+
+		switch {
+		case info.IsDir():
+		case info.Mode().IsRegular():
+			file.Size = info.Size()
+			if file.Chunks, err = cs.uploadChunks(ctx, p, info.Size(), buf, uploaded); err != nil {
+				return err
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			if file.Link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		default:
+			// devices, sockets and pipes have no content to carry over
+			return nil
+		}
+		backup.Files = append(backup.Files, file)
+		return nil
+	})
+}
+
+func (cs *Controller) uploadChunks(ctx context.Context, p string, size int64, buf []byte, uploaded map[string]bool) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chunks := []string{}
+	for size > 0 {
+		n := int64(len(buf))
+		if size < n {
+			n = size
+		}
+		if _, err := io.ReadFull(f, buf[:n]); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return nil, fmt.Errorf("%s shrank while it was uploaded", p)
+			}
+			return nil, err
+		}
+		size -= n
+
+		sum := sha256Hex(buf[:n])
+		chunks = append(chunks, sum)
+		if uploaded[sum] {
+			continue
+		}
+		key := cs.backups.key(backupChunksDir, sum)
+		exists, err := cs.objects.Exists(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			if err := cs.objects.Put(ctx, key, buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+		uploaded[sum] = true
+	}
+	return chunks, nil
+}
+
+// pruneBackups deletes the backups the retention policy no longer keeps,
+// then those of their chunks that no backup kept uses. Chunks no manifest
+// refers to are left alone: they may belong to an upload in progress, or to
+// another plugin sharing the prefix. Restores are held off meanwhile.
+func (cs *Controller) pruneBackups(ctx context.Context, logger lager.Logger) error {
+	cs.pruneLock.Lock()
+	defer cs.pruneLock.Unlock()
+
+	backups, err := cs.listBackups(ctx)
+	if err != nil {
+		return err
+	}
+
+	byVolume := map[string][]*Backup{}
+	for _, b := range backups {
+		byVolume[b.SourceVolumeId] = append(byVolume[b.SourceVolumeId], b)
+	}
+	retention := cs.backups.Retention
+	cutoff := time.Now().Add(-time.Duration(retention.KeepDays) * 24 * time.Hour).UnixNano()
+	used := map[string]bool{}
+	unused := []string{}
+	for _, volBackups := range byVolume {
+		sort.Slice(volBackups, func(i, j int) bool { return volBackups[i].CreatedAt > volBackups[j].CreatedAt })
+		for i, b := range volBackups {
+			expired := (retention.KeepLast > 0 && i >= retention.KeepLast) ||
+				(retention.KeepDays > 0 && b.CreatedAt < cutoff)
+			if !expired {
+				for _, f := range b.Files {
+					for _, sum := range f.Chunks {
+						used[sum] = true
+					}
+				}
+				continue
+			}
+			logger.Info("deleting-backup", lager.Data{"backup_id": b.BackupId, "created_at": time.Unix(0, b.CreatedAt)})
+			if err := cs.objects.Delete(ctx, cs.backups.key(backupManifestsDir, b.SnapshotId+".json")); err != nil {
+				return err
+			}
+			for _, f := range b.Files {
+				unused = append(unused, f.Chunks...)
+			}
+		}
+	}
+
+	deleted := map[string]bool{}
+	for _, sum := range unused {
+		if used[sum] || deleted[sum] {
+			continue
+		}
+		key := cs.backups.key(backupChunksDir, sum)
+		logger.Debug("deleting-chunk", lager.Data{"key": key})
+		if err := cs.objects.Delete(ctx, key); err != nil {
+			return err
+		}
+		deleted[sum] = true
+	}
+	return nil
+}
+
+// Backups lists the backups in the object store, sorted by id, without
+// their files.
+func (cs *Controller) Backups(ctx context.Context) ([]*Backup, error) {
+	if cs.objects == nil {
+		return nil, errBackupsNotConfigured
+	}
+
+	backups, err := cs.listBackups(ctx)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unavailable, "Failed to list backups: %s", err.Error())
+	}
+	for _, b := range backups {
+		b.Files = nil
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].BackupId < backups[j].BackupId })
+	return backups, nil
+}
+
+func (cs *Controller) listBackups(ctx context.Context) ([]*Backup, error) {
+	prefix := cs.backups.key(backupManifestsDir, "")
+	keys, err := cs.objects.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []*Backup{}
+	for _, key := range keys {
+		snapId := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".json")
+		b, err := cs.backup(ctx, BackupIdPrefix+snapId)
+		if err == ErrObjectNotFound {
+			// deleted since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, b)
+	}
+	return backups, nil
+}
+
+// backup reads the manifest of a backup, returning ErrObjectNotFound when
+// the object store has none.
+func (cs *Controller) backup(ctx context.Context, backupId string) (*Backup, error) {
+	snapId, ok := backupSnapshotId(backupId)
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	data, err := cs.objects.Get(ctx, cs.backups.key(backupManifestsDir, snapId+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Backup{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, damagedBackup("the manifest of %s: %s", backupId, err.Error())
+	}
+	if b.Version != BackupVersion {
+		return nil, damagedBackup("backup version %d of %s is not supported", b.Version, backupId)
+	}
+	if b.BackupId != backupId || b.SizeBytes < 0 {
+		return nil, damagedBackup("the manifest of %s describes %s with size %d", backupId, b.BackupId, b.SizeBytes)
+	}
+	return b, nil
+}
+
+// remoteBackup reads the manifest of the backup CreateVolume is to restore.
+// It returns nil if there is none, leaving reserveVolume to decide whether
+// that matters.
+func (cs *Controller) remoteBackup(ctx context.Context, logger lager.Logger, backupId string) (*Backup, error) {
+	if cs.objects == nil {
+		return nil, errBackupsNotConfigured
+	}
+	b, err := cs.backup(ctx, backupId)
+	switch err.(type) {
+	case nil:
+		return b, nil
+	case *backupError:
+		logger.Error("damaged-backup", err)
+		return nil, grpc.Errorf(codes.DataLoss, "Backup %q is damaged: %s", backupId, err.Error())
+	}
+	if err == ErrObjectNotFound {
+		return nil, nil
+	}
+	logger.Error("read-backup-failed", err)
+	return nil, grpc.Errorf(codes.Unavailable, "Failed to read backup %q: %s", backupId, err.Error())
+}
+
+// restoreBackup downloads a backup into a volume reserved by reserveVolume.
+// The files' paths are checked as an archive's are, and the chunks against
+// their checksums. Chunks of zeros are skipped, so that images stay sparse.
+func (cs *Controller) restoreBackup(ctx context.Context, pool *Pool, localVol *LocalVolume, dst string, capacity int64) error {
+	if cs.objects == nil {
+		return errBackupsNotConfigured
+	}
+	cs.pruneLock.RLock()
+	defer cs.pruneLock.RUnlock()
+
+	b, err := cs.backup(ctx, localVol.SourceSnapshotId)
+	if err == ErrObjectNotFound {
+		return damagedBackup("%s no longer exists", localVol.SourceSnapshotId)
+	}
+	if err != nil {
+		return err
+	}
+	if err := cs.prepareCopy(ctx, pool, localVol, dst, capacity); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return err
+	}
+
+	dirs := []dirMode{}
+	symlinks := map[string]bool{}
+	zeros := map[string]int64{}
+	var total int64
+
+	for _, f := range b.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel := path.Clean(f.Path)
+		if rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+			return damagedBackup("%s escapes the volume", f.Path)
+		}
+		// a symlink restored earlier must not lead a file out of dst
+		for p := rel; p != "."; p = path.Dir(p) {
+			if symlinks[p] {
+				return damagedBackup("%s is reached through the symlink %s", f.Path, p)
+			}
+		}
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+		if rel == "." && !f.Mode.IsDir() {
+			return damagedBackup("%s must be a directory", f.Path)
+		}
+		conflict, err := typeConflict(target, f.Mode)
+		if err != nil {
+			return err
+		}
+		if conflict {
+			return damagedBackup("%s replaces a file of another type", f.Path)
+		}
+
+		switch {
+		case f.Mode.IsDir():
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMode{path: target, mode: f.Mode.Perm()})
+		case f.Mode.IsRegular():
+			total += f.Size
+			if capacity > 0 && total > capacity {
+				return damagedBackup("the files hold more than the capacity of %d bytes", capacity)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := cs.restoreFile(ctx, f, target, zeros); err != nil {
+				return err
+			}
+		case f.Mode&os.ModeSymlink != 0:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(f.Link, target); err != nil {
+				return err
+			}
+			symlinks[rel] = true
+		default:
+			return damagedBackup("%s has unsupported mode %s", f.Path, f.Mode)
+		}
+	}
+
+	return applyDirModes(dirs)
+}
+
+// restoreFile writes the chunks of f to target. zeros records the sizes of
+// the chunks found to hold only zeros, which need not be downloaded again.
+func (cs *Controller) restoreFile(ctx context.Context, f BackupFile, target string, zeros map[string]int64) error {
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	var written int64
+	for _, sum := range f.Chunks {
+		size, zero := zeros[sum]
+		if !zero {
+			var data []byte
+			if data, err = cs.chunk(ctx, sum, f.Path); err != nil {
+				break
+			}
+			size = int64(len(data))
+			if isZero(data) {
+				zeros[sum] = size
+				zero = true
+			} else if _, err = out.Write(data); err != nil {
+				break
+			}
+		}
+		if zero {
+			if _, err = out.Seek(size, io.SeekCurrent); err != nil {
+				break
+			}
+		}
+		written += size
+	}
+	if err == nil && written != f.Size {
+		err = damagedBackup("the chunks of %s hold %d bytes rather than %d", f.Path, written, f.Size)
+	}
+	if err == nil {
+		// skipped zeros at the end still count towards the size
+		err = out.Truncate(f.Size)
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	modTime := time.Unix(0, f.ModTime)
+	return os.Chtimes(target, modTime, modTime)
+}
+
+// chunk downloads a chunk of the file at p and checks it against its sum.
+func (cs *Controller) chunk(ctx context.Context, sum, p string) ([]byte, error) {
+	data, err := cs.objects.Get(ctx, cs.backups.key(backupChunksDir, sum))
+	if err == ErrObjectNotFound {
+		return nil, damagedBackup("chunk %s of %s is missing", sum, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sha256Hex(data) != sum {
+		return nil, damagedBackup("chunk %s of %s does not match its checksum", sum, p)
+	}
+	return data, nil
+}
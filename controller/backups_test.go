@@ -0,0 +1,399 @@
+package controller_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/controller/controllerfakes"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Backups", func() {
+	var (
+		root    string
+		standIn *s3StandIn
+		config  controller.Config
+		cs      *controller.Controller
+		ctx     context.Context
+		vc      []*VolumeCapability
+		modTime time.Time
+		big     []byte
+	)
+
+	volumePath := func(name string) string {
+		return filepath.Join(root, "default", controller.VolumesRootDir, name)
+	}
+	newController := func() *controller.Controller {
+		images := &controllerfakes.FakeImages{}
+		images.CreateStub = func(ctx context.Context, path string, size int64, fsType string) error {
+			return ioutil.WriteFile(path, append(make([]byte, 8192), "superblock"...), 0600)
+		}
+		c := controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), images, controller.NewSubvolumes(), controller.NewMemoryRegistry(), config)
+		c.SetLogger(lagertest.NewTestLogger("backups"))
+		ExpectWithOffset(1, c.Recover()).To(Succeed())
+		return c
+	}
+	snapshot := func(name, volId string) {
+		_, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: name, SourceVolumeId: volId, Parameters: map[string]string{controller.BackupParameter: "true"}})
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	}
+	restore := func(name, backupId string) (*CreateVolumeResponse, error) {
+		return cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:                name,
+			VolumeCapabilities:  vc,
+			VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: backupId}}},
+		})
+	}
+	chunks := func() []string {
+		return standIn.keys("plugin-1/chunks/")
+	}
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "backups")
+		Expect(err).NotTo(HaveOccurred())
+		standIn = newS3StandIn("bucket", "AKIDEXAMPLE", "secret")
+		ctx = context.Background()
+		vc = []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}}}
+		modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		config = controller.Config{
+			Pools: []controller.PoolConfig{
+				{Name: "default", Root: filepath.Join(root, "default")},
+				{Name: "images", Root: filepath.Join(root, "images"), Backend: controller.BackendImage},
+			},
+			DefaultPool: "default",
+			Backups: &controller.BackupConfig{
+				Endpoint:        standIn.URL(),
+				Bucket:          "bucket",
+				Prefix:          "plugin-1",
+				AccessKeyId:     "AKIDEXAMPLE",
+				SecretAccessKey: "secret",
+				ChunkBytes:      4096,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		cs = newController()
+
+		_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 1 << 20}})
+		Expect(err).NotTo(HaveOccurred())
+		vol := volumePath("vol")
+		Expect(os.MkdirAll(filepath.Join(vol, "dir", "sub"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(vol, "dir", "sub", "data"), []byte("hello"), 0640)).To(Succeed())
+		Expect(os.Chtimes(filepath.Join(vol, "dir", "sub", "data"), modTime, modTime)).To(Succeed())
+		Expect(os.Symlink("sub/data", filepath.Join(vol, "dir", "link"))).To(Succeed())
+		// three chunks of zeros and a short one of data
+		big = append(make([]byte, 3*4096), "tail"...)
+		Expect(ioutil.WriteFile(filepath.Join(vol, "big"), big, 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		standIn.Close()
+		os.RemoveAll(root)
+	})
+
+	It("uploads the snapshots created with the backup parameter as chunks and a manifest", func() {
+		snapshot("snap", "default:vol")
+		_, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "local", SourceVolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cs.UploadBackups(ctx)).To(Succeed())
+
+		Expect(standIn.keys("plugin-1/backups/")).To(Equal([]string{"plugin-1/backups/default:snap.json"}))
+		// "hello", the chunk of zeros and "tail"
+		Expect(chunks()).To(HaveLen(3))
+
+		snapshots, err := cs.Snapshots(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshots[0].SnapshotId).To(Equal("default:local"))
+		Expect(snapshots[0].BackedUpAt).To(BeZero())
+		Expect(snapshots[1].Backup).To(BeTrue())
+		Expect(snapshots[1].BackedUpAt).NotTo(BeZero())
+
+		backups, err := cs.Backups(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backups).To(HaveLen(1))
+		Expect(backups[0].BackupId).To(Equal("backup/default:snap"))
+		Expect(backups[0].SourceVolumeId).To(Equal("default:vol"))
+		Expect(backups[0].SizeBytes).To(Equal(int64(1 << 20)))
+		Expect(backups[0].Files).To(BeEmpty())
+
+		puts := standIn.count("PUT")
+		Expect(cs.UploadBackups(ctx)).To(Succeed())
+		Expect(standIn.count("PUT")).To(Equal(puts))
+	})
+
+	It("uploads only the chunks the store does not have", func() {
+		snapshot("first", "default:vol")
+		Expect(cs.UploadBackups(ctx)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(volumePath("vol"), "more"), []byte("more"), 0600)).To(Succeed())
+		snapshot("second", "default:vol")
+
+		puts := standIn.count("PUT")
+		Expect(cs.UploadBackups(ctx)).To(Succeed())
+		Expect(standIn.count("PUT") - puts).To(Equal(2))
+		Expect(chunks()).To(HaveLen(4))
+	})
+
+	It("retries an upload that failed", func() {
+		snapshot("snap", "default:vol")
+		standIn.failPuts = true
+		err := cs.UploadBackups(ctx)
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+		Expect(err.Error()).To(ContainSubstring("InternalError"))
+		snapshots, err := cs.Snapshots(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshots[0].BackedUpAt).To(BeZero())
+
+		standIn.failPuts = false
+		Expect(cs.UploadBackups(ctx)).To(Succeed())
+		Expect(standIn.keys("plugin-1/backups/")).To(HaveLen(1))
+	})
+
+	Context("once a backup is uploaded", func() {
+		JustBeforeEach(func() {
+			snapshot("snap", "default:vol")
+			Expect(cs.UploadBackups(ctx)).To(Succeed())
+		})
+
+		expectRestored := func(dir string) {
+			Expect(ioutil.ReadFile(filepath.Join(dir, "dir", "sub", "data"))).To(Equal([]byte("hello")))
+			info, err := os.Stat(filepath.Join(dir, "dir", "sub", "data"))
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			ExpectWithOffset(1, info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+			ExpectWithOffset(1, info.ModTime().Equal(modTime)).To(BeTrue())
+			Expect(os.Readlink(filepath.Join(dir, "dir", "link"))).To(Equal("sub/data"))
+			Expect(ioutil.ReadFile(filepath.Join(dir, "big"))).To(Equal(big))
+		}
+
+		It("restores it through CreateVolume, also once the snapshot is gone", func() {
+			_, err := cs.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: "default:snap"})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := restore("restored", "backup/default:snap")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetVolume().GetCapacityBytes()).To(Equal(int64(1 << 20)))
+			Expect(resp.GetVolume().GetContentSource().GetSnapshot().GetSnapshotId()).To(Equal("backup/default:snap"))
+			expectRestored(volumePath("restored"))
+
+			_, err = restore("restored", "backup/default:snap")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("restores it on a plugin that has lost its disk", func() {
+			Expect(os.RemoveAll(root)).To(Succeed())
+			cs = newController()
+
+			_, err := restore("restored", "backup/default:snap")
+			Expect(err).NotTo(HaveOccurred())
+			expectRestored(volumePath("restored"))
+			volume, err := cs.Volume(ctx, "default:restored")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.Incomplete).To(BeFalse())
+		})
+
+		It("refuses backups that do not exist, overlay clones and pools of another backend", func() {
+			_, err := restore("restored", "backup/default:nope")
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+			_, err = restore("restored", "backup/../default:snap")
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+
+			_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{
+				Name:                "clone",
+				VolumeCapabilities:  vc,
+				Parameters:          map[string]string{controller.CloneParameter: controller.CloneOverlay},
+				VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: "backup/default:snap"}}},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+			_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{
+				Name:                "image",
+				VolumeCapabilities:  vc,
+				Parameters:          map[string]string{controller.PoolParameter: "images"},
+				VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: "backup/default:snap"}}},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+
+		It("reports a chunk that does not match its checksum as data loss", func() {
+			for _, key := range chunks() {
+				standIn.setObject(key, []byte("rotten"))
+			}
+
+			_, err := restore("restored", "backup/default:snap")
+			Expect(status.Code(err)).To(Equal(codes.DataLoss))
+			Expect(err.Error()).To(ContainSubstring("does not match its checksum"))
+		})
+
+		It("refuses manifests whose files escape the volume", func() {
+			manifest := &controller.Backup{}
+			Expect(json.Unmarshal(standIn.object("plugin-1/backups/default:snap.json"), manifest)).To(Succeed())
+			manifest.Files = append(manifest.Files,
+				controller.BackupFile{Path: "link", Mode: os.ModeSymlink | 0777, Link: root},
+				controller.BackupFile{Path: "link/escaped", Mode: 0644},
+			)
+			data, err := json.Marshal(manifest)
+			Expect(err).NotTo(HaveOccurred())
+			standIn.setObject("plugin-1/backups/default:snap.json", data)
+
+			_, err = restore("restored", "backup/default:snap")
+			Expect(status.Code(err)).To(Equal(codes.DataLoss))
+			Expect(err.Error()).To(ContainSubstring("through the symlink link"))
+			Expect(filepath.Join(root, "escaped")).NotTo(BeAnExistingFile())
+
+			manifest.Files[len(manifest.Files)-1].Path = "../../escaped"
+			data, err = json.Marshal(manifest)
+			Expect(err).NotTo(HaveOccurred())
+			standIn.setObject("plugin-1/backups/default:snap.json", data)
+			_, err = restore("again", "backup/default:snap")
+			Expect(status.Code(err)).To(Equal(codes.DataLoss))
+			Expect(err.Error()).To(ContainSubstring("escapes the volume"))
+		})
+
+		It("refuses manifests that replace the volume directory or a file of another type", func() {
+			outside := filepath.Join(root, "outside")
+			Expect(os.Mkdir(outside, 0755)).To(Succeed())
+			manifest := &controller.Backup{}
+			Expect(json.Unmarshal(standIn.object("plugin-1/backups/default:snap.json"), manifest)).To(Succeed())
+			files := manifest.Files
+
+			manifest.Files = append([]controller.BackupFile{{Path: ".", Mode: os.ModeSymlink | 0777, Link: outside}}, files...)
+			data, err := json.Marshal(manifest)
+			Expect(err).NotTo(HaveOccurred())
+			standIn.setObject("plugin-1/backups/default:snap.json", data)
+			_, err = restore("restored", "backup/default:snap")
+			Expect(status.Code(err)).To(Equal(codes.DataLoss))
+			Expect(err.Error()).To(ContainSubstring(". must be a directory"))
+			Expect(ioutil.ReadDir(outside)).To(BeEmpty())
+
+			manifest.Files = append(files,
+				controller.BackupFile{Path: "sneaky", Mode: os.ModeDir | 0644},
+				controller.BackupFile{Path: "sneaky", Mode: os.ModeSymlink | 0777, Link: outside},
+			)
+			data, err = json.Marshal(manifest)
+			Expect(err).NotTo(HaveOccurred())
+			standIn.setObject("plugin-1/backups/default:snap.json", data)
+			_, err = restore("again", "backup/default:snap")
+			Expect(status.Code(err)).To(Equal(codes.DataLoss))
+			Expect(err.Error()).To(ContainSubstring("sneaky replaces a file of another type"))
+			info, err := os.Stat(outside)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		})
+	})
+
+	It("backs up image volumes, which restore only into image pools", func() {
+		vc = []*VolumeCapability{{
+			AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}},
+			AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}}
+		_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "image", VolumeCapabilities: vc, Parameters: map[string]string{controller.PoolParameter: "images"}, CapacityRange: &CapacityRange{RequiredBytes: 1 << 20}})
+		Expect(err).NotTo(HaveOccurred())
+		snapshot("image-snap", "images:image")
+		Expect(cs.UploadBackups(ctx)).To(Succeed())
+
+		_, err = restore("restored", "backup/images:image-snap")
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		resp, err := cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:                "restored",
+			VolumeCapabilities:  vc,
+			Parameters:          map[string]string{controller.PoolParameter: "images"},
+			VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: "backup/images:image-snap"}}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetVolume().GetCapacityBytes()).To(Equal(int64(1 << 20)))
+		image := filepath.Join(root, "images", controller.VolumesRootDir, "restored", controller.ImageFileName)
+		Expect(ioutil.ReadFile(image)).To(Equal(append(make([]byte, 8192), "superblock"...)))
+	})
+
+	It("refuses backup parameters it cannot honour", func() {
+		_, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol", Parameters: map[string]string{controller.BackupParameter: "yes"}})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		snapshot("snap", "default:vol")
+		_, err = cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol"})
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+
+		config.Backups = nil
+		os.RemoveAll(root)
+		cs = newController()
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol", VolumeCapabilities: vc})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol", Parameters: map[string]string{controller.BackupParameter: "true"}})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		_, err = restore("restored", "backup/default:snap")
+		Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+		_, err = cs.Backups(ctx)
+		Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+	})
+
+	Describe("retention", func() {
+		BeforeEach(func() {
+			config.Backups.Retention = controller.BackupRetention{KeepLast: 1, KeepDays: 7}
+		})
+
+		It("keeps the newest backups of each volume and the chunks they use", func() {
+			snapshot("first", "default:vol")
+			Expect(cs.UploadBackups(ctx)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(volumePath("vol"), "dir", "sub", "data"), []byte("changed"), 0640)).To(Succeed())
+			snapshot("second", "default:vol")
+			Expect(cs.UploadBackups(ctx)).To(Succeed())
+
+			backups, err := cs.Backups(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backups).To(HaveLen(1))
+			Expect(backups[0].BackupId).To(Equal("backup/default:second"))
+			Expect(chunks()).To(HaveLen(3))
+			Expect(chunks()).NotTo(ContainElement("plugin-1/chunks/" + sha256Hex("hello")))
+		})
+
+		It("deletes backups older than the days to keep", func() {
+			snapshot("snap", "default:vol")
+			Expect(cs.UploadBackups(ctx)).To(Succeed())
+
+			manifest := &controller.Backup{}
+			Expect(json.Unmarshal(standIn.object("plugin-1/backups/default:snap.json"), manifest)).To(Succeed())
+			manifest.BackupId = "backup/default:old"
+			manifest.SnapshotId = "default:old"
+			manifest.SourceVolumeId = "default:other"
+			manifest.CreatedAt = time.Now().Add(-8 * 24 * time.Hour).UnixNano()
+			manifest.Files = append(manifest.Files, controller.BackupFile{Path: "gone", Mode: 0644, Size: 4, Chunks: []string{sha256Hex("gone")}})
+			data, err := json.Marshal(manifest)
+			Expect(err).NotTo(HaveOccurred())
+			standIn.setObject("plugin-1/backups/default:old.json", data)
+			standIn.setObject("plugin-1/chunks/"+sha256Hex("gone"), []byte("gone"))
+
+			Expect(cs.UploadBackups(ctx)).To(Succeed())
+			Expect(standIn.keys("plugin-1/backups/")).To(Equal([]string{"plugin-1/backups/default:snap.json"}))
+			Expect(chunks()).To(HaveLen(3))
+		})
+
+		It("leaves the chunks no manifest refers to, which may belong to another upload", func() {
+			standIn.setObject("plugin-1/chunks/"+sha256Hex("stray"), []byte("stray"))
+			Expect(cs.UploadBackups(ctx)).To(Succeed())
+			Expect(chunks()).To(Equal([]string{"plugin-1/chunks/" + sha256Hex("stray")}))
+		})
+	})
+})
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
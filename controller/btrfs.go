@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/net/context"
+)
+
+// BackendDirectory is the Pool.Backend that keeps volumes as plain
+// directories even when the pool's root is on btrfs.
+const BackendDirectory = "directory"
+
+//go:generate counterfeiter -o controllerfakes/fake_subvolumes.go . Subvolumes
+
+// Subvolumes manages the btrfs subvolumes that hold the volumes and snapshots
+// of pools on btrfs.
+type Subvolumes interface {
+	// IsBtrfs reports whether path, or the directory it would be created in,
+	// is on a btrfs filesystem.
+	IsBtrfs(path string) (bool, error)
+	// IsSubvolume reports whether path is the top directory of a subvolume.
+	IsSubvolume(path string) (bool, error)
+	// Create makes path a new subvolume, unless it is one already.
+	Create(ctx context.Context, path string) error
+	// Snapshot makes dst a snapshot of the subvolume src.
+	Snapshot(ctx context.Context, src, dst string, readOnly bool) error
+	// Delete deletes the subvolume path; a missing path is not an error.
+	Delete(ctx context.Context, path string) error
+	// EnableQuota turns on qgroups for the filesystem holding path.
+	EnableQuota(ctx context.Context, path string) error
+	// Limit sets the referenced bytes limit of the subvolume path's qgroup;
+	// 0 removes it.
+	Limit(ctx context.Context, path string, bytes int64) error
+}
+
+type btrfsSubvolumes struct{}
+
+// NewSubvolumes returns Subvolumes that run the btrfs command, which must be
+// on the PATH of a host with btrfs pools.
+func NewSubvolumes() Subvolumes {
+	return &btrfsSubvolumes{}
+}
+
+func (*btrfsSubvolumes) IsBtrfs(path string) (bool, error) {
+	return isBtrfs(path)
+}
+
+func (*btrfsSubvolumes) IsSubvolume(path string) (bool, error) {
+	return isSubvolume(path)
+}
+
+func (*btrfsSubvolumes) Create(ctx context.Context, path string) error {
+	if ok, err := isSubvolume(path); err != nil || ok {
+		return err
+	}
+	return btrfs(ctx, "subvolume", "create", path)
+}
+
+func (*btrfsSubvolumes) Snapshot(ctx context.Context, src, dst string, readOnly bool) error {
+	args := []string{"subvolume", "snapshot"}
+	if readOnly {
+		args = append(args, "-r")
+	}
+	return btrfs(ctx, append(args, src, dst)...)
+}
+
+func (*btrfsSubvolumes) Delete(ctx context.Context, path string) error {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+	return btrfs(ctx, "subvolume", "delete", path)
+}
+
+func (*btrfsSubvolumes) EnableQuota(ctx context.Context, path string) error {
+	return btrfs(ctx, "quota", "enable", path)
+}
+
+func (*btrfsSubvolumes) Limit(ctx context.Context, path string, bytes int64) error {
+	size := "none"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+	return btrfs(ctx, "qgroup", "limit", size, path)
+}
+
+func btrfs(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "btrfs", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("btrfs %s: %s: %s", strings.Join(args, " "), err.Error(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// detectBtrfs must be called with cs.lock held. Pools that leave their
+// backend to be detected and whose root is on btrfs get subvolumes for their
+// volumes, and qgroup limits when the filesystem allows them.
+func (cs *Controller) detectBtrfs(logger lager.Logger) {
+	for _, name := range cs.poolOrder {
+		pool := cs.pools[name]
+		if pool.Backend != "" {
+			continue
+		}
+
+		ok, err := cs.subvolumes.IsBtrfs(pool.Root)
+		if err != nil {
+			logger.Error("detect-btrfs-failed", err, lager.Data{"pool": name, "root": pool.Root})
+			continue
+		}
+		if !ok {
+			continue
+		}
+		pool.btrfs = true
+
+		root := cs.volumePath(pool, "")
+		if err := cs.subvolumes.EnableQuota(context.Background(), root); err != nil {
+			logger.Error("btrfs-qgroups-unavailable", err, lager.Data{"pool": name, "root": root})
+		} else {
+			pool.qgroupsEnabled = true
+		}
+		logger.Info("btrfs-detected", lager.Data{"pool": name, "qgroups": pool.qgroupsEnabled})
+	}
+}
+
+// snapshotSubvolume replaces whatever an interrupted attempt left at dst with
+// a snapshot of src.
+func (cs *Controller) snapshotSubvolume(ctx context.Context, src, dst string, readOnly bool) error {
+	if err := cs.subvolumes.Delete(ctx, dst); err != nil {
+		return err
+	}
+	return cs.subvolumes.Snapshot(ctx, src, dst, readOnly)
+}
+
+// removeTree removes a volume or snapshot directory, deleting it as a
+// subvolume when it is one, as read-only snapshots cannot be emptied.
+func (cs *Controller) removeTree(ctx context.Context, path string) error {
+	ok, err := cs.subvolumes.IsSubvolume(path)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return cs.subvolumes.Delete(ctx, path)
+	}
+	return cs.dirTree.Remove(ctx, path)
+}
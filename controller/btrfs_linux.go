@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	btrfsSuperMagic = 0x9123683e
+	// subvolumeRootInode is the inode number of every subvolume's top directory.
+	subvolumeRootInode = 256
+)
+
+func isBtrfs(path string) (bool, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	// a root that does not exist yet will be created on its parent's filesystem
+	for err == syscall.ENOENT && filepath.Dir(path) != path {
+		path = filepath.Dir(path)
+		err = syscall.Statfs(path, &stat)
+	}
+	if err != nil {
+		return false, err
+	}
+	return uint32(stat.Type) == btrfsSuperMagic, nil
+}
+
+func isSubvolume(path string) (bool, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.IsDir() || info.Sys().(*syscall.Stat_t).Ino != subvolumeRootInode {
+		return false, nil
+	}
+	return isBtrfs(path)
+}
@@ -0,0 +1,124 @@
+package controller_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Btrfs on a loopback filesystem", func() {
+	var (
+		dir        string
+		mountPoint string
+		subvolumes controller.Subvolumes
+		cs         *controller.Controller
+		ctx        context.Context
+	)
+
+	BeforeEach(func() {
+		if os.Getuid() != 0 {
+			Skip("loop mounts need root")
+		}
+		for _, tool := range []string{"mkfs.btrfs", "btrfs"} {
+			if _, err := exec.LookPath(tool); err != nil {
+				Skip(tool + " is not installed")
+			}
+		}
+
+		var err error
+		dir, err = ioutil.TempDir("", "btrfs-loopback")
+		Expect(err).NotTo(HaveOccurred())
+		image := filepath.Join(dir, "fs.img")
+		mountPoint = filepath.Join(dir, "mnt")
+		Expect(os.Mkdir(mountPoint, 0755)).To(Succeed())
+
+		f, err := os.Create(image)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Truncate(256 << 20)).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+		out, err := exec.Command("mkfs.btrfs", "-q", image).CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(out))
+		if out, err := exec.Command("mount", "-o", "loop", image, mountPoint).CombinedOutput(); err != nil {
+			Skip("the kernel cannot mount btrfs: " + string(out))
+		}
+
+		ctx = context.Background()
+		subvolumes = controller.NewSubvolumes()
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), subvolumes, controller.NewMemoryRegistry(), controller.DefaultConfig(filepath.Join(mountPoint, "pool")))
+		cs.SetLogger(lagertest.NewTestLogger("btrfs"))
+		Expect(cs.Recover()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if dir == "" {
+			return
+		}
+		exec.Command("umount", mountPoint).Run()
+		os.RemoveAll(dir)
+	})
+
+	volumePath := func(name string) string {
+		return filepath.Join(mountPoint, "pool", controller.VolumesRootDir, name)
+	}
+	vc := []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}}}
+
+	It("keeps volumes, snapshots and clones in subvolumes, limited by qgroups", func() {
+		_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 8 << 20}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subvolumes.IsSubvolume(volumePath("vol"))).To(BeTrue())
+		Expect(ioutil.WriteFile(filepath.Join(volumePath("vol"), "data"), []byte("hello"), 0644)).To(Succeed())
+
+		snap, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+		snapshot := filepath.Join(mountPoint, "pool", controller.SnapshotsRootDir, "snap")
+		Expect(subvolumes.IsSubvolume(snapshot)).To(BeTrue())
+		err = ioutil.WriteFile(filepath.Join(snapshot, "more"), []byte("hello"), 0644)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*os.PathError).Err).To(Equal(syscall.EROFS))
+
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:                "clone",
+			VolumeCapabilities:  vc,
+			VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: snap.GetSnapshot().GetSnapshotId()}}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subvolumes.IsSubvolume(volumePath("clone"))).To(BeTrue())
+		Expect(ioutil.ReadFile(filepath.Join(volumePath("clone"), "data"))).To(Equal([]byte("hello")))
+		Expect(ioutil.WriteFile(filepath.Join(volumePath("clone"), "more"), []byte("hello"), 0644)).To(Succeed())
+
+		// qgroup accounting lags behind writes until they reach the disk
+		big := bytes.Repeat([]byte{'x'}, 1<<20)
+		var writeErr error
+		f, err := os.Create(filepath.Join(volumePath("vol"), "big"))
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 32 && writeErr == nil; i++ {
+			if _, writeErr = f.Write(big); writeErr == nil {
+				writeErr = f.Sync()
+			}
+		}
+		f.Close()
+		Expect(writeErr).To(HaveOccurred())
+		Expect(writeErr.(*os.PathError).Err).To(Equal(syscall.EDQUOT))
+
+		_, err = cs.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: "default:snap"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshot).NotTo(BeADirectory())
+		for _, volId := range []string{"default:vol", "default:clone"} {
+			_, err = cs.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: volId})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(volumePath("vol")).NotTo(BeADirectory())
+	})
+})
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package controller
+
+// btrfs is only detected on linux.
+func isBtrfs(path string) (bool, error)     { return false, nil }
+func isSubvolume(path string) (bool, error) { return false, nil }
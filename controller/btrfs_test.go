@@ -0,0 +1,216 @@
+package controller_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/controller/controllerfakes"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Btrfs pools", func() {
+	var (
+		root       string
+		subvolumes *controllerfakes.FakeSubvolumes
+		created    map[string]bool
+		logger     *lagertest.TestLogger
+		config     controller.Config
+		cs         *controller.Controller
+		ctx        context.Context
+		vc         []*VolumeCapability
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "btrfs")
+		Expect(err).NotTo(HaveOccurred())
+		ctx = context.Background()
+		logger = lagertest.NewTestLogger("btrfs")
+		vc = []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}}}
+
+		// the fake keeps subvolumes as directories it remembers
+		created = map[string]bool{}
+		subvolumes = &controllerfakes.FakeSubvolumes{}
+		subvolumes.IsBtrfsStub = func(path string) (bool, error) {
+			return filepath.Base(path) == "btrfs", nil
+		}
+		subvolumes.IsSubvolumeStub = func(path string) (bool, error) { return created[path], nil }
+		subvolumes.CreateStub = func(ctx context.Context, path string) error {
+			created[path] = true
+			return os.MkdirAll(path, 0755)
+		}
+		subvolumes.SnapshotStub = func(ctx context.Context, src, dst string, readOnly bool) error {
+			created[dst] = true
+			return os.MkdirAll(dst, 0755)
+		}
+		subvolumes.DeleteStub = func(ctx context.Context, path string) error {
+			delete(created, path)
+			return os.RemoveAll(path)
+		}
+
+		config = controller.Config{
+			Pools: []controller.PoolConfig{
+				{Name: "default", Root: filepath.Join(root, "btrfs")},
+				{Name: "plain", Root: filepath.Join(root, "plain")},
+			},
+			DefaultPool: "default",
+		}
+	})
+
+	JustBeforeEach(func() {
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), subvolumes, controller.NewMemoryRegistry(), config)
+		cs.SetLogger(logger)
+		Expect(cs.Recover()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	volumePath := func(pool, name string) string {
+		return filepath.Join(root, pool, controller.VolumesRootDir, name)
+	}
+	snapshotPath := func(pool, name string) string {
+		return filepath.Join(root, pool, controller.SnapshotsRootDir, name)
+	}
+	createVolume := func(name, pool string, capacity int64) {
+		_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:               name,
+			VolumeCapabilities: vc,
+			CapacityRange:      &CapacityRange{RequiredBytes: capacity},
+			Parameters:         map[string]string{controller.PoolParameter: pool},
+		})
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	}
+	restore := func(name, pool, snapId string) {
+		_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:                name,
+			VolumeCapabilities:  vc,
+			Parameters:          map[string]string{controller.PoolParameter: pool},
+			VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: snapId}}},
+		})
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	}
+
+	It("detects the pools whose root is on btrfs and enables qgroups on them", func() {
+		Expect(logger).To(gbytes.Say(`btrfs-detected.*"pool":"default","qgroups":true`))
+		Expect(subvolumes.EnableQuotaCallCount()).To(Equal(1))
+		_, path := subvolumes.EnableQuotaArgsForCall(0)
+		Expect(path).To(Equal(volumePath("btrfs", "")))
+	})
+
+	It("makes each volume a subvolume limited to its capacity", func() {
+		createVolume("vol", "default", 4096)
+		createVolume("vol", "plain", 4096)
+
+		Expect(subvolumes.CreateCallCount()).To(Equal(1))
+		_, path := subvolumes.CreateArgsForCall(0)
+		Expect(path).To(Equal(volumePath("btrfs", "vol")))
+		Expect(volumePath("plain", "vol")).To(BeADirectory())
+
+		Expect(subvolumes.LimitCallCount()).To(Equal(1))
+		_, path, bytes := subvolumes.LimitArgsForCall(0)
+		Expect(path).To(Equal(volumePath("btrfs", "vol")))
+		Expect(bytes).To(Equal(int64(4096)))
+
+		_, err := cs.ControllerExpandVolume(ctx, &ControllerExpandVolumeRequest{VolumeId: "default:vol", CapacityRange: &CapacityRange{RequiredBytes: 8192}})
+		Expect(err).NotTo(HaveOccurred())
+		_, _, bytes = subvolumes.LimitArgsForCall(1)
+		Expect(bytes).To(Equal(int64(8192)))
+	})
+
+	It("snapshots read-only and restores as a writable snapshot", func() {
+		createVolume("vol", "default", 4096)
+		snap, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snap.GetSnapshot().GetReadyToUse()).To(BeTrue())
+
+		Expect(subvolumes.SnapshotCallCount()).To(Equal(1))
+		_, src, dst, readOnly := subvolumes.SnapshotArgsForCall(0)
+		Expect(src).To(Equal(volumePath("btrfs", "vol")))
+		Expect(dst).To(Equal(snapshotPath("btrfs", "snap")))
+		Expect(readOnly).To(BeTrue())
+
+		restore("clone", "default", "default:snap")
+		Expect(subvolumes.SnapshotCallCount()).To(Equal(2))
+		_, src, dst, readOnly = subvolumes.SnapshotArgsForCall(1)
+		Expect(src).To(Equal(snapshotPath("btrfs", "snap")))
+		Expect(dst).To(Equal(volumePath("btrfs", "clone")))
+		Expect(readOnly).To(BeFalse())
+
+		_, path, bytes := subvolumes.LimitArgsForCall(1)
+		Expect(path).To(Equal(volumePath("btrfs", "clone")))
+		Expect(bytes).To(Equal(int64(4096)))
+	})
+
+	It("copies a snapshot restored into another pool", func() {
+		createVolume("vol", "default", 4096)
+		Expect(ioutil.WriteFile(filepath.Join(volumePath("btrfs", "vol"), "data"), []byte("hello"), 0644)).To(Succeed())
+		subvolumes.SnapshotStub = func(ctx context.Context, src, dst string, readOnly bool) error {
+			created[dst] = true
+			return controller.NewDirTree().Copy(ctx, src, dst)
+		}
+		_, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+
+		restore("copy", "plain", "default:snap")
+		Expect(subvolumes.SnapshotCallCount()).To(Equal(1))
+		Expect(ioutil.ReadFile(filepath.Join(volumePath("plain", "copy"), "data"))).To(Equal([]byte("hello")))
+	})
+
+	It("deletes subvolumes rather than emptying them", func() {
+		createVolume("vol", "default", 4096)
+		_, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = cs.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: "default:snap"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cs.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+
+		// the snapshot's leftovers are cleared before it is taken, then each is deleted
+		deleted := []string{}
+		for i := 0; i < subvolumes.DeleteCallCount(); i++ {
+			_, path := subvolumes.DeleteArgsForCall(i)
+			deleted = append(deleted, path)
+		}
+		Expect(deleted).To(Equal([]string{snapshotPath("btrfs", "snap"), snapshotPath("btrfs", "snap"), volumePath("btrfs", "vol")}))
+		Expect(volumePath("btrfs", "vol")).NotTo(BeADirectory())
+	})
+
+	Context("when qgroups cannot be enabled", func() {
+		BeforeEach(func() {
+			subvolumes.EnableQuotaReturns(errors.New("ERROR: quota command failed: Operation not permitted"))
+		})
+
+		It("creates subvolumes without limits", func() {
+			Expect(logger).To(gbytes.Say("btrfs-qgroups-unavailable"))
+			createVolume("vol", "default", 4096)
+			Expect(subvolumes.CreateCallCount()).To(Equal(1))
+			Expect(subvolumes.LimitCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when a pool asks for plain directories", func() {
+		BeforeEach(func() {
+			config.Pools[0].Backend = controller.BackendDirectory
+		})
+
+		It("does not look at its filesystem", func() {
+			createVolume("vol", "default", 4096)
+			Expect(subvolumes.EnableQuotaCallCount()).To(Equal(0))
+			Expect(subvolumes.CreateCallCount()).To(Equal(0))
+			Expect(volumePath("btrfs", "vol")).To(BeADirectory())
+		})
+	})
+})
@@ -1,14 +1,21 @@
 package controller
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
-	"syscall"
+	"sort"
+	"strings"
+	"sync"
 
 	"code.cloudfoundry.org/goshims/filepathshim"
 	"code.cloudfoundry.org/goshims/osshim"
 	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagerctx"
 	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -18,89 +25,782 @@ const VolumesRootDir = "_volumes"
 const MountsRootDir = "_mounts"
 
 type LocalVolume struct {
-	Volume
+	VolumeId       string          `json:"volume_id"`
+	Pool           string          `json:"pool"`
+	Name           string          `json:"name"`
+	CapacityBytes  int64           `json:"capacity_bytes"`
+	PublishedNodes map[string]bool `json:"published_nodes"`
+	// SourceSnapshotId names the snapshot the volume was created from, if any.
+	SourceSnapshotId string `json:"source_snapshot_id,omitempty"`
+	// Incomplete is set while the volume is being populated from its snapshot,
+	// imported from an archive, or formatted.
+	Incomplete bool `json:"incomplete,omitempty"`
+	// Formatting is set, along with Incomplete, while the image of an empty
+	// image volume is being created.
+	Formatting bool `json:"formatting,omitempty"`
+	// Missing is set by Reconcile when the volume's directory has disappeared.
+	Missing bool `json:"missing,omitempty"`
+	// ProjectId is the volume's quota project in a pool that enforces quotas.
+	ProjectId uint32 `json:"project_id,omitempty"`
+	// FsType is the filesystem an image volume's image is formatted with.
+	FsType string `json:"fs_type,omitempty"`
+	// Layers lists the snapshots an overlay volume mounts read-only beneath
+	// its upper directory, topmost first.
+	Layers []string `json:"layers,omitempty"`
+}
+
+// publishedNodes must be called with cs.lock held.
+func (localVol *LocalVolume) publishedNodes() []string {
+	nodes := []string{}
+	for node := range localVol.PublishedNodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
 }
 
 type Controller struct {
-	logger   lager.Logger
-	volumes  map[string]*LocalVolume
-	os       osshim.Os
-	filepath filepathshim.Filepath
-	///Where does this fit into the create/mount logic?
-	mountPathRoot string
+	logger     lager.Logger
+	lock       sync.Mutex
+	volumes    map[string]*LocalVolume
+	snapshots  map[string]*LocalSnapshot
+	operations map[string]bool
+	os         osshim.Os
+	filepath   filepathshim.Filepath
+	diskStats  DiskStats
+	dirTree    DirTree
+	quotas     Quotas
+	images     Images
+	subvolumes Subvolumes
+	registry   Registry
+
+	// objects holds the backups configured by backups, if any. Uploads are
+	// serialized by uploadLock, and restores share pruneLock, which keeps
+	// pruning from deleting the chunks they are reading.
+	backups    *BackupConfig
+	objects    ObjectStore
+	uploadLock sync.Mutex
+	pruneLock  sync.RWMutex
+
+	// ready is false until Recover has loaded the registry
+	ready       bool
+	recoveryErr error
+
+	pools        map[string]*Pool
+	poolOrder    []string
+	defaultPool  string
+	nodes        map[string]map[string]string
+	orphanPolicy string
+
+	// lastReconcile is the report of the most recent Reconcile
+	lastReconcile ReconcileReport
+	// usage is what the most recent RefreshUsage measured, by volume id
+	usage map[string]*LocalVolumeUsage
 }
 
-func NewController(osshim osshim.Os, filepath filepathshim.Filepath, mountPathRoot string) *Controller {
+// NewController expects a config that has passed Config.Validate. The
+// controller refuses volume RPCs until Recover has been called.
+func NewController(osshim osshim.Os, filepath filepathshim.Filepath, diskStats DiskStats, dirTree DirTree, quotas Quotas, images Images, subvolumes Subvolumes, registry Registry, config Config) *Controller {
 	logger := lager.NewLogger("local-controller-plugin")
 	sink := lager.NewReconfigurableSink(lager.NewWriterSink(os.Stdout, lager.DEBUG), lager.DEBUG)
 	logger.RegisterSink(sink)
 
+	pools := map[string]*Pool{}
+	poolOrder := []string{}
+	for _, p := range config.Pools {
+		pools[p.Name] = newPool(p)
+		poolOrder = append(poolOrder, p.Name)
+	}
+
+	var objects ObjectStore
+	if config.Backups != nil {
+		objects = NewS3ObjectStore(*config.Backups)
+	}
+
 	return &Controller{
-		logger:        logger,
-		volumes:       map[string]*LocalVolume{},
-		os:            osshim,
-		filepath:      filepath,
-		mountPathRoot: mountPathRoot,
+		logger:      logger,
+		volumes:     map[string]*LocalVolume{},
+		snapshots:   map[string]*LocalSnapshot{},
+		operations:  map[string]bool{},
+		usage:       map[string]*LocalVolumeUsage{},
+		os:          osshim,
+		filepath:    filepath,
+		diskStats:   diskStats,
+		dirTree:     dirTree,
+		quotas:      quotas,
+		images:      images,
+		subvolumes:  subvolumes,
+		registry:    registry,
+		backups:     config.Backups,
+		objects:     objects,
+		pools:       pools,
+		poolOrder:   poolOrder,
+		defaultPool: config.DefaultPool,
+		nodes:       config.Nodes,
+
+		orphanPolicy: config.OrphanPolicy,
+	}
+}
+
+// Recover loads the registry and, if it is consistent with the configured
+// pools, marks the controller ready. A failure is also reported by Probe.
+func (cs *Controller) Recover() error {
+	logger := cs.logger.Session("recover")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if err := cs.preparePools(logger, true); err != nil {
+		return err
+	}
+	if err := cs.load(logger); err != nil {
+		return err
+	}
+	cs.detectBtrfs(logger)
+	cs.enableQuotas(logger)
+	return nil
+}
+
+// Load is Recover for inspecting the state: it leaves the pools' filesystems
+// alone, setting up neither btrfs nor quotas, and saves nothing.
+func (cs *Controller) Load() error {
+	logger := cs.logger.Session("load")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if err := cs.preparePools(logger, false); err != nil {
+		return err
+	}
+	return cs.load(logger)
+}
+
+// preparePools resolves the pools' roots and, with create, creates the
+// directories their volumes and snapshots are kept in, so that the path
+// helpers only join paths. It must be called with cs.lock held.
+func (cs *Controller) preparePools(logger lager.Logger, create bool) error {
+	for _, name := range cs.poolOrder {
+		if err := cs.preparePool(cs.pools[name], create); err != nil {
+			logger.Error("preparing-pool-failed", err, lager.Data{"pool": name})
+			cs.recoveryErr = fmt.Errorf("pool %q: %s", name, err.Error())
+			return cs.recoveryErr
+		}
+	}
+	return nil
+}
+
+func (cs *Controller) preparePool(pool *Pool, create bool) error {
+	root, err := cs.filepath.Abs(pool.Root)
+	if err != nil {
+		return err
+	}
+	pool.root = root
+	if !create {
+		return nil
+	}
+	for _, dir := range []string{VolumesRootDir, SnapshotsRootDir, QuarantineRootDir} {
+		if err := cs.os.MkdirAll(filepath.Join(root, dir), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load must be called with cs.lock held.
+func (cs *Controller) load(logger lager.Logger) error {
+	state, err := cs.registry.Load()
+	if err == nil {
+		if state.Snapshots == nil {
+			state.Snapshots = map[string]*LocalSnapshot{}
+		}
+		err = cs.checkConsistency(state.Volumes, state.Snapshots)
+	}
+	if err != nil {
+		logger.Error("recovery-failed", err)
+		cs.recoveryErr = err
+		return err
+	}
+
+	for _, v := range state.Volumes {
+		if v.PublishedNodes == nil {
+			v.PublishedNodes = map[string]bool{}
+		}
+	}
+
+	logger.Info("recovered", lager.Data{"volumes": len(state.Volumes), "snapshots": len(state.Snapshots)})
+	cs.volumes = state.Volumes
+	cs.snapshots = state.Snapshots
+	cs.recoveryErr = nil
+	cs.ready = true
+	return nil
+}
+
+// Flush persists the current state. The server calls it after draining
+// in-flight requests on shutdown; state that was never recovered is left alone.
+func (cs *Controller) Flush() error {
+	logger := cs.logger.Session("flush")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if !cs.ready {
+		return nil
+	}
+	return cs.saveState(context.Background(), logger)
+}
+
+// session starts a logging session on the request's logger, which carries the
+// request id when the interceptors installed one, or on the controller's own.
+func (cs *Controller) session(ctx context.Context, task string) lager.Logger {
+	// lagerctx falls back to a logger with no session name when none was installed
+	if logger := lagerctx.FromContext(ctx); logger.SessionName() != "" {
+		return logger.Session(task)
+	}
+	return cs.logger.Session(task)
+}
+
+func (cs *Controller) checkReady() error {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if !cs.ready {
+		return grpc.Errorf(codes.Unavailable, "Controller is recovering its state")
+	}
+	return nil
+}
+
+// saveState must be called with cs.lock held.
+func (cs *Controller) saveState(ctx context.Context, logger lager.Logger) error {
+	err := traced(ctx, "registry-write", func() error {
+		return cs.registry.Save(&State{Volumes: cs.volumes, Snapshots: cs.snapshots})
+	}, attribute.Int("volumes", len(cs.volumes)), attribute.Int("snapshots", len(cs.snapshots)))
+	if err != nil {
+		logger.Error("save-state-failed", err)
+		return grpc.Errorf(codes.Internal, "Failed to persist controller state: %s", err.Error())
+	}
+	return nil
+}
+
+func (cs *Controller) csiVolume(localVol *LocalVolume) *Volume {
+	vol := &Volume{
+		VolumeId:           localVol.VolumeId,
+		CapacityBytes:      localVol.CapacityBytes,
+		AccessibleTopology: cs.pools[localVol.Pool].accessibleTopology(),
+	}
+	if localVol.SourceSnapshotId != "" {
+		vol.ContentSource = &VolumeContentSource{
+			Type: &VolumeContentSource_Snapshot{
+				Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: localVol.SourceSnapshotId},
+			},
+		}
 	}
+	return vol
 }
 
 func (cs *Controller) CreateVolume(ctx context.Context, in *CreateVolumeRequest) (*CreateVolumeResponse, error) {
-	logger := cs.logger.Session("create-volume")
+	logger := cs.session(ctx, "create-volume")
 	logger.Info("start")
 	defer logger.Info("end")
 
-	var volId string = in.GetName()
-	var ok bool
-	if volId == "" {
-		return nil, grpc.Errorf(codes.InvalidArgument, "Volume name not supplied")
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	var volName string = in.GetName()
+	if err := checkVolumeName(volName); err != nil {
+		return nil, err
+	}
+	if len(in.GetVolumeCapabilities()) == 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume capabilities not supplied")
+	}
+
+	if p, ok := in.GetParameters()[PoolParameter]; ok {
+		if _, known := cs.pools[p]; !known {
+			return nil, grpc.Errorf(codes.InvalidArgument, "Unknown pool %q", p)
+		}
+	}
+	pool, ok := cs.selectPool(in.GetParameters(), in.GetAccessibilityRequirements())
+	if !ok {
+		return nil, grpc.Errorf(codes.ResourceExhausted, "No pool is accessible from the requisite topology")
+	}
+	poolName := pool.Name
+	if !pool.Supports(in.GetVolumeCapabilities()) {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Pool %q does not support the requested access mode", poolName)
+	}
+
+	capacity, err := requestedCapacity(in.GetCapacityRange())
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	var fsType string
+	if pool.Backend == BackendImage {
+		if fsType, err = requestedFsType(in.GetVolumeCapabilities()); err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+		}
+	}
+
+	var snapId string
+	if source := in.GetVolumeContentSource(); source != nil {
+		if source.GetSnapshot() == nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "Only snapshots are supported as volume content sources")
+		}
+		snapId = source.GetSnapshot().GetSnapshotId()
 	}
 
-	var localVol *LocalVolume
+	overlay, err := requestedClone(in.GetParameters())
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	if overlay && snapId == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Overlay clones need a snapshot as their content source")
+	}
+	if overlay && pool.Backend == BackendImage {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Pool %q keeps image volumes, which cannot be overlay clones", poolName)
+	}
 
-	logger.Info("creating-volume", lager.Data{"volume_name": volId, "volume_id": volId})
+	volId := volumeID(poolName, volName)
 
-	if _, ok = cs.volumes[volId]; !ok {
-		localVol = &LocalVolume{Volume: Volume{VolumeId: volId}}
-		cs.volumes[in.Name] = localVol
+	// a backup's manifest is only needed to create the volume, not to find
+	// that it exists already
+	var backup *Backup
+	if isBackupId(snapId) {
+		if overlay {
+			return nil, grpc.Errorf(codes.InvalidArgument, "Backups cannot be restored as overlay clones")
+		}
+		cs.lock.Lock()
+		_, exists := cs.volumes[volId]
+		cs.lock.Unlock()
+		if !exists {
+			if backup, err = cs.remoteBackup(ctx, logger, snapId); err != nil {
+				return nil, err
+			}
+		}
 	}
-	localVol = cs.volumes[volId]
 
-	resp := &CreateVolumeResponse{
-		Volume: &localVol.Volume,
+	logger.Info("creating-volume", lager.Data{"volume_name": volName, "volume_id": volId, "pool": poolName, "snapshot_id": snapId, "overlay": overlay})
+
+	vol, populate, err := cs.reserveVolume(ctx, logger, pool, volName, capacity, in.GetCapacityRange(), fsType, snapId, backup, overlay)
+	if err != nil {
+		return nil, err
+	}
+	if populate {
+		vol, err = cs.populateVolume(ctx, logger, volId)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	logger.Info("CreateVolumeResponse", lager.Data{"resp": resp})
-	return resp, nil
+	return &CreateVolumeResponse{
+		Volume: vol,
+	}, nil
 }
 
-func (cs *Controller) DeleteVolume(context context.Context, request *DeleteVolumeRequest) (*DeleteVolumeResponse, error) {
-	logger := cs.logger.Session("delete-volume")
+// checkVolumeName verifies that name can name a volume's directory.
+func checkVolumeName(name string) error {
+	if name == "" {
+		return grpc.Errorf(codes.InvalidArgument, "Volume name not supplied")
+	}
+	if strings.Contains(name, "/") || name == "." || name == ".." {
+		return grpc.Errorf(codes.InvalidArgument, "Volume name %q is not a valid directory name", name)
+	}
+	return nil
+}
+
+func checkSnapshotName(name string) error {
+	if name == "" {
+		return grpc.Errorf(codes.InvalidArgument, "Snapshot name not supplied")
+	}
+	if strings.Contains(name, "/") || name == "." || name == ".." {
+		return grpc.Errorf(codes.InvalidArgument, "Snapshot name %q is not a valid directory name", name)
+	}
+	return nil
+}
+
+// reserveVolume records the volume unless it already exists. An empty volume
+// gets its directory straight away. One created from a snapshot, or from a
+// backup whose manifest is given, or an empty image volume, is saved as
+// incomplete with its operation begun, and the caller must run
+// populateVolume. An overlay clone only needs its upper and work directories,
+// so it is created straight away too. An empty fsType takes the snapshot's or
+// the pool's.
+func (cs *Controller) reserveVolume(ctx context.Context, logger lager.Logger, pool *Pool, volName string, capacity int64, capacityRange *CapacityRange, fsType string, snapId string, backup *Backup, overlay bool) (*Volume, bool, error) {
+	poolName := pool.Name
+	volId := volumeID(poolName, volName)
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	localVol, ok := cs.volumes[volId]
+	if ok {
+		if !capacityInRange(localVol.CapacityBytes, capacityRange) {
+			return nil, false, grpc.Errorf(codes.AlreadyExists, "Volume %q exists with capacity %d", volName, localVol.CapacityBytes)
+		}
+		if localVol.SourceSnapshotId != snapId {
+			return nil, false, grpc.Errorf(codes.AlreadyExists, "Volume %q exists with a different content source", volName)
+		}
+		if fsType != "" && localVol.FsType != fsType {
+			return nil, false, grpc.Errorf(codes.AlreadyExists, "Volume %q exists with fs type %q", volName, localVol.FsType)
+		}
+		if (len(localVol.Layers) > 0) != overlay {
+			return nil, false, grpc.Errorf(codes.AlreadyExists, "Volume %q exists with a different clone mode", volName)
+		}
+		if !localVol.Incomplete {
+			return cs.csiVolume(localVol), false, nil
+		}
+		if localVol.SourceSnapshotId == "" && !localVol.Formatting {
+			return nil, false, grpc.Errorf(codes.FailedPrecondition, "Volume %q is being imported, or its import was interrupted and it must be deleted", volName)
+		}
+		if err := cs.beginOperation(volumeOperation(volId)); err != nil {
+			return nil, false, err
+		}
+		logger.Info("resuming-volume", lager.Data{"volume_id": volId})
+		return cs.csiVolume(localVol), true, nil
+	}
+
+	var layers []string
+	if snapId != "" {
+		var size int64
+		var image bool
+		var snapFsType string
+		var snapLayers []string
+		if isBackupId(snapId) {
+			if backup == nil {
+				return nil, false, grpc.Errorf(codes.NotFound, "Backup %q does not exist", snapId)
+			}
+			size, image, snapFsType = backup.SizeBytes, backup.Backend == BackendImage, backup.FsType
+		} else {
+			snapshot, ok := cs.snapshots[snapId]
+			if !ok {
+				return nil, false, grpc.Errorf(codes.NotFound, "Snapshot %q does not exist", snapId)
+			}
+			if !snapshot.ReadyToUse {
+				return nil, false, grpc.Errorf(codes.FailedPrecondition, "Snapshot %q is not ready to use", snapId)
+			}
+			size, image, snapFsType, snapLayers = snapshot.SizeBytes, cs.pools[snapshot.Pool].Backend == BackendImage, snapshot.FsType, snapshot.Layers
+		}
+		if capacity == 0 {
+			capacity = size
+		} else if capacity < size {
+			return nil, false, grpc.Errorf(codes.OutOfRange, "Requested capacity %d is smaller than snapshot %q (%d bytes)", capacity, snapId, size)
+		}
+
+		// an image can only be restored as an image of the same size and filesystem
+		if image != (pool.Backend == BackendImage) {
+			return nil, false, grpc.Errorf(codes.InvalidArgument, "Snapshot %q cannot be restored into pool %q, which has a different backend", snapId, pool.Name)
+		}
+		if pool.Backend == BackendImage {
+			if capacity != size {
+				return nil, false, grpc.Errorf(codes.OutOfRange, "Image volumes restored from snapshot %q have its size, %d bytes", snapId, size)
+			}
+			if fsType != "" && fsType != snapFsType {
+				return nil, false, grpc.Errorf(codes.InvalidArgument, "Snapshot %q holds a %s filesystem", snapId, snapFsType)
+			}
+			fsType = snapFsType
+		}
+
+		// a snapshot of an overlay volume holds only its changes, so it has
+		// to be mounted over the layers beneath it
+		if overlay {
+			layers = append([]string{snapId}, snapLayers...)
+		} else if len(snapLayers) > 0 {
+			return nil, false, grpc.Errorf(codes.InvalidArgument, "Snapshot %q holds the changes of an overlay volume and can only be restored with %s=%s", snapId, CloneParameter, CloneOverlay)
+		}
+	} else if pool.Backend == BackendImage {
+		if capacity == 0 {
+			capacity = DefaultImageBytes
+		}
+		if fsType == "" {
+			fsType = pool.defaultFsType()
+		}
+	}
+
+	if err := cs.checkCapacity(pool, capacity); err != nil {
+		return nil, false, err
+	}
+
+	var projectId uint32
+	if pool.quotasEnabled {
+		projectId = cs.nextProjectId()
+	}
+
+	// volumes that are copied into or formatted are populated without cs.lock
+	formatting := snapId == "" && pool.Backend == BackendImage
+	incomplete := (snapId != "" && !overlay) || formatting
+	if incomplete {
+		if err := cs.beginOperation(volumeOperation(volId)); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if snapId == "" || overlay {
+		path := cs.volumePath(pool, volName)
+		err := traced(ctx, "create-directory", func() error {
+			if pool.btrfs {
+				return cs.subvolumes.Create(ctx, path)
+			}
+			return cs.os.MkdirAll(path, os.ModePerm)
+		}, attribute.String("path", path))
+		if err == nil {
+			if err = cs.limit(ctx, pool, &LocalVolume{ProjectId: projectId}, path, capacity); err != nil {
+				logger.Error("limit-failed", err)
+				cs.removeTree(ctx, path)
+				err = grpc.Errorf(codes.Internal, "Failed to limit volume directory: %s", err.Error())
+			}
+		} else {
+			logger.Error("mkdir-failed", err)
+			err = grpc.Errorf(codes.Internal, "Failed to create volume directory: %s", err.Error())
+		}
+		if err == nil && overlay {
+			if err = cs.createOverlayDirs(path); err != nil {
+				logger.Error("create-overlay-dirs-failed", err)
+				cs.removeTree(ctx, path)
+				err = grpc.Errorf(codes.Internal, "Failed to create overlay directories: %s", err.Error())
+			}
+		}
+		if err != nil {
+			if incomplete {
+				cs.endOperation(volumeOperation(volId))
+			}
+			return nil, false, err
+		}
+	}
+
+	localVol = &LocalVolume{
+		VolumeId:         volId,
+		Pool:             poolName,
+		Name:             volName,
+		CapacityBytes:    capacity,
+		PublishedNodes:   map[string]bool{},
+		SourceSnapshotId: snapId,
+		Incomplete:       incomplete,
+		Formatting:       formatting,
+		ProjectId:        projectId,
+		FsType:           fsType,
+		Layers:           layers,
+	}
+	cs.volumes[volId] = localVol
+
+	if err := cs.saveState(ctx, logger); err != nil {
+		delete(cs.volumes, volId)
+		if localVol.Incomplete {
+			cs.endOperation(volumeOperation(volId))
+		}
+		return nil, false, err
+	}
+	return cs.csiVolume(localVol), localVol.Incomplete, nil
+}
+
+// checkCapacity must be called with cs.lock held. It verifies that the pool
+// has room for a new volume of the given capacity.
+func (cs *Controller) checkCapacity(pool *Pool, capacity int64) error {
+	if pool.CapacityBytes == 0 {
+		return nil
+	}
+	if capacity > pool.CapacityBytes {
+		return grpc.Errorf(codes.OutOfRange, "Requested capacity %d exceeds the capacity of pool %q", capacity, pool.Name)
+	}
+	if cs.usedCapacity(pool.Name)+capacity > pool.CapacityBytes {
+		return grpc.Errorf(codes.ResourceExhausted, "Pool %q does not have %d bytes available", pool.Name, capacity)
+	}
+	return nil
+}
+
+func (cs *Controller) DeleteVolume(ctx context.Context, request *DeleteVolumeRequest) (*DeleteVolumeResponse, error) {
+	logger := cs.session(ctx, "delete-volume")
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
 	volId := request.GetVolumeId()
 	if volId == "" {
 		return nil, grpc.Errorf(codes.InvalidArgument, "Volume name not supplied")
 	}
 
-	delete(cs.volumes, volId)
+	// the pool is taken from the id rather than the volume map so that
+	// directories left behind by an earlier controller process are removed
+	// too; a name that is not a directory name cannot belong to a volume
+	poolName, volName, ok := splitVolumeID(volId)
+	pool, known := cs.pools[poolName]
+	if !ok || !known || checkVolumeName(volName) != nil {
+		return &DeleteVolumeResponse{}, nil
+	}
+
+	cs.lock.Lock()
+	for _, snapshot := range cs.snapshots {
+		if snapshot.SourceVolumeId == volId && cs.operations[snapshotOperation(snapshot.SnapshotId)] {
+			cs.lock.Unlock()
+			return nil, grpc.Errorf(codes.Aborted, "Volume %q is being copied into snapshot %q", volId, snapshot.SnapshotId)
+		}
+	}
+	if err := cs.beginOperation(volumeOperation(volId)); err != nil {
+		cs.lock.Unlock()
+		return nil, err
+	}
+	path := cs.volumePath(pool, volName)
+	cs.lock.Unlock()
+
+	// a removal cut short leaves the volume recorded, so a retry finishes it
+	err := traced(ctx, "remove-directory", func() error {
+		return cs.removeTree(ctx, path)
+	}, attribute.String("path", path))
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.endOperation(volumeOperation(volId))
+
+	if err != nil {
+		return nil, operationError(ctx, logger, "remove volume directory", err)
+	}
+
+	if localVol, ok := cs.volumes[volId]; ok {
+		if pool.quotasEnabled && localVol.ProjectId != 0 {
+			// the project's directory is gone, so a stale limit only matters if the id is reused
+			if err := cs.quotas.Clear(cs.volumePath(pool, ""), localVol.ProjectId); err != nil {
+				logger.Error("clear-quota-failed", err, lager.Data{"project_id": localVol.ProjectId})
+			}
+		}
+		delete(cs.volumes, volId)
+		delete(cs.usage, volId)
+		if err := cs.saveState(ctx, logger); err != nil {
+			return nil, err
+		}
+	}
 
 	return &DeleteVolumeResponse{}, nil
 }
 
 func (cs *Controller) ControllerPublishVolume(ctx context.Context, in *ControllerPublishVolumeRequest) (*ControllerPublishVolumeResponse, error) {
-	return &ControllerPublishVolumeResponse{PublishContext: map[string]string{}}, nil
+	logger := cs.session(ctx, "publish-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+	if in.GetNodeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Node id not supplied")
+	}
+	if in.GetVolumeCapability() == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume capability not supplied")
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	localVol, ok := cs.volumes[in.GetVolumeId()]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "Volume %q does not exist", in.GetVolumeId())
+	}
+
+	if localVol.Incomplete {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "Volume %q is still being populated from snapshot %q", in.GetVolumeId(), localVol.SourceSnapshotId)
+	}
+
+	pool := cs.pools[localVol.Pool]
+	if !segmentsContain(cs.nodeSegments(in.GetNodeId()), pool.Topology) {
+		logger.Info("node-outside-topology", lager.Data{"volume_id": in.GetVolumeId(), "node_id": in.GetNodeId(), "topology": pool.Topology})
+		return nil, grpc.Errorf(codes.FailedPrecondition, "Volume %q is not accessible from node %q", in.GetVolumeId(), in.GetNodeId())
+	}
+
+	if pool.Backend == BackendImage {
+		for node := range localVol.PublishedNodes {
+			if node != in.GetNodeId() {
+				return nil, grpc.Errorf(codes.FailedPrecondition, "Image volume %q is already published to node %q", in.GetVolumeId(), node)
+			}
+		}
+	}
+
+	logger.Info("publishing-volume", lager.Data{"volume_id": in.GetVolumeId(), "node_id": in.GetNodeId()})
+	if !localVol.PublishedNodes[in.GetNodeId()] {
+		localVol.PublishedNodes[in.GetNodeId()] = true
+		if err := cs.saveState(ctx, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ControllerPublishVolumeResponse{PublishContext: cs.publishContext(localVol)}, nil
 }
 
 func (cs *Controller) ControllerUnpublishVolume(ctx context.Context, in *ControllerUnpublishVolumeRequest) (*ControllerUnpublishVolumeResponse, error) {
+	logger := cs.session(ctx, "unpublish-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if localVol, ok := cs.volumes[in.GetVolumeId()]; ok && len(localVol.PublishedNodes) > 0 {
+		// an empty node id unpublishes the volume from every node
+		if in.GetNodeId() == "" {
+			localVol.PublishedNodes = map[string]bool{}
+		} else {
+			delete(localVol.PublishedNodes, in.GetNodeId())
+		}
+		if err := cs.saveState(ctx, logger); err != nil {
+			return nil, err
+		}
+	}
+
 	return &ControllerUnpublishVolumeResponse{}, nil
 }
 
 func (cs *Controller) ValidateVolumeCapabilities(ctx context.Context, in *ValidateVolumeCapabilitiesRequest) (*ValidateVolumeCapabilitiesResponse, error) {
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+	if len(in.GetVolumeCapabilities()) == 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume capabilities not supplied")
+	}
+
+	cs.lock.Lock()
+	localVol, ok := cs.volumes[in.GetVolumeId()]
+	cs.lock.Unlock()
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "Volume %q does not exist", in.GetVolumeId())
+	}
+
+	if !cs.pools[localVol.Pool].Supports(in.GetVolumeCapabilities()) {
+		return &ValidateVolumeCapabilitiesResponse{
+			Message: "Access mode is not supported by the volume's pool.",
+		}, nil
+	}
+
 	for _, vc := range in.GetVolumeCapabilities() {
-		if vc.GetMount().GetFsType() != "" {
+		// an image volume's filesystem was chosen when it was created
+		if fsType := vc.GetMount().GetFsType(); fsType != "" && fsType != localVol.FsType {
+			if localVol.FsType == "" {
+				return &ValidateVolumeCapabilitiesResponse{
+					Message: "Specifying FsType is unsupported.",
+				}, nil
+			}
 			return &ValidateVolumeCapabilitiesResponse{
-				Message: "Specifying FsType is unsupported.",
+				Message: fmt.Sprintf("The volume holds a %s filesystem, not %s.", localVol.FsType, fsType),
 			}, nil
 		}
 
@@ -121,17 +821,80 @@ func (cs *Controller) ValidateVolumeCapabilities(ctx context.Context, in *Valida
 }
 
 func (cs *Controller) ListVolumes(ctx context.Context, in *ListVolumesRequest) (*ListVolumesResponse, error) {
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
 	var volList []*ListVolumesResponse_Entry
 
-	for _, v := range cs.volumes {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	volIds := []string{}
+	for volId := range cs.volumes {
+		volIds = append(volIds, volId)
+	}
+	sort.Strings(volIds)
+
+	start, end, next, err := page(len(volIds), in.GetMaxEntries(), in.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, volId := range volIds[start:end] {
+		v := cs.volumes[volId]
+		volume := cs.csiVolume(v)
+		volume.VolumeContext = cs.usageContext(volId)
 		entry := &ListVolumesResponse_Entry{
-			Volume: &v.Volume,
+			Volume: volume,
+			Status: &ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: v.publishedNodes(),
+				VolumeCondition:  cs.volumeCondition(v),
+			},
 		}
 		volList = append(volList, entry)
 	}
 
 	return &ListVolumesResponse{
-		Entries: volList,
+		Entries:   volList,
+		NextToken: next,
+	}, nil
+}
+
+func (cs *Controller) ControllerGetVolume(ctx context.Context, in *ControllerGetVolumeRequest) (*ControllerGetVolumeResponse, error) {
+	logger := cs.session(ctx, "get-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	localVol, ok := cs.volumes[in.GetVolumeId()]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "Volume %q does not exist", in.GetVolumeId())
+	}
+
+	condition := cs.volumeCondition(localVol)
+	if condition.GetAbnormal() {
+		logger.Info("volume-abnormal", lager.Data{"volume_id": localVol.VolumeId, "message": condition.GetMessage()})
+	}
+
+	volume := cs.csiVolume(localVol)
+	volume.VolumeContext = cs.usageContext(localVol.VolumeId)
+	return &ControllerGetVolumeResponse{
+		Volume: volume,
+		Status: &ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: localVol.publishedNodes(),
+			VolumeCondition:  condition,
+		},
 	}, nil
 }
 
@@ -144,17 +907,89 @@ func (cs *Controller) GetPluginCapabilities(ctx context.Context, in *GetPluginCa
 				},
 			},
 		},
+		{
+			Type: &PluginCapability_Service_{
+				Service: &PluginCapability_Service{
+					Type: PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+				},
+			},
+		},
+		{
+			Type: &PluginCapability_VolumeExpansion_{
+				VolumeExpansion: &PluginCapability_VolumeExpansion{
+					Type: PluginCapability_VolumeExpansion_ONLINE,
+				},
+			},
+		},
 	}}, nil
 }
 
 func (cs *Controller) GetCapacity(ctx context.Context, in *GetCapacityRequest) (*GetCapacityResponse, error) {
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	poolName := cs.defaultPool
+	if p, ok := in.GetParameters()[PoolParameter]; ok {
+		poolName = p
+	}
+	pool, ok := cs.pools[poolName]
+	if !ok {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Unknown pool %q", poolName)
+	}
+
+	if !pool.Supports(in.GetVolumeCapabilities()) {
+		return &GetCapacityResponse{AvailableCapacity: 0}, nil
+	}
+
+	if in.GetAccessibleTopology() != nil && !pool.accessibleFrom([]*Topology{in.GetAccessibleTopology()}) {
+		return &GetCapacityResponse{AvailableCapacity: 0}, nil
+	}
+
+	if pool.CapacityBytes == 0 {
+		return &GetCapacityResponse{
+			AvailableCapacity: math.MaxInt64,
+		}, nil
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	available := pool.CapacityBytes - cs.usedCapacity(poolName)
+	if available < 0 {
+		available = 0
+	}
 	return &GetCapacityResponse{
-		AvailableCapacity: ^int64(0),
+		AvailableCapacity: available,
 	}, nil
 }
 
 func (cs *Controller) Probe(ctx context.Context, in *ProbeRequest) (*ProbeResponse, error) {
-	return &ProbeResponse{}, nil
+	logger := cs.session(ctx, "probe")
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if cs.recoveryErr != nil {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "State registry failed to load: %s", cs.recoveryErr.Error())
+	}
+	if !cs.ready {
+		return &ProbeResponse{Ready: &wrappers.BoolValue{Value: false}}, nil
+	}
+
+	if err := cs.checkConsistency(cs.volumes, cs.snapshots); err != nil {
+		logger.Error("state-inconsistent", err)
+		return nil, grpc.Errorf(codes.FailedPrecondition, "State registry is inconsistent: %s", err.Error())
+	}
+
+	for _, name := range cs.poolOrder {
+		if err := cs.checkPool(logger, cs.pools[name]); err != nil {
+			logger.Error("pool-check-failed", err, lager.Data{"pool": name})
+			return nil, grpc.Errorf(codes.FailedPrecondition, "Pool %q is not healthy: %s", name, err.Error())
+		}
+	}
+
+	return &ProbeResponse{Ready: &wrappers.BoolValue{Value: true}}, nil
 }
 
 func (cs *Controller) ControllerGetCapabilities(ctx context.Context, in *ControllerGetCapabilitiesRequest) (*ControllerGetCapabilitiesResponse, error) {
@@ -188,20 +1023,257 @@ func (cs *Controller) ControllerGetCapabilities(ctx context.Context, in *Control
 					},
 				},
 			},
+			{
+				Type: &ControllerServiceCapability_Rpc{
+					Rpc: &ControllerServiceCapability_RPC{
+						Type: ControllerServiceCapability_RPC_EXPAND_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &ControllerServiceCapability_Rpc{
+					Rpc: &ControllerServiceCapability_RPC{
+						Type: ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+					},
+				},
+			},
+			{
+				Type: &ControllerServiceCapability_Rpc{
+					Rpc: &ControllerServiceCapability_RPC{
+						Type: ControllerServiceCapability_RPC_GET_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &ControllerServiceCapability_Rpc{
+					Rpc: &ControllerServiceCapability_RPC{
+						Type: ControllerServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
+			{
+				Type: &ControllerServiceCapability_Rpc{
+					Rpc: &ControllerServiceCapability_RPC{
+						Type: ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+					},
+				},
+			},
+			{
+				Type: &ControllerServiceCapability_Rpc{
+					Rpc: &ControllerServiceCapability_RPC{
+						Type: ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
+func (cs *Controller) ControllerExpandVolume(ctx context.Context, in *ControllerExpandVolumeRequest) (*ControllerExpandVolumeResponse, error) {
+	logger := cs.session(ctx, "expand-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	volId := in.GetVolumeId()
+	if volId == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+	if in.GetCapacityRange() == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Capacity range not supplied")
+	}
+
+	capacity, err := requestedCapacity(in.GetCapacityRange())
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	localVol, ok := cs.volumes[volId]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "Volume %q does not exist", volId)
+	}
+
+	// a volume that already holds the required bytes satisfies the range;
+	// only a limit below its capacity asks it to shrink
+	if localVol.CapacityBytes > 0 {
+		if limit := in.GetCapacityRange().GetLimitBytes(); limit > 0 && limit < localVol.CapacityBytes {
+			return nil, grpc.Errorf(codes.OutOfRange, "Volume %q cannot shrink from %d to %d bytes", volId, localVol.CapacityBytes, limit)
+		}
+		if in.GetCapacityRange().GetRequiredBytes() <= localVol.CapacityBytes {
+			capacity = localVol.CapacityBytes
+		}
+	}
+
+	pool := cs.pools[localVol.Pool]
+	image := pool.Backend == BackendImage
+	if image && localVol.Incomplete {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "Volume %q is still being created", volId)
+	}
+	if pool.CapacityBytes > 0 && cs.usedCapacity(pool.Name)-localVol.CapacityBytes+capacity > pool.CapacityBytes {
+		return nil, grpc.Errorf(codes.OutOfRange, "Pool %q does not have room to expand volume %q to %d bytes", pool.Name, volId, capacity)
+	}
+
+	logger.Info("expanding-volume", lager.Data{"volume_id": volId, "from": localVol.CapacityBytes, "to": capacity})
+	if capacity != localVol.CapacityBytes {
+		if image {
+			path := cs.imagePath(pool, localVol.Name)
+			err := traced(ctx, "grow-image", func() error {
+				return cs.images.Grow(ctx, path, capacity)
+			}, attribute.String("path", path))
+			if err != nil {
+				logger.Error("grow-image-failed", err)
+				return nil, grpc.Errorf(codes.Internal, "Failed to grow the volume's image: %s", err.Error())
+			}
+		} else if err := cs.limit(ctx, pool, localVol, cs.volumePath(pool, localVol.Name), capacity); err != nil {
+			logger.Error("limit-failed", err)
+			return nil, grpc.Errorf(codes.Internal, "Failed to raise the volume's quota: %s", err.Error())
+		}
+		localVol.CapacityBytes = capacity
+		if err := cs.saveState(ctx, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	// directory volumes grow in place; block access and an image's
+	// filesystem need the node to resize them
+	return &ControllerExpandVolumeResponse{
+		CapacityBytes:         capacity,
+		NodeExpansionRequired: image || in.GetVolumeCapability().GetBlock() != nil,
+	}, nil
+}
+
 func (cs *Controller) CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest) (*CreateSnapshotResponse, error) {
-	return nil, grpc.Errorf(codes.Unimplemented, "Snapshots not implemented")
+	logger := cs.session(ctx, "create-snapshot")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	name := in.GetName()
+	if err := checkSnapshotName(name); err != nil {
+		return nil, err
+	}
+	if in.GetSourceVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Source volume id not supplied")
+	}
+
+	backup, err := requestedBackup(in.GetParameters())
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	if backup && cs.objects == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Snapshots cannot be backed up, as no object store is configured")
+	}
+
+	logger.Info("creating-snapshot", lager.Data{"snapshot_name": name, "source_volume_id": in.GetSourceVolumeId(), "backup": backup})
+	snapshot, copy, err := cs.reserveSnapshot(ctx, logger, name, in.GetSourceVolumeId(), backup)
+	if err != nil {
+		return nil, err
+	}
+	if copy {
+		snapshot, err = cs.copySnapshot(ctx, logger, snapshot.GetSnapshotId())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &CreateSnapshotResponse{Snapshot: snapshot}, nil
 }
 
 func (cs *Controller) DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest) (*DeleteSnapshotResponse, error) {
-	return nil, grpc.Errorf(codes.Unimplemented, "Snapshots not implemented")
+	logger := cs.session(ctx, "delete-snapshot")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	snapId := in.GetSnapshotId()
+	if snapId == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Snapshot id not supplied")
+	}
+
+	// as with volumes, a name that is not a directory name cannot belong to
+	// a snapshot
+	poolName, name, ok := splitVolumeID(snapId)
+	pool, known := cs.pools[poolName]
+	if !ok || !known || checkSnapshotName(name) != nil {
+		return &DeleteSnapshotResponse{}, nil
+	}
+
+	cs.lock.Lock()
+	for _, v := range cs.volumes {
+		if v.Incomplete && v.SourceSnapshotId == snapId {
+			cs.lock.Unlock()
+			return nil, grpc.Errorf(codes.FailedPrecondition, "Snapshot %q is being restored into volume %q", snapId, v.VolumeId)
+		}
+	}
+	if dependent, ok := cs.layerDependent(snapId); ok {
+		cs.lock.Unlock()
+		return nil, grpc.Errorf(codes.FailedPrecondition, "Snapshot %q is a layer of %q", snapId, dependent)
+	}
+	if err := cs.beginOperation(snapshotOperation(snapId)); err != nil {
+		cs.lock.Unlock()
+		return nil, err
+	}
+	path := cs.snapshotPath(pool, name)
+	cs.lock.Unlock()
+
+	err := traced(ctx, "remove-directory", func() error {
+		return cs.removeTree(ctx, path)
+	}, attribute.String("path", path))
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.endOperation(snapshotOperation(snapId))
+
+	if err != nil {
+		return nil, operationError(ctx, logger, "remove snapshot directory", err)
+	}
+
+	if _, ok := cs.snapshots[snapId]; ok {
+		delete(cs.snapshots, snapId)
+		if err := cs.saveState(ctx, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DeleteSnapshotResponse{}, nil
 }
 
 func (cs *Controller) ListSnapshots(ctx context.Context, in *ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
-	return &ListSnapshotsResponse{}, nil
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	var entries []*ListSnapshotsResponse_Entry
+	for _, s := range cs.sortedSnapshots() {
+		if in.GetSnapshotId() != "" && s.SnapshotId != in.GetSnapshotId() {
+			continue
+		}
+		if in.GetSourceVolumeId() != "" && s.SourceVolumeId != in.GetSourceVolumeId() {
+			continue
+		}
+		entries = append(entries, &ListSnapshotsResponse_Entry{Snapshot: cs.csiSnapshot(s)})
+	}
+
+	start, end, next, err := page(len(entries), in.GetMaxEntries(), in.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+	return &ListSnapshotsResponse{Entries: entries[start:end], NextToken: next}, nil
 }
 
 func (cs *Controller) GetPluginInfo(ctx context.Context, in *GetPluginInfoRequest) (*GetPluginInfoResponse, error) {
@@ -211,20 +1283,47 @@ func (cs *Controller) GetPluginInfo(ctx context.Context, in *GetPluginInfoReques
 	}, nil
 }
 
-func (cs *Controller) volumePath(logger lager.Logger, volumeId string) string {
-	dir, err := cs.filepath.Abs(cs.mountPathRoot)
-	if err != nil {
-		logger.Fatal("abs-failed", err)
+// VolumePath returns the directory holding a volume's data, for front-ends
+// that hand it to a container runtime.
+func (cs *Controller) VolumePath(ctx context.Context, volId string) (string, error) {
+	if err := cs.checkReady(); err != nil {
+		return "", err
 	}
 
-	volumesPathRoot := filepath.Join(dir, VolumesRootDir)
-	orig := syscall.Umask(000)
-	defer syscall.Umask(orig)
-	err = cs.os.MkdirAll(volumesPathRoot, os.ModePerm)
+	cs.lock.Lock()
+	localVol, ok := cs.volumes[volId]
+	cs.lock.Unlock()
+	if !ok {
+		return "", grpc.Errorf(codes.NotFound, "Volume %q does not exist", volId)
+	}
+	return cs.volumePath(cs.pools[localVol.Pool], localVol.Name), nil
+}
 
-	if err != nil {
-		logger.Fatal("mkdir-all-failed", err)
+// ImagePath returns the image of an image volume, or "" for other volumes.
+func (cs *Controller) ImagePath(ctx context.Context, volId string) (string, error) {
+	if err := cs.checkReady(); err != nil {
+		return "", err
 	}
 
-	return filepath.Join(volumesPathRoot, volumeId)
+	cs.lock.Lock()
+	localVol, ok := cs.volumes[volId]
+	cs.lock.Unlock()
+	if !ok {
+		return "", grpc.Errorf(codes.NotFound, "Volume %q does not exist", volId)
+	}
+	pool := cs.pools[localVol.Pool]
+	if pool.Backend != BackendImage {
+		return "", nil
+	}
+	return cs.imagePath(pool, localVol.Name), nil
+}
+
+func (cs *Controller) volumePath(pool *Pool, volumeName string) string {
+	return cs.poolPath(pool, VolumesRootDir, volumeName)
+}
+
+// poolPath returns the path of name in the pool's rootDir. Recover creates
+// the rootDirs.
+func (cs *Controller) poolPath(pool *Pool, rootDir, name string) string {
+	return filepath.Join(pool.root, rootDir, name)
 }
@@ -1,15 +1,28 @@
 package controller_test
 
 import (
+	"errors"
+	"math"
+	"os"
+	"syscall"
 	"time"
 
 	"code.cloudfoundry.org/goshims/filepathshim/filepath_fake"
 	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagerctx"
+	"code.cloudfoundry.org/lager/lagertest"
 	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/controller/controllerfakes"
 	. "github.com/container-storage-interface/spec/lib/go/csi"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -26,23 +39,35 @@ var _ = Describe("ControllerService", func() {
 		cs      *controller.Controller
 		context context.Context
 
-		fakeOs       *os_fake.FakeOs
-		fakeFilepath *filepath_fake.FakeFilepath
-		mountDir     string
-		volumeName   string
-		volumeId     string
-		vc           []*VolumeCapability
-		vol          *Volume
-		err          error
+		fakeOs        *os_fake.FakeOs
+		fakeFilepath  *filepath_fake.FakeFilepath
+		fakeDiskStats *controllerfakes.FakeDiskStats
+		fakeDirTree   *controllerfakes.FakeDirTree
+		registry      controller.Registry
+		mountDir      string
+		volumeName    string
+		volumeId      string
+		vc            []*VolumeCapability
+		vol           *Volume
+		err           error
 	)
 
 	BeforeEach(func() {
 		mountDir = "/path/to/mount"
 		fakeOs = &os_fake.FakeOs{}
 		fakeFilepath = &filepath_fake.FakeFilepath{}
-		cs = controller.NewController(fakeOs, fakeFilepath, mountDir)
+		fakeFilepath.AbsStub = func(path string) (string, error) {
+			return path, nil
+		}
+		fakeOs.StatReturns(&FakeFileInfo{FileMode: os.ModeDir | 0755, Stat: &syscall.Stat_t{Uid: 1000, Gid: 1000}}, nil)
+		fakeOs.GeteuidReturns(1000)
+		fakeOs.GetegidReturns(1000)
+		fakeDiskStats = &controllerfakes.FakeDiskStats{}
+		fakeDirTree = &controllerfakes.FakeDirTree{}
+		registry = controller.NewMemoryRegistry()
+		cs = newRecoveredController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, registry, controller.DefaultConfig(mountDir))
 		context = &DummyContext{}
-		volumeId = "vol-name"
+		volumeId = "default:vol-name"
 		volumeName = "vol-name"
 		vc = []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}}}
 		vol = &Volume{VolumeId: volumeId}
@@ -63,6 +88,47 @@ var _ = Describe("ControllerService", func() {
 			}))
 		})
 
+		It("creates the volume directory in the default pool", func() {
+			path, _ := fakeOs.MkdirAllArgsForCall(fakeOs.MkdirAllCallCount() - 1)
+			Expect(path).To(Equal("/path/to/mount/_volumes/vol-name"))
+		})
+
+		Context("when the request carries a logger", func() {
+			It("logs to the request's session", func() {
+				logger := lagertest.NewTestLogger("request")
+				_, err := cs.CreateVolume(lagerctx.NewContext(context, logger), &CreateVolumeRequest{Name: "other", VolumeCapabilities: vc})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logger.LogMessages()).To(ContainElement("request.create-volume.creating-volume"))
+			})
+		})
+
+		Context("when the volume name is not a valid directory name", func() {
+			It("should fail with an invalid argument error", func() {
+				_, err = cs.CreateVolume(context, &CreateVolumeRequest{Name: "../escape", VolumeCapabilities: vc})
+				grpcStatus, _ := status.FromError(err)
+				Expect(grpcStatus.Code()).To(Equal(codes.InvalidArgument))
+			})
+		})
+
+		Context("when no volume capabilities are supplied", func() {
+			It("should fail with an invalid argument error", func() {
+				_, err = cs.CreateVolume(context, &CreateVolumeRequest{Name: "other"})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+		})
+
+		Context("when the volume exists with an incompatible capacity", func() {
+			It("should fail with an already exists error", func() {
+				_, err = cs.CreateVolume(context, &CreateVolumeRequest{
+					Name:               volumeName,
+					VolumeCapabilities: vc,
+					CapacityRange:      &CapacityRange{RequiredBytes: 1024},
+				})
+				grpcStatus, _ := status.FromError(err)
+				Expect(grpcStatus.Code()).To(Equal(codes.AlreadyExists))
+			})
+		})
+
 		Context("when the Volume exists", func() {
 			BeforeEach(func() {
 				expectedResponse = createSuccessful(context, cs, fakeOs, volumeName, vc)
@@ -120,6 +186,14 @@ var _ = Describe("ControllerService", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
 
+			It("should succeed without removing anything for ids that are not directory names", func() {
+				for _, volId := range []string{"default:..", "default:.", "default:../../x"} {
+					_, err = cs.DeleteVolume(context, &DeleteVolumeRequest{VolumeId: volId})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				Expect(fakeDirTree.RemoveCallCount()).To(Equal(0))
+			})
+
 			Context("when the volume has been created", func() {
 				var (
 					createVolResponse *CreateVolumeResponse
@@ -132,6 +206,10 @@ var _ = Describe("ControllerService", func() {
 					response := deleteSuccessful(context, cs, volumeId)
 					Expect(response).NotTo(BeNil())
 
+					Expect(fakeDirTree.RemoveCallCount()).To(Equal(1))
+					_, path := fakeDirTree.RemoveArgsForCall(0)
+					Expect(path).To(Equal("/path/to/mount/_volumes/vol-name"))
+
 					listReq = &ListVolumesRequest{
 						MaxEntries: 100,
 					}
@@ -154,7 +232,8 @@ var _ = Describe("ControllerService", func() {
 			Context("when ControllerPublishVolume is called with a ControllerPublishVolumeRequest", func() {
 				BeforeEach(func() {
 					request = &ControllerPublishVolumeRequest{
-						VolumeId: volumeId,
+						VolumeId:         volumeId,
+						NodeId:           "node-1",
 						VolumeCapability: vc[0],
 					}
 				})
@@ -166,6 +245,121 @@ var _ = Describe("ControllerService", func() {
 					Expect(expectedResponse).NotTo(BeNil())
 					Expect(expectedResponse.GetPublishContext()).NotTo(BeNil())
 				})
+
+				It("should record the node the volume is published to", func() {
+					getResp, err := cs.ControllerGetVolume(context, &ControllerGetVolumeRequest{VolumeId: volumeId})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(getResp.GetStatus().GetPublishedNodeIds()).To(Equal([]string{"node-1"}))
+
+					_, err = cs.ControllerUnpublishVolume(context, &ControllerUnpublishVolumeRequest{VolumeId: volumeId, NodeId: "node-1"})
+					Expect(err).NotTo(HaveOccurred())
+					getResp, err = cs.ControllerGetVolume(context, &ControllerGetVolumeRequest{VolumeId: volumeId})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(getResp.GetStatus().GetPublishedNodeIds()).To(BeEmpty())
+				})
+
+				Context("when the volume does not exist", func() {
+					BeforeEach(func() {
+						request.VolumeId = "default:missing"
+					})
+
+					It("should fail with a not found error", func() {
+						grpcStatus, _ := status.FromError(err)
+						Expect(grpcStatus.Code()).To(Equal(codes.NotFound))
+					})
+				})
+
+				Context("when no node id is supplied", func() {
+					BeforeEach(func() {
+						request.NodeId = ""
+					})
+
+					It("should fail with an invalid argument error", func() {
+						grpcStatus, _ := status.FromError(err)
+						Expect(grpcStatus.Code()).To(Equal(codes.InvalidArgument))
+					})
+				})
+
+				Context("when no volume capability is supplied", func() {
+					BeforeEach(func() {
+						request.VolumeCapability = nil
+					})
+
+					It("should fail with an invalid argument error", func() {
+						Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+					})
+				})
+			})
+		})
+
+		Describe("ControllerGetVolume", func() {
+			var (
+				request          *ControllerGetVolumeRequest
+				expectedResponse *ControllerGetVolumeResponse
+			)
+
+			BeforeEach(func() {
+				request = &ControllerGetVolumeRequest{VolumeId: volumeId}
+			})
+
+			JustBeforeEach(func() {
+				expectedResponse, err = cs.ControllerGetVolume(context, request)
+			})
+
+			It("should report the volume as healthy", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(expectedResponse.GetVolume().GetVolumeId()).To(Equal(volumeId))
+				Expect(expectedResponse.GetStatus().GetVolumeCondition().GetAbnormal()).To(BeFalse())
+				Expect(fakeOs.StatArgsForCall(0)).To(Equal("/path/to/mount/_volumes/vol-name"))
+			})
+
+			Context("when the backing directory is missing", func() {
+				BeforeEach(func() {
+					fakeOs.StatReturns(nil, errors.New("no such file or directory"))
+					fakeOs.IsNotExistReturns(true)
+				})
+
+				It("should report an abnormal condition", func() {
+					Expect(err).NotTo(HaveOccurred())
+					condition := expectedResponse.GetStatus().GetVolumeCondition()
+					Expect(condition.GetAbnormal()).To(BeTrue())
+					Expect(condition.GetMessage()).To(ContainSubstring("is missing"))
+				})
+			})
+
+			Context("when the backing directory is unreadable", func() {
+				BeforeEach(func() {
+					fakeOs.StatReturns(&FakeFileInfo{FileMode: os.ModeDir | 0300, Stat: &syscall.Stat_t{Uid: 1000, Gid: 1000}}, nil)
+				})
+
+				It("should report an abnormal condition", func() {
+					condition := expectedResponse.GetStatus().GetVolumeCondition()
+					Expect(condition.GetAbnormal()).To(BeTrue())
+					Expect(condition.GetMessage()).To(ContainSubstring("not readable"))
+				})
+			})
+
+			Context("when the backing directory has the wrong owner", func() {
+				BeforeEach(func() {
+					fakeOs.StatReturns(&FakeFileInfo{FileMode: os.ModeDir | 0755, Stat: &syscall.Stat_t{Uid: 0, Gid: 0}}, nil)
+				})
+
+				It("should report an abnormal condition", func() {
+					condition := expectedResponse.GetStatus().GetVolumeCondition()
+					Expect(condition.GetAbnormal()).To(BeTrue())
+					Expect(condition.GetMessage()).To(ContainSubstring("owned by 0:0"))
+				})
+			})
+
+			Context("when the volume does not exist", func() {
+				BeforeEach(func() {
+					request.VolumeId = "default:missing"
+				})
+
+				It("should fail with a not found error", func() {
+					grpcStatus, _ := status.FromError(err)
+					Expect(grpcStatus.Code()).To(Equal(codes.NotFound))
+				})
 			})
 		})
 
@@ -199,12 +393,114 @@ var _ = Describe("ControllerService", func() {
 			})
 		})
 
+		Describe("ControllerExpandVolume", func() {
+			var (
+				request          *ControllerExpandVolumeRequest
+				expectedResponse *ControllerExpandVolumeResponse
+			)
+
+			BeforeEach(func() {
+				request = &ControllerExpandVolumeRequest{
+					VolumeId:         volumeId,
+					CapacityRange:    &CapacityRange{RequiredBytes: 2048},
+					VolumeCapability: vc[0],
+				}
+			})
+
+			JustBeforeEach(func() {
+				expectedResponse, err = cs.ControllerExpandVolume(context, request)
+			})
+
+			It("should grow the recorded capacity", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(expectedResponse.GetCapacityBytes()).To(Equal(int64(2048)))
+				Expect(expectedResponse.GetNodeExpansionRequired()).To(BeFalse())
+
+				listResp, err := cs.ListVolumes(context, &ListVolumesRequest{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(listResp.GetEntries()[0].GetVolume().GetCapacityBytes()).To(Equal(int64(2048)))
+			})
+
+			Context("when the volume is accessed as a block device", func() {
+				BeforeEach(func() {
+					request.VolumeCapability = &VolumeCapability{AccessType: &VolumeCapability_Block{Block: &VolumeCapability_BlockVolume{}}}
+				})
+
+				It("should require node expansion", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(expectedResponse.GetNodeExpansionRequired()).To(BeTrue())
+				})
+			})
+
+			Context("when the volume already holds the required bytes", func() {
+				BeforeEach(func() {
+					_, err := cs.ControllerExpandVolume(context, request)
+					Expect(err).NotTo(HaveOccurred())
+					request.CapacityRange = &CapacityRange{RequiredBytes: 1024, LimitBytes: 4096}
+				})
+
+				It("should keep and return its capacity", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(expectedResponse.GetCapacityBytes()).To(Equal(int64(2048)))
+
+					listResp, err := cs.ListVolumes(context, &ListVolumesRequest{})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(listResp.GetEntries()[0].GetVolume().GetCapacityBytes()).To(Equal(int64(2048)))
+				})
+			})
+
+			Context("when asked to shrink the volume", func() {
+				BeforeEach(func() {
+					_, err := cs.ControllerExpandVolume(context, request)
+					Expect(err).NotTo(HaveOccurred())
+					request.CapacityRange = &CapacityRange{LimitBytes: 1024}
+				})
+
+				It("should fail with an out of range error", func() {
+					grpcStatus, _ := status.FromError(err)
+					Expect(grpcStatus.Code()).To(Equal(codes.OutOfRange))
+				})
+			})
+
+			Context("when the volume does not exist", func() {
+				BeforeEach(func() {
+					request.VolumeId = "default:missing"
+				})
+
+				It("should fail with a not found error", func() {
+					grpcStatus, _ := status.FromError(err)
+					Expect(grpcStatus.Code()).To(Equal(codes.NotFound))
+				})
+			})
+
+			Context("when no capacity range is supplied", func() {
+				BeforeEach(func() {
+					request.CapacityRange = nil
+				})
+
+				It("should fail with an invalid argument error", func() {
+					grpcStatus, _ := status.FromError(err)
+					Expect(grpcStatus.Code()).To(Equal(codes.InvalidArgument))
+				})
+			})
+		})
+
 		Describe("ValidateVolumeCapabilities", func() {
 			var (
 				request            *ValidateVolumeCapabilitiesRequest
 				expectedResponse   *ValidateVolumeCapabilitiesResponse
 				volumeCapabilities []*VolumeCapability
 			)
+			Context("when the volume id or capabilities are missing", func() {
+				It("should fail with an invalid argument error", func() {
+					_, err := cs.ValidateVolumeCapabilities(context, &ValidateVolumeCapabilitiesRequest{VolumeCapabilities: vc})
+					Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+					_, err = cs.ValidateVolumeCapabilities(context, &ValidateVolumeCapabilitiesRequest{VolumeId: volumeId})
+					Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+				})
+			})
+
 			Context("when called with no capabilities", func() {
 				BeforeEach(func() {
 					volumeCapabilities = []*VolumeCapability{{AccessType: &VolumeCapability_Mount{
@@ -282,8 +578,7 @@ var _ = Describe("ControllerService", func() {
 
 			JustBeforeEach(func() {
 				request = &ListVolumesRequest{
-					MaxEntries:    10,
-					StartingToken: "starting-token",
+					MaxEntries: 10,
 				}
 				expectedResponse, err = cs.ListVolumes(context, request)
 			})
@@ -293,6 +588,32 @@ var _ = Describe("ControllerService", func() {
 				Expect(expectedResponse).NotTo(BeNil())
 				Expect(expectedResponse.GetEntries()).To(ContainElement(VolumeIDMatcher(volumeId)))
 			})
+
+			It("should report the condition of each volume", func() {
+				Expect(expectedResponse.GetEntries()[0].GetStatus().GetVolumeCondition().GetAbnormal()).To(BeFalse())
+			})
+
+			It("pages through the volumes in id order", func() {
+				createSuccessful(context, cs, fakeOs, "other", vc)
+
+				resp, err := cs.ListVolumes(context, &ListVolumesRequest{MaxEntries: 1})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetEntries()).To(ConsistOf(VolumeIDMatcher("default:other")))
+				Expect(resp.GetNextToken()).NotTo(BeEmpty())
+
+				resp, err = cs.ListVolumes(context, &ListVolumesRequest{MaxEntries: 1, StartingToken: resp.GetNextToken()})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetEntries()).To(ConsistOf(VolumeIDMatcher(volumeId)))
+				Expect(resp.GetNextToken()).To(BeEmpty())
+			})
+
+			It("rejects an invalid starting token and negative max entries", func() {
+				_, err := cs.ListVolumes(context, &ListVolumesRequest{StartingToken: "starting-token"})
+				Expect(status.Code(err)).To(Equal(codes.Aborted))
+
+				_, err = cs.ListVolumes(context, &ListVolumesRequest{MaxEntries: -1})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
 		})
 
 		Describe("ControllerProbe", func() {
@@ -312,6 +633,12 @@ var _ = Describe("ControllerService", func() {
 				Expect(*expectedResponse).NotTo(BeNil())
 				Expect(expectedResponse).ToNot(BeNil())
 			})
+
+			It("should report the plugin as ready", func() {
+				Expect(expectedResponse.GetReady().GetValue()).To(BeTrue())
+				Expect(fakeOs.MkdirCallCount()).To(Equal(1))
+				Expect(fakeOs.RemoveCallCount()).To(Equal(1))
+			})
 		})
 
 		Describe("GetCapacity", func() {
@@ -333,7 +660,7 @@ var _ = Describe("ControllerService", func() {
 				It("should return a GetCapacityResponse", func() {
 					Expect(err).ToNot(HaveOccurred())
 					Expect(expectedResponse).NotTo(BeNil())
-					Expect(expectedResponse.GetAvailableCapacity()).NotTo(BeNil())
+					Expect(expectedResponse.GetAvailableCapacity()).To(Equal(int64(math.MaxInt64)))
 				})
 			})
 		})
@@ -354,16 +681,518 @@ var _ = Describe("ControllerService", func() {
 				It("should return a listing all capabilities", func() {
 					Expect(expectedResponse).NotTo(BeNil())
 					capabilities := expectedResponse.GetCapabilities()
-					Expect(capabilities).To(HaveLen(4))
+					Expect(capabilities).To(HaveLen(10))
 					Expect(capabilities[0].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME))
 					Expect(capabilities[1].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME))
 					Expect(capabilities[2].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_LIST_VOLUMES))
 					Expect(capabilities[3].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_GET_CAPACITY))
+					Expect(capabilities[4].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_EXPAND_VOLUME))
+					Expect(capabilities[5].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES))
+					Expect(capabilities[6].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_GET_VOLUME))
+					Expect(capabilities[7].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_VOLUME_CONDITION))
+					Expect(capabilities[8].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT))
+					Expect(capabilities[9].GetRpc().GetType()).To(Equal(ControllerServiceCapability_RPC_LIST_SNAPSHOTS))
 				})
 			})
 		})
 	})
 
+	Describe("storage pools", func() {
+		BeforeEach(func() {
+			cs = newRecoveredController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, registry, controller.Config{
+				Pools: []controller.PoolConfig{
+					{Name: "default", Root: "/path/to/default"},
+					{Name: "small", Root: "/path/to/small", CapacityBytes: 100, AccessModes: []string{"SINGLE_NODE_WRITER"}},
+				},
+				DefaultPool: "default",
+			})
+		})
+
+		createInPool := func(name, pool string, required int64, mode VolumeCapability_AccessMode_Mode) (*CreateVolumeResponse, error) {
+			return cs.CreateVolume(context, &CreateVolumeRequest{
+				Name:          name,
+				Parameters:    map[string]string{controller.PoolParameter: pool},
+				CapacityRange: &CapacityRange{RequiredBytes: required},
+				VolumeCapabilities: []*VolumeCapability{{
+					AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}},
+					AccessMode: &VolumeCapability_AccessMode{Mode: mode},
+				}},
+			})
+		}
+
+		It("encodes the pool in the volume id and creates the directory under the pool root", func() {
+			resp, err := createInPool("vol", "small", 10, VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetVolume().GetVolumeId()).To(Equal("small:vol"))
+			Expect(resp.GetVolume().GetCapacityBytes()).To(Equal(int64(10)))
+
+			path, _ := fakeOs.MkdirAllArgsForCall(fakeOs.MkdirAllCallCount() - 1)
+			Expect(path).To(Equal("/path/to/small/_volumes/vol"))
+		})
+
+		It("rejects unknown pools", func() {
+			_, err := createInPool("vol", "missing", 0, VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+			grpcStatus, _ := status.FromError(err)
+			Expect(grpcStatus.Code()).To(Equal(codes.InvalidArgument))
+		})
+
+		It("rejects access modes the pool does not allow", func() {
+			_, err := createInPool("vol", "small", 0, VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+			grpcStatus, _ := status.FromError(err)
+			Expect(grpcStatus.Code()).To(Equal(codes.InvalidArgument))
+		})
+
+		It("rejects requests larger than the pool", func() {
+			_, err := createInPool("vol", "small", 101, VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+			grpcStatus, _ := status.FromError(err)
+			Expect(grpcStatus.Code()).To(Equal(codes.OutOfRange))
+		})
+
+		It("rejects requests once the pool is exhausted", func() {
+			_, err := createInPool("vol-1", "small", 60, VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = createInPool("vol-2", "small", 60, VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+			grpcStatus, _ := status.FromError(err)
+			Expect(grpcStatus.Code()).To(Equal(codes.ResourceExhausted))
+		})
+
+		It("reports capacity per pool", func() {
+			_, err := createInPool("vol", "small", 60, VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := cs.GetCapacity(context, &GetCapacityRequest{
+				Parameters: map[string]string{controller.PoolParameter: "small"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetAvailableCapacity()).To(Equal(int64(40)))
+
+			resp, err = cs.GetCapacity(context, &GetCapacityRequest{
+				Parameters: map[string]string{controller.PoolParameter: "small"},
+				VolumeCapabilities: []*VolumeCapability{{
+					AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+				}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetAvailableCapacity()).To(Equal(int64(0)))
+		})
+
+		It("refuses to expand a volume past the pool capacity", func() {
+			_, err := createInPool("vol", "small", 60, VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cs.ControllerExpandVolume(context, &ControllerExpandVolumeRequest{
+				VolumeId:      "small:vol",
+				CapacityRange: &CapacityRange{RequiredBytes: 101},
+			})
+			grpcStatus, _ := status.FromError(err)
+			Expect(grpcStatus.Code()).To(Equal(codes.OutOfRange))
+		})
+
+		It("deletes the volume directory from the pool named in the volume id", func() {
+			deleteSuccessful(context, cs, "small:vol")
+			Expect(fakeDirTree.RemoveCallCount()).To(Equal(1))
+			_, path := fakeDirTree.RemoveArgsForCall(0)
+			Expect(path).To(Equal("/path/to/small/_volumes/vol"))
+		})
+	})
+
+	Describe("recovery and readiness", func() {
+		var fakeRegistry *controllerfakes.FakeRegistry
+
+		BeforeEach(func() {
+			fakeRegistry = &controllerfakes.FakeRegistry{}
+			fakeRegistry.LoadReturns(controller.NewState(), nil)
+			cs = controller.NewController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, &controllerfakes.FakeQuotas{}, &controllerfakes.FakeImages{}, &controllerfakes.FakeSubvolumes{}, fakeRegistry, controller.DefaultConfig(mountDir))
+		})
+
+		probeCode := func() codes.Code {
+			_, err := cs.Probe(context, &ProbeRequest{})
+			grpcStatus, _ := status.FromError(err)
+			return grpcStatus.Code()
+		}
+
+		Context("before the state has been recovered", func() {
+			It("does not overwrite the saved state when flushed", func() {
+				Expect(cs.Flush()).To(Succeed())
+				Expect(fakeRegistry.SaveCallCount()).To(Equal(0))
+			})
+
+			It("reports the plugin as not ready", func() {
+				resp, err := cs.Probe(context, &ProbeRequest{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetReady().GetValue()).To(BeFalse())
+			})
+
+			It("refuses volume RPCs", func() {
+				_, err := cs.CreateVolume(context, &CreateVolumeRequest{Name: volumeName, VolumeCapabilities: vc})
+				grpcStatus, _ := status.FromError(err)
+				Expect(grpcStatus.Code()).To(Equal(codes.Unavailable))
+			})
+		})
+
+		It("restores volumes saved by an earlier controller", func() {
+			cs = newRecoveredController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, registry, controller.DefaultConfig(mountDir))
+			createSuccessful(context, cs, fakeOs, volumeName, vc)
+
+			cs = newRecoveredController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, registry, controller.DefaultConfig(mountDir))
+			resp, err := cs.ControllerGetVolume(context, &ControllerGetVolumeRequest{VolumeId: volumeId})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetVolume().GetVolumeId()).To(Equal(volumeId))
+		})
+
+		It("fails the probe when the registry cannot be loaded", func() {
+			fakeRegistry.LoadReturns(nil, errors.New("corrupt"))
+			Expect(cs.Recover()).NotTo(Succeed())
+			Expect(probeCode()).To(Equal(codes.FailedPrecondition))
+		})
+
+		It("refuses a registry that does not match the configured pools", func() {
+			state := controller.NewState()
+			state.Volumes["gone:vol"] = &controller.LocalVolume{VolumeId: "gone:vol", Pool: "gone", Name: "vol"}
+			fakeRegistry.LoadReturns(state, nil)
+			Expect(cs.Recover()).To(MatchError(ContainSubstring("unknown pool")))
+			Expect(probeCode()).To(Equal(codes.FailedPrecondition))
+		})
+
+		It("creates the directories of the pools", func() {
+			fakeOs.MkdirAllReturns(nil)
+			mkdirs := fakeOs.MkdirAllCallCount()
+			Expect(cs.Recover()).To(Succeed())
+			Expect(fakeOs.MkdirAllCallCount()).To(Equal(mkdirs + 3))
+			var paths []string
+			for i := mkdirs; i < fakeOs.MkdirAllCallCount(); i++ {
+				path, _ := fakeOs.MkdirAllArgsForCall(i)
+				paths = append(paths, path)
+			}
+			Expect(paths).To(Equal([]string{"/path/to/mount/_volumes", "/path/to/mount/_snapshots", "/path/to/mount/_quarantine"}))
+		})
+
+		It("fails the probe when the directories of a pool cannot be created", func() {
+			fakeOs.MkdirAllReturns(errors.New("read-only file system"))
+			Expect(cs.Recover()).To(MatchError(ContainSubstring(`pool "default": read-only file system`)))
+			Expect(probeCode()).To(Equal(codes.FailedPrecondition))
+		})
+
+		Context("once recovered", func() {
+			BeforeEach(func() {
+				Expect(cs.Recover()).To(Succeed())
+			})
+
+			It("fails the probe when a storage root is missing", func() {
+				fakeOs.StatReturns(nil, errors.New("no such file or directory"))
+				Expect(probeCode()).To(Equal(codes.FailedPrecondition))
+			})
+
+			It("fails the probe when a storage root is not writable", func() {
+				fakeOs.MkdirReturns(errors.New("read-only file system"))
+				Expect(probeCode()).To(Equal(codes.FailedPrecondition))
+			})
+
+			It("fails the probe when free space drops below the threshold", func() {
+				config := controller.DefaultConfig(mountDir)
+				config.Pools[0].MinFreeBytes = 100
+				cs = newRecoveredController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, registry, config)
+
+				fakeDiskStats.FreeBytesReturns(99, nil)
+				Expect(probeCode()).To(Equal(codes.FailedPrecondition))
+				Expect(fakeDiskStats.FreeBytesArgsForCall(0)).To(Equal(mountDir))
+
+				fakeDiskStats.FreeBytesReturns(100, nil)
+				Expect(probeCode()).To(Equal(codes.OK))
+			})
+
+			It("saves the state when flushed", func() {
+				Expect(cs.Flush()).To(Succeed())
+				Expect(fakeRegistry.SaveCallCount()).To(Equal(1))
+			})
+
+			It("fails a volume RPC when the state cannot be saved", func() {
+				fakeRegistry.SaveReturns(errors.New("disk full"))
+				_, err := cs.CreateVolume(context, &CreateVolumeRequest{Name: volumeName, VolumeCapabilities: vc})
+				grpcStatus, _ := status.FromError(err)
+				Expect(grpcStatus.Code()).To(Equal(codes.Internal))
+			})
+		})
+	})
+
+	Describe("Reconcile", func() {
+		var config controller.Config
+
+		BeforeEach(func() {
+			config = controller.DefaultConfig(mountDir)
+		})
+
+		JustBeforeEach(func() {
+			cs = newRecoveredController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, registry, config)
+			createSuccessful(context, cs, fakeOs, volumeName, vc)
+			fakeFilepath.GlobReturns([]string{
+				"/path/to/mount/_volumes/.probe-12",
+				"/path/to/mount/_volumes/orphan",
+				"/path/to/mount/_volumes/vol-name",
+			}, nil)
+		})
+
+		It("reports directories without a registry entry and leaves them alone", func() {
+			report, err := cs.Reconcile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Orphans).To(Equal([]string{"/path/to/mount/_volumes/orphan"}))
+			Expect(report.Adopted).To(BeEmpty())
+			Expect(report.Missing).To(BeEmpty())
+
+			Expect(fakeFilepath.GlobArgsForCall(0)).To(Equal("/path/to/mount/_volumes/*"))
+			Expect(fakeOs.RenameCallCount()).To(Equal(0))
+			Expect(cs.Stats().OrphanedVolumes).To(Equal(1))
+		})
+
+		Context("with the adopt policy", func() {
+			BeforeEach(func() {
+				config.OrphanPolicy = controller.OrphanPolicyAdopt
+			})
+
+			It("records orphans as volumes", func() {
+				report, err := cs.Reconcile()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Adopted).To(Equal([]string{"default:orphan"}))
+
+				state, err := registry.Load()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(state.Volumes).To(HaveKey("default:orphan"))
+
+				_, err = cs.ControllerGetVolume(context, &ControllerGetVolumeRequest{VolumeId: "default:orphan"})
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("with the quarantine policy", func() {
+			BeforeEach(func() {
+				config.OrphanPolicy = controller.OrphanPolicyQuarantine
+			})
+
+			It("moves orphans into the pool's quarantine directory", func() {
+				report, err := cs.Reconcile()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeOs.RenameCallCount()).To(Equal(1))
+				from, to := fakeOs.RenameArgsForCall(0)
+				Expect(from).To(Equal("/path/to/mount/_volumes/orphan"))
+				Expect(to).To(MatchRegexp(`^/path/to/mount/_quarantine/orphan-\d+$`))
+				Expect(report.Quarantined).To(Equal([]string{to}))
+			})
+		})
+
+		It("marks volumes whose directory is gone until it comes back", func() {
+			fakeOs.StatReturns(nil, errors.New("no such file or directory"))
+			fakeOs.IsNotExistReturns(true)
+
+			report, err := cs.Reconcile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Missing).To(Equal([]string{volumeId}))
+			Expect(cs.Stats().MissingVolumes).To(Equal(1))
+
+			state, err := registry.Load()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state.Volumes[volumeId].Missing).To(BeTrue())
+
+			fakeOs.StatReturns(&FakeFileInfo{FileMode: os.ModeDir | 0755}, nil)
+			fakeOs.IsNotExistReturns(false)
+			report, err = cs.Reconcile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Missing).To(BeEmpty())
+
+			state, err = registry.Load()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state.Volumes[volumeId].Missing).To(BeFalse())
+		})
+
+		It("refuses to run before the state is recovered", func() {
+			cs = controller.NewController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, &controllerfakes.FakeQuotas{}, &controllerfakes.FakeImages{}, &controllerfakes.FakeSubvolumes{}, registry, config)
+			_, err := cs.Reconcile()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Stats", func() {
+		BeforeEach(func() {
+			cs = newRecoveredController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, registry, controller.Config{
+				Pools: []controller.PoolConfig{
+					{Name: "default", Root: "/path/to/default"},
+					{Name: "small", Root: "/path/to/small", CapacityBytes: 100},
+				},
+				DefaultPool: "default",
+			})
+			fakeDiskStats.FreeBytesReturns(5000, nil)
+
+			_, err := cs.CreateVolume(context, &CreateVolumeRequest{
+				Name:               "vol-1",
+				VolumeCapabilities: vc,
+				Parameters:         map[string]string{controller.PoolParameter: "small"},
+				CapacityRange:      &CapacityRange{RequiredBytes: 30},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = cs.CreateVolume(context, &CreateVolumeRequest{Name: "vol-2", VolumeCapabilities: vc})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = cs.ControllerPublishVolume(context, &ControllerPublishVolumeRequest{VolumeId: "small:vol-1", NodeId: "node-1", VolumeCapability: vc[0]})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("summarises volumes and pool capacity", func() {
+			Expect(cs.Stats()).To(Equal(controller.Stats{
+				Volumes:          2,
+				PublishedVolumes: 1,
+				Pools: []controller.PoolStats{
+					{Name: "default", UsedBytes: 0, FreeBytes: 5000},
+					{Name: "small", CapacityBytes: 100, UsedBytes: 30, FreeBytes: 70},
+				},
+				VolumeUsage: []controller.LocalVolumeUsage{},
+			}))
+		})
+
+		It("measures free space without holding up the RPCs", func() {
+			fakeDiskStats.FreeBytesStub = func(string) (uint64, error) {
+				_, err := cs.ControllerGetVolume(context, &ControllerGetVolumeRequest{VolumeId: "default:vol-2"})
+				Expect(err).NotTo(HaveOccurred())
+				return 5000, nil
+			}
+
+			stats := make(chan controller.Stats)
+			go func() {
+				defer GinkgoRecover()
+				stats <- cs.Stats()
+			}()
+			Eventually(stats).Should(Receive())
+		})
+	})
+
+	Describe("topology", func() {
+		BeforeEach(func() {
+			cs = newRecoveredController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, registry, controller.Config{
+				Pools: []controller.PoolConfig{
+					{Name: "anywhere", Root: "/path/to/anywhere"},
+					{Name: "host-a", Root: "/path/to/a", Topology: map[string]string{"zone": "z1", controller.TopologyNodeKey: "node-a"}},
+					{Name: "host-b", Root: "/path/to/b", Topology: map[string]string{"zone": "z2", controller.TopologyNodeKey: "node-b"}},
+				},
+				DefaultPool: "anywhere",
+				Nodes: map[string]map[string]string{
+					"node-a": {"zone": "z1"},
+				},
+			})
+		})
+
+		segments := func(zone, node string) *Topology {
+			return &Topology{Segments: map[string]string{"zone": zone, controller.TopologyNodeKey: node}}
+		}
+
+		createWithRequirements := func(requirements *TopologyRequirement, parameters map[string]string) (*CreateVolumeResponse, error) {
+			return cs.CreateVolume(context, &CreateVolumeRequest{
+				Name:                      "vol",
+				Parameters:                parameters,
+				VolumeCapabilities:        vc,
+				AccessibilityRequirements: requirements,
+			})
+		}
+
+		It("uses the default pool when no topology is required", func() {
+			resp, err := createWithRequirements(nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetVolume().GetVolumeId()).To(Equal("anywhere:vol"))
+			Expect(resp.GetVolume().GetAccessibleTopology()).To(BeEmpty())
+		})
+
+		It("chooses the pool serving the first preferred topology", func() {
+			resp, err := createWithRequirements(&TopologyRequirement{
+				Requisite: []*Topology{segments("z1", "node-a"), segments("z2", "node-b")},
+				Preferred: []*Topology{segments("z2", "node-b"), segments("z1", "node-a")},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetVolume().GetVolumeId()).To(Equal("host-b:vol"))
+			Expect(resp.GetVolume().GetAccessibleTopology()).To(HaveLen(1))
+			Expect(resp.GetVolume().GetAccessibleTopology()[0].GetSegments()).To(HaveKeyWithValue("zone", "z2"))
+		})
+
+		It("fails when the requested pool is outside the requisite topology", func() {
+			_, err := createWithRequirements(&TopologyRequirement{
+				Requisite: []*Topology{segments("z1", "node-a")},
+			}, map[string]string{controller.PoolParameter: "host-b"})
+			grpcStatus, _ := status.FromError(err)
+			Expect(grpcStatus.Code()).To(Equal(codes.ResourceExhausted))
+		})
+
+		It("reports no capacity for pools outside the requested topology", func() {
+			resp, err := cs.GetCapacity(context, &GetCapacityRequest{
+				Parameters:         map[string]string{controller.PoolParameter: "host-a"},
+				AccessibleTopology: segments("z2", "node-b"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetAvailableCapacity()).To(Equal(int64(0)))
+		})
+
+		Context("when publishing", func() {
+			BeforeEach(func() {
+				_, err := createWithRequirements(nil, map[string]string{controller.PoolParameter: "host-a"})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("allows nodes inside the volume's topology", func() {
+				_, err := cs.ControllerPublishVolume(context, &ControllerPublishVolumeRequest{VolumeId: "host-a:vol", NodeId: "node-a", VolumeCapability: vc[0]})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("rejects nodes outside the volume's topology", func() {
+				_, err := cs.ControllerPublishVolume(context, &ControllerPublishVolumeRequest{VolumeId: "host-a:vol", NodeId: "node-b", VolumeCapability: vc[0]})
+				grpcStatus, _ := status.FromError(err)
+				Expect(grpcStatus.Code()).To(Equal(codes.FailedPrecondition))
+			})
+		})
+	})
+
+	Describe("tracing", func() {
+		var (
+			exporter *tracetest.InMemoryExporter
+			parent   trace.Span
+		)
+
+		BeforeEach(func() {
+			exporter = tracetest.NewInMemoryExporter()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+			otel.SetTracerProvider(provider)
+			context, parent = provider.Tracer("test").Start(context, "request")
+		})
+
+		spanNames := func() []string {
+			var names []string
+			for _, span := range exporter.GetSpans() {
+				Expect(span.Parent.SpanID()).To(Equal(parent.SpanContext().SpanID()))
+				names = append(names, span.Name)
+			}
+			return names
+		}
+
+		It("records the directory creation and registry write of CreateVolume as child spans", func() {
+			createSuccessful(context, cs, fakeOs, volumeName, vc)
+			Expect(spanNames()).To(Equal([]string{"create-directory", "registry-write"}))
+			Expect(exporter.GetSpans()[0].Attributes).To(ContainElement(attribute.String("path", "/path/to/mount/_volumes/vol-name")))
+		})
+
+		It("records the directory removal of DeleteVolume as a child span", func() {
+			createSuccessful(context, cs, fakeOs, volumeName, vc)
+			exporter.Reset()
+
+			deleteSuccessful(context, cs, volumeId)
+			Expect(spanNames()).To(Equal([]string{"remove-directory", "registry-write"}))
+		})
+
+		It("marks the span of a failed operation as an error", func() {
+			fakeOs.MkdirAllReturns(errors.New("badness"))
+			_, err = cs.CreateVolume(context, &CreateVolumeRequest{Name: volumeName, VolumeCapabilities: vc})
+			Expect(err).To(HaveOccurred())
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Status.Code).To(Equal(otelcodes.Error))
+			Expect(spans[0].Events[0].Name).To(Equal("exception"))
+		})
+	})
+
 	Describe("GetPluginInfo", func() {
 		var (
 			request          *GetPluginInfoRequest
@@ -404,55 +1233,337 @@ var _ = Describe("ControllerService", func() {
 		It("returns the plugin capabilities", func() {
 			Expect(expectedResponse).NotTo(BeNil())
 			Expect(err).ToNot(HaveOccurred())
-			Expect(expectedResponse.Capabilities).To(HaveLen(1))
+			Expect(expectedResponse.Capabilities).To(HaveLen(3))
 			service := expectedResponse.Capabilities[0].GetService()
 			Expect(service).NotTo(BeNil())
 			Expect(service.GetType()).To(Equal(PluginCapability_Service_CONTROLLER_SERVICE))
+			Expect(expectedResponse.Capabilities[1].GetService().GetType()).To(Equal(PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS))
+			expansion := expectedResponse.Capabilities[2].GetVolumeExpansion()
+			Expect(expansion).NotTo(BeNil())
+			Expect(expansion.GetType()).To(Equal(PluginCapability_VolumeExpansion_ONLINE))
 		})
 	})
 
-	Describe("CreateSnapshot", func() {
-		Context("when provided with a CreateSnapshotRequest", func() {
-			It("returns an unimplemented error", func() {
-				_, err = cs.CreateSnapshot(context, &CreateSnapshotRequest{})
-				Expect(err).To(HaveOccurred())
-				Expect(err.Error()).To(ContainSubstring("Snapshots not implemented"))
+	Describe("snapshots", func() {
+		BeforeEach(func() {
+			createSuccessful(context, cs, fakeOs, volumeName, vc)
+		})
+
+		Describe("CreateSnapshot", func() {
+			It("copies the volume into the pool's snapshots directory", func() {
+				snapshot, err := createSnapshot(context, cs, "snap", volumeId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(snapshot.GetSnapshotId()).To(Equal("default:snap"))
+				Expect(snapshot.GetSourceVolumeId()).To(Equal(volumeId))
+				Expect(snapshot.GetReadyToUse()).To(BeTrue())
+				Expect(snapshot.GetCreationTime()).NotTo(BeNil())
+
+				Expect(fakeDirTree.CopyCallCount()).To(Equal(1))
+				_, src, dst := fakeDirTree.CopyArgsForCall(0)
+				Expect(src).To(Equal("/path/to/mount/_volumes/vol-name"))
+				Expect(dst).To(Equal("/path/to/mount/_snapshots/snap"))
+			})
+
+			It("returns the existing snapshot when called again", func() {
+				first, err := createSnapshot(context, cs, "snap", volumeId)
+				Expect(err).NotTo(HaveOccurred())
+				second, err := createSnapshot(context, cs, "snap", volumeId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(second).To(Equal(first))
+				Expect(fakeDirTree.CopyCallCount()).To(Equal(1))
+			})
+
+			It("refuses a name already used for another volume's snapshot", func() {
+				createSuccessful(context, cs, fakeOs, "other", vc)
+				_, err := createSnapshot(context, cs, "snap", volumeId)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = createSnapshot(context, cs, "snap", "default:other")
+				Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+			})
+
+			It("fails for a missing source volume", func() {
+				_, err := createSnapshot(context, cs, "snap", "default:missing")
+				Expect(status.Code(err)).To(Equal(codes.NotFound))
+			})
+
+			It("requires a name and a source volume", func() {
+				_, err := createSnapshot(context, cs, "", volumeId)
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+				_, err = createSnapshot(context, cs, "snap", "")
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
 			})
 		})
-	})
 
-	Describe("DeleteSnapshot", func() {
-		Context("when provided with a DeleteSnapshotRequest", func() {
-			It("returns an unimplemented error", func() {
-				_, err = cs.DeleteSnapshot(context, &DeleteSnapshotRequest{})
-				Expect(err).To(HaveOccurred())
-				Expect(err.Error()).To(ContainSubstring("Snapshots not implemented"))
+		Describe("ListSnapshots", func() {
+			BeforeEach(func() {
+				createSuccessful(context, cs, fakeOs, "other", vc)
+				for _, s := range []struct{ name, source string }{{"b", volumeId}, {"a", volumeId}, {"c", "default:other"}} {
+					_, err := createSnapshot(context, cs, s.name, s.source)
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+
+			ids := func(resp *ListSnapshotsResponse) []string {
+				ids := []string{}
+				for _, e := range resp.GetEntries() {
+					ids = append(ids, e.GetSnapshot().GetSnapshotId())
+				}
+				return ids
+			}
+
+			It("lists every snapshot in id order", func() {
+				resp, err := cs.ListSnapshots(context, &ListSnapshotsRequest{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ids(resp)).To(Equal([]string{"default:a", "default:b", "default:c"}))
+			})
+
+			It("filters by source volume and by snapshot id", func() {
+				resp, err := cs.ListSnapshots(context, &ListSnapshotsRequest{SourceVolumeId: "default:other"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ids(resp)).To(Equal([]string{"default:c"}))
+
+				resp, err = cs.ListSnapshots(context, &ListSnapshotsRequest{SnapshotId: "default:b"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ids(resp)).To(Equal([]string{"default:b"}))
+
+				resp, err = cs.ListSnapshots(context, &ListSnapshotsRequest{SnapshotId: "default:missing"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetEntries()).To(BeEmpty())
+			})
+
+			It("pages through the snapshots", func() {
+				resp, err := cs.ListSnapshots(context, &ListSnapshotsRequest{MaxEntries: 2})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ids(resp)).To(Equal([]string{"default:a", "default:b"}))
+
+				resp, err = cs.ListSnapshots(context, &ListSnapshotsRequest{MaxEntries: 2, StartingToken: resp.GetNextToken()})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ids(resp)).To(Equal([]string{"default:c"}))
+				Expect(resp.GetNextToken()).To(BeEmpty())
+
+				_, err = cs.ListSnapshots(context, &ListSnapshotsRequest{StartingToken: "4"})
+				Expect(status.Code(err)).To(Equal(codes.Aborted))
 			})
 		})
-	})
 
-	Describe("ListSnapshots", func() {
-		var (
-			request          *ListSnapshotsRequest
-			expectedResponse *ListSnapshotsResponse
-		)
-		Context("when provided with a ListSnapshotsRequest", func() {
+		Describe("DeleteSnapshot", func() {
+			It("removes the snapshot directory and forgets the snapshot", func() {
+				_, err := createSnapshot(context, cs, "snap", volumeId)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = cs.DeleteSnapshot(context, &DeleteSnapshotRequest{SnapshotId: "default:snap"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeDirTree.RemoveCallCount()).To(Equal(1))
+				_, path := fakeDirTree.RemoveArgsForCall(0)
+				Expect(path).To(Equal("/path/to/mount/_snapshots/snap"))
+
+				resp, err := cs.ListSnapshots(context, &ListSnapshotsRequest{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetEntries()).To(BeEmpty())
+			})
+
+			It("succeeds for a snapshot that does not exist", func() {
+				_, err := cs.DeleteSnapshot(context, &DeleteSnapshotRequest{SnapshotId: "default:missing"})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("succeeds without removing anything for ids that are not directory names", func() {
+				for _, snapId := range []string{"default:..", "default:.", "default:../../x"} {
+					_, err := cs.DeleteSnapshot(context, &DeleteSnapshotRequest{SnapshotId: snapId})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				Expect(fakeDirTree.RemoveCallCount()).To(Equal(0))
+			})
+
+			It("requires a snapshot id", func() {
+				_, err := cs.DeleteSnapshot(context, &DeleteSnapshotRequest{})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+		})
+
+		Describe("CreateVolume from a snapshot", func() {
 			BeforeEach(func() {
-				request = &ListSnapshotsRequest{}
+				_, err := cs.ControllerExpandVolume(context, &ControllerExpandVolumeRequest{
+					VolumeId:      volumeId,
+					CapacityRange: &CapacityRange{RequiredBytes: 50},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				_, err = createSnapshot(context, cs, "snap", volumeId)
+				Expect(err).NotTo(HaveOccurred())
 			})
 
-			JustBeforeEach(func() {
-				expectedResponse, err = cs.ListSnapshots(context, request)
+			It("copies the snapshot into the new volume", func() {
+				vol, err := createFromSnapshot(context, cs, vc, "restored", "default:snap")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vol.GetVolumeId()).To(Equal("default:restored"))
+				Expect(vol.GetCapacityBytes()).To(Equal(int64(50)))
+				Expect(vol.GetContentSource().GetSnapshot().GetSnapshotId()).To(Equal("default:snap"))
+
+				Expect(fakeDirTree.CopyCallCount()).To(Equal(2))
+				_, src, dst := fakeDirTree.CopyArgsForCall(1)
+				Expect(src).To(Equal("/path/to/mount/_snapshots/snap"))
+				Expect(dst).To(Equal("/path/to/mount/_volumes/restored"))
+			})
+
+			It("refuses a capacity smaller than the snapshot", func() {
+				_, err := cs.CreateVolume(context, &CreateVolumeRequest{
+					Name:               "restored",
+					VolumeCapabilities: vc,
+					CapacityRange:      &CapacityRange{RequiredBytes: 10},
+					VolumeContentSource: &VolumeContentSource{
+						Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: "default:snap"}},
+					},
+				})
+				Expect(status.Code(err)).To(Equal(codes.OutOfRange))
+			})
+
+			It("fails for a missing snapshot", func() {
+				_, err := createFromSnapshot(context, cs, vc, "restored", "default:missing")
+				Expect(status.Code(err)).To(Equal(codes.NotFound))
+			})
+
+			It("refuses to clone a volume", func() {
+				_, err := cs.CreateVolume(context, &CreateVolumeRequest{
+					Name:               "clone",
+					VolumeCapabilities: vc,
+					VolumeContentSource: &VolumeContentSource{
+						Type: &VolumeContentSource_Volume{Volume: &VolumeContentSource_VolumeSource{VolumeId: volumeId}},
+					},
+				})
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			})
+
+			Context("when the request is cancelled during the copy", func() {
+				BeforeEach(func() {
+					fakeDirTree.CopyStub = copyUnlessDone
+					_, err := createFromSnapshot(cancelledContext(), cs, vc, "restored", "default:snap")
+					Expect(status.Code(err)).To(Equal(codes.Canceled))
+				})
+
+				It("leaves the volume recorded as incomplete", func() {
+					resp, err := cs.ControllerGetVolume(context, &ControllerGetVolumeRequest{VolumeId: "default:restored"})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.GetStatus().GetVolumeCondition().GetAbnormal()).To(BeTrue())
+					Expect(resp.GetStatus().GetVolumeCondition().GetMessage()).To(ContainSubstring("still being populated"))
+
+					_, err = cs.ControllerPublishVolume(context, &ControllerPublishVolumeRequest{VolumeId: "default:restored", NodeId: "node", VolumeCapability: vc[0]})
+					Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+
+					_, err = cs.DeleteSnapshot(context, &DeleteSnapshotRequest{SnapshotId: "default:snap"})
+					Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+				})
+
+				It("resumes the copy when the request is retried", func() {
+					vol, err := createFromSnapshot(context, cs, vc, "restored", "default:snap")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(vol.GetVolumeId()).To(Equal("default:restored"))
+
+					Expect(fakeDirTree.CopyCallCount()).To(Equal(3))
+					_, src, dst := fakeDirTree.CopyArgsForCall(2)
+					Expect(src).To(Equal("/path/to/mount/_snapshots/snap"))
+					Expect(dst).To(Equal("/path/to/mount/_volumes/restored"))
+
+					resp, err := cs.ControllerGetVolume(context, &ControllerGetVolumeRequest{VolumeId: "default:restored"})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.GetStatus().GetVolumeCondition().GetAbnormal()).To(BeFalse())
+				})
+			})
+
+			It("reports an expired deadline", func() {
+				fakeDirTree.CopyStub = copyUnlessDone
+				_, err := createFromSnapshot(expiredContext(), cs, vc, "restored", "default:snap")
+				Expect(status.Code(err)).To(Equal(codes.DeadlineExceeded))
+			})
+
+			Context("while the copy is running", func() {
+				var (
+					release chan struct{}
+					done    chan error
+				)
+
+				BeforeEach(func() {
+					release = make(chan struct{})
+					started := make(chan struct{})
+					fakeDirTree.CopyStub = blockingCopy(started, release)
+
+					done = make(chan error, 1)
+					go func() {
+						_, err := createFromSnapshot(context, cs, vc, "restored", "default:snap")
+						done <- err
+					}()
+					Eventually(started).Should(BeClosed())
+				})
+
+				It("aborts concurrent requests for the same volume without blocking others", func() {
+					_, err := createFromSnapshot(context, cs, vc, "restored", "default:snap")
+					Expect(status.Code(err)).To(Equal(codes.Aborted))
+
+					_, err = cs.DeleteVolume(context, &DeleteVolumeRequest{VolumeId: "default:restored"})
+					Expect(status.Code(err)).To(Equal(codes.Aborted))
+
+					createSuccessful(context, cs, fakeOs, "unrelated", vc)
+
+					close(release)
+					Eventually(done).Should(Receive(BeNil()))
+				})
+			})
+		})
+
+		Context("when CreateSnapshot is cancelled during the copy", func() {
+			BeforeEach(func() {
+				fakeDirTree.CopyStub = copyUnlessDone
+				_, err := createSnapshot(cancelledContext(), cs, "snap", volumeId)
+				Expect(status.Code(err)).To(Equal(codes.Canceled))
+			})
+
+			It("lists the snapshot as not ready and resumes the copy on retry", func() {
+				resp, err := cs.ListSnapshots(context, &ListSnapshotsRequest{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.GetEntries()).To(HaveLen(1))
+				Expect(resp.GetEntries()[0].GetSnapshot().GetReadyToUse()).To(BeFalse())
+
+				_, err = createFromSnapshot(context, cs, vc, "restored", "default:snap")
+				Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+
+				snapshot, err := createSnapshot(context, cs, "snap", volumeId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(snapshot.GetReadyToUse()).To(BeTrue())
+				Expect(fakeDirTree.CopyCallCount()).To(Equal(2))
 			})
+		})
 
-			It("returns an empty list of snapshots", func() {
+		Context("when DeleteVolume is cancelled during the removal", func() {
+			It("keeps the volume so that a retry finishes the removal", func() {
+				fakeDirTree.RemoveStub = removeUnlessDone
+				_, err := cs.DeleteVolume(cancelledContext(), &DeleteVolumeRequest{VolumeId: volumeId})
+				Expect(status.Code(err)).To(Equal(codes.Canceled))
+
+				_, err = cs.ControllerGetVolume(context, &ControllerGetVolumeRequest{VolumeId: volumeId})
 				Expect(err).NotTo(HaveOccurred())
-				Expect(*expectedResponse).To(Equal(ListSnapshotsResponse{}))
+
+				deleteSuccessful(context, cs, volumeId)
+				_, err = cs.ControllerGetVolume(context, &ControllerGetVolumeRequest{VolumeId: volumeId})
+				Expect(status.Code(err)).To(Equal(codes.NotFound))
 			})
 		})
 	})
 })
 
+type FakeFileInfo struct {
+	FileName string
+	FileSize int64
+	FileMode os.FileMode
+	Stat     *syscall.Stat_t
+}
+
+func (f *FakeFileInfo) Name() string       { return f.FileName }
+func (f *FakeFileInfo) Size() int64        { return f.FileSize }
+func (f *FakeFileInfo) Mode() os.FileMode  { return f.FileMode }
+func (f *FakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (f *FakeFileInfo) IsDir() bool        { return f.FileMode.IsDir() }
+func (f *FakeFileInfo) Sys() interface{}   { return f.Stat }
+
 type DummyContext struct{}
 
 func (*DummyContext) Deadline() (deadline time.Time, ok bool) { return time.Time{}, false }
@@ -463,6 +1574,12 @@ func (*DummyContext) Err() error { return nil }
 
 func (*DummyContext) Value(key interface{}) interface{} { return nil }
 
+func newRecoveredController(fakeOs *os_fake.FakeOs, fakeFilepath *filepath_fake.FakeFilepath, fakeDiskStats *controllerfakes.FakeDiskStats, fakeDirTree *controllerfakes.FakeDirTree, registry controller.Registry, config controller.Config) *controller.Controller {
+	cs := controller.NewController(fakeOs, fakeFilepath, fakeDiskStats, fakeDirTree, &controllerfakes.FakeQuotas{}, &controllerfakes.FakeImages{}, &controllerfakes.FakeSubvolumes{}, registry, config)
+	Expect(cs.Recover()).To(Succeed())
+	return cs
+}
+
 func createSuccessful(ctx context.Context, cs ControllerServer, fakeOs *os_fake.FakeOs, volumeName string, vc []*VolumeCapability) *CreateVolumeResponse {
 	createResponse, err := cs.CreateVolume(ctx, &CreateVolumeRequest{
 		Name:               volumeName,
@@ -479,3 +1596,49 @@ func deleteSuccessful(ctx context.Context, cs ControllerServer, volumeId string)
 	Expect(err).NotTo(HaveOccurred())
 	return deleteResponse
 }
+
+func createSnapshot(ctx context.Context, cs ControllerServer, name, sourceVolumeId string) (*Snapshot, error) {
+	resp, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: name, SourceVolumeId: sourceVolumeId})
+	return resp.GetSnapshot(), err
+}
+
+func createFromSnapshot(ctx context.Context, cs ControllerServer, vc []*VolumeCapability, name, snapshotId string) (*Volume, error) {
+	resp, err := cs.CreateVolume(ctx, &CreateVolumeRequest{
+		Name:               name,
+		VolumeCapabilities: vc,
+		VolumeContentSource: &VolumeContentSource{
+			Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: snapshotId}},
+		},
+	})
+	return resp.GetVolume(), err
+}
+
+func cancelledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func expiredContext() context.Context {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	cancel()
+	return ctx
+}
+
+// copyUnlessDone and removeUnlessDone stand in for a DirTree that notices
+// cancellation before it has finished.
+func copyUnlessDone(ctx context.Context, src, dst string) error {
+	return ctx.Err()
+}
+
+func removeUnlessDone(ctx context.Context, path string) error {
+	return ctx.Err()
+}
+
+func blockingCopy(started, release chan struct{}) func(context.Context, string, string) error {
+	return func(ctx context.Context, src, dst string) error {
+		close(started)
+		<-release
+		return nil
+	}
+}
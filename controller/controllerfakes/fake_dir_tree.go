@@ -0,0 +1,278 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package controllerfakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"golang.org/x/net/context"
+)
+
+type FakeDirTree struct {
+	CopyStub        func(context.Context, string, string) error
+	copyMutex       sync.RWMutex
+	copyArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}
+	copyReturns struct {
+		result1 error
+	}
+	copyReturnsOnCall map[int]struct {
+		result1 error
+	}
+	RemoveStub        func(context.Context, string) error
+	removeMutex       sync.RWMutex
+	removeArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	removeReturns struct {
+		result1 error
+	}
+	removeReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UsageStub        func(context.Context, string) (int64, int64, error)
+	usageMutex       sync.RWMutex
+	usageArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	usageReturns struct {
+		result1 int64
+		result2 int64
+		result3 error
+	}
+	usageReturnsOnCall map[int]struct {
+		result1 int64
+		result2 int64
+		result3 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeDirTree) Copy(arg1 context.Context, arg2 string, arg3 string) error {
+	fake.copyMutex.Lock()
+	ret, specificReturn := fake.copyReturnsOnCall[len(fake.copyArgsForCall)]
+	fake.copyArgsForCall = append(fake.copyArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.CopyStub
+	fakeReturns := fake.copyReturns
+	fake.recordInvocation("Copy", []interface{}{arg1, arg2, arg3})
+	fake.copyMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDirTree) CopyCallCount() int {
+	fake.copyMutex.RLock()
+	defer fake.copyMutex.RUnlock()
+	return len(fake.copyArgsForCall)
+}
+
+func (fake *FakeDirTree) CopyCalls(stub func(context.Context, string, string) error) {
+	fake.copyMutex.Lock()
+	defer fake.copyMutex.Unlock()
+	fake.CopyStub = stub
+}
+
+func (fake *FakeDirTree) CopyArgsForCall(i int) (context.Context, string, string) {
+	fake.copyMutex.RLock()
+	defer fake.copyMutex.RUnlock()
+	argsForCall := fake.copyArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeDirTree) CopyReturns(result1 error) {
+	fake.copyMutex.Lock()
+	defer fake.copyMutex.Unlock()
+	fake.CopyStub = nil
+	fake.copyReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDirTree) CopyReturnsOnCall(i int, result1 error) {
+	fake.copyMutex.Lock()
+	defer fake.copyMutex.Unlock()
+	fake.CopyStub = nil
+	if fake.copyReturnsOnCall == nil {
+		fake.copyReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.copyReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDirTree) Remove(arg1 context.Context, arg2 string) error {
+	fake.removeMutex.Lock()
+	ret, specificReturn := fake.removeReturnsOnCall[len(fake.removeArgsForCall)]
+	fake.removeArgsForCall = append(fake.removeArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.RemoveStub
+	fakeReturns := fake.removeReturns
+	fake.recordInvocation("Remove", []interface{}{arg1, arg2})
+	fake.removeMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDirTree) RemoveCallCount() int {
+	fake.removeMutex.RLock()
+	defer fake.removeMutex.RUnlock()
+	return len(fake.removeArgsForCall)
+}
+
+func (fake *FakeDirTree) RemoveCalls(stub func(context.Context, string) error) {
+	fake.removeMutex.Lock()
+	defer fake.removeMutex.Unlock()
+	fake.RemoveStub = stub
+}
+
+func (fake *FakeDirTree) RemoveArgsForCall(i int) (context.Context, string) {
+	fake.removeMutex.RLock()
+	defer fake.removeMutex.RUnlock()
+	argsForCall := fake.removeArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDirTree) RemoveReturns(result1 error) {
+	fake.removeMutex.Lock()
+	defer fake.removeMutex.Unlock()
+	fake.RemoveStub = nil
+	fake.removeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDirTree) RemoveReturnsOnCall(i int, result1 error) {
+	fake.removeMutex.Lock()
+	defer fake.removeMutex.Unlock()
+	fake.RemoveStub = nil
+	if fake.removeReturnsOnCall == nil {
+		fake.removeReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.removeReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDirTree) Usage(arg1 context.Context, arg2 string) (int64, int64, error) {
+	fake.usageMutex.Lock()
+	ret, specificReturn := fake.usageReturnsOnCall[len(fake.usageArgsForCall)]
+	fake.usageArgsForCall = append(fake.usageArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.UsageStub
+	fakeReturns := fake.usageReturns
+	fake.recordInvocation("Usage", []interface{}{arg1, arg2})
+	fake.usageMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeDirTree) UsageCallCount() int {
+	fake.usageMutex.RLock()
+	defer fake.usageMutex.RUnlock()
+	return len(fake.usageArgsForCall)
+}
+
+func (fake *FakeDirTree) UsageCalls(stub func(context.Context, string) (int64, int64, error)) {
+	fake.usageMutex.Lock()
+	defer fake.usageMutex.Unlock()
+	fake.UsageStub = stub
+}
+
+func (fake *FakeDirTree) UsageArgsForCall(i int) (context.Context, string) {
+	fake.usageMutex.RLock()
+	defer fake.usageMutex.RUnlock()
+	argsForCall := fake.usageArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDirTree) UsageReturns(result1 int64, result2 int64, result3 error) {
+	fake.usageMutex.Lock()
+	defer fake.usageMutex.Unlock()
+	fake.UsageStub = nil
+	fake.usageReturns = struct {
+		result1 int64
+		result2 int64
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeDirTree) UsageReturnsOnCall(i int, result1 int64, result2 int64, result3 error) {
+	fake.usageMutex.Lock()
+	defer fake.usageMutex.Unlock()
+	fake.UsageStub = nil
+	if fake.usageReturnsOnCall == nil {
+		fake.usageReturnsOnCall = make(map[int]struct {
+			result1 int64
+			result2 int64
+			result3 error
+		})
+	}
+	fake.usageReturnsOnCall[i] = struct {
+		result1 int64
+		result2 int64
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeDirTree) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.copyMutex.RLock()
+	defer fake.copyMutex.RUnlock()
+	fake.removeMutex.RLock()
+	defer fake.removeMutex.RUnlock()
+	fake.usageMutex.RLock()
+	defer fake.usageMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeDirTree) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ controller.DirTree = new(FakeDirTree)
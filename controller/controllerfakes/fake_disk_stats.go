@@ -0,0 +1,116 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package controllerfakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+)
+
+type FakeDiskStats struct {
+	FreeBytesStub        func(string) (uint64, error)
+	freeBytesMutex       sync.RWMutex
+	freeBytesArgsForCall []struct {
+		arg1 string
+	}
+	freeBytesReturns struct {
+		result1 uint64
+		result2 error
+	}
+	freeBytesReturnsOnCall map[int]struct {
+		result1 uint64
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeDiskStats) FreeBytes(arg1 string) (uint64, error) {
+	fake.freeBytesMutex.Lock()
+	ret, specificReturn := fake.freeBytesReturnsOnCall[len(fake.freeBytesArgsForCall)]
+	fake.freeBytesArgsForCall = append(fake.freeBytesArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.FreeBytesStub
+	fakeReturns := fake.freeBytesReturns
+	fake.recordInvocation("FreeBytes", []interface{}{arg1})
+	fake.freeBytesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeDiskStats) FreeBytesCallCount() int {
+	fake.freeBytesMutex.RLock()
+	defer fake.freeBytesMutex.RUnlock()
+	return len(fake.freeBytesArgsForCall)
+}
+
+func (fake *FakeDiskStats) FreeBytesCalls(stub func(string) (uint64, error)) {
+	fake.freeBytesMutex.Lock()
+	defer fake.freeBytesMutex.Unlock()
+	fake.FreeBytesStub = stub
+}
+
+func (fake *FakeDiskStats) FreeBytesArgsForCall(i int) string {
+	fake.freeBytesMutex.RLock()
+	defer fake.freeBytesMutex.RUnlock()
+	argsForCall := fake.freeBytesArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeDiskStats) FreeBytesReturns(result1 uint64, result2 error) {
+	fake.freeBytesMutex.Lock()
+	defer fake.freeBytesMutex.Unlock()
+	fake.FreeBytesStub = nil
+	fake.freeBytesReturns = struct {
+		result1 uint64
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDiskStats) FreeBytesReturnsOnCall(i int, result1 uint64, result2 error) {
+	fake.freeBytesMutex.Lock()
+	defer fake.freeBytesMutex.Unlock()
+	fake.FreeBytesStub = nil
+	if fake.freeBytesReturnsOnCall == nil {
+		fake.freeBytesReturnsOnCall = make(map[int]struct {
+			result1 uint64
+			result2 error
+		})
+	}
+	fake.freeBytesReturnsOnCall[i] = struct {
+		result1 uint64
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDiskStats) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.freeBytesMutex.RLock()
+	defer fake.freeBytesMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeDiskStats) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ controller.DiskStats = new(FakeDiskStats)
@@ -0,0 +1,196 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package controllerfakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"golang.org/x/net/context"
+)
+
+type FakeImages struct {
+	CreateStub        func(context.Context, string, int64, string) error
+	createMutex       sync.RWMutex
+	createArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 int64
+		arg4 string
+	}
+	createReturns struct {
+		result1 error
+	}
+	createReturnsOnCall map[int]struct {
+		result1 error
+	}
+	GrowStub        func(context.Context, string, int64) error
+	growMutex       sync.RWMutex
+	growArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 int64
+	}
+	growReturns struct {
+		result1 error
+	}
+	growReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeImages) Create(arg1 context.Context, arg2 string, arg3 int64, arg4 string) error {
+	fake.createMutex.Lock()
+	ret, specificReturn := fake.createReturnsOnCall[len(fake.createArgsForCall)]
+	fake.createArgsForCall = append(fake.createArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 int64
+		arg4 string
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.CreateStub
+	fakeReturns := fake.createReturns
+	fake.recordInvocation("Create", []interface{}{arg1, arg2, arg3, arg4})
+	fake.createMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeImages) CreateCallCount() int {
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	return len(fake.createArgsForCall)
+}
+
+func (fake *FakeImages) CreateCalls(stub func(context.Context, string, int64, string) error) {
+	fake.createMutex.Lock()
+	defer fake.createMutex.Unlock()
+	fake.CreateStub = stub
+}
+
+func (fake *FakeImages) CreateArgsForCall(i int) (context.Context, string, int64, string) {
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	argsForCall := fake.createArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeImages) CreateReturns(result1 error) {
+	fake.createMutex.Lock()
+	defer fake.createMutex.Unlock()
+	fake.CreateStub = nil
+	fake.createReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeImages) CreateReturnsOnCall(i int, result1 error) {
+	fake.createMutex.Lock()
+	defer fake.createMutex.Unlock()
+	fake.CreateStub = nil
+	if fake.createReturnsOnCall == nil {
+		fake.createReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeImages) Grow(arg1 context.Context, arg2 string, arg3 int64) error {
+	fake.growMutex.Lock()
+	ret, specificReturn := fake.growReturnsOnCall[len(fake.growArgsForCall)]
+	fake.growArgsForCall = append(fake.growArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 int64
+	}{arg1, arg2, arg3})
+	stub := fake.GrowStub
+	fakeReturns := fake.growReturns
+	fake.recordInvocation("Grow", []interface{}{arg1, arg2, arg3})
+	fake.growMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeImages) GrowCallCount() int {
+	fake.growMutex.RLock()
+	defer fake.growMutex.RUnlock()
+	return len(fake.growArgsForCall)
+}
+
+func (fake *FakeImages) GrowCalls(stub func(context.Context, string, int64) error) {
+	fake.growMutex.Lock()
+	defer fake.growMutex.Unlock()
+	fake.GrowStub = stub
+}
+
+func (fake *FakeImages) GrowArgsForCall(i int) (context.Context, string, int64) {
+	fake.growMutex.RLock()
+	defer fake.growMutex.RUnlock()
+	argsForCall := fake.growArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeImages) GrowReturns(result1 error) {
+	fake.growMutex.Lock()
+	defer fake.growMutex.Unlock()
+	fake.GrowStub = nil
+	fake.growReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeImages) GrowReturnsOnCall(i int, result1 error) {
+	fake.growMutex.Lock()
+	defer fake.growMutex.Unlock()
+	fake.GrowStub = nil
+	if fake.growReturnsOnCall == nil {
+		fake.growReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.growReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeImages) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	fake.growMutex.RLock()
+	defer fake.growMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeImages) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ controller.Images = new(FakeImages)
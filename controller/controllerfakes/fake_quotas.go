@@ -0,0 +1,267 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package controllerfakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+)
+
+type FakeQuotas struct {
+	CheckStub        func(string) error
+	checkMutex       sync.RWMutex
+	checkArgsForCall []struct {
+		arg1 string
+	}
+	checkReturns struct {
+		result1 error
+	}
+	checkReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ClearStub        func(string, uint32) error
+	clearMutex       sync.RWMutex
+	clearArgsForCall []struct {
+		arg1 string
+		arg2 uint32
+	}
+	clearReturns struct {
+		result1 error
+	}
+	clearReturnsOnCall map[int]struct {
+		result1 error
+	}
+	LimitStub        func(string, uint32, uint64, uint64) error
+	limitMutex       sync.RWMutex
+	limitArgsForCall []struct {
+		arg1 string
+		arg2 uint32
+		arg3 uint64
+		arg4 uint64
+	}
+	limitReturns struct {
+		result1 error
+	}
+	limitReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeQuotas) Check(arg1 string) error {
+	fake.checkMutex.Lock()
+	ret, specificReturn := fake.checkReturnsOnCall[len(fake.checkArgsForCall)]
+	fake.checkArgsForCall = append(fake.checkArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.CheckStub
+	fakeReturns := fake.checkReturns
+	fake.recordInvocation("Check", []interface{}{arg1})
+	fake.checkMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeQuotas) CheckCallCount() int {
+	fake.checkMutex.RLock()
+	defer fake.checkMutex.RUnlock()
+	return len(fake.checkArgsForCall)
+}
+
+func (fake *FakeQuotas) CheckCalls(stub func(string) error) {
+	fake.checkMutex.Lock()
+	defer fake.checkMutex.Unlock()
+	fake.CheckStub = stub
+}
+
+func (fake *FakeQuotas) CheckArgsForCall(i int) string {
+	fake.checkMutex.RLock()
+	defer fake.checkMutex.RUnlock()
+	argsForCall := fake.checkArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeQuotas) CheckReturns(result1 error) {
+	fake.checkMutex.Lock()
+	defer fake.checkMutex.Unlock()
+	fake.CheckStub = nil
+	fake.checkReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeQuotas) CheckReturnsOnCall(i int, result1 error) {
+	fake.checkMutex.Lock()
+	defer fake.checkMutex.Unlock()
+	fake.CheckStub = nil
+	if fake.checkReturnsOnCall == nil {
+		fake.checkReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.checkReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeQuotas) Clear(arg1 string, arg2 uint32) error {
+	fake.clearMutex.Lock()
+	ret, specificReturn := fake.clearReturnsOnCall[len(fake.clearArgsForCall)]
+	fake.clearArgsForCall = append(fake.clearArgsForCall, struct {
+		arg1 string
+		arg2 uint32
+	}{arg1, arg2})
+	stub := fake.ClearStub
+	fakeReturns := fake.clearReturns
+	fake.recordInvocation("Clear", []interface{}{arg1, arg2})
+	fake.clearMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeQuotas) ClearCallCount() int {
+	fake.clearMutex.RLock()
+	defer fake.clearMutex.RUnlock()
+	return len(fake.clearArgsForCall)
+}
+
+func (fake *FakeQuotas) ClearCalls(stub func(string, uint32) error) {
+	fake.clearMutex.Lock()
+	defer fake.clearMutex.Unlock()
+	fake.ClearStub = stub
+}
+
+func (fake *FakeQuotas) ClearArgsForCall(i int) (string, uint32) {
+	fake.clearMutex.RLock()
+	defer fake.clearMutex.RUnlock()
+	argsForCall := fake.clearArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeQuotas) ClearReturns(result1 error) {
+	fake.clearMutex.Lock()
+	defer fake.clearMutex.Unlock()
+	fake.ClearStub = nil
+	fake.clearReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeQuotas) ClearReturnsOnCall(i int, result1 error) {
+	fake.clearMutex.Lock()
+	defer fake.clearMutex.Unlock()
+	fake.ClearStub = nil
+	if fake.clearReturnsOnCall == nil {
+		fake.clearReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.clearReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeQuotas) Limit(arg1 string, arg2 uint32, arg3 uint64, arg4 uint64) error {
+	fake.limitMutex.Lock()
+	ret, specificReturn := fake.limitReturnsOnCall[len(fake.limitArgsForCall)]
+	fake.limitArgsForCall = append(fake.limitArgsForCall, struct {
+		arg1 string
+		arg2 uint32
+		arg3 uint64
+		arg4 uint64
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.LimitStub
+	fakeReturns := fake.limitReturns
+	fake.recordInvocation("Limit", []interface{}{arg1, arg2, arg3, arg4})
+	fake.limitMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeQuotas) LimitCallCount() int {
+	fake.limitMutex.RLock()
+	defer fake.limitMutex.RUnlock()
+	return len(fake.limitArgsForCall)
+}
+
+func (fake *FakeQuotas) LimitCalls(stub func(string, uint32, uint64, uint64) error) {
+	fake.limitMutex.Lock()
+	defer fake.limitMutex.Unlock()
+	fake.LimitStub = stub
+}
+
+func (fake *FakeQuotas) LimitArgsForCall(i int) (string, uint32, uint64, uint64) {
+	fake.limitMutex.RLock()
+	defer fake.limitMutex.RUnlock()
+	argsForCall := fake.limitArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeQuotas) LimitReturns(result1 error) {
+	fake.limitMutex.Lock()
+	defer fake.limitMutex.Unlock()
+	fake.LimitStub = nil
+	fake.limitReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeQuotas) LimitReturnsOnCall(i int, result1 error) {
+	fake.limitMutex.Lock()
+	defer fake.limitMutex.Unlock()
+	fake.LimitStub = nil
+	if fake.limitReturnsOnCall == nil {
+		fake.limitReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.limitReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeQuotas) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.checkMutex.RLock()
+	defer fake.checkMutex.RUnlock()
+	fake.clearMutex.RLock()
+	defer fake.clearMutex.RUnlock()
+	fake.limitMutex.RLock()
+	defer fake.limitMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeQuotas) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ controller.Quotas = new(FakeQuotas)
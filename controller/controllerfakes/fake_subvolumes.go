@@ -0,0 +1,582 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package controllerfakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"golang.org/x/net/context"
+)
+
+type FakeSubvolumes struct {
+	CreateStub        func(context.Context, string) error
+	createMutex       sync.RWMutex
+	createArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	createReturns struct {
+		result1 error
+	}
+	createReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DeleteStub        func(context.Context, string) error
+	deleteMutex       sync.RWMutex
+	deleteArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	deleteReturns struct {
+		result1 error
+	}
+	deleteReturnsOnCall map[int]struct {
+		result1 error
+	}
+	EnableQuotaStub        func(context.Context, string) error
+	enableQuotaMutex       sync.RWMutex
+	enableQuotaArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	enableQuotaReturns struct {
+		result1 error
+	}
+	enableQuotaReturnsOnCall map[int]struct {
+		result1 error
+	}
+	IsBtrfsStub        func(string) (bool, error)
+	isBtrfsMutex       sync.RWMutex
+	isBtrfsArgsForCall []struct {
+		arg1 string
+	}
+	isBtrfsReturns struct {
+		result1 bool
+		result2 error
+	}
+	isBtrfsReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	IsSubvolumeStub        func(string) (bool, error)
+	isSubvolumeMutex       sync.RWMutex
+	isSubvolumeArgsForCall []struct {
+		arg1 string
+	}
+	isSubvolumeReturns struct {
+		result1 bool
+		result2 error
+	}
+	isSubvolumeReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	LimitStub        func(context.Context, string, int64) error
+	limitMutex       sync.RWMutex
+	limitArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 int64
+	}
+	limitReturns struct {
+		result1 error
+	}
+	limitReturnsOnCall map[int]struct {
+		result1 error
+	}
+	SnapshotStub        func(context.Context, string, string, bool) error
+	snapshotMutex       sync.RWMutex
+	snapshotArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 bool
+	}
+	snapshotReturns struct {
+		result1 error
+	}
+	snapshotReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeSubvolumes) Create(arg1 context.Context, arg2 string) error {
+	fake.createMutex.Lock()
+	ret, specificReturn := fake.createReturnsOnCall[len(fake.createArgsForCall)]
+	fake.createArgsForCall = append(fake.createArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.CreateStub
+	fakeReturns := fake.createReturns
+	fake.recordInvocation("Create", []interface{}{arg1, arg2})
+	fake.createMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeSubvolumes) CreateCallCount() int {
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	return len(fake.createArgsForCall)
+}
+
+func (fake *FakeSubvolumes) CreateCalls(stub func(context.Context, string) error) {
+	fake.createMutex.Lock()
+	defer fake.createMutex.Unlock()
+	fake.CreateStub = stub
+}
+
+func (fake *FakeSubvolumes) CreateArgsForCall(i int) (context.Context, string) {
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	argsForCall := fake.createArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeSubvolumes) CreateReturns(result1 error) {
+	fake.createMutex.Lock()
+	defer fake.createMutex.Unlock()
+	fake.CreateStub = nil
+	fake.createReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) CreateReturnsOnCall(i int, result1 error) {
+	fake.createMutex.Lock()
+	defer fake.createMutex.Unlock()
+	fake.CreateStub = nil
+	if fake.createReturnsOnCall == nil {
+		fake.createReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) Delete(arg1 context.Context, arg2 string) error {
+	fake.deleteMutex.Lock()
+	ret, specificReturn := fake.deleteReturnsOnCall[len(fake.deleteArgsForCall)]
+	fake.deleteArgsForCall = append(fake.deleteArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.DeleteStub
+	fakeReturns := fake.deleteReturns
+	fake.recordInvocation("Delete", []interface{}{arg1, arg2})
+	fake.deleteMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeSubvolumes) DeleteCallCount() int {
+	fake.deleteMutex.RLock()
+	defer fake.deleteMutex.RUnlock()
+	return len(fake.deleteArgsForCall)
+}
+
+func (fake *FakeSubvolumes) DeleteCalls(stub func(context.Context, string) error) {
+	fake.deleteMutex.Lock()
+	defer fake.deleteMutex.Unlock()
+	fake.DeleteStub = stub
+}
+
+func (fake *FakeSubvolumes) DeleteArgsForCall(i int) (context.Context, string) {
+	fake.deleteMutex.RLock()
+	defer fake.deleteMutex.RUnlock()
+	argsForCall := fake.deleteArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeSubvolumes) DeleteReturns(result1 error) {
+	fake.deleteMutex.Lock()
+	defer fake.deleteMutex.Unlock()
+	fake.DeleteStub = nil
+	fake.deleteReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) DeleteReturnsOnCall(i int, result1 error) {
+	fake.deleteMutex.Lock()
+	defer fake.deleteMutex.Unlock()
+	fake.DeleteStub = nil
+	if fake.deleteReturnsOnCall == nil {
+		fake.deleteReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) EnableQuota(arg1 context.Context, arg2 string) error {
+	fake.enableQuotaMutex.Lock()
+	ret, specificReturn := fake.enableQuotaReturnsOnCall[len(fake.enableQuotaArgsForCall)]
+	fake.enableQuotaArgsForCall = append(fake.enableQuotaArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.EnableQuotaStub
+	fakeReturns := fake.enableQuotaReturns
+	fake.recordInvocation("EnableQuota", []interface{}{arg1, arg2})
+	fake.enableQuotaMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeSubvolumes) EnableQuotaCallCount() int {
+	fake.enableQuotaMutex.RLock()
+	defer fake.enableQuotaMutex.RUnlock()
+	return len(fake.enableQuotaArgsForCall)
+}
+
+func (fake *FakeSubvolumes) EnableQuotaCalls(stub func(context.Context, string) error) {
+	fake.enableQuotaMutex.Lock()
+	defer fake.enableQuotaMutex.Unlock()
+	fake.EnableQuotaStub = stub
+}
+
+func (fake *FakeSubvolumes) EnableQuotaArgsForCall(i int) (context.Context, string) {
+	fake.enableQuotaMutex.RLock()
+	defer fake.enableQuotaMutex.RUnlock()
+	argsForCall := fake.enableQuotaArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeSubvolumes) EnableQuotaReturns(result1 error) {
+	fake.enableQuotaMutex.Lock()
+	defer fake.enableQuotaMutex.Unlock()
+	fake.EnableQuotaStub = nil
+	fake.enableQuotaReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) EnableQuotaReturnsOnCall(i int, result1 error) {
+	fake.enableQuotaMutex.Lock()
+	defer fake.enableQuotaMutex.Unlock()
+	fake.EnableQuotaStub = nil
+	if fake.enableQuotaReturnsOnCall == nil {
+		fake.enableQuotaReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.enableQuotaReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) IsBtrfs(arg1 string) (bool, error) {
+	fake.isBtrfsMutex.Lock()
+	ret, specificReturn := fake.isBtrfsReturnsOnCall[len(fake.isBtrfsArgsForCall)]
+	fake.isBtrfsArgsForCall = append(fake.isBtrfsArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.IsBtrfsStub
+	fakeReturns := fake.isBtrfsReturns
+	fake.recordInvocation("IsBtrfs", []interface{}{arg1})
+	fake.isBtrfsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeSubvolumes) IsBtrfsCallCount() int {
+	fake.isBtrfsMutex.RLock()
+	defer fake.isBtrfsMutex.RUnlock()
+	return len(fake.isBtrfsArgsForCall)
+}
+
+func (fake *FakeSubvolumes) IsBtrfsCalls(stub func(string) (bool, error)) {
+	fake.isBtrfsMutex.Lock()
+	defer fake.isBtrfsMutex.Unlock()
+	fake.IsBtrfsStub = stub
+}
+
+func (fake *FakeSubvolumes) IsBtrfsArgsForCall(i int) string {
+	fake.isBtrfsMutex.RLock()
+	defer fake.isBtrfsMutex.RUnlock()
+	argsForCall := fake.isBtrfsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeSubvolumes) IsBtrfsReturns(result1 bool, result2 error) {
+	fake.isBtrfsMutex.Lock()
+	defer fake.isBtrfsMutex.Unlock()
+	fake.IsBtrfsStub = nil
+	fake.isBtrfsReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeSubvolumes) IsBtrfsReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.isBtrfsMutex.Lock()
+	defer fake.isBtrfsMutex.Unlock()
+	fake.IsBtrfsStub = nil
+	if fake.isBtrfsReturnsOnCall == nil {
+		fake.isBtrfsReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.isBtrfsReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeSubvolumes) IsSubvolume(arg1 string) (bool, error) {
+	fake.isSubvolumeMutex.Lock()
+	ret, specificReturn := fake.isSubvolumeReturnsOnCall[len(fake.isSubvolumeArgsForCall)]
+	fake.isSubvolumeArgsForCall = append(fake.isSubvolumeArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.IsSubvolumeStub
+	fakeReturns := fake.isSubvolumeReturns
+	fake.recordInvocation("IsSubvolume", []interface{}{arg1})
+	fake.isSubvolumeMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeSubvolumes) IsSubvolumeCallCount() int {
+	fake.isSubvolumeMutex.RLock()
+	defer fake.isSubvolumeMutex.RUnlock()
+	return len(fake.isSubvolumeArgsForCall)
+}
+
+func (fake *FakeSubvolumes) IsSubvolumeCalls(stub func(string) (bool, error)) {
+	fake.isSubvolumeMutex.Lock()
+	defer fake.isSubvolumeMutex.Unlock()
+	fake.IsSubvolumeStub = stub
+}
+
+func (fake *FakeSubvolumes) IsSubvolumeArgsForCall(i int) string {
+	fake.isSubvolumeMutex.RLock()
+	defer fake.isSubvolumeMutex.RUnlock()
+	argsForCall := fake.isSubvolumeArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeSubvolumes) IsSubvolumeReturns(result1 bool, result2 error) {
+	fake.isSubvolumeMutex.Lock()
+	defer fake.isSubvolumeMutex.Unlock()
+	fake.IsSubvolumeStub = nil
+	fake.isSubvolumeReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeSubvolumes) IsSubvolumeReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.isSubvolumeMutex.Lock()
+	defer fake.isSubvolumeMutex.Unlock()
+	fake.IsSubvolumeStub = nil
+	if fake.isSubvolumeReturnsOnCall == nil {
+		fake.isSubvolumeReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.isSubvolumeReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeSubvolumes) Limit(arg1 context.Context, arg2 string, arg3 int64) error {
+	fake.limitMutex.Lock()
+	ret, specificReturn := fake.limitReturnsOnCall[len(fake.limitArgsForCall)]
+	fake.limitArgsForCall = append(fake.limitArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 int64
+	}{arg1, arg2, arg3})
+	stub := fake.LimitStub
+	fakeReturns := fake.limitReturns
+	fake.recordInvocation("Limit", []interface{}{arg1, arg2, arg3})
+	fake.limitMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeSubvolumes) LimitCallCount() int {
+	fake.limitMutex.RLock()
+	defer fake.limitMutex.RUnlock()
+	return len(fake.limitArgsForCall)
+}
+
+func (fake *FakeSubvolumes) LimitCalls(stub func(context.Context, string, int64) error) {
+	fake.limitMutex.Lock()
+	defer fake.limitMutex.Unlock()
+	fake.LimitStub = stub
+}
+
+func (fake *FakeSubvolumes) LimitArgsForCall(i int) (context.Context, string, int64) {
+	fake.limitMutex.RLock()
+	defer fake.limitMutex.RUnlock()
+	argsForCall := fake.limitArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeSubvolumes) LimitReturns(result1 error) {
+	fake.limitMutex.Lock()
+	defer fake.limitMutex.Unlock()
+	fake.LimitStub = nil
+	fake.limitReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) LimitReturnsOnCall(i int, result1 error) {
+	fake.limitMutex.Lock()
+	defer fake.limitMutex.Unlock()
+	fake.LimitStub = nil
+	if fake.limitReturnsOnCall == nil {
+		fake.limitReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.limitReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) Snapshot(arg1 context.Context, arg2 string, arg3 string, arg4 bool) error {
+	fake.snapshotMutex.Lock()
+	ret, specificReturn := fake.snapshotReturnsOnCall[len(fake.snapshotArgsForCall)]
+	fake.snapshotArgsForCall = append(fake.snapshotArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 bool
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.SnapshotStub
+	fakeReturns := fake.snapshotReturns
+	fake.recordInvocation("Snapshot", []interface{}{arg1, arg2, arg3, arg4})
+	fake.snapshotMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeSubvolumes) SnapshotCallCount() int {
+	fake.snapshotMutex.RLock()
+	defer fake.snapshotMutex.RUnlock()
+	return len(fake.snapshotArgsForCall)
+}
+
+func (fake *FakeSubvolumes) SnapshotCalls(stub func(context.Context, string, string, bool) error) {
+	fake.snapshotMutex.Lock()
+	defer fake.snapshotMutex.Unlock()
+	fake.SnapshotStub = stub
+}
+
+func (fake *FakeSubvolumes) SnapshotArgsForCall(i int) (context.Context, string, string, bool) {
+	fake.snapshotMutex.RLock()
+	defer fake.snapshotMutex.RUnlock()
+	argsForCall := fake.snapshotArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeSubvolumes) SnapshotReturns(result1 error) {
+	fake.snapshotMutex.Lock()
+	defer fake.snapshotMutex.Unlock()
+	fake.SnapshotStub = nil
+	fake.snapshotReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) SnapshotReturnsOnCall(i int, result1 error) {
+	fake.snapshotMutex.Lock()
+	defer fake.snapshotMutex.Unlock()
+	fake.SnapshotStub = nil
+	if fake.snapshotReturnsOnCall == nil {
+		fake.snapshotReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.snapshotReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSubvolumes) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	fake.deleteMutex.RLock()
+	defer fake.deleteMutex.RUnlock()
+	fake.enableQuotaMutex.RLock()
+	defer fake.enableQuotaMutex.RUnlock()
+	fake.isBtrfsMutex.RLock()
+	defer fake.isBtrfsMutex.RUnlock()
+	fake.isSubvolumeMutex.RLock()
+	defer fake.isSubvolumeMutex.RUnlock()
+	fake.limitMutex.RLock()
+	defer fake.limitMutex.RUnlock()
+	fake.snapshotMutex.RLock()
+	defer fake.snapshotMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeSubvolumes) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ controller.Subvolumes = new(FakeSubvolumes)
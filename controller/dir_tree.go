@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+//go:generate counterfeiter -o controllerfakes/fake_dir_tree.go . DirTree
+
+// DirTree copies and removes directory trees, checking ctx between files so
+// that a cancelled request stops promptly. Both operations can be repeated
+// after an interruption to finish what the earlier call started.
+type DirTree interface {
+	// Copy copies src into dst, skipping regular files that already exist in
+	// dst with the same size and modification time. The whiteouts and opaque
+	// directories of an overlayfs upper directory are kept.
+	Copy(ctx context.Context, src, dst string) error
+	// Remove removes path and everything under it; a missing path is not an error.
+	Remove(ctx context.Context, path string) error
+	// Usage returns the bytes allocated to path and everything under it, and
+	// the number of inodes they use. Hard-linked files are counted once.
+	Usage(ctx context.Context, path string) (bytes int64, inodes int64, err error)
+}
+
+// copyChunkSize bounds how much of a file is copied between checks of ctx.
+const copyChunkSize = 1 << 20
+
+type osDirTree struct{}
+
+func NewDirTree() DirTree {
+	return &osDirTree{}
+}
+
+func (*osDirTree) Copy(ctx context.Context, src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			if err := os.Mkdir(target, info.Mode().Perm()); err != nil && !os.IsExist(err) {
+				return err
+			}
+			if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return copyOpaque(path, target)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(ctx, path, target, info)
+		case isWhiteout(info):
+			return copyWhiteout(target)
+		default:
+			// other devices, sockets and pipes have no content to carry over
+			return nil
+		}
+	})
+}
+
+func copyFile(ctx context.Context, src, dst string, info os.FileInfo) error {
+	if existing, err := os.Lstat(dst); err == nil && existing.Mode().IsRegular() &&
+		existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime()) {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	// chunks of zeros are skipped rather than written, so that sparse files,
+	// such as the images of image volumes, stay sparse
+	buf := make([]byte, copyChunkSize)
+	var written int64
+	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		var n int
+		n, err = io.ReadFull(in, buf)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		eof := err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			break
+		}
+		if isZero(buf[:n]) {
+			_, err = out.Seek(int64(n), io.SeekCurrent)
+		} else {
+			_, err = out.Write(buf[:n])
+		}
+		written += int64(n)
+		if err != nil || eof {
+			break
+		}
+	}
+	if err == nil {
+		// skipped zeros at the end still count towards the size
+		err = out.Truncate(written)
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// the modification time marks the copy as complete for a resumed Copy
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *osDirTree) Remove(ctx context.Context, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if info.IsDir() {
+		dir, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		names, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := t.Remove(ctx, filepath.Join(path, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (*osDirTree) Usage(ctx context.Context, path string) (int64, int64, error) {
+	var bytes, inodes int64
+	seen := map[uint64]bool{}
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			bytes += info.Size()
+			inodes++
+			return nil
+		}
+		if stat.Nlink > 1 {
+			if seen[uint64(stat.Ino)] {
+				return nil
+			}
+			seen[uint64(stat.Ino)] = true
+		}
+		// st_blocks counts 512-byte units whatever the filesystem's block size,
+		// so sparse image files count only what they have written
+		bytes += int64(stat.Blocks) * 512
+		inodes++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return bytes, inodes, nil
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"os"
+	"syscall"
+)
+
+// opaqueXattr marks an overlayfs upper directory that hides the directory of
+// the same name in the layers beneath it.
+const opaqueXattr = "trusted.overlay.opaque"
+
+// isWhiteout reports whether info describes an overlayfs whiteout, the 0/0
+// character device that hides a file of the layers beneath an upper directory.
+func isWhiteout(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && info.Mode()&os.ModeCharDevice != 0 && stat.Rdev == 0
+}
+
+func copyWhiteout(target string) error {
+	if existing, err := os.Lstat(target); err == nil {
+		if isWhiteout(existing) {
+			return nil
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	return syscall.Mknod(target, syscall.S_IFCHR, 0)
+}
+
+// copyOpaque marks dst opaque when src is.
+func copyOpaque(src, dst string) error {
+	value := make([]byte, 16)
+	n, err := syscall.Getxattr(src, opaqueXattr, value)
+	if err == syscall.ENODATA || err == syscall.ENOTSUP {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "getxattr", Path: src, Err: err}
+	}
+	if err := syscall.Setxattr(dst, opaqueXattr, value[:n], 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: dst, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package controller_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Copying an overlay upper directory", func() {
+	var (
+		dir string
+		src string
+		dst string
+	)
+
+	BeforeEach(func() {
+		if os.Getuid() != 0 {
+			Skip("whiteouts and trusted xattrs need root")
+		}
+
+		var err error
+		dir, err = ioutil.TempDir("", "upper-copy")
+		Expect(err).NotTo(HaveOccurred())
+		src = filepath.Join(dir, "src")
+		dst = filepath.Join(dir, "dst")
+		Expect(os.MkdirAll(filepath.Join(src, "opaque"), 0755)).To(Succeed())
+		Expect(syscall.Mknod(filepath.Join(src, "deleted"), syscall.S_IFCHR, 0)).To(Succeed())
+		if err := syscall.Setxattr(filepath.Join(src, "opaque"), "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+			Skip("cannot set trusted xattrs: " + err.Error())
+		}
+	})
+
+	AfterEach(func() {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	})
+
+	It("keeps whiteouts and opaque directories, also when resumed", func() {
+		for i := 0; i < 2; i++ {
+			Expect(controller.NewDirTree().Copy(context.Background(), src, dst)).To(Succeed())
+		}
+
+		info, err := os.Lstat(filepath.Join(dst, "deleted"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode() & os.ModeCharDevice).NotTo(BeZero())
+		Expect(info.Sys().(*syscall.Stat_t).Rdev).To(BeZero())
+
+		value := make([]byte, 16)
+		n, err := syscall.Getxattr(filepath.Join(dst, "opaque"), "trusted.overlay.opaque", value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(value[:n])).To(Equal("y"))
+	})
+})
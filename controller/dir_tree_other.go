@@ -0,0 +1,10 @@
+//go:build !linux
+
+package controller
+
+import "os"
+
+// overlayfs only exists on linux, so no other tree holds its markers.
+func isWhiteout(info os.FileInfo) bool { return false }
+func copyWhiteout(target string) error { return nil }
+func copyOpaque(src, dst string) error { return nil }
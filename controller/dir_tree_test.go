@@ -0,0 +1,158 @@
+package controller_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("DirTree", func() {
+	var (
+		dir     string
+		src     string
+		dst     string
+		tree    controller.DirTree
+		modTime time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "dir-tree")
+		Expect(err).NotTo(HaveOccurred())
+		src = filepath.Join(dir, "src")
+		dst = filepath.Join(dir, "dst")
+		tree = controller.NewDirTree()
+		modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		Expect(os.MkdirAll(filepath.Join(src, "nested"), 0750)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(src, "top"), []byte("top data"), 0640)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(src, "nested", "file"), []byte("nested data"), 0600)).To(Succeed())
+		Expect(os.Chtimes(filepath.Join(src, "top"), modTime, modTime)).To(Succeed())
+		Expect(os.Symlink("nested/file", filepath.Join(src, "link"))).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Copy", func() {
+		It("copies files, directories and symlinks with their permissions", func() {
+			Expect(tree.Copy(context.Background(), src, dst)).To(Succeed())
+
+			Expect(ioutil.ReadFile(filepath.Join(dst, "top"))).To(Equal([]byte("top data")))
+			Expect(ioutil.ReadFile(filepath.Join(dst, "nested", "file"))).To(Equal([]byte("nested data")))
+			Expect(os.Readlink(filepath.Join(dst, "link"))).To(Equal("nested/file"))
+
+			info, err := os.Stat(filepath.Join(dst, "nested"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0750)))
+
+			info, err = os.Stat(filepath.Join(dst, "top"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+			Expect(info.ModTime().Equal(modTime)).To(BeTrue())
+		})
+
+		It("skips files a previous copy completed and redoes partial ones", func() {
+			Expect(os.MkdirAll(dst, 0700)).To(Succeed())
+			// same size and time as the source, so taken as already copied
+			Expect(ioutil.WriteFile(filepath.Join(dst, "top"), []byte("TOP DATA"), 0640)).To(Succeed())
+			Expect(os.Chtimes(filepath.Join(dst, "top"), modTime, modTime)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(dst, "nested"), 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dst, "nested", "file"), []byte("nest"), 0600)).To(Succeed())
+
+			Expect(tree.Copy(context.Background(), src, dst)).To(Succeed())
+
+			Expect(ioutil.ReadFile(filepath.Join(dst, "top"))).To(Equal([]byte("TOP DATA")))
+			Expect(ioutil.ReadFile(filepath.Join(dst, "nested", "file"))).To(Equal([]byte("nested data")))
+		})
+
+		It("keeps sparse files sparse", func() {
+			f, err := os.Create(filepath.Join(src, "image"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Truncate(100 << 20)).To(Succeed())
+			_, err = f.WriteAt([]byte("data"), 50<<20)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+
+			Expect(tree.Copy(context.Background(), src, dst)).To(Succeed())
+
+			copied, err := ioutil.ReadFile(filepath.Join(dst, "image"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(copied).To(HaveLen(100 << 20))
+			Expect(copied[50<<20 : 50<<20+4]).To(Equal([]byte("data")))
+			bytes, _, err := tree.Usage(context.Background(), filepath.Join(dst, "image"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bytes).To(BeNumerically("<", 2<<20))
+		})
+
+		It("stops with the context's error once it is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(tree.Copy(ctx, src, dst)).To(MatchError(context.Canceled))
+			_, err := os.Stat(dst)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Remove", func() {
+		It("removes the whole tree", func() {
+			Expect(tree.Remove(context.Background(), src)).To(Succeed())
+			_, err := os.Lstat(src)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("succeeds for a missing path", func() {
+			Expect(tree.Remove(context.Background(), filepath.Join(dir, "missing"))).To(Succeed())
+		})
+
+		It("leaves the tree in place once the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(tree.Remove(ctx, src)).To(MatchError(context.Canceled))
+			Expect(filepath.Join(src, "top")).To(BeAnExistingFile())
+		})
+	})
+
+	Describe("Usage", func() {
+		It("counts every inode under the path, and hard-linked files once", func() {
+			_, inodes, err := tree.Usage(context.Background(), src)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inodes).To(Equal(int64(5)))
+
+			Expect(os.Link(filepath.Join(src, "top"), filepath.Join(src, "hardlink"))).To(Succeed())
+			_, inodes, err = tree.Usage(context.Background(), src)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inodes).To(Equal(int64(5)))
+		})
+
+		It("counts the blocks allocated to a sparse image file, not its size", func() {
+			image := filepath.Join(dir, "image")
+			f, err := os.Create(image)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Truncate(100 << 20)).To(Succeed())
+			_, err = f.Write([]byte("data"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+
+			bytes, inodes, err := tree.Usage(context.Background(), image)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bytes).To(BeNumerically(">", 0))
+			Expect(bytes).To(BeNumerically("<", 1<<20))
+			Expect(inodes).To(Equal(int64(1)))
+		})
+
+		It("fails for a missing path", func() {
+			_, _, err := tree.Usage(context.Background(), filepath.Join(dir, "missing"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,24 @@
+package controller
+
+import "syscall"
+
+//go:generate counterfeiter -o controllerfakes/fake_disk_stats.go . DiskStats
+
+// DiskStats reports on the filesystem holding a path.
+type DiskStats interface {
+	FreeBytes(path string) (uint64, error)
+}
+
+type statfsDiskStats struct{}
+
+func NewDiskStats() DiskStats {
+	return &statfsDiskStats{}
+}
+
+func (*statfsDiskStats) FreeBytes(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
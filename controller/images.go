@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// BackendImage is the Pool.Backend that gives each volume an image file of
+// its capacity, formatted with a filesystem of its own, for a node to
+// loop-mount. The image lives in the volume's directory.
+const BackendImage = "image"
+
+// ImageFileName names the image inside an image volume's directory.
+const ImageFileName = "disk.img"
+
+// DefaultImageBytes is the size of an image volume created without a capacity.
+const DefaultImageBytes = 1 << 30
+
+// DefaultFsType formats image volumes when neither the request nor the pool
+// names a filesystem type.
+const DefaultFsType = "ext4"
+
+// The publish context entries that tell a node to loop-mount an image volume.
+const (
+	ImagePathKey = "image.local.cloudfoundry.org/path"
+	FsTypeKey    = "image.local.cloudfoundry.org/fs-type"
+)
+
+// mkfsArgs holds, for each filesystem type images can be formatted with, the
+// mkfs arguments that run quietly and overwrite the image without asking.
+var mkfsArgs = map[string][]string{
+	"ext4": {"-q", "-F"},
+	"xfs":  {"-q", "-f"},
+}
+
+// ImageFsTypes returns the filesystem types image volumes can be formatted
+// with, sorted.
+func ImageFsTypes() []string {
+	fsTypes := []string{}
+	for fsType := range mkfsArgs {
+		fsTypes = append(fsTypes, fsType)
+	}
+	sort.Strings(fsTypes)
+	return fsTypes
+}
+
+//go:generate counterfeiter -o controllerfakes/fake_images.go . Images
+
+// Images creates and grows the image files of image volumes.
+type Images interface {
+	// Create makes a sparse file of size bytes at path and formats it with
+	// fsType, one of ImageFsTypes.
+	Create(ctx context.Context, path string, size int64, fsType string) error
+	// Grow extends the image at path to size bytes, leaving its filesystem
+	// for the node that mounts it to resize. It never shrinks an image.
+	Grow(ctx context.Context, path string, size int64) error
+}
+
+type mkfsImages struct{}
+
+// NewImages returns Images that format with mkfs.<fs type>, which must be on
+// the PATH.
+func NewImages() Images {
+	return &mkfsImages{}
+}
+
+func (*mkfsImages) Create(ctx context.Context, path string, size int64, fsType string) error {
+	args, ok := mkfsArgs[fsType]
+	if !ok {
+		return fmt.Errorf("unsupported fs type %q", fsType)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.CommandContext(ctx, "mkfs."+fsType, append(args, path)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mkfs.%s: %s: %s", fsType, err.Error(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (*mkfsImages) Grow(ctx context.Context, path string, size int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() >= size {
+		return nil
+	}
+	return os.Truncate(path, size)
+}
+
+// requestedFsType returns the filesystem type the capabilities ask for, or ""
+// when they name none.
+func requestedFsType(capabilities []*VolumeCapability) (string, error) {
+	fsType := ""
+	for _, vc := range capabilities {
+		t := vc.GetMount().GetFsType()
+		if t == "" {
+			continue
+		}
+		if fsType != "" && t != fsType {
+			return "", fmt.Errorf("volume capabilities ask for both %q and %q", fsType, t)
+		}
+		fsType = t
+	}
+	if _, ok := mkfsArgs[fsType]; fsType != "" && !ok {
+		return "", fmt.Errorf("FsType %q is not supported; image volumes can be %s", fsType, strings.Join(ImageFsTypes(), " or "))
+	}
+	return fsType, nil
+}
+
+// defaultFsType is the filesystem type of the pool's image volumes when the
+// request names none.
+func (p *Pool) defaultFsType() string {
+	if p.FsType != "" {
+		return p.FsType
+	}
+	return DefaultFsType
+}
+
+func (cs *Controller) imagePath(pool *Pool, volumeName string) string {
+	return filepath.Join(cs.volumePath(pool, volumeName), ImageFileName)
+}
+
+// publishContext must be called with cs.lock held. Only image and overlay
+// volumes need one.
+func (cs *Controller) publishContext(localVol *LocalVolume) map[string]string {
+	if len(localVol.Layers) > 0 {
+		return map[string]string{OverlayOptionsKey: cs.overlayOptions(localVol)}
+	}
+	pool := cs.pools[localVol.Pool]
+	if pool.Backend != BackendImage {
+		return map[string]string{}
+	}
+	return map[string]string{
+		ImagePathKey: cs.imagePath(pool, localVol.Name),
+		FsTypeKey:    localVol.FsType,
+	}
+}
+
+// formatVolume creates the image of an empty image volume reserved as
+// formatting, whose operation is already begun, without cs.lock. When mkfs
+// fails the volume is forgotten, as if it had never been created; when the
+// request ends first it is left for a retry to resume.
+func (cs *Controller) formatVolume(ctx context.Context, logger lager.Logger, localVol *LocalVolume, dir string) (*Volume, error) {
+	image := filepath.Join(dir, ImageFileName)
+	err := traced(ctx, "create-image", func() error {
+		return cs.images.Create(ctx, image, localVol.CapacityBytes, localVol.FsType)
+	}, attribute.String("path", image), attribute.String("fs_type", localVol.FsType))
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	defer cs.endOperation(volumeOperation(localVol.VolumeId))
+
+	if err != nil && ctx.Err() != nil {
+		return nil, operationError(ctx, logger, "format volume", err)
+	}
+	if err != nil {
+		logger.Error("create-image-failed", err)
+		cs.removeTree(ctx, dir)
+		delete(cs.volumes, localVol.VolumeId)
+		if saveErr := cs.saveState(ctx, logger); saveErr != nil {
+			return nil, saveErr
+		}
+		return nil, grpc.Errorf(codes.Internal, "Failed to create volume image: %s", err.Error())
+	}
+
+	localVol.Incomplete, localVol.Formatting = false, false
+	if err := cs.saveState(ctx, logger); err != nil {
+		localVol.Incomplete, localVol.Formatting = true, true
+		return nil, err
+	}
+	return cs.csiVolume(localVol), nil
+}
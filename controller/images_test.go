@@ -0,0 +1,338 @@
+package controller_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/controller/controllerfakes"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Image volumes", func() {
+	var (
+		root   string
+		images *controllerfakes.FakeImages
+		cs     *controller.Controller
+		ctx    context.Context
+	)
+
+	capability := func(fsType string, mode VolumeCapability_AccessMode_Mode) *VolumeCapability {
+		return &VolumeCapability{
+			AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{FsType: fsType}},
+			AccessMode: &VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+	writer := func(fsType string) []*VolumeCapability {
+		return []*VolumeCapability{capability(fsType, VolumeCapability_AccessMode_SINGLE_NODE_WRITER)}
+	}
+	create := func(name, fsType string, capacity int64) (*CreateVolumeResponse, error) {
+		return cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:               name,
+			VolumeCapabilities: writer(fsType),
+			CapacityRange:      &CapacityRange{RequiredBytes: capacity},
+			Parameters:         map[string]string{controller.PoolParameter: "images"},
+		})
+	}
+	imagePath := func(name string) string {
+		return filepath.Join(root, "images", controller.VolumesRootDir, name, controller.ImageFileName)
+	}
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "images")
+		Expect(err).NotTo(HaveOccurred())
+		ctx = context.Background()
+
+		images = &controllerfakes.FakeImages{}
+		images.CreateStub = func(ctx context.Context, path string, size int64, fsType string) error {
+			return ioutil.WriteFile(path, nil, 0600)
+		}
+
+		config := controller.Config{
+			Pools: []controller.PoolConfig{
+				{Name: "default", Root: filepath.Join(root, "default")},
+				{Name: "images", Root: filepath.Join(root, "images"), Backend: controller.BackendImage},
+			},
+			DefaultPool: "default",
+		}
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), images, controller.NewSubvolumes(), controller.NewMemoryRegistry(), config)
+		cs.SetLogger(lagertest.NewTestLogger("images"))
+		Expect(cs.Recover()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	Describe("CreateVolume", func() {
+		It("formats an image of the requested size and fs type in the volume's directory", func() {
+			resp, err := create("vol", "xfs", 300<<20)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetVolume().GetCapacityBytes()).To(Equal(int64(300 << 20)))
+
+			Expect(images.CreateCallCount()).To(Equal(1))
+			_, path, size, fsType := images.CreateArgsForCall(0)
+			Expect(path).To(Equal(imagePath("vol")))
+			Expect(size).To(Equal(int64(300 << 20)))
+			Expect(fsType).To(Equal("xfs"))
+
+			volume, err := cs.Volume(ctx, "images:vol")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.FsType).To(Equal("xfs"))
+		})
+
+		It("defaults to an ext4 image of DefaultImageBytes", func() {
+			_, err := create("vol", "", 0)
+			Expect(err).NotTo(HaveOccurred())
+			_, _, size, fsType := images.CreateArgsForCall(0)
+			Expect(size).To(Equal(int64(controller.DefaultImageBytes)))
+			Expect(fsType).To(Equal(controller.DefaultFsType))
+		})
+
+		It("leaves plain directory pools alone", func() {
+			_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol", VolumeCapabilities: writer("")})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(images.CreateCallCount()).To(Equal(0))
+		})
+
+		It("is idempotent, unless the fs type differs", func() {
+			_, err := create("vol", "ext4", 4096)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = create("vol", "", 4096)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(images.CreateCallCount()).To(Equal(1))
+
+			_, err = create("vol", "xfs", 4096)
+			Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+		})
+
+		It("rejects unsupported and conflicting fs types", func() {
+			_, err := create("vol", "btrfs", 4096)
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+			_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{
+				Name:               "vol",
+				VolumeCapabilities: append(writer("ext4"), writer("xfs")...),
+				Parameters:         map[string]string{controller.PoolParameter: "images"},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(images.CreateCallCount()).To(Equal(0))
+		})
+
+		It("rejects access modes for several nodes", func() {
+			_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{
+				Name:               "vol",
+				VolumeCapabilities: []*VolumeCapability{capability("", VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+				Parameters:         map[string]string{controller.PoolParameter: "images"},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+
+		It("removes the directory and records nothing when formatting fails", func() {
+			images.CreateStub = nil
+			images.CreateReturns(errors.New("mkfs.ext4: exit status 1"))
+			_, err := create("vol", "", 4096)
+			Expect(status.Code(err)).To(Equal(codes.Internal))
+			Expect(filepath.Dir(imagePath("vol"))).NotTo(BeADirectory())
+			Expect(cs.Volumes(ctx)).To(BeEmpty())
+		})
+
+		It("formats without holding up other requests, and resumes when cancelled", func() {
+			formatting := make(chan struct{})
+			images.CreateStub = func(ctx context.Context, path string, size int64, fsType string) error {
+				close(formatting)
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			cancelCtx, cancel := context.WithCancel(ctx)
+			errs := make(chan error, 1)
+			go func() {
+				_, err := cs.CreateVolume(cancelCtx, &CreateVolumeRequest{
+					Name:               "vol",
+					VolumeCapabilities: writer(""),
+					Parameters:         map[string]string{controller.PoolParameter: "images"},
+				})
+				errs <- err
+			}()
+
+			Eventually(formatting).Should(BeClosed())
+			volume, err := cs.Volume(ctx, "images:vol")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.Incomplete).To(BeTrue())
+			Expect(volume.Formatting).To(BeTrue())
+			_, err = create("vol", "", 0)
+			Expect(status.Code(err)).To(Equal(codes.Aborted))
+
+			cancel()
+			Eventually(errs).Should(Receive(WithTransform(status.Code, Equal(codes.Canceled))))
+
+			images.CreateStub = func(ctx context.Context, path string, size int64, fsType string) error {
+				return ioutil.WriteFile(path, nil, 0600)
+			}
+			_, err = create("vol", "", 0)
+			Expect(err).NotTo(HaveOccurred())
+			volume, err = cs.Volume(ctx, "images:vol")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.Incomplete).To(BeFalse())
+			Expect(imagePath("vol")).To(BeARegularFile())
+		})
+
+		Context("from a snapshot", func() {
+			var snapId string
+
+			BeforeEach(func() {
+				_, err := create("src", "xfs", 4096)
+				Expect(err).NotTo(HaveOccurred())
+				snap, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "images:src"})
+				Expect(err).NotTo(HaveOccurred())
+				snapId = snap.GetSnapshot().GetSnapshotId()
+			})
+
+			restore := func(pool, fsType string, capacity int64) error {
+				_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{
+					Name:                "copy",
+					VolumeCapabilities:  writer(fsType),
+					CapacityRange:       &CapacityRange{RequiredBytes: capacity},
+					Parameters:          map[string]string{controller.PoolParameter: pool},
+					VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: snapId}}},
+				})
+				return err
+			}
+
+			It("copies the image, keeping its fs type", func() {
+				Expect(restore("images", "", 0)).To(Succeed())
+				Expect(imagePath("copy")).To(BeARegularFile())
+				Expect(images.CreateCallCount()).To(Equal(1))
+
+				volume, err := cs.Volume(ctx, "images:copy")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(volume.FsType).To(Equal("xfs"))
+				Expect(volume.CapacityBytes).To(Equal(int64(4096)))
+			})
+
+			It("rejects another size, another fs type and a directory pool", func() {
+				Expect(status.Code(restore("images", "", 8192))).To(Equal(codes.OutOfRange))
+				Expect(status.Code(restore("images", "ext4", 0))).To(Equal(codes.InvalidArgument))
+				Expect(status.Code(restore("default", "", 0))).To(Equal(codes.InvalidArgument))
+			})
+		})
+	})
+
+	Describe("ControllerPublishVolume", func() {
+		BeforeEach(func() {
+			_, err := create("vol", "xfs", 4096)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("tells the node where the image is and how to mount it", func() {
+			resp, err := cs.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: "images:vol", NodeId: "node-1", VolumeCapability: writer("")[0]})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetPublishContext()).To(Equal(map[string]string{
+				controller.ImagePathKey: imagePath("vol"),
+				controller.FsTypeKey:    "xfs",
+			}))
+		})
+
+		It("refuses a second node until the first is unpublished", func() {
+			_, err := cs.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: "images:vol", NodeId: "node-1", VolumeCapability: writer("")[0]})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = cs.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: "images:vol", NodeId: "node-1", VolumeCapability: writer("")[0]})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cs.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: "images:vol", NodeId: "node-2", VolumeCapability: writer("")[0]})
+			Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+
+			_, err = cs.ControllerUnpublishVolume(ctx, &ControllerUnpublishVolumeRequest{VolumeId: "images:vol", NodeId: "node-1"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = cs.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: "images:vol", NodeId: "node-2", VolumeCapability: writer("")[0]})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("ValidateVolumeCapabilities", func() {
+		BeforeEach(func() {
+			_, err := create("vol", "xfs", 4096)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("confirms the volume's own fs type only", func() {
+			resp, err := cs.ValidateVolumeCapabilities(ctx, &ValidateVolumeCapabilitiesRequest{VolumeId: "images:vol", VolumeCapabilities: writer("xfs")})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetConfirmed()).NotTo(BeNil())
+
+			resp, err = cs.ValidateVolumeCapabilities(ctx, &ValidateVolumeCapabilitiesRequest{VolumeId: "images:vol", VolumeCapabilities: writer("ext4")})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetConfirmed()).To(BeNil())
+			Expect(resp.GetMessage()).To(ContainSubstring("xfs"))
+		})
+	})
+
+	It("grows the image and leaves its filesystem to the node", func() {
+		_, err := create("vol", "", 4096)
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := cs.ControllerExpandVolume(ctx, &ControllerExpandVolumeRequest{VolumeId: "images:vol", CapacityRange: &CapacityRange{RequiredBytes: 8192}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetCapacityBytes()).To(Equal(int64(8192)))
+		Expect(resp.GetNodeExpansionRequired()).To(BeTrue())
+
+		Expect(images.GrowCallCount()).To(Equal(1))
+		_, path, size := images.GrowArgsForCall(0)
+		Expect(path).To(Equal(imagePath("vol")))
+		Expect(size).To(Equal(int64(8192)))
+		volume, err := cs.Volume(ctx, "images:vol")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volume.CapacityBytes).To(Equal(int64(8192)))
+		Expect(cs.ImagePath(ctx, "images:vol")).To(Equal(imagePath("vol")))
+		_, err = cs.ImagePath(ctx, "images:nope")
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+	})
+
+	Describe("NewImages", func() {
+		It("formats a sparse image with mkfs", func() {
+			if _, err := exec.LookPath("mkfs.ext4"); err != nil {
+				Skip("mkfs.ext4 is not installed")
+			}
+			path := filepath.Join(root, controller.ImageFileName)
+			Expect(controller.NewImages().Create(ctx, path, 16<<20, "ext4")).To(Succeed())
+
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(Equal(int64(16 << 20)))
+
+			// the ext4 superblock starts 1024 bytes in, with its magic 56 bytes into it
+			data, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binary.LittleEndian.Uint16(data[1024+56:])).To(Equal(uint16(0xEF53)))
+		})
+
+		It("grows an image without ever shrinking it", func() {
+			path := filepath.Join(root, controller.ImageFileName)
+			Expect(ioutil.WriteFile(path, []byte("superblock"), 0600)).To(Succeed())
+			Expect(controller.NewImages().Grow(ctx, path, 8192)).To(Succeed())
+			Expect(controller.NewImages().Grow(ctx, path, 4096)).To(Succeed())
+
+			data, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(HaveLen(8192))
+			Expect(string(data[:10])).To(Equal("superblock"))
+		})
+
+		It("rejects a filesystem type it cannot format", func() {
+			err := controller.NewImages().Create(ctx, filepath.Join(root, controller.ImageFileName), 16<<20, "ntfs")
+			Expect(err).To(MatchError(ContainSubstring("unsupported fs type")))
+		})
+	})
+})
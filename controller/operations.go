@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Copies and removals run without cs.lock, so that one slow request does not
+// hold up the others. cs.operations records the volumes and snapshots they
+// are working on; a second request for the same one is refused with Aborted,
+// as the CSI spec asks for operations already in progress.
+
+func volumeOperation(volId string) string {
+	return "volume/" + volId
+}
+
+func snapshotOperation(snapId string) string {
+	return "snapshot/" + snapId
+}
+
+// beginOperation must be called with cs.lock held.
+func (cs *Controller) beginOperation(key string) error {
+	if cs.operations[key] {
+		return grpc.Errorf(codes.Aborted, "An operation on %s is already in progress", key)
+	}
+	cs.operations[key] = true
+	return nil
+}
+
+// endOperation must be called with cs.lock held.
+func (cs *Controller) endOperation(key string) {
+	delete(cs.operations, key)
+}
+
+// operationError reports a copy or removal that failed or was cut short by
+// ctx. An interrupted operation leaves its volume or snapshot incomplete in
+// the registry, so retrying the request resumes it.
+func operationError(ctx context.Context, logger lager.Logger, action string, err error) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		logger.Info("operation-cancelled", lager.Data{"operation": action})
+		return grpc.Errorf(codes.Canceled, "Request cancelled while trying to %s; retry to resume", action)
+	case context.DeadlineExceeded:
+		logger.Info("operation-timed-out", lager.Data{"operation": action})
+		return grpc.Errorf(codes.DeadlineExceeded, "Deadline exceeded while trying to %s; retry to resume", action)
+	}
+
+	logger.Error("operation-failed", err, lager.Data{"operation": action})
+	return grpc.Errorf(codes.Internal, "Failed to %s: %s", action, err.Error())
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CloneParameter is the CreateVolume parameter that chooses how a volume is
+// created from a snapshot: CloneCopy, the default, copies the snapshot into
+// the volume, while CloneOverlay mounts the snapshot read-only beneath an
+// upper directory that holds the volume's own changes.
+const (
+	CloneParameter = "clone"
+	CloneCopy      = "copy"
+	CloneOverlay   = "overlay"
+)
+
+// The directories of an overlay volume: upper holds the files written to the
+// volume and work is overlayfs's scratch space.
+const (
+	OverlayUpperDir = "upper"
+	OverlayWorkDir  = "work"
+)
+
+// OverlayOptionsKey is the publish context entry that tells a node to mount
+// an overlay volume, with the overlayfs mount options to use.
+const OverlayOptionsKey = "overlay.local.cloudfoundry.org/options"
+
+// requestedClone returns whether the parameters ask for an overlay clone.
+func requestedClone(parameters map[string]string) (bool, error) {
+	switch parameters[CloneParameter] {
+	case "", CloneCopy:
+		return false, nil
+	case CloneOverlay:
+		return true, nil
+	default:
+		return false, fmt.Errorf("Clone mode %q is not supported; volumes can be cloned by %s or %s", parameters[CloneParameter], CloneCopy, CloneOverlay)
+	}
+}
+
+// createOverlayDirs makes the upper and work directories of an overlay
+// volume whose directory exists.
+func (cs *Controller) createOverlayDirs(path string) error {
+	for _, dir := range []string{OverlayUpperDir, OverlayWorkDir} {
+		if err := cs.os.MkdirAll(filepath.Join(path, dir), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// layerDependent must be called with cs.lock held. It returns the id of a
+// volume or snapshot that has the snapshot as one of its layers.
+func (cs *Controller) layerDependent(snapId string) (string, bool) {
+	for _, v := range cs.volumes {
+		if hasLayer(v.Layers, snapId) {
+			return v.VolumeId, true
+		}
+	}
+	for _, s := range cs.snapshots {
+		if hasLayer(s.Layers, snapId) {
+			return s.SnapshotId, true
+		}
+	}
+	return "", false
+}
+
+func hasLayer(layers []string, snapId string) bool {
+	for _, l := range layers {
+		if l == snapId {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayOptions must be called with cs.lock held. It returns the overlayfs
+// mount options of an overlay volume, its layers' directories topmost first.
+func (cs *Controller) overlayOptions(localVol *LocalVolume) string {
+	lower := []string{}
+	for _, snapId := range localVol.Layers {
+		snapshot := cs.snapshots[snapId]
+		lower = append(lower, escapeOverlayPath(cs.snapshotPath(cs.pools[snapshot.Pool], snapshot.Name)))
+	}
+	path := cs.volumePath(cs.pools[localVol.Pool], localVol.Name)
+	return fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lower, ":"),
+		escapeOverlayPath(filepath.Join(path, OverlayUpperDir)),
+		escapeOverlayPath(filepath.Join(path, OverlayWorkDir)))
+}
+
+// escapeOverlayPath escapes the characters that separate overlayfs options
+// and lower directories, which volume and snapshot names may contain.
+var escapeOverlayPath = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `:`, `\:`).Replace
@@ -0,0 +1,199 @@
+package controller_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Overlay clones", func() {
+	var (
+		root string
+		cs   *controller.Controller
+		ctx  context.Context
+		vc   []*VolumeCapability
+	)
+
+	volumePath := func(name string) string {
+		return filepath.Join(root, "default", controller.VolumesRootDir, name)
+	}
+	snapshotPath := func(name string) string {
+		return filepath.Join(root, "default", controller.SnapshotsRootDir, name)
+	}
+	clone := func(name, snapId, mode string) (*CreateVolumeResponse, error) {
+		return cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:                name,
+			VolumeCapabilities:  vc,
+			Parameters:          map[string]string{controller.CloneParameter: mode},
+			VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: snapId}}},
+		})
+	}
+	snapshot := func(name, volId string) {
+		_, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: name, SourceVolumeId: volId})
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "overlay")
+		Expect(err).NotTo(HaveOccurred())
+		ctx = context.Background()
+		vc = []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}}}
+
+		config := controller.Config{
+			Pools: []controller.PoolConfig{
+				{Name: "default", Root: filepath.Join(root, "default")},
+				{Name: "images", Root: filepath.Join(root, "images"), Backend: controller.BackendImage},
+			},
+			DefaultPool: "default",
+		}
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), controller.NewSubvolumes(), controller.NewMemoryRegistry(), config)
+		cs.SetLogger(lagertest.NewTestLogger("overlay"))
+		Expect(cs.Recover()).To(Succeed())
+
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "seed", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 4096}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(volumePath("seed"), "data"), []byte("hello"), 0644)).To(Succeed())
+		snapshot("base", "default:seed")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	It("creates the upper and work directories only, and records the layer", func() {
+		resp, err := clone("clone", "default:base", controller.CloneOverlay)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetVolume().GetCapacityBytes()).To(Equal(int64(4096)))
+
+		Expect(filepath.Join(volumePath("clone"), controller.OverlayUpperDir)).To(BeADirectory())
+		Expect(filepath.Join(volumePath("clone"), controller.OverlayWorkDir)).To(BeADirectory())
+		Expect(filepath.Join(volumePath("clone"), controller.OverlayUpperDir, "data")).NotTo(BeAnExistingFile())
+
+		volume, err := cs.Volume(ctx, "default:clone")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volume.Incomplete).To(BeFalse())
+		Expect(volume.Layers).To(Equal([]string{"default:base"}))
+	})
+
+	It("is idempotent, unless the clone mode differs", func() {
+		_, err := clone("clone", "default:base", controller.CloneOverlay)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clone("clone", "default:base", controller.CloneOverlay)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = clone("clone", "default:base", controller.CloneCopy)
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+	})
+
+	It("rejects unknown clone modes, overlays without a snapshot and image pools", func() {
+		_, err := clone("clone", "default:base", "hardlink")
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "empty", VolumeCapabilities: vc, Parameters: map[string]string{controller.CloneParameter: controller.CloneOverlay}})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		vc = []*VolumeCapability{{
+			AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}},
+			AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}}
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:                "image",
+			VolumeCapabilities:  vc,
+			Parameters:          map[string]string{controller.PoolParameter: "images", controller.CloneParameter: controller.CloneOverlay},
+			VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: "default:base"}}},
+		})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+
+	It("tells the node how to mount the overlay, escaping the separators in names", func() {
+		snapshot("base:v2", "default:seed")
+		_, err := clone("clone,1", "default:base:v2", controller.CloneOverlay)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := cs.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: "default:clone,1", NodeId: "node-1", VolumeCapability: vc[0]})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetPublishContext()).To(Equal(map[string]string{
+			controller.OverlayOptionsKey: "lowerdir=" + snapshotPath(`base\:v2`) +
+				",upperdir=" + volumePath(`clone\,1`) + "/upper" +
+				",workdir=" + volumePath(`clone\,1`) + "/work",
+		}))
+	})
+
+	Context("when an overlay volume is snapshotted", func() {
+		BeforeEach(func() {
+			_, err := clone("clone", "default:base", controller.CloneOverlay)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(volumePath("clone"), controller.OverlayUpperDir, "more"), []byte("hello"), 0644)).To(Succeed())
+			snapshot("changes", "default:clone")
+		})
+
+		It("keeps only the upper directory, layered on the volume's layers", func() {
+			Expect(filepath.Join(snapshotPath("changes"), "more")).To(BeARegularFile())
+			Expect(filepath.Join(snapshotPath("changes"), controller.OverlayUpperDir)).NotTo(BeADirectory())
+
+			snapshots, err := cs.Snapshots(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(snapshots).To(HaveLen(2))
+			Expect(snapshots[1].SnapshotId).To(Equal("default:changes"))
+			Expect(snapshots[1].Layers).To(Equal([]string{"default:base"}))
+			Expect(snapshots[0].Layers).To(BeEmpty())
+		})
+
+		It("stacks a clone of the snapshot on every layer, topmost first", func() {
+			_, err := clone("again", "default:changes", controller.CloneOverlay)
+			Expect(err).NotTo(HaveOccurred())
+			volume, err := cs.Volume(ctx, "default:again")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.Layers).To(Equal([]string{"default:changes", "default:base"}))
+
+			resp, err := cs.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{VolumeId: "default:again", NodeId: "node-1", VolumeCapability: vc[0]})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetPublishContext()[controller.OverlayOptionsKey]).To(HavePrefix("lowerdir=" + snapshotPath("changes") + ":" + snapshotPath("base") + ","))
+		})
+
+		It("refuses to copy the snapshot, which lacks its layers", func() {
+			_, err := clone("copy", "default:changes", controller.CloneCopy)
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(volumePath("copy")).NotTo(BeADirectory())
+		})
+
+		It("keeps the layers until nothing depends on them", func() {
+			_, err := cs.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: "default:base"})
+			Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+
+			_, err = cs.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: "default:clone"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = cs.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: "default:base"})
+			Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+			Expect(snapshotPath("base")).To(BeADirectory())
+
+			_, err = cs.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: "default:changes"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = cs.DeleteSnapshot(ctx, &DeleteSnapshotRequest{SnapshotId: "default:base"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(snapshotPath("base")).NotTo(BeADirectory())
+		})
+	})
+
+	It("reports a clone whose layer has gone as abnormal", func() {
+		_, err := clone("clone", "default:base", controller.CloneOverlay)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.RemoveAll(snapshotPath("base"))).To(Succeed())
+
+		resp, err := cs.ControllerGetVolume(ctx, &ControllerGetVolumeRequest{VolumeId: "default:clone"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetStatus().GetVolumeCondition().GetAbnormal()).To(BeTrue())
+		Expect(resp.GetStatus().GetVolumeCondition().GetMessage()).To(ContainSubstring(snapshotPath("base")))
+	})
+})
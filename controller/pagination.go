@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// page returns the bounds of the entries a List request asks for out of count
+// entries sorted by id, and the token for the next page, empty on the last.
+// Tokens are offsets into the sorted entries.
+func page(count int, maxEntries int32, startingToken string) (int, int, string, error) {
+	if maxEntries < 0 {
+		return 0, 0, "", grpc.Errorf(codes.InvalidArgument, "max_entries must not be negative")
+	}
+
+	start := 0
+	if startingToken != "" {
+		var err error
+		start, err = strconv.Atoi(startingToken)
+		if err != nil || start < 0 || start > count {
+			return 0, 0, "", grpc.Errorf(codes.Aborted, "Invalid starting token %q", startingToken)
+		}
+	}
+
+	end := count
+	if maxEntries > 0 && start+int(maxEntries) < count {
+		end = start + int(maxEntries)
+	}
+
+	next := ""
+	if end < count {
+		next = strconv.Itoa(end)
+	}
+	return start, end, next, nil
+}
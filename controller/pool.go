@@ -0,0 +1,269 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// PoolParameter is the CreateVolume/GetCapacity parameter that selects a storage pool.
+const PoolParameter = "pool"
+
+// DefaultPoolName names the single pool created by DefaultConfig.
+const DefaultPoolName = "default"
+
+// volumeIdSeparator joins a pool name and a volume name into a volume id.
+const volumeIdSeparator = ":"
+
+type PoolConfig struct {
+	Name string `json:"name"`
+	Root string `json:"root"`
+	// CapacityBytes limits the total recorded capacity of the pool's volumes; 0 means unlimited.
+	CapacityBytes int64 `json:"capacity_bytes"`
+	// AccessModes lists the CSI access mode names (e.g. "SINGLE_NODE_WRITER") the pool accepts; empty means all.
+	AccessModes []string `json:"access_modes"`
+	// MinFreeBytes is the free space below which Probe reports the plugin as unhealthy; 0 disables the check.
+	MinFreeBytes int64 `json:"min_free_bytes"`
+	// Topology holds the segments (e.g. hostname or zone) from which the pool's volumes are accessible.
+	Topology map[string]string `json:"topology"`
+	// SoftLimitPercent is how full a volume may get before a warning is logged; 0 means DefaultSoftLimitPercent.
+	SoftLimitPercent int `json:"soft_limit_percent"`
+	// Quota is QuotaProject to enforce volume capacities with project quotas; empty leaves them unenforced.
+	Quota string `json:"quota"`
+	// QuotaInodes limits the inodes of each volume when Quota is set; 0 means unlimited.
+	QuotaInodes int64 `json:"quota_inodes"`
+	// Backend is BackendImage to give each volume a formatted image file, or BackendDirectory for plain
+	// directories; empty makes volumes btrfs subvolumes when the root is on btrfs and directories otherwise.
+	Backend string `json:"backend"`
+	// FsType formats the pool's image volumes when CreateVolume names none; empty means DefaultFsType.
+	FsType string `json:"fs_type"`
+}
+
+type Config struct {
+	Pools       []PoolConfig `json:"pools"`
+	DefaultPool string       `json:"default_pool"`
+	// Nodes maps node ids to their topology segments for publish checks.
+	Nodes map[string]map[string]string `json:"nodes"`
+	// OrphanPolicy is what Reconcile does with volume directories missing
+	// from the registry: "report" (the default), "quarantine" or "adopt".
+	OrphanPolicy string `json:"orphan_policy"`
+	// Backups configures the object store snapshots are backed up to; nil
+	// disables backups.
+	Backups *BackupConfig `json:"backups"`
+}
+
+// DefaultConfig returns a configuration with a single unlimited pool rooted at root.
+func DefaultConfig(root string) Config {
+	return Config{
+		Pools:       []PoolConfig{{Name: DefaultPoolName, Root: root}},
+		DefaultPool: DefaultPoolName,
+	}
+}
+
+func ParseConfig(data []byte) (Config, error) {
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, err
+	}
+	return config, config.Validate()
+}
+
+func (c Config) Validate() error {
+	if len(c.Pools) == 0 {
+		return fmt.Errorf("no storage pools configured")
+	}
+
+	names := map[string]bool{}
+	roots := map[string]string{}
+	for _, p := range c.Pools {
+		if p.Name == "" || strings.Contains(p.Name, volumeIdSeparator) || strings.Contains(p.Name, "/") {
+			return fmt.Errorf("invalid pool name %q", p.Name)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate pool name %q", p.Name)
+		}
+		names[p.Name] = true
+
+		// pools sharing a root would share their volume directories
+		if p.Root == "" {
+			return fmt.Errorf("pool %q: root not configured", p.Name)
+		}
+		root, err := filepath.Abs(p.Root)
+		if err != nil {
+			return fmt.Errorf("pool %q: %s", p.Name, err.Error())
+		}
+		if other, ok := roots[root]; ok {
+			return fmt.Errorf("pools %q and %q have the same root %q", other, p.Name, root)
+		}
+		roots[root] = p.Name
+
+		if p.CapacityBytes < 0 || p.MinFreeBytes < 0 {
+			return fmt.Errorf("pool %q: capacity_bytes and min_free_bytes must not be negative", p.Name)
+		}
+		if p.Quota != "" && p.Quota != QuotaProject {
+			return fmt.Errorf("pool %q: unknown quota %q", p.Name, p.Quota)
+		}
+		if p.QuotaInodes < 0 {
+			return fmt.Errorf("pool %q: quota_inodes must not be negative", p.Name)
+		}
+		switch p.Backend {
+		case "", BackendDirectory, BackendImage:
+		default:
+			return fmt.Errorf("pool %q: unknown backend %q", p.Name, p.Backend)
+		}
+		if p.FsType != "" {
+			if p.Backend != BackendImage {
+				return fmt.Errorf("pool %q: fs_type needs the %q backend", p.Name, BackendImage)
+			}
+			if _, ok := mkfsArgs[p.FsType]; !ok {
+				return fmt.Errorf("pool %q: unsupported fs_type %q", p.Name, p.FsType)
+			}
+		}
+		if p.SoftLimitPercent < 0 || p.SoftLimitPercent > 100 {
+			return fmt.Errorf("pool %q: soft_limit_percent must be between 0 and 100", p.Name)
+		}
+		for _, mode := range p.AccessModes {
+			if _, ok := VolumeCapability_AccessMode_Mode_value[mode]; !ok {
+				return fmt.Errorf("pool %q: unknown access mode %q", p.Name, mode)
+			}
+		}
+		for key := range p.Topology {
+			if key == "" {
+				return fmt.Errorf("pool %q: topology keys must not be empty", p.Name)
+			}
+		}
+	}
+
+	if !names[c.DefaultPool] {
+		return fmt.Errorf("default pool %q is not configured", c.DefaultPool)
+	}
+
+	switch c.OrphanPolicy {
+	case "", OrphanPolicyReport, OrphanPolicyQuarantine, OrphanPolicyAdopt:
+	default:
+		return fmt.Errorf("unknown orphan policy %q", c.OrphanPolicy)
+	}
+	if c.Backups != nil {
+		return c.Backups.validate()
+	}
+	return nil
+}
+
+// Pool returns the pool named name, or the default pool when name is empty.
+func (c Config) Pool(name string) (*Pool, bool) {
+	if name == "" {
+		name = c.DefaultPool
+	}
+	for _, p := range c.Pools {
+		if p.Name == name {
+			return newPool(p), true
+		}
+	}
+	return nil, false
+}
+
+type Pool struct {
+	PoolConfig
+	accessModes map[VolumeCapability_AccessMode_Mode]bool
+	// root is Root made absolute by Recover
+	root string
+	// quotasEnabled is set by Recover once the pool's filesystem passed the quota check
+	quotasEnabled bool
+	// btrfs is set by Recover when the pool's volumes are to be subvolumes,
+	// and qgroupsEnabled when their capacities can be enforced with qgroups
+	btrfs          bool
+	qgroupsEnabled bool
+}
+
+func newPool(config PoolConfig) *Pool {
+	pool := &Pool{PoolConfig: config, root: config.Root}
+	if len(config.AccessModes) > 0 {
+		pool.accessModes = map[VolumeCapability_AccessMode_Mode]bool{}
+		for _, mode := range config.AccessModes {
+			pool.accessModes[VolumeCapability_AccessMode_Mode(VolumeCapability_AccessMode_Mode_value[mode])] = true
+		}
+	}
+	return pool
+}
+
+// Supports reports whether every capability uses an access mode the pool
+// allows. Image volumes can only be mounted on one node at a time.
+func (p *Pool) Supports(capabilities []*VolumeCapability) bool {
+	for _, vc := range capabilities {
+		if vc.GetAccessMode() == nil {
+			continue
+		}
+		mode := vc.GetAccessMode().GetMode()
+		if p.accessModes != nil && !p.accessModes[mode] {
+			return false
+		}
+		if p.Backend == BackendImage && !singleNode[mode] {
+			return false
+		}
+	}
+	return true
+}
+
+var singleNode = map[VolumeCapability_AccessMode_Mode]bool{
+	VolumeCapability_AccessMode_SINGLE_NODE_WRITER:        true,
+	VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:   true,
+	VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER: true,
+	VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:  true,
+}
+
+// VolumeID returns the id of the volume named name in pool.
+func VolumeID(pool, name string) string {
+	return volumeID(pool, name)
+}
+
+func volumeID(pool, name string) string {
+	return pool + volumeIdSeparator + name
+}
+
+func splitVolumeID(volId string) (pool string, name string, ok bool) {
+	parts := strings.SplitN(volId, volumeIdSeparator, 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// requestedCapacity returns the capacity to record for a volume, or an error if the range is contradictory.
+func requestedCapacity(capacityRange *CapacityRange) (int64, error) {
+	required := capacityRange.GetRequiredBytes()
+	limit := capacityRange.GetLimitBytes()
+	if required < 0 || limit < 0 {
+		return 0, fmt.Errorf("capacity range must not be negative")
+	}
+	if limit > 0 && required > limit {
+		return 0, fmt.Errorf("required bytes %d exceed limit bytes %d", required, limit)
+	}
+	if required == 0 {
+		return limit, nil
+	}
+	return required, nil
+}
+
+func capacityInRange(capacity int64, capacityRange *CapacityRange) bool {
+	if capacityRange.GetRequiredBytes() > 0 && capacity < capacityRange.GetRequiredBytes() {
+		return false
+	}
+	if capacityRange.GetLimitBytes() > 0 && capacity > capacityRange.GetLimitBytes() {
+		return false
+	}
+	return true
+}
+
+// usedCapacity must be called with cs.lock held.
+func (cs *Controller) usedCapacity(pool string) int64 {
+	var used int64
+	for _, v := range cs.volumes {
+		if v.Pool == pool {
+			used += v.CapacityBytes
+		}
+	}
+	return used
+}
@@ -0,0 +1,109 @@
+package controller_test
+
+import (
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	It("parses a pool configuration", func() {
+		config, err := controller.ParseConfig([]byte(`{
+			"pools": [
+				{"name": "fast", "root": "/var/vcap/data/fast", "capacity_bytes": 1024, "access_modes": ["SINGLE_NODE_WRITER"]},
+				{"name": "slow", "root": "/var/vcap/store/slow"}
+			],
+			"default_pool": "slow"
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.DefaultPool).To(Equal("slow"))
+		Expect(config.Pools).To(HaveLen(2))
+		Expect(config.Pools[0]).To(Equal(controller.PoolConfig{
+			Name:          "fast",
+			Root:          "/var/vcap/data/fast",
+			CapacityBytes: 1024,
+			AccessModes:   []string{"SINGLE_NODE_WRITER"},
+		}))
+	})
+
+	DescribeTable("rejects invalid configurations",
+		func(config controller.Config) {
+			Expect(config.Validate()).To(HaveOccurred())
+		},
+		Entry("no pools", controller.Config{}),
+		Entry("an unnamed pool", controller.Config{Pools: []controller.PoolConfig{{Root: "/a"}}}),
+		Entry("a pool name containing the id separator", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a:b", Root: "/a"}},
+			DefaultPool: "a:b",
+		}),
+		Entry("duplicate pool names", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a"}, {Name: "a", Root: "/b"}},
+			DefaultPool: "a",
+		}),
+		Entry("a pool without a root", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a"}},
+			DefaultPool: "a",
+		}),
+		Entry("pools sharing a root", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a"}, {Name: "b", Root: "/b/../a/"}},
+			DefaultPool: "a",
+		}),
+		Entry("an unknown access mode", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a", AccessModes: []string{"SOMETIMES"}}},
+			DefaultPool: "a",
+		}),
+		Entry("a soft limit over 100 percent", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a", SoftLimitPercent: 150}},
+			DefaultPool: "a",
+		}),
+		Entry("an unknown quota", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a", Quota: "directory"}},
+			DefaultPool: "a",
+		}),
+		Entry("a negative inode quota", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a", Quota: controller.QuotaProject, QuotaInodes: -1}},
+			DefaultPool: "a",
+		}),
+		Entry("an unknown backend", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a", Backend: "zfs"}},
+			DefaultPool: "a",
+		}),
+		Entry("an fs type for a directory pool", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a", FsType: "ext4"}},
+			DefaultPool: "a",
+		}),
+		Entry("an fs type images cannot be formatted with", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a", Backend: controller.BackendImage, FsType: "vfat"}},
+			DefaultPool: "a",
+		}),
+		Entry("a missing default pool", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a"}},
+			DefaultPool: "b",
+		}),
+		Entry("an unknown orphan policy", controller.Config{
+			Pools:        []controller.PoolConfig{{Name: "a", Root: "/a"}},
+			DefaultPool:  "a",
+			OrphanPolicy: "delete",
+		}),
+		Entry("a backup endpoint that is not an http URL", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a"}},
+			DefaultPool: "a",
+			Backups:     &controller.BackupConfig{Endpoint: "s3.amazonaws.com", Bucket: "b"},
+		}),
+		Entry("backups without a bucket", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a"}},
+			DefaultPool: "a",
+			Backups:     &controller.BackupConfig{Endpoint: "https://s3.amazonaws.com"},
+		}),
+		Entry("a negative backup retention", controller.Config{
+			Pools:       []controller.PoolConfig{{Name: "a", Root: "/a"}},
+			DefaultPool: "a",
+			Backups:     &controller.BackupConfig{Endpoint: "https://s3.amazonaws.com", Bucket: "b", Retention: controller.BackupRetention{KeepLast: -1}},
+		}),
+	)
+
+	It("accepts the default configuration", func() {
+		Expect(controller.DefaultConfig("/tmp").Validate()).To(Succeed())
+	})
+})
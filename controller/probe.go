@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// checkConsistency verifies that volumes and snapshots agree with their ids
+// and with the configured pools. It must be called with cs.lock held.
+func (cs *Controller) checkConsistency(volumes map[string]*LocalVolume, snapshots map[string]*LocalSnapshot) error {
+	used := map[string]int64{}
+	for id, v := range volumes {
+		if id != v.VolumeId || id != volumeID(v.Pool, v.Name) {
+			return fmt.Errorf("volume %q is recorded under id %q", v.VolumeId, id)
+		}
+		if _, ok := cs.pools[v.Pool]; !ok {
+			return fmt.Errorf("volume %q belongs to unknown pool %q", id, v.Pool)
+		}
+		used[v.Pool] += v.CapacityBytes
+		if err := checkLayers(id, v.Layers, snapshots); err != nil {
+			return err
+		}
+	}
+
+	for id, s := range snapshots {
+		if id != s.SnapshotId || id != volumeID(s.Pool, s.Name) {
+			return fmt.Errorf("snapshot %q is recorded under id %q", s.SnapshotId, id)
+		}
+		if _, ok := cs.pools[s.Pool]; !ok {
+			return fmt.Errorf("snapshot %q belongs to unknown pool %q", id, s.Pool)
+		}
+		if err := checkLayers(id, s.Layers, snapshots); err != nil {
+			return err
+		}
+	}
+
+	for name, total := range used {
+		if limit := cs.pools[name].CapacityBytes; limit > 0 && total > limit {
+			return fmt.Errorf("pool %q holds %d bytes of volumes but is limited to %d", name, total, limit)
+		}
+	}
+	return nil
+}
+
+// checkLayers verifies that the snapshots an overlay volume, or a snapshot of
+// one, is layered on are recorded.
+func checkLayers(id string, layers []string, snapshots map[string]*LocalSnapshot) error {
+	for _, snapId := range layers {
+		if _, ok := snapshots[snapId]; !ok {
+			return fmt.Errorf("%q is layered on unknown snapshot %q", id, snapId)
+		}
+	}
+	return nil
+}
+
+// checkPool verifies that the pool's root exists, that volumes can be
+// created in it and that it has at least MinFreeBytes available.
+func (cs *Controller) checkPool(logger lager.Logger, pool *Pool) error {
+	root, err := cs.filepath.Abs(pool.Root)
+	if err != nil {
+		return err
+	}
+
+	info, err := cs.os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("storage root %s is not accessible: %s", root, err.Error())
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("storage root %s is not a directory", root)
+	}
+
+	volumesRoot := filepath.Join(root, VolumesRootDir)
+	if err := cs.os.MkdirAll(volumesRoot, os.ModePerm); err != nil {
+		return fmt.Errorf("storage root %s is not writable: %s", root, err.Error())
+	}
+	probeDir := filepath.Join(volumesRoot, ".probe-"+strconv.Itoa(cs.os.Getpid()))
+	if err := cs.os.Mkdir(probeDir, 0700); err != nil && !cs.os.IsExist(err) {
+		return fmt.Errorf("storage root %s is not writable: %s", root, err.Error())
+	}
+	if err := cs.os.Remove(probeDir); err != nil {
+		logger.Error("remove-probe-dir-failed", err, lager.Data{"path": probeDir})
+	}
+
+	if pool.MinFreeBytes > 0 {
+		free, err := cs.diskStats.FreeBytes(root)
+		if err != nil {
+			return fmt.Errorf("cannot determine free space of %s: %s", root, err.Error())
+		}
+		if free < uint64(pool.MinFreeBytes) {
+			return fmt.Errorf("storage root %s has %d bytes free, below the threshold of %d", root, free, pool.MinFreeBytes)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/net/context"
+)
+
+// QuotaProject is the Pool.Quota setting that enforces each volume's capacity
+// with an XFS or ext4 project quota.
+const QuotaProject = "project"
+
+// QuotaProjectBase is the first project id given to a volume. Other users
+// of project quotas on the same filesystem should stay below it.
+const QuotaProjectBase = 1 << 20
+
+//go:generate counterfeiter -o controllerfakes/fake_quotas.go . Quotas
+
+// Quotas limits what a volume directory can hold with filesystem project
+// quotas.
+type Quotas interface {
+	// Check returns why the filesystem holding path cannot enforce project
+	// quotas, or nil if it can.
+	Check(path string) error
+	// Limit puts dir in project projectId, which everything created in it
+	// inherits, and limits the project to bytes and inodes; 0 is no limit.
+	Limit(dir string, projectId uint32, bytes, inodes uint64) error
+	// Clear removes the limits of project projectId on the filesystem
+	// holding path.
+	Clear(path string, projectId uint32) error
+}
+
+// enableQuotas must be called with cs.lock held, once the state has been
+// recovered. Pools whose filesystem cannot enforce project quotas fall back
+// to reporting usage only. Volumes recorded before quotas were enabled get a
+// project now.
+func (cs *Controller) enableQuotas(logger lager.Logger) {
+	changed := false
+	for _, name := range cs.poolOrder {
+		pool := cs.pools[name]
+		if pool.Quota == "" {
+			continue
+		}
+
+		root := cs.volumePath(pool, "")
+		if err := cs.quotas.Check(root); err != nil {
+			logger.Error("quotas-unsupported", err, lager.Data{"pool": name, "root": root})
+			continue
+		}
+		logger.Info("quotas-enabled", lager.Data{"pool": name})
+		pool.quotasEnabled = true
+
+		for _, v := range cs.volumes {
+			if v.Pool != name || v.Incomplete || v.Missing {
+				continue
+			}
+			if v.ProjectId == 0 {
+				v.ProjectId = cs.nextProjectId()
+				changed = true
+			}
+			if err := cs.limit(context.Background(), pool, v, cs.volumePath(pool, v.Name), v.CapacityBytes); err != nil {
+				logger.Error("limit-failed", err, lager.Data{"volume_id": v.VolumeId})
+			}
+		}
+	}
+
+	// a failed save is logged, and the ids are saved with the next change
+	if changed {
+		cs.saveState(context.Background(), logger)
+	}
+}
+
+// nextProjectId must be called with cs.lock held. Ids are unique across
+// pools, since pools may share a filesystem.
+func (cs *Controller) nextProjectId() uint32 {
+	next := uint32(QuotaProjectBase)
+	for _, v := range cs.volumes {
+		if v.ProjectId >= next {
+			next = v.ProjectId + 1
+		}
+	}
+	return next
+}
+
+// limit applies the volume's qgroup limit or project quota for capacity, if
+// its pool enforces either.
+func (cs *Controller) limit(ctx context.Context, pool *Pool, localVol *LocalVolume, dir string, capacity int64) error {
+	if pool.qgroupsEnabled {
+		// volumes created before the pool was on btrfs stay plain directories
+		if ok, err := cs.subvolumes.IsSubvolume(dir); err != nil || !ok {
+			return err
+		}
+		if err := cs.subvolumes.Limit(ctx, dir, capacity); err != nil {
+			return fmt.Errorf("setting the qgroup limit of %s: %s", dir, err.Error())
+		}
+		return nil
+	}
+	if !pool.quotasEnabled || localVol.ProjectId == 0 {
+		return nil
+	}
+	if err := cs.quotas.Limit(dir, localVol.ProjectId, uint64(capacity), uint64(pool.QuotaInodes)); err != nil {
+		return fmt.Errorf("setting the quota of project %d on %s: %s", localVol.ProjectId, dir, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prjQuota = 2
+
+	// quotactl commands, already combined with the quota type as QCMD does
+	qGetQuota = 0x800007<<8 | prjQuota
+	qSetQuota = 0x800008<<8 | prjQuota
+
+	qifBLimits = 1
+	qifILimits = 4
+	// qifBlockSize is the unit of the block limits
+	qifBlockSize = 1024
+
+	fsIocFsGetXattr    = 0x801c581f
+	fsIocFsSetXattr    = 0x401c5820
+	fsXflagProjInherit = 0x200
+)
+
+// dqblk is struct if_dqblk from linux/quota.h.
+type dqblk struct {
+	bHardLimit uint64
+	bSoftLimit uint64
+	curSpace   uint64
+	iHardLimit uint64
+	iSoftLimit uint64
+	curInodes  uint64
+	bTime      uint64
+	iTime      uint64
+	valid      uint32
+	_          uint32
+}
+
+// fsxattr is struct fsxattr from linux/fs.h.
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	_          [8]byte
+}
+
+type projectQuotas struct{}
+
+// NewQuotas returns Quotas that call quotactl(2) on the block device of the
+// XFS or ext4 filesystem holding a directory. The filesystem must be
+// mounted with project quotas (prjquota).
+func NewQuotas() Quotas {
+	return &projectQuotas{}
+}
+
+func (q *projectQuotas) Check(path string) error {
+	device, err := quotaDevice(path)
+	if err != nil {
+		return err
+	}
+	var quota dqblk
+	if err := quotactl(qGetQuota, device, 0, &quota); err != nil {
+		return fmt.Errorf("project quotas are not enabled on %s: %s", device, err.Error())
+	}
+	return nil
+}
+
+func (q *projectQuotas) Limit(dir string, projectId uint32, bytes, inodes uint64) error {
+	device, err := quotaDevice(dir)
+	if err != nil {
+		return err
+	}
+	if err := setProject(dir, projectId); err != nil {
+		return err
+	}
+	return quotactl(qSetQuota, device, projectId, &dqblk{
+		bHardLimit: (bytes + qifBlockSize - 1) / qifBlockSize,
+		iHardLimit: inodes,
+		valid:      qifBLimits | qifILimits,
+	})
+}
+
+func (q *projectQuotas) Clear(path string, projectId uint32) error {
+	device, err := quotaDevice(path)
+	if err != nil {
+		return err
+	}
+	return quotactl(qSetQuota, device, projectId, &dqblk{valid: qifBLimits | qifILimits})
+}
+
+func quotactl(cmd int, device string, id uint32, quota *dqblk) error {
+	devicePtr, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, uintptr(cmd), uintptr(unsafe.Pointer(devicePtr)), uintptr(id), uintptr(unsafe.Pointer(quota)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setProject puts dir in the project and marks it so that what is created
+// in it inherits the project.
+func setProject(dir string, projectId uint32) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var attr fsxattr
+	if err := ioctl(f.Fd(), fsIocFsGetXattr, &attr); err != nil {
+		return fmt.Errorf("reading the project of %s: %s", dir, err.Error())
+	}
+	attr.projid = projectId
+	attr.xflags |= fsXflagProjInherit
+	if err := ioctl(f.Fd(), fsIocFsSetXattr, &attr); err != nil {
+		return fmt.Errorf("setting the project of %s: %s", dir, err.Error())
+	}
+	return nil
+}
+
+func ioctl(fd uintptr, request uintptr, attr *fsxattr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(attr)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// quotaDevice returns the block device of the filesystem holding path,
+// which must be XFS or ext4, from the innermost mount in
+// /proc/self/mountinfo.
+func quotaDevice(path string) (string, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", err
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var mountPoint, fsType, device string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// the fifth field is the mount point; the filesystem type and the
+		// source follow the optional fields, after a lone "-"
+		fields := strings.Fields(scanner.Text())
+		separator := -1
+		for i, field := range fields {
+			if field == "-" {
+				separator = i
+				break
+			}
+		}
+		if len(fields) < 5 || separator < 0 || len(fields) < separator+3 {
+			continue
+		}
+
+		point := mountInfoEscapes.Replace(fields[4])
+		if !within(path, point) || len(point) < len(mountPoint) {
+			continue
+		}
+		// later mounts of the same point hide the earlier ones
+		mountPoint, fsType, device = point, fields[separator+1], mountInfoEscapes.Replace(fields[separator+2])
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if mountPoint == "" {
+		return "", fmt.Errorf("no mount found for %s", path)
+	}
+	if fsType != "xfs" && fsType != "ext4" {
+		return "", fmt.Errorf("%s is on %s, which has no project quotas", path, fsType)
+	}
+	return device, nil
+}
+
+// mountInfoEscapes undoes the octal escapes of /proc/self/mountinfo.
+var mountInfoEscapes = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+func within(path, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,101 @@
+package controller_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project quotas on a loopback filesystem", func() {
+	var (
+		dir        string
+		image      string
+		mountPoint string
+		quotas     controller.Quotas
+	)
+
+	mkfs := func(args ...string) {
+		f, err := os.Create(image)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Truncate(64 << 20)).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		out, err := exec.Command("mkfs.ext4", append(append([]string{"-q", "-F"}, args...), image)...).CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(out))
+	}
+
+	// mount returns the mount(8) output when the kernel refuses the options
+	mount := func(options string) string {
+		out, err := exec.Command("mount", "-o", options, image, mountPoint).CombinedOutput()
+		if err != nil {
+			return string(out)
+		}
+		return ""
+	}
+
+	BeforeEach(func() {
+		if os.Getuid() != 0 {
+			Skip("loop mounts need root")
+		}
+		if _, err := exec.LookPath("mkfs.ext4"); err != nil {
+			Skip("mkfs.ext4 is not installed")
+		}
+
+		var err error
+		dir, err = ioutil.TempDir("", "quotas-loopback")
+		Expect(err).NotTo(HaveOccurred())
+		image = filepath.Join(dir, "fs.img")
+		mountPoint = filepath.Join(dir, "mnt")
+		Expect(os.Mkdir(mountPoint, 0755)).To(Succeed())
+		quotas = controller.NewQuotas()
+	})
+
+	AfterEach(func() {
+		if dir == "" {
+			return
+		}
+		exec.Command("umount", mountPoint).Run()
+		os.RemoveAll(dir)
+	})
+
+	It("reports a filesystem mounted without project quotas as unsupported", func() {
+		mkfs()
+		if out := mount("loop"); out != "" {
+			Skip("cannot loop mount: " + out)
+		}
+
+		Expect(quotas.Check(mountPoint)).To(MatchError(ContainSubstring("project quotas are not enabled")))
+	})
+
+	It("reports a filesystem other than XFS or ext4 as unsupported", func() {
+		Expect(quotas.Check("/proc")).To(MatchError(ContainSubstring("has no project quotas")))
+	})
+
+	It("stops writes past the limit", func() {
+		mkfs("-O", "quota,project")
+		if out := mount("loop,prjquota"); out != "" {
+			Skip("the kernel cannot mount ext4 with project quotas: " + out)
+		}
+
+		Expect(quotas.Check(mountPoint)).To(Succeed())
+
+		volume := filepath.Join(mountPoint, "vol")
+		Expect(os.Mkdir(volume, 0750)).To(Succeed())
+		Expect(quotas.Limit(volume, controller.QuotaProjectBase, 1<<20, 0)).To(Succeed())
+
+		data := bytes.Repeat([]byte{'x'}, 2<<20)
+		err := ioutil.WriteFile(filepath.Join(volume, "big"), data, 0640)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*os.PathError).Err).To(Equal(syscall.EDQUOT))
+
+		Expect(quotas.Clear(mountPoint, controller.QuotaProjectBase)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(volume, "big"), data, 0640)).To(Succeed())
+	})
+})
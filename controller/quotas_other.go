@@ -0,0 +1,20 @@
+//go:build !linux
+
+package controller
+
+import "errors"
+
+var errQuotasUnsupported = errors.New("project quotas are only supported on linux")
+
+type projectQuotas struct{}
+
+// NewQuotas returns Quotas that fail their check, as project quotas need linux.
+func NewQuotas() Quotas {
+	return &projectQuotas{}
+}
+
+func (*projectQuotas) Check(path string) error { return errQuotasUnsupported }
+func (*projectQuotas) Limit(dir string, projectId uint32, bytes, inodes uint64) error {
+	return errQuotasUnsupported
+}
+func (*projectQuotas) Clear(path string, projectId uint32) error { return errQuotasUnsupported }
@@ -0,0 +1,171 @@
+package controller_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/controller/controllerfakes"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Quotas", func() {
+	var (
+		root     string
+		quotas   *controllerfakes.FakeQuotas
+		registry controller.Registry
+		logger   *lagertest.TestLogger
+		cs       *controller.Controller
+		ctx      context.Context
+		vc       []*VolumeCapability
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "quotas")
+		Expect(err).NotTo(HaveOccurred())
+		quotas = &controllerfakes.FakeQuotas{}
+		registry = controller.NewMemoryRegistry()
+		logger = lagertest.NewTestLogger("quotas")
+		ctx = context.Background()
+		vc = []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}}}
+	})
+
+	JustBeforeEach(func() {
+		config := controller.DefaultConfig(root)
+		config.Pools[0].Quota = controller.QuotaProject
+		config.Pools[0].QuotaInodes = 1000
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), quotas, controller.NewImages(), controller.NewSubvolumes(), registry, config)
+		cs.SetLogger(logger)
+		Expect(cs.Recover()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	volumesDir := func() string {
+		return filepath.Join(root, controller.VolumesRootDir)
+	}
+
+	It("checks the pool's filesystem on recovery", func() {
+		Expect(quotas.CheckCallCount()).To(Equal(1))
+		Expect(quotas.CheckArgsForCall(0)).To(Equal(volumesDir()))
+		Expect(logger).To(gbytes.Say("quotas-enabled"))
+	})
+
+	It("limits a new volume to its capacity in a project of its own", func() {
+		_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol-1", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 4096}})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol-2", VolumeCapabilities: vc})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(quotas.LimitCallCount()).To(Equal(2))
+		dir, projectId, bytes, inodes := quotas.LimitArgsForCall(0)
+		Expect(dir).To(Equal(filepath.Join(volumesDir(), "vol-1")))
+		Expect(projectId).To(Equal(uint32(controller.QuotaProjectBase)))
+		Expect(bytes).To(Equal(uint64(4096)))
+		Expect(inodes).To(Equal(uint64(1000)))
+		_, projectId, _, _ = quotas.LimitArgsForCall(1)
+		Expect(projectId).To(Equal(uint32(controller.QuotaProjectBase + 1)))
+
+		volume, err := cs.Volume(ctx, "default:vol-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volume.ProjectId).To(Equal(uint32(controller.QuotaProjectBase)))
+	})
+
+	It("fails the create and removes the directory when the limit cannot be set", func() {
+		quotas.LimitReturns(errors.New("operation not permitted"))
+		_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 4096}})
+		Expect(status.Code(err)).To(Equal(codes.Internal))
+		Expect(filepath.Join(volumesDir(), "vol")).NotTo(BeADirectory())
+		Expect(cs.Volumes(ctx)).To(BeEmpty())
+	})
+
+	It("raises the limit on expansion and clears it on deletion", func() {
+		_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 4096}})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = cs.ControllerExpandVolume(ctx, &ControllerExpandVolumeRequest{VolumeId: "default:vol", CapacityRange: &CapacityRange{RequiredBytes: 8192}})
+		Expect(err).NotTo(HaveOccurred())
+		_, _, bytes, _ := quotas.LimitArgsForCall(1)
+		Expect(bytes).To(Equal(uint64(8192)))
+
+		_, err = cs.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quotas.ClearCallCount()).To(Equal(1))
+		path, projectId := quotas.ClearArgsForCall(0)
+		Expect(path).To(Equal(volumesDir()))
+		Expect(projectId).To(Equal(uint32(controller.QuotaProjectBase)))
+	})
+
+	It("limits a volume created from a snapshot before copying into it", func() {
+		_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "src", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 4096}})
+		Expect(err).NotTo(HaveOccurred())
+		snap, err := cs.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:src"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{
+			Name:               "copy",
+			VolumeCapabilities: vc,
+			VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{
+				Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: snap.GetSnapshot().GetSnapshotId()},
+			}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(quotas.LimitCallCount()).To(Equal(2))
+		dir, projectId, _, _ := quotas.LimitArgsForCall(1)
+		Expect(dir).To(Equal(filepath.Join(volumesDir(), "copy")))
+		Expect(projectId).To(Equal(uint32(controller.QuotaProjectBase + 1)))
+	})
+
+	Context("when the filesystem has no project quotas", func() {
+		BeforeEach(func() {
+			quotas.CheckReturns(errors.New("project quotas are not enabled on /dev/sda1"))
+		})
+
+		It("logs it and leaves volumes unlimited", func() {
+			Expect(logger).To(gbytes.Say("quotas-unsupported"))
+			_, err := cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 4096}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(quotas.LimitCallCount()).To(Equal(0))
+
+			volume, err := cs.Volume(ctx, "default:vol")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.ProjectId).To(BeZero())
+		})
+	})
+
+	Context("with volumes recorded before quotas were enabled", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(root, controller.VolumesRootDir, "old"), 0750)).To(Succeed())
+			state := controller.NewState()
+			state.Volumes["default:old"] = &controller.LocalVolume{VolumeId: "default:old", Pool: "default", Name: "old", CapacityBytes: 2048}
+			Expect(registry.Save(state)).To(Succeed())
+		})
+
+		It("gives them a project and limits them", func() {
+			Expect(quotas.LimitCallCount()).To(Equal(1))
+			dir, projectId, bytes, _ := quotas.LimitArgsForCall(0)
+			Expect(dir).To(Equal(filepath.Join(volumesDir(), "old")))
+			Expect(projectId).To(Equal(uint32(controller.QuotaProjectBase)))
+			Expect(bytes).To(Equal(uint64(2048)))
+
+			state, err := registry.Load()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state.Volumes["default:old"].ProjectId).To(Equal(uint32(controller.QuotaProjectBase)))
+		})
+	})
+})
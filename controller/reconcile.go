@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/net/context"
+)
+
+const (
+	// OrphanPolicyReport only logs orphaned volume directories.
+	OrphanPolicyReport = "report"
+	// OrphanPolicyQuarantine moves orphaned directories into the pool's quarantine directory.
+	OrphanPolicyQuarantine = "quarantine"
+	// OrphanPolicyAdopt records orphaned directories as volumes with no capacity.
+	OrphanPolicyAdopt = "adopt"
+)
+
+const QuarantineRootDir = "_quarantine"
+
+// ReconcileReport summarises a pass of Reconcile.
+type ReconcileReport struct {
+	// Orphans are the paths of volume directories with no registry entry.
+	Orphans []string
+	// Adopted are the ids of the orphans recorded as volumes.
+	Adopted []string
+	// Quarantined are the paths the orphans were moved to.
+	Quarantined []string
+	// Missing are the ids of recorded volumes whose directory is gone.
+	Missing []string
+}
+
+// Reconcile compares the volume directories of every pool with the registry.
+// Orphaned directories are handled according to the orphan policy, and
+// volumes whose directory has disappeared are marked Missing until it returns.
+// Volumes with an operation in progress or still being populated are skipped.
+func (cs *Controller) Reconcile() (ReconcileReport, error) {
+	logger := cs.logger.Session("reconcile")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if !cs.ready {
+		return ReconcileReport{}, fmt.Errorf("state has not been recovered")
+	}
+
+	report := ReconcileReport{}
+	changed := false
+
+	for _, name := range cs.poolOrder {
+		pool := cs.pools[name]
+		paths, err := cs.filepath.Glob(cs.volumePath(pool, "*"))
+		if err != nil {
+			return ReconcileReport{}, err
+		}
+
+		for _, path := range paths {
+			volName := filepath.Base(path)
+			if strings.HasPrefix(volName, ".") {
+				// Probe's scratch directories
+				continue
+			}
+			volId := volumeID(pool.Name, volName)
+			if _, ok := cs.volumes[volId]; ok || cs.operations[volumeOperation(volId)] {
+				continue
+			}
+
+			report.Orphans = append(report.Orphans, path)
+			switch cs.orphanPolicy {
+			case OrphanPolicyAdopt:
+				logger.Info("adopting-orphan", lager.Data{"path": path, "volume_id": volId})
+				cs.volumes[volId] = &LocalVolume{
+					VolumeId:       volId,
+					Pool:           pool.Name,
+					Name:           volName,
+					PublishedNodes: map[string]bool{},
+				}
+				report.Adopted = append(report.Adopted, volId)
+				changed = true
+			case OrphanPolicyQuarantine:
+				dest := cs.poolPath(pool, QuarantineRootDir, fmt.Sprintf("%s-%d", volName, time.Now().Unix()))
+				if err := cs.os.Rename(path, dest); err != nil {
+					logger.Error("quarantine-failed", err, lager.Data{"path": path})
+					continue
+				}
+				logger.Info("quarantined-orphan", lager.Data{"path": path, "quarantine_path": dest})
+				report.Quarantined = append(report.Quarantined, dest)
+			default:
+				logger.Info("found-orphan", lager.Data{"path": path, "volume_id": volId})
+			}
+		}
+	}
+
+	volIds := []string{}
+	for volId := range cs.volumes {
+		volIds = append(volIds, volId)
+	}
+	sort.Strings(volIds)
+
+	for _, volId := range volIds {
+		v := cs.volumes[volId]
+		if v.Incomplete || cs.operations[volumeOperation(volId)] {
+			continue
+		}
+
+		_, err := cs.os.Stat(cs.volumePath(cs.pools[v.Pool], v.Name))
+		missing := err != nil && cs.os.IsNotExist(err)
+		if missing {
+			logger.Info("volume-missing", lager.Data{"volume_id": volId})
+			report.Missing = append(report.Missing, volId)
+		}
+		if missing != v.Missing {
+			v.Missing = missing
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := cs.saveState(context.Background(), logger); err != nil {
+			for _, volId := range report.Adopted {
+				delete(cs.volumes, volId)
+			}
+			return ReconcileReport{}, err
+		}
+	}
+
+	cs.lastReconcile = report
+	logger.Info("reconciled", lager.Data{
+		"policy":      cs.orphanPolicy,
+		"orphans":     len(report.Orphans),
+		"adopted":     len(report.Adopted),
+		"quarantined": len(report.Quarantined),
+		"missing":     len(report.Missing),
+	})
+	return report, nil
+}
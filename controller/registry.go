@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"encoding/json"
+	"sync"
+
+	"code.cloudfoundry.org/goshims/ioutilshim"
+	"code.cloudfoundry.org/goshims/osshim"
+)
+
+// State is everything the controller needs to survive a restart.
+type State struct {
+	Volumes   map[string]*LocalVolume   `json:"volumes"`
+	Snapshots map[string]*LocalSnapshot `json:"snapshots"`
+}
+
+func NewState() *State {
+	return &State{Volumes: map[string]*LocalVolume{}, Snapshots: map[string]*LocalSnapshot{}}
+}
+
+//go:generate counterfeiter -o controllerfakes/fake_registry.go . Registry
+
+// Registry persists the controller's state.
+type Registry interface {
+	Load() (*State, error)
+	Save(state *State) error
+}
+
+type fileRegistry struct {
+	os     osshim.Os
+	ioutil ioutilshim.Ioutil
+	path   string
+}
+
+// NewFileRegistry stores the state as JSON at path. Saves write a sibling
+// temporary file and rename it into place so a crash never leaves a torn file.
+func NewFileRegistry(os osshim.Os, ioutil ioutilshim.Ioutil, path string) Registry {
+	return &fileRegistry{os: os, ioutil: ioutil, path: path}
+}
+
+func (r *fileRegistry) Load() (*State, error) {
+	data, err := r.ioutil.ReadFile(r.path)
+	if err != nil {
+		if r.os.IsNotExist(err) {
+			return NewState(), nil
+		}
+		return nil, err
+	}
+
+	state := NewState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Volumes == nil {
+		state.Volumes = map[string]*LocalVolume{}
+	}
+	if state.Snapshots == nil {
+		state.Snapshots = map[string]*LocalSnapshot{}
+	}
+	return state, nil
+}
+
+func (r *fileRegistry) Save(state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := r.ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return r.os.Rename(tmp, r.path)
+}
+
+type memoryRegistry struct {
+	lock sync.Mutex
+	data []byte
+}
+
+// NewMemoryRegistry keeps the state in memory, for running without a state file.
+func NewMemoryRegistry() Registry {
+	return &memoryRegistry{}
+}
+
+func (r *memoryRegistry) Load() (*State, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	state := NewState()
+	if r.data == nil {
+		return state, nil
+	}
+	err := json.Unmarshal(r.data, state)
+	return state, err
+}
+
+func (r *memoryRegistry) Save(state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.data = data
+	return nil
+}
@@ -0,0 +1,62 @@
+package controller_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/ioutilshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var (
+		dir      string
+		path     string
+		registry controller.Registry
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "registry")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "state.json")
+		registry = controller.NewFileRegistry(&osshim.OsShim{}, &ioutilshim.IoutilShim{}, path)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("loads an empty state when there is no state file", func() {
+		state, err := registry.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Volumes).To(BeEmpty())
+	})
+
+	It("round-trips the state through the state file", func() {
+		state := controller.NewState()
+		state.Volumes["default:vol"] = &controller.LocalVolume{
+			VolumeId:       "default:vol",
+			Pool:           "default",
+			Name:           "vol",
+			CapacityBytes:  1024,
+			PublishedNodes: map[string]bool{"node-1": true},
+		}
+		Expect(registry.Save(state)).To(Succeed())
+		Expect(path + ".tmp").NotTo(BeAnExistingFile())
+
+		loaded, err := controller.NewFileRegistry(&osshim.OsShim{}, &ioutilshim.IoutilShim{}, path).Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded).To(Equal(state))
+	})
+
+	It("fails to load a corrupt state file", func() {
+		Expect(ioutil.WriteFile(path, []byte("{"), 0600)).To(Succeed())
+		_, err := registry.Load()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrObjectNotFound is returned by ObjectStore.Get for a key that holds nothing.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore keeps the manifests and chunks of backups.
+type ObjectStore interface {
+	// Put stores data under key, replacing what was there.
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete succeeds when there is nothing under key.
+	Delete(ctx context.Context, key string) error
+	// List returns the keys that start with prefix, sorted.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// s3SignedHeaders are the headers covered by a request's signature.
+const s3SignedHeaders = "host;x-amz-content-sha256;x-amz-date"
+
+// s3RequestTimeout bounds each request, so that a stalled connection does
+// not hold up the backups for good.
+const s3RequestTimeout = 5 * time.Minute
+
+// DefaultS3Region signs the requests of a BackupConfig without a region.
+const DefaultS3Region = "us-east-1"
+
+type s3Store struct {
+	client          *http.Client
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	accessKeyId     string
+	secretAccessKey string
+}
+
+// NewS3ObjectStore keeps objects in the bucket of an S3-compatible endpoint,
+// addressed path-style, and signs the requests with AWS Signature Version 4.
+// Credentials missing from the config are read from AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY; without any the requests are sent unsigned. It
+// expects a config that has passed Config.Validate.
+func NewS3ObjectStore(config BackupConfig) ObjectStore {
+	endpoint, _ := url.Parse(config.Endpoint)
+	s := &s3Store{
+		client:          &http.Client{Timeout: s3RequestTimeout},
+		endpoint:        endpoint,
+		region:          config.Region,
+		bucket:          config.Bucket,
+		accessKeyId:     config.AccessKeyId,
+		secretAccessKey: config.SecretAccessKey,
+	}
+	if s.region == "" {
+		s.region = DefaultS3Region
+	}
+	if s.accessKeyId == "" && s.secretAccessKey == "" {
+		s.accessKeyId = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return s
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return s3Error(http.MethodPut, key, resp)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		e := s3Error(http.MethodGet, key, resp)
+		// a missing bucket is 404 too, but not a missing object
+		if resp.StatusCode == http.StatusNotFound && (e.Code == "" || e.Code == "NoSuchKey") {
+			return nil, ErrObjectNotFound
+		}
+		return nil, e
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s *s3Store) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode/100 != 2:
+		return false, s3Error(http.MethodHead, key, resp)
+	}
+	return true, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return s3Error(http.MethodDelete, key, resp)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// listBucketResult is the part of a ListObjectsV2 response that List reads.
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 != 2 {
+			err := s3Error(http.MethodGet, prefix, resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		result := &listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %s", prefix, err.Error())
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// do sends a signed request for key, or for the bucket when key is empty.
+func (s *s3Store) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, u.RawPath, u.RawQuery, body, time.Now())
+	return s.client.Do(req)
+}
+
+// sign adds the headers of Signature Version 4 to req, whose path and query
+// are already escaped as canonicalURI and canonicalQuery.
+func (s *s3Store) sign(req *http.Request, canonicalURI, canonicalQuery string, body []byte, now time.Time) {
+	payload := sha256Hex(body)
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.accessKeyId == "" {
+		return
+	}
+
+	scope := amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payload + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{req.Method, canonicalURI, canonicalQuery, canonicalHeaders, s3SignedHeaders, payload}, "\n")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + s.secretAccessKey)
+	for _, part := range []string{amzDate[:8], s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKeyId, scope, s3SignedHeaders, signature))
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, and
+// slashes unless escapeSlash is set, as Signature Version 4 requires.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes query sorted by key, as both the request and its
+// signature carry it.
+func s3Query(query url.Values) string {
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3ErrorResponse is the error document of a failed request.
+type s3ErrorResponse struct {
+	Method  string `xml:"-"`
+	Key     string `xml:"-"`
+	Status  string `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3ErrorResponse) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.Key, e.Status)
+	}
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.Key, e.Code, e.Message)
+}
+
+func s3Error(method, key string, resp *http.Response) *s3ErrorResponse {
+	e := &s3ErrorResponse{}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	xml.Unmarshal(body, e)
+	e.Method, e.Key, e.Status = method, key, resp.Status
+	return e
+}
@@ -0,0 +1,241 @@
+package controller_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// s3StandIn is an in-memory bucket behind an httptest server. It serves the
+// path-style requests of the S3 object store and checks their signatures.
+type s3StandIn struct {
+	server          *httptest.Server
+	bucket          string
+	accessKeyId     string
+	secretAccessKey string
+	// pageSize is the number of keys each list response holds.
+	pageSize int
+
+	lock     sync.Mutex
+	objects  map[string][]byte
+	requests map[string]int
+	// failPuts makes PUT requests fail with an internal error.
+	failPuts bool
+}
+
+func newS3StandIn(bucket, accessKeyId, secretAccessKey string) *s3StandIn {
+	s := &s3StandIn{
+		bucket:          bucket,
+		accessKeyId:     accessKeyId,
+		secretAccessKey: secretAccessKey,
+		pageSize:        1000,
+		objects:         map[string][]byte{},
+		requests:        map[string]int{},
+	}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+func (s *s3StandIn) URL() string {
+	return s.server.URL
+}
+
+func (s *s3StandIn) Close() {
+	s.server.Close()
+}
+
+// keys returns the keys that start with prefix, sorted.
+func (s *s3StandIn) keys(prefix string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := []string{}
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *s3StandIn) object(key string) []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.objects[key]
+}
+
+func (s *s3StandIn) setObject(key string, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.objects[key] = data
+}
+
+func (s *s3StandIn) count(method string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[method]
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := s.verify(r, body); err != nil {
+		s.fail(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+s.bucket) {
+		s.fail(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+s.bucket), "/")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests[r.Method]++
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r.URL.Query())
+	case r.Method == http.MethodPut:
+		if s.failPuts {
+			s.fail(w, http.StatusInternalServerError, "InternalError", "We encountered an internal error")
+			return
+		}
+		s.objects[key] = body
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		data, ok := s.objects[key]
+		if !ok {
+			s.fail(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// list must be called with s.lock held. Continuation tokens are the last key
+// of the previous page.
+func (s *s3StandIn) list(w http.ResponseWriter, query url.Values) {
+	type contents struct {
+		Key string `xml:"Key"`
+	}
+	result := struct {
+		XMLName               xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Contents              []contents `xml:"Contents"`
+		IsTruncated           bool       `xml:"IsTruncated"`
+		NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+	}{}
+
+	keys := []string{}
+	for k := range s.objects {
+		if strings.HasPrefix(k, query.Get("prefix")) && k > query.Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > s.pageSize {
+		keys = keys[:s.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		result.Contents = append(result.Contents, contents{Key: k})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (s *s3StandIn) fail(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+// verify recomputes the request's Signature Version 4 from the headers it
+// signed.
+func (s *s3StandIn) verify(r *http.Request, body []byte) error {
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("the content checksum does not match")
+	}
+
+	var credential, signedHeaders, signature string
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "), ", ") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("malformed authorization %q", r.Header.Get("Authorization"))
+		}
+		switch parts[0] {
+		case "Credential":
+			credential = parts[1]
+		case "SignedHeaders":
+			signedHeaders = parts[1]
+		case "Signature":
+			signature = parts[1]
+		}
+	}
+	scope := strings.SplitN(credential, "/", 2)
+	if len(scope) != 2 || scope[0] != s.accessKeyId {
+		return fmt.Errorf("unknown credential %q", credential)
+	}
+
+	headers := ""
+	for _, h := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(h)
+		if h == "host" {
+			value = r.Host
+		}
+		headers += h + ":" + strings.TrimSpace(value) + "\n"
+	}
+	query := []string{}
+	for k, vs := range r.URL.Query() {
+		for _, v := range vs {
+			query = append(query, awsEncode(k)+"="+awsEncode(v))
+		}
+	}
+	sort.Strings(query)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		strings.Replace(awsEncode(r.URL.Path), "%2F", "/", -1),
+		strings.Join(query, "&"),
+		headers,
+		signedHeaders,
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + scope[1] + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.secretAccessKey)
+	for _, part := range strings.Split(scope[1], "/") {
+		key = hmacSum(key, part)
+	}
+	if hex.EncodeToString(hmacSum(key, stringToSign)) != signature {
+		return fmt.Errorf("the signature does not match")
+	}
+	return nil
+}
+
+func hmacSum(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEncode is url.QueryEscape with the exceptions AWS makes.
+func awsEncode(s string) string {
+	return strings.NewReplacer("+", "%20", "*", "%2A", "%7E", "~").Replace(url.QueryEscape(s))
+}
@@ -0,0 +1,80 @@
+package controller_test
+
+import (
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("S3 object store", func() {
+	var (
+		standIn *s3StandIn
+		config  controller.BackupConfig
+		store   controller.ObjectStore
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		standIn = newS3StandIn("bucket", "AKIDEXAMPLE", "secret")
+		config = controller.BackupConfig{Endpoint: standIn.URL(), Bucket: "bucket", AccessKeyId: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+		ctx = context.Background()
+	})
+
+	JustBeforeEach(func() {
+		store = controller.NewS3ObjectStore(config)
+	})
+
+	AfterEach(func() {
+		standIn.Close()
+	})
+
+	It("puts, gets and deletes objects whose keys need escaping", func() {
+		key := "backups/default:snap name+1~é.json"
+		Expect(store.Put(ctx, key, []byte("hello"))).To(Succeed())
+		Expect(standIn.object(key)).To(Equal([]byte("hello")))
+
+		Expect(store.Get(ctx, key)).To(Equal([]byte("hello")))
+		Expect(store.Exists(ctx, key)).To(BeTrue())
+
+		Expect(store.Delete(ctx, key)).To(Succeed())
+		Expect(store.Exists(ctx, key)).To(BeFalse())
+		_, err := store.Get(ctx, key)
+		Expect(err).To(Equal(controller.ErrObjectNotFound))
+		Expect(store.Delete(ctx, key)).To(Succeed())
+	})
+
+	It("lists the keys with a prefix across pages", func() {
+		standIn.pageSize = 2
+		for _, key := range []string{"chunks/c", "chunks/a", "chunks/e", "chunks/b", "chunks/d", "backups/x.json"} {
+			Expect(store.Put(ctx, key, []byte(key))).To(Succeed())
+		}
+
+		Expect(store.List(ctx, "chunks/")).To(Equal([]string{"chunks/a", "chunks/b", "chunks/c", "chunks/d", "chunks/e"}))
+		Expect(store.List(ctx, "none/")).To(BeEmpty())
+	})
+
+	Context("with the wrong secret", func() {
+		BeforeEach(func() {
+			config.SecretAccessKey = "guess"
+		})
+
+		It("reports the service's error", func() {
+			err := store.Put(ctx, "key", []byte("hello"))
+			Expect(err).To(MatchError(ContainSubstring("PUT key: SignatureDoesNotMatch")))
+			_, err = store.Get(ctx, "key")
+			Expect(err).To(MatchError(ContainSubstring("SignatureDoesNotMatch")))
+		})
+	})
+
+	Context("with a bucket that does not exist", func() {
+		BeforeEach(func() {
+			config.Bucket = "other"
+		})
+
+		It("does not mistake it for a missing object", func() {
+			_, err := store.Get(ctx, "key")
+			Expect(err).To(MatchError(ContainSubstring("NoSuchBucket")))
+		})
+	})
+})
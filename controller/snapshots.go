@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const SnapshotsRootDir = "_snapshots"
+
+// LocalSnapshot is a copy of a volume's directory, or a read-only snapshot of
+// a subvolume, kept in the snapshots directory of the source volume's pool.
+// A snapshot of an overlay volume copies only its upper directory. Its id is
+// built like a volume id.
+type LocalSnapshot struct {
+	SnapshotId     string `json:"snapshot_id"`
+	Pool           string `json:"pool"`
+	Name           string `json:"name"`
+	SourceVolumeId string `json:"source_volume_id"`
+	SizeBytes      int64  `json:"size_bytes"`
+	// FsType is the filesystem of a snapshot of an image volume.
+	FsType string `json:"fs_type,omitempty"`
+	// Layers lists the snapshots beneath a snapshot of an overlay volume,
+	// topmost first.
+	Layers []string `json:"layers,omitempty"`
+	// CreatedAt is in nanoseconds since the Unix epoch.
+	CreatedAt int64 `json:"created_at"`
+	// ReadyToUse is false until the copy has completed.
+	ReadyToUse bool `json:"ready_to_use"`
+	// Backup is set when the snapshot is to be uploaded to the object store,
+	// and BackedUpAt once it has been, in nanoseconds since the Unix epoch.
+	Backup     bool  `json:"backup,omitempty"`
+	BackedUpAt int64 `json:"backed_up_at,omitempty"`
+}
+
+func (cs *Controller) csiSnapshot(snapshot *LocalSnapshot) *Snapshot {
+	created := time.Unix(0, snapshot.CreatedAt)
+	return &Snapshot{
+		SnapshotId:     snapshot.SnapshotId,
+		SourceVolumeId: snapshot.SourceVolumeId,
+		SizeBytes:      snapshot.SizeBytes,
+		CreationTime:   &timestamp.Timestamp{Seconds: created.Unix(), Nanos: int32(created.Nanosecond())},
+		ReadyToUse:     snapshot.ReadyToUse,
+	}
+}
+
+// snapshotByName must be called with cs.lock held. Snapshot names are unique
+// across pools, as CSI expects of CreateSnapshot names.
+func (cs *Controller) snapshotByName(name string) (*LocalSnapshot, bool) {
+	for _, s := range cs.snapshots {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// sortedSnapshots must be called with cs.lock held.
+func (cs *Controller) sortedSnapshots() []*LocalSnapshot {
+	snapshots := []*LocalSnapshot{}
+	for _, s := range cs.snapshots {
+		snapshots = append(snapshots, s)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].SnapshotId < snapshots[j].SnapshotId })
+	return snapshots
+}
+
+// reserveSnapshot records the snapshot as not ready to use and begins its
+// copy operation, unless it already exists. It returns the snapshot and
+// whether the caller must run copySnapshot.
+func (cs *Controller) reserveSnapshot(ctx context.Context, logger lager.Logger, name, sourceVolId string, backup bool) (*Snapshot, bool, error) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	snapshot, ok := cs.snapshotByName(name)
+	if ok {
+		if snapshot.SourceVolumeId != sourceVolId {
+			return nil, false, grpc.Errorf(codes.AlreadyExists, "Snapshot %q exists for volume %q", name, snapshot.SourceVolumeId)
+		}
+		if snapshot.Backup != backup {
+			return nil, false, grpc.Errorf(codes.AlreadyExists, "Snapshot %q exists with %s=%t", name, BackupParameter, snapshot.Backup)
+		}
+		if snapshot.ReadyToUse {
+			return cs.csiSnapshot(snapshot), false, nil
+		}
+		if err := cs.beginOperation(snapshotOperation(snapshot.SnapshotId)); err != nil {
+			return nil, false, err
+		}
+		logger.Info("resuming-snapshot", lager.Data{"snapshot_id": snapshot.SnapshotId})
+		return cs.csiSnapshot(snapshot), true, nil
+	}
+
+	sourceVol, ok := cs.volumes[sourceVolId]
+	if !ok {
+		return nil, false, grpc.Errorf(codes.NotFound, "Volume %q does not exist", sourceVolId)
+	}
+	if sourceVol.Incomplete {
+		return nil, false, grpc.Errorf(codes.FailedPrecondition, "Volume %q is still being populated from snapshot %q", sourceVolId, sourceVol.SourceSnapshotId)
+	}
+	if backup && len(sourceVol.Layers) > 0 {
+		return nil, false, grpc.Errorf(codes.InvalidArgument, "Volume %q is an overlay clone, whose snapshots hold only its changes and cannot be backed up", sourceVolId)
+	}
+
+	snapshot = &LocalSnapshot{
+		SnapshotId:     volumeID(sourceVol.Pool, name),
+		Pool:           sourceVol.Pool,
+		Name:           name,
+		SourceVolumeId: sourceVolId,
+		SizeBytes:      sourceVol.CapacityBytes,
+		FsType:         sourceVol.FsType,
+		Layers:         append([]string(nil), sourceVol.Layers...),
+		CreatedAt:      time.Now().UnixNano(),
+		Backup:         backup,
+	}
+	if err := cs.beginOperation(snapshotOperation(snapshot.SnapshotId)); err != nil {
+		return nil, false, err
+	}
+	cs.snapshots[snapshot.SnapshotId] = snapshot
+
+	if err := cs.saveState(ctx, logger); err != nil {
+		delete(cs.snapshots, snapshot.SnapshotId)
+		cs.endOperation(snapshotOperation(snapshot.SnapshotId))
+		return nil, false, err
+	}
+	return cs.csiSnapshot(snapshot), true, nil
+}
+
+// copySnapshot copies the source volume into a snapshot reserved by
+// reserveSnapshot and marks it ready to use. The copy runs without cs.lock.
+func (cs *Controller) copySnapshot(ctx context.Context, logger lager.Logger, snapId string) (*Snapshot, error) {
+	cs.lock.Lock()
+	snapshot := cs.snapshots[snapId]
+	sourceVol := cs.volumes[snapshot.SourceVolumeId]
+	pool := cs.pools[sourceVol.Pool]
+	src := cs.volumePath(pool, sourceVol.Name)
+	dst := cs.snapshotPath(cs.pools[snapshot.Pool], snapshot.Name)
+	overlay := len(sourceVol.Layers) > 0
+	if overlay {
+		src = filepath.Join(src, OverlayUpperDir)
+	}
+	cs.lock.Unlock()
+
+	var err error
+	subvolume := false
+	if pool.btrfs && !overlay {
+		subvolume, err = cs.subvolumes.IsSubvolume(src)
+	}
+	if err == nil && subvolume {
+		logger.Info("snapshotting-subvolume", lager.Data{"snapshot_id": snapId, "source_volume_id": sourceVol.VolumeId})
+		err = traced(ctx, "snapshot-subvolume", func() error {
+			return cs.snapshotSubvolume(ctx, src, dst, true)
+		}, attribute.String("source", src), attribute.String("path", dst))
+	} else if err == nil {
+		logger.Info("copying-volume", lager.Data{"snapshot_id": snapId, "source_volume_id": sourceVol.VolumeId, "overlay": overlay})
+		err = traced(ctx, "copy-volume", func() error {
+			return cs.dirTree.Copy(ctx, src, dst)
+		}, attribute.String("source", src), attribute.String("path", dst))
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.endOperation(snapshotOperation(snapId))
+
+	if err != nil {
+		return nil, operationError(ctx, logger, "copy volume into snapshot", err)
+	}
+
+	snapshot.ReadyToUse = true
+	if err := cs.saveState(ctx, logger); err != nil {
+		snapshot.ReadyToUse = false
+		return nil, err
+	}
+	return cs.csiSnapshot(snapshot), nil
+}
+
+// populateVolume copies the source snapshot into a volume reserved as
+// incomplete by CreateVolume, whose operation is already begun, and marks the
+// volume complete. A subvolume snapshot restored into its own pool becomes a
+// writable snapshot instead, and a backup is downloaded from the object
+// store, and an empty image volume is formatted. The copy runs without
+// cs.lock.
+func (cs *Controller) populateVolume(ctx context.Context, logger lager.Logger, volId string) (*Volume, error) {
+	cs.lock.Lock()
+	localVol := cs.volumes[volId]
+	pool := cs.pools[localVol.Pool]
+	dst := cs.volumePath(pool, localVol.Name)
+	capacity := localVol.CapacityBytes
+	snapshot, local := cs.snapshots[localVol.SourceSnapshotId]
+	var src string
+	if local {
+		src = cs.snapshotPath(cs.pools[snapshot.Pool], snapshot.Name)
+	}
+	cs.lock.Unlock()
+
+	if localVol.Formatting {
+		return cs.formatVolume(ctx, logger, localVol, dst)
+	}
+
+	var err error
+	if !local {
+		logger.Info("restoring-backup", lager.Data{"volume_id": volId, "backup_id": localVol.SourceSnapshotId})
+		err = traced(ctx, "restore-backup", func() error {
+			return cs.restoreBackup(ctx, pool, localVol, dst, capacity)
+		}, attribute.String("path", dst))
+	} else {
+		clone := false
+		if pool.btrfs && snapshot.Pool == localVol.Pool {
+			clone, err = cs.subvolumes.IsSubvolume(src)
+		}
+		logger.Info("populating-volume", lager.Data{"volume_id": volId, "snapshot_id": snapshot.SnapshotId, "clone": clone})
+		if err == nil && clone {
+			err = traced(ctx, "snapshot-subvolume", func() error {
+				return cs.snapshotSubvolume(ctx, src, dst, false)
+			}, attribute.String("source", src), attribute.String("path", dst))
+			if err == nil {
+				err = cs.limit(ctx, pool, localVol, dst, capacity)
+			}
+		} else if err == nil {
+			err = cs.prepareCopy(ctx, pool, localVol, dst, capacity)
+			if err == nil {
+				err = traced(ctx, "copy-snapshot", func() error {
+					return cs.dirTree.Copy(ctx, src, dst)
+				}, attribute.String("source", src), attribute.String("path", dst))
+			}
+		}
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.endOperation(volumeOperation(volId))
+
+	if _, ok := err.(*backupError); ok {
+		logger.Error("damaged-backup", err)
+		return nil, grpc.Errorf(codes.DataLoss, "Backup %q cannot be restored: %s", localVol.SourceSnapshotId, err.Error())
+	}
+	if err != nil {
+		return nil, operationError(ctx, logger, "populate volume from snapshot", err)
+	}
+
+	localVol.Incomplete = false
+	if err := cs.saveState(ctx, logger); err != nil {
+		localVol.Incomplete = true
+		return nil, err
+	}
+	return cs.csiVolume(localVol), nil
+}
+
+// prepareCopy creates the volume's directory ahead of a copy into it when it
+// has to be a subvolume, or be limited so that the copied files inherit its
+// project.
+func (cs *Controller) prepareCopy(ctx context.Context, pool *Pool, localVol *LocalVolume, dst string, capacity int64) error {
+	if pool.btrfs {
+		if err := cs.subvolumes.Create(ctx, dst); err != nil {
+			return err
+		}
+	} else if pool.quotasEnabled && localVol.ProjectId != 0 {
+		if err := cs.os.MkdirAll(dst, os.ModePerm); err != nil {
+			return err
+		}
+	} else {
+		return nil
+	}
+	return cs.limit(ctx, pool, localVol, dst, capacity)
+}
+
+func (cs *Controller) snapshotPath(pool *Pool, snapshotName string) string {
+	return cs.poolPath(pool, SnapshotsRootDir, snapshotName)
+}
@@ -0,0 +1,81 @@
+package controller
+
+import "code.cloudfoundry.org/lager"
+
+type PoolStats struct {
+	Name string
+	// CapacityBytes is the pool's configured limit, 0 when unlimited.
+	CapacityBytes int64
+	UsedBytes     int64
+	// FreeBytes is what is left of the limit, or the free space on the
+	// pool's filesystem when the pool is unlimited.
+	FreeBytes int64
+}
+
+type Stats struct {
+	Volumes          int
+	Snapshots        int
+	PublishedVolumes int
+	// OrphanedVolumes and MissingVolumes are from the latest Reconcile.
+	OrphanedVolumes int
+	MissingVolumes  int
+	Pools           []PoolStats
+	// VolumeUsage is from the latest RefreshUsage.
+	VolumeUsage []LocalVolumeUsage
+}
+
+// Stats summarises the controller's state for monitoring. The free space of
+// unlimited pools is measured after releasing the lock, so that a slow
+// filesystem does not hold up the RPCs.
+func (cs *Controller) Stats() Stats {
+	logger := cs.logger.Session("stats")
+
+	cs.lock.Lock()
+	stats := Stats{
+		Volumes:         len(cs.volumes),
+		Snapshots:       len(cs.snapshots),
+		OrphanedVolumes: len(cs.lastReconcile.Orphans),
+		MissingVolumes:  len(cs.lastReconcile.Missing),
+	}
+	for _, v := range cs.volumes {
+		if len(v.PublishedNodes) > 0 {
+			stats.PublishedVolumes++
+		}
+	}
+
+	// roots holds the roots of the unlimited pools by their index in stats.Pools
+	roots := map[int]string{}
+	for _, name := range cs.poolOrder {
+		pool := cs.pools[name]
+		poolStats := PoolStats{
+			Name:          name,
+			CapacityBytes: pool.CapacityBytes,
+			UsedBytes:     cs.usedCapacity(name),
+		}
+		if pool.CapacityBytes > 0 {
+			poolStats.FreeBytes = pool.CapacityBytes - poolStats.UsedBytes
+		} else {
+			roots[len(stats.Pools)] = pool.Root
+		}
+		stats.Pools = append(stats.Pools, poolStats)
+	}
+	stats.VolumeUsage = cs.sortedUsage()
+	cs.lock.Unlock()
+
+	for i := range stats.Pools {
+		poolStats := &stats.Pools[i]
+		if root, ok := roots[i]; ok {
+			if root, err := cs.filepath.Abs(root); err == nil {
+				free, err := cs.diskStats.FreeBytes(root)
+				if err != nil {
+					logger.Error("free-bytes-failed", err, lager.Data{"pool": poolStats.Name})
+				}
+				poolStats.FreeBytes = int64(free)
+			}
+		}
+		if poolStats.FreeBytes < 0 {
+			poolStats.FreeBytes = 0
+		}
+	}
+	return stats
+}
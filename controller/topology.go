@@ -0,0 +1,88 @@
+package controller
+
+import (
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// TopologyNodeKey is the segment every node implicitly carries, set to its node id,
+// so that pools can be pinned to a single host without listing it under Config.Nodes.
+const TopologyNodeKey = "topology.local.cloudfoundry.org/node"
+
+// nodeSegments must only be called after NewController.
+func (cs *Controller) nodeSegments(nodeId string) map[string]string {
+	segments := map[string]string{TopologyNodeKey: nodeId}
+	for k, v := range cs.nodes[nodeId] {
+		segments[k] = v
+	}
+	return segments
+}
+
+// segmentsContain reports whether every segment in inner has the same value in outer.
+func segmentsContain(outer, inner map[string]string) bool {
+	for k, v := range inner {
+		if outer[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// accessibleFrom reports whether a volume in the pool can be reached from any of the topologies.
+func (p *Pool) accessibleFrom(topologies []*Topology) bool {
+	for _, t := range topologies {
+		if segmentsContain(t.GetSegments(), p.Topology) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) accessibleTopology() []*Topology {
+	if len(p.Topology) == 0 {
+		return nil
+	}
+	segments := map[string]string{}
+	for k, v := range p.Topology {
+		segments[k] = v
+	}
+	return []*Topology{{Segments: segments}}
+}
+
+// selectPool picks the pool for a CreateVolume request. An explicit pool parameter
+// wins but must still satisfy the requisite topologies; otherwise the first
+// preferred topology served by a pool decides, then the default pool, then the
+// first configured pool that satisfies the requisite topologies.
+func (cs *Controller) selectPool(parameters map[string]string, requirements *TopologyRequirement) (*Pool, bool) {
+	requisite := requirements.GetRequisite()
+	eligible := func(p *Pool) bool {
+		return len(requisite) == 0 || p.accessibleFrom(requisite)
+	}
+
+	if name, ok := parameters[PoolParameter]; ok {
+		pool, known := cs.pools[name]
+		if !known || !eligible(pool) {
+			return nil, false
+		}
+		return pool, true
+	}
+
+	for _, preferred := range requirements.GetPreferred() {
+		for _, name := range cs.poolOrder {
+			pool := cs.pools[name]
+			if len(pool.Topology) > 0 && eligible(pool) && pool.accessibleFrom([]*Topology{preferred}) {
+				return pool, true
+			}
+		}
+	}
+
+	if pool := cs.pools[cs.defaultPool]; eligible(pool) {
+		return pool, true
+	}
+
+	for _, name := range cs.poolOrder {
+		if pool := cs.pools[name]; eligible(pool) {
+			return pool, true
+		}
+	}
+	return nil, false
+}
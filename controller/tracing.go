@@ -0,0 +1,26 @@
+package controller
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/net/context"
+)
+
+// TracerName identifies the spans the controller records around backend operations.
+const TracerName = "code.cloudfoundry.org/local-controller-plugin/controller"
+
+// traced runs op in a child span of ctx named name. Without a configured
+// tracer provider the span is a no-op.
+func traced(ctx context.Context, name string, op func() error, attrs ...attribute.KeyValue) error {
+	_, span := otel.Tracer(TracerName).Start(ctx, name)
+	defer span.End()
+	span.SetAttributes(attrs...)
+
+	err := op()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
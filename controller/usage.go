@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// The volume context entries in which ControllerGetVolume and ListVolumes
+// report a volume's usage, once RefreshUsage has measured it.
+const (
+	UsedBytesKey    = "usage.local.cloudfoundry.org/used-bytes"
+	UsedInodesKey   = "usage.local.cloudfoundry.org/used-inodes"
+	UsageUpdatedKey = "usage.local.cloudfoundry.org/updated-at"
+)
+
+// DefaultSoftLimitPercent is how full a volume may get before a warning is
+// logged, for pools that set no soft_limit_percent.
+const DefaultSoftLimitPercent = 90
+
+type LocalVolumeUsage struct {
+	VolumeId      string    `json:"volume_id"`
+	CapacityBytes int64     `json:"capacity_bytes"`
+	UsedBytes     int64     `json:"used_bytes"`
+	UsedInodes    int64     `json:"used_inodes"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// AboveSoftLimit is set when UsedBytes exceeds the pool's soft limit
+	// share of CapacityBytes. Volumes without a capacity have no soft limit.
+	AboveSoftLimit bool `json:"above_soft_limit"`
+}
+
+// softLimit returns the used bytes above which a volume of the given
+// capacity is reported, or 0 when it has none.
+func (p *Pool) softLimit(capacity int64) int64 {
+	percent := p.SoftLimitPercent
+	if percent == 0 {
+		percent = DefaultSoftLimitPercent
+	}
+	return int64(float64(capacity) * float64(percent) / 100)
+}
+
+// RefreshUsage measures every volume's directory and replaces the cached
+// usage, logging the volumes that cross their soft limit either way. The
+// directories are walked without cs.lock, so that large volumes do not hold
+// up the RPCs. A volume that cannot be measured keeps its previous figures.
+func (cs *Controller) RefreshUsage(ctx context.Context) error {
+	logger := cs.logger.Session("refresh-usage")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := cs.checkReady(); err != nil {
+		return err
+	}
+
+	type target struct {
+		usage     LocalVolumeUsage
+		path      string
+		softLimit int64
+	}
+
+	cs.lock.Lock()
+	targets := []*target{}
+	for _, v := range cs.volumes {
+		if v.Incomplete || v.Missing {
+			continue
+		}
+		pool := cs.pools[v.Pool]
+		targets = append(targets, &target{
+			usage:     LocalVolumeUsage{VolumeId: v.VolumeId, CapacityBytes: v.CapacityBytes},
+			path:      cs.volumePath(pool, v.Name),
+			softLimit: pool.softLimit(v.CapacityBytes),
+		})
+	}
+	cs.lock.Unlock()
+
+	measured := []*target{}
+	for _, t := range targets {
+		bytes, inodes, err := cs.dirTree.Usage(ctx, t.path)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Error("usage-failed", err, lager.Data{"volume_id": t.usage.VolumeId})
+			continue
+		}
+		t.usage.UsedBytes = bytes
+		t.usage.UsedInodes = inodes
+		t.usage.UpdatedAt = time.Now()
+		t.usage.AboveSoftLimit = t.softLimit > 0 && bytes > t.softLimit
+		measured = append(measured, t)
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	usage := map[string]*LocalVolumeUsage{}
+	for volId, u := range cs.usage {
+		if _, ok := cs.volumes[volId]; ok {
+			usage[volId] = u
+		}
+	}
+	for _, t := range measured {
+		// the volume may have been deleted while its directory was walked
+		if _, ok := cs.volumes[t.usage.VolumeId]; !ok {
+			continue
+		}
+
+		data := lager.Data{"volume_id": t.usage.VolumeId, "used_bytes": t.usage.UsedBytes, "capacity_bytes": t.usage.CapacityBytes, "soft_limit_bytes": t.softLimit}
+		wasAbove := usage[t.usage.VolumeId] != nil && usage[t.usage.VolumeId].AboveSoftLimit
+		if t.usage.AboveSoftLimit && !wasAbove {
+			logger.Info("usage-above-soft-limit", data)
+		} else if !t.usage.AboveSoftLimit && wasAbove {
+			logger.Info("usage-below-soft-limit", data)
+		}
+
+		u := t.usage
+		usage[u.VolumeId] = &u
+	}
+	cs.usage = usage
+	return nil
+}
+
+// Usage returns the cached usage of the measured volumes sorted by id,
+// refreshing it first if asked to.
+func (cs *Controller) Usage(ctx context.Context, refresh bool) ([]LocalVolumeUsage, error) {
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+	if refresh {
+		if err := cs.RefreshUsage(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	return cs.sortedUsage(), nil
+}
+
+// VolumeUsage returns the cached usage of a volume, or nil until it has been
+// measured.
+func (cs *Controller) VolumeUsage(ctx context.Context, volId string) (*LocalVolumeUsage, error) {
+	if err := cs.checkReady(); err != nil {
+		return nil, err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if _, ok := cs.volumes[volId]; !ok {
+		return nil, grpc.Errorf(codes.NotFound, "Volume %q does not exist", volId)
+	}
+	u, ok := cs.usage[volId]
+	if !ok {
+		return nil, nil
+	}
+	usage := *u
+	return &usage, nil
+}
+
+// sortedUsage must be called with cs.lock held.
+func (cs *Controller) sortedUsage() []LocalVolumeUsage {
+	usage := []LocalVolumeUsage{}
+	for volId, u := range cs.usage {
+		if _, ok := cs.volumes[volId]; ok {
+			usage = append(usage, *u)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].VolumeId < usage[j].VolumeId })
+	return usage
+}
+
+// usageContext must be called with cs.lock held. It returns nil until the
+// volume has been measured.
+func (cs *Controller) usageContext(volId string) map[string]string {
+	u, ok := cs.usage[volId]
+	if !ok {
+		return nil
+	}
+	return map[string]string{
+		UsedBytesKey:    strconv.FormatInt(u.UsedBytes, 10),
+		UsedInodesKey:   strconv.FormatInt(u.UsedInodes, 10),
+		UsageUpdatedKey: u.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,121 @@
+package controller_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Usage", func() {
+	var (
+		root   string
+		cs     *controller.Controller
+		logger *lagertest.TestLogger
+		ctx    context.Context
+		data   string
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "usage")
+		Expect(err).NotTo(HaveOccurred())
+		ctx = context.Background()
+
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), controller.NewImages(), controller.NewSubvolumes(), controller.NewMemoryRegistry(), controller.DefaultConfig(root))
+		logger = lagertest.NewTestLogger("usage")
+		cs.SetLogger(logger)
+		Expect(cs.Recover()).To(Succeed())
+
+		vc := []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}}}
+		_, err = cs.CreateVolume(ctx, &CreateVolumeRequest{Name: "vol", VolumeCapabilities: vc, CapacityRange: &CapacityRange{RequiredBytes: 64 << 10}})
+		Expect(err).NotTo(HaveOccurred())
+		data = filepath.Join(root, controller.VolumesRootDir, "vol", "data")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	It("reports nothing until the volume has been measured", func() {
+		resp, err := cs.ControllerGetVolume(ctx, &ControllerGetVolumeRequest{VolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetVolume().GetVolumeContext()).To(BeEmpty())
+		Expect(cs.Usage(ctx, false)).To(BeEmpty())
+		Expect(cs.VolumeUsage(ctx, "default:vol")).To(BeNil())
+	})
+
+	It("returns a volume's measured usage", func() {
+		Expect(cs.RefreshUsage(ctx)).To(Succeed())
+		usage, err := cs.VolumeUsage(ctx, "default:vol")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage.CapacityBytes).To(Equal(int64(64 << 10)))
+		Expect(usage.UsedInodes).To(Equal(int64(1)))
+
+		_, err = cs.VolumeUsage(ctx, "default:nope")
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+	})
+
+	It("reports the measured usage in the volume context until the next refresh", func() {
+		Expect(ioutil.WriteFile(data, make([]byte, 16<<10), 0600)).To(Succeed())
+		Expect(cs.RefreshUsage(ctx)).To(Succeed())
+
+		usage, err := cs.Usage(ctx, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage).To(HaveLen(1))
+		Expect(usage[0].VolumeId).To(Equal("default:vol"))
+		Expect(usage[0].UsedBytes).To(BeNumerically(">=", 16<<10))
+		Expect(usage[0].UsedInodes).To(Equal(int64(2)))
+
+		resp, err := cs.ControllerGetVolume(ctx, &ControllerGetVolumeRequest{VolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+		volumeContext := resp.GetVolume().GetVolumeContext()
+		Expect(volumeContext).To(HaveKeyWithValue(controller.UsedBytesKey, strconv.FormatInt(usage[0].UsedBytes, 10)))
+		Expect(volumeContext).To(HaveKeyWithValue(controller.UsedInodesKey, "2"))
+		Expect(volumeContext).To(HaveKey(controller.UsageUpdatedKey))
+
+		list, err := cs.ListVolumes(ctx, &ListVolumesRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list.GetEntries()[0].GetVolume().GetVolumeContext()).To(Equal(volumeContext))
+
+		// cached, so a new file shows only after a refresh
+		Expect(ioutil.WriteFile(data+"2", []byte("more"), 0600)).To(Succeed())
+		Expect(cs.Usage(ctx, false)).To(Equal(usage))
+		usage, err = cs.Usage(ctx, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage[0].UsedInodes).To(Equal(int64(3)))
+	})
+
+	It("logs once when a volume crosses its soft limit, and again when it drops back", func() {
+		Expect(ioutil.WriteFile(data, make([]byte, 60<<10), 0600)).To(Succeed())
+		Expect(cs.RefreshUsage(ctx)).To(Succeed())
+		Expect(logger).To(gbytes.Say("usage-above-soft-limit"))
+
+		Expect(cs.RefreshUsage(ctx)).To(Succeed())
+		Expect(logger).NotTo(gbytes.Say("usage-above-soft-limit"))
+
+		Expect(os.Remove(data)).To(Succeed())
+		Expect(cs.RefreshUsage(ctx)).To(Succeed())
+		Expect(logger).To(gbytes.Say("usage-below-soft-limit"))
+	})
+
+	It("forgets deleted volumes", func() {
+		Expect(cs.RefreshUsage(ctx)).To(Succeed())
+		_, err := cs.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: "default:vol"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cs.Usage(ctx, false)).To(BeEmpty())
+		Expect(cs.Stats().VolumeUsage).To(BeEmpty())
+	})
+})
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"fmt"
+	"syscall"
+
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// volumeCondition inspects the volume's backing directory. It reports an
+// abnormal condition while the volume is being populated from a snapshot,
+// imported or formatted, and when the directory is missing, cannot be read by
+// its owner, or is no longer owned by the user the plugin runs as. An overlay
+// volume also needs the directories of its layers.
+func (cs *Controller) volumeCondition(localVol *LocalVolume) *VolumeCondition {
+	if localVol.Formatting {
+		return abnormal("volume image is still being formatted")
+	}
+	if localVol.Incomplete && localVol.SourceSnapshotId == "" {
+		return abnormal("volume is still being imported")
+	}
+	if localVol.Incomplete {
+		return abnormal("volume is still being populated from snapshot %s", localVol.SourceSnapshotId)
+	}
+
+	path := cs.volumePath(cs.pools[localVol.Pool], localVol.Name)
+
+	info, err := cs.os.Stat(path)
+	if err != nil {
+		if cs.os.IsNotExist(err) {
+			return abnormal("backing directory %s is missing", path)
+		}
+		return abnormal("backing directory %s cannot be inspected: %s", path, err.Error())
+	}
+
+	if !info.IsDir() {
+		return abnormal("backing path %s is not a directory", path)
+	}
+
+	if info.Mode().Perm()&0500 != 0500 {
+		return abnormal("backing directory %s is not readable (mode %s)", path, info.Mode().Perm())
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		uid, gid := cs.os.Geteuid(), cs.os.Getegid()
+		if int(stat.Uid) != uid || int(stat.Gid) != gid {
+			return abnormal("backing directory %s is owned by %d:%d, expected %d:%d", path, stat.Uid, stat.Gid, uid, gid)
+		}
+	}
+
+	for _, snapId := range localVol.Layers {
+		snapshot := cs.snapshots[snapId]
+		layer := cs.snapshotPath(cs.pools[snapshot.Pool], snapshot.Name)
+		if _, err := cs.os.Stat(layer); err != nil {
+			return abnormal("layer %s cannot be inspected: %s", layer, err.Error())
+		}
+	}
+
+	return &VolumeCondition{Abnormal: false, Message: "volume is healthy"}
+}
+
+func abnormal(format string, args ...interface{}) *VolumeCondition {
+	return &VolumeCondition{Abnormal: true, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,326 @@
+// Package docker serves the Docker volume plugin protocol in front of the
+// controller, so that Docker and Diego's volman can use its volumes. Volumes
+// are known to Docker by their name, and each mount publishes the volume to
+// a node named by the mount's id.
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const ContentType = "application/vnd.docker.plugins.v1.2+json"
+
+// SizeOption is the Create option that sets the volume's capacity in bytes.
+// The other options are passed to CreateVolume as parameters.
+const SizeOption = "size"
+
+// Controller is the part of the controller the plugin drives.
+type Controller interface {
+	CreateVolume(ctx context.Context, in *CreateVolumeRequest) (*CreateVolumeResponse, error)
+	DeleteVolume(ctx context.Context, in *DeleteVolumeRequest) (*DeleteVolumeResponse, error)
+	ControllerPublishVolume(ctx context.Context, in *ControllerPublishVolumeRequest) (*ControllerPublishVolumeResponse, error)
+	ControllerUnpublishVolume(ctx context.Context, in *ControllerUnpublishVolumeRequest) (*ControllerUnpublishVolumeResponse, error)
+	Volumes(ctx context.Context) ([]*controller.LocalVolume, error)
+	VolumePath(ctx context.Context, volId string) (string, error)
+	ImagePath(ctx context.Context, volId string) (string, error)
+}
+
+// Request is the body of every VolumeDriver call; each uses some of the fields.
+type Request struct {
+	Name string            `json:"Name"`
+	Opts map[string]string `json:"Opts,omitempty"`
+	ID   string            `json:"ID,omitempty"`
+}
+
+// VolumeInfo is a volume as Docker sees it.
+type VolumeInfo struct {
+	Name       string                 `json:"Name"`
+	Mountpoint string                 `json:"Mountpoint,omitempty"`
+	Status     map[string]interface{} `json:"Status,omitempty"`
+}
+
+type ErrorResponse struct {
+	Err string `json:"Err"`
+}
+
+type ActivateResponse struct {
+	Implements []string `json:"Implements"`
+}
+
+type MountResponse struct {
+	Mountpoint string `json:"Mountpoint"`
+	Err        string `json:"Err"`
+}
+
+type GetResponse struct {
+	Volume *VolumeInfo `json:"Volume"`
+	Err    string      `json:"Err"`
+}
+
+type ListResponse struct {
+	Volumes []*VolumeInfo `json:"Volumes"`
+	Err     string        `json:"Err"`
+}
+
+type CapabilitiesResponse struct {
+	Capabilities Capabilities `json:"Capabilities"`
+}
+
+type Capabilities struct {
+	Scope string `json:"Scope"`
+}
+
+// capability is what volumes are created and mounted with; every mount
+// counts as a node of its own.
+var capability = &VolumeCapability{
+	AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}},
+	AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+}
+
+type plugin struct {
+	logger     lager.Logger
+	controller Controller
+}
+
+// New returns the plugin's HTTP handler.
+func New(logger lager.Logger, controller Controller) http.Handler {
+	p := &plugin{logger: logger.Session("docker"), controller: controller}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", p.handle("activate", false, p.activate))
+	mux.HandleFunc("/VolumeDriver.Create", p.handle("create", true, p.create))
+	mux.HandleFunc("/VolumeDriver.Remove", p.handle("remove", true, p.remove))
+	mux.HandleFunc("/VolumeDriver.Get", p.handle("get", true, p.get))
+	mux.HandleFunc("/VolumeDriver.List", p.handle("list", false, p.list))
+	mux.HandleFunc("/VolumeDriver.Path", p.handle("path", true, p.path))
+	mux.HandleFunc("/VolumeDriver.Mount", p.handle("mount", true, p.mount))
+	mux.HandleFunc("/VolumeDriver.Unmount", p.handle("unmount", true, p.unmount))
+	mux.HandleFunc("/VolumeDriver.Capabilities", p.handle("capabilities", false, p.capabilities))
+	return mux
+}
+
+type call func(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error)
+
+// handle decodes the request, when the call takes one, and encodes the
+// response or the error.
+func (p *plugin) handle(name string, decode bool, c call) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &Request{}
+		logger := p.logger.Session(name)
+		if decode {
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				respond(w, http.StatusBadRequest, ErrorResponse{Err: "invalid request: " + err.Error()})
+				return
+			}
+			logger = logger.WithData(lager.Data{"name": req.Name})
+		}
+		logger.Info("start")
+		defer logger.Info("end")
+
+		resp, err := c(r.Context(), logger, req)
+		if err != nil {
+			logger.Error("failed", err)
+			respond(w, http.StatusInternalServerError, ErrorResponse{Err: status.Convert(err).Message()})
+			return
+		}
+		respond(w, http.StatusOK, resp)
+	}
+}
+
+func (p *plugin) activate(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error) {
+	return ActivateResponse{Implements: []string{"VolumeDriver"}}, nil
+}
+
+func (p *plugin) capabilities(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error) {
+	return CapabilitiesResponse{Capabilities: Capabilities{Scope: "local"}}, nil
+}
+
+func (p *plugin) create(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error) {
+	createReq := &CreateVolumeRequest{
+		Name:               req.Name,
+		VolumeCapabilities: []*VolumeCapability{capability},
+		Parameters:         map[string]string{},
+	}
+	for key, value := range req.Opts {
+		if key != SizeOption {
+			createReq.Parameters[key] = value
+			continue
+		}
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid %s %q: a number of bytes is required", SizeOption, value)
+		}
+		createReq.CapacityRange = &CapacityRange{RequiredBytes: size}
+	}
+
+	resp, err := p.controller.CreateVolume(ctx, createReq)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("created", lager.Data{"volume_id": resp.GetVolume().GetVolumeId()})
+	return ErrorResponse{}, nil
+}
+
+func (p *plugin) remove(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error) {
+	volume, err := p.volume(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.controller.DeleteVolume(ctx, &DeleteVolumeRequest{VolumeId: volume.VolumeId}); err != nil {
+		return nil, err
+	}
+	return ErrorResponse{}, nil
+}
+
+func (p *plugin) get(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error) {
+	volume, err := p.volume(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.dockerVolume(ctx, volume)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := []string{}
+	for id := range volume.PublishedNodes {
+		mounts = append(mounts, id)
+	}
+	sort.Strings(mounts)
+	v.Status = map[string]interface{}{
+		"volume_id":      volume.VolumeId,
+		"pool":           volume.Pool,
+		"capacity_bytes": volume.CapacityBytes,
+		"mounts":         mounts,
+	}
+	return GetResponse{Volume: v}, nil
+}
+
+func (p *plugin) list(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error) {
+	volumes, err := p.controller.Volumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := ListResponse{Volumes: []*VolumeInfo{}}
+	for _, volume := range volumes {
+		v, err := p.dockerVolume(ctx, volume)
+		if err != nil {
+			return nil, err
+		}
+		resp.Volumes = append(resp.Volumes, v)
+	}
+	return resp, nil
+}
+
+func (p *plugin) path(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error) {
+	volume, err := p.volume(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	path, err := p.mountpoint(ctx, volume)
+	if err != nil {
+		return nil, err
+	}
+	return MountResponse{Mountpoint: path}, nil
+}
+
+func (p *plugin) mount(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error) {
+	volume, err := p.volume(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	path, err := p.mountpoint(ctx, volume)
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.controller.ControllerPublishVolume(ctx, &ControllerPublishVolumeRequest{
+		VolumeId:         volume.VolumeId,
+		NodeId:           req.ID,
+		VolumeCapability: capability,
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("mounted", lager.Data{"volume_id": volume.VolumeId, "id": req.ID, "mountpoint": path})
+	return MountResponse{Mountpoint: path}, nil
+}
+
+func (p *plugin) unmount(ctx context.Context, logger lager.Logger, req *Request) (interface{}, error) {
+	volume, err := p.volume(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.controller.ControllerUnpublishVolume(ctx, &ControllerUnpublishVolumeRequest{VolumeId: volume.VolumeId, NodeId: req.ID})
+	if err != nil {
+		return nil, err
+	}
+	return ErrorResponse{}, nil
+}
+
+// volume finds the volume Docker knows by name. Names are unique within a
+// pool; should two pools hold the same name, the first by id wins.
+func (p *plugin) volume(ctx context.Context, name string) (*controller.LocalVolume, error) {
+	if name == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume name not supplied")
+	}
+
+	volumes, err := p.controller.Volumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range volumes {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return nil, grpc.Errorf(codes.NotFound, "Volume %q does not exist", name)
+}
+
+// dockerVolume leaves out the mountpoint of a volume Docker cannot mount.
+func (p *plugin) dockerVolume(ctx context.Context, volume *controller.LocalVolume) (*VolumeInfo, error) {
+	path, err := p.mountpoint(ctx, volume)
+	if status.Code(err) == codes.FailedPrecondition {
+		return &VolumeInfo{Name: volume.Name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &VolumeInfo{Name: volume.Name, Mountpoint: path}, nil
+}
+
+// mountpoint returns the directory Docker mounts the volume from. Nothing
+// here mounts anything, so an image volume, whose directory holds only its
+// image, and an overlay clone, whose directory holds only its changes, are
+// refused.
+func (p *plugin) mountpoint(ctx context.Context, volume *controller.LocalVolume) (string, error) {
+	if len(volume.Layers) > 0 {
+		return "", grpc.Errorf(codes.FailedPrecondition, "Volume %q is an overlay clone, which Docker cannot mount; use the CSI Node service", volume.Name)
+	}
+	image, err := p.controller.ImagePath(ctx, volume.VolumeId)
+	if err != nil {
+		return "", err
+	}
+	if image != "" {
+		return "", grpc.Errorf(codes.FailedPrecondition, "Volume %q is an image volume, which Docker cannot mount; use the CSI Node service", volume.Name)
+	}
+	return p.controller.VolumePath(ctx, volume.VolumeId)
+}
+
+func respond(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,188 @@
+package docker_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/controller/controllerfakes"
+	"code.cloudfoundry.org/local-controller-plugin/docker"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Docker volume plugin", func() {
+	var (
+		root   string
+		cs     *controller.Controller
+		server *httptest.Server
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "docker")
+		Expect(err).NotTo(HaveOccurred())
+		root, err = filepath.EvalSymlinks(root)
+		Expect(err).NotTo(HaveOccurred())
+
+		images := &controllerfakes.FakeImages{}
+		images.CreateStub = func(ctx context.Context, path string, size int64, fsType string) error {
+			return ioutil.WriteFile(path, nil, 0600)
+		}
+		config := controller.DefaultConfig(root)
+		config.Pools = append(config.Pools, controller.PoolConfig{Name: "images", Root: filepath.Join(root, "images"), Backend: controller.BackendImage})
+
+		cs = controller.NewController(&osshim.OsShim{}, &filepathshim.FilepathShim{}, controller.NewDiskStats(), controller.NewDirTree(), controller.NewQuotas(), images, controller.NewSubvolumes(), controller.NewMemoryRegistry(), config)
+		cs.SetLogger(lagertest.NewTestLogger("controller"))
+		Expect(cs.Recover()).To(Succeed())
+		server = httptest.NewServer(docker.New(lagertest.NewTestLogger("docker"), cs))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(root)
+	})
+
+	call := func(endpoint, body string) (int, map[string]interface{}) {
+		resp, err := http.Post(server.URL+endpoint, docker.ContentType, strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.Header.Get("Content-Type")).To(Equal(docker.ContentType))
+
+		var decoded map[string]interface{}
+		Expect(json.NewDecoder(resp.Body).Decode(&decoded)).To(Succeed())
+		return resp.StatusCode, decoded
+	}
+
+	volumePath := filepath.Join(controller.VolumesRootDir, "vol")
+
+	It("activates as a volume driver with local scope", func() {
+		code, body := call("/Plugin.Activate", "")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal(map[string]interface{}{"Implements": []interface{}{"VolumeDriver"}}))
+
+		_, body = call("/VolumeDriver.Capabilities", "")
+		Expect(body).To(Equal(map[string]interface{}{"Capabilities": map[string]interface{}{"Scope": "local"}}))
+	})
+
+	It("creates a volume with the requested size and lists it", func() {
+		code, body := call("/VolumeDriver.Create", `{"Name": "vol", "Opts": {"size": "1024", "pool": "default"}}`)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal(map[string]interface{}{"Err": ""}))
+
+		volume, err := cs.Volume(context.Background(), "default:vol")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volume.CapacityBytes).To(Equal(int64(1024)))
+
+		_, body = call("/VolumeDriver.List", `{}`)
+		Expect(body["Volumes"]).To(Equal([]interface{}{
+			map[string]interface{}{"Name": "vol", "Mountpoint": filepath.Join(root, volumePath)},
+		}))
+	})
+
+	It("refuses an invalid size", func() {
+		code, body := call("/VolumeDriver.Create", `{"Name": "vol", "Opts": {"size": "lots"}}`)
+		Expect(code).To(Equal(http.StatusInternalServerError))
+		Expect(body["Err"]).To(ContainSubstring(`invalid size "lots"`))
+	})
+
+	Context("with a volume", func() {
+		BeforeEach(func() {
+			code, _ := call("/VolumeDriver.Create", `{"Name": "vol"}`)
+			Expect(code).To(Equal(http.StatusOK))
+		})
+
+		It("mounts the volume's directory and unmounts it", func() {
+			code, body := call("/VolumeDriver.Mount", `{"Name": "vol", "ID": "container-1"}`)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body["Mountpoint"]).To(Equal(filepath.Join(root, volumePath)))
+			Expect(filepath.Join(root, volumePath)).To(BeADirectory())
+
+			_, body = call("/VolumeDriver.Get", `{"Name": "vol"}`)
+			Expect(body["Volume"]).To(HaveKeyWithValue("Status", HaveKeyWithValue("mounts", []interface{}{"container-1"})))
+
+			code, _ = call("/VolumeDriver.Unmount", `{"Name": "vol", "ID": "container-1"}`)
+			Expect(code).To(Equal(http.StatusOK))
+			volume, err := cs.Volume(context.Background(), "default:vol")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volume.PublishedNodes).To(BeEmpty())
+		})
+
+		It("reports the volume's path", func() {
+			_, body := call("/VolumeDriver.Path", `{"Name": "vol"}`)
+			Expect(body["Mountpoint"]).To(Equal(filepath.Join(root, volumePath)))
+		})
+
+		It("removes the volume", func() {
+			code, _ := call("/VolumeDriver.Remove", `{"Name": "vol"}`)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(cs.Volumes(context.Background())).To(BeEmpty())
+		})
+	})
+
+	It("refuses to mount an image volume, whose directory holds only its image", func() {
+		// Docker cannot create one, as image volumes are for a single node
+		_, err := cs.CreateVolume(context.Background(), &CreateVolumeRequest{
+			Name: "vol",
+			VolumeCapabilities: []*VolumeCapability{{
+				AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}},
+				AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: map[string]string{controller.PoolParameter: "images"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, endpoint := range []string{"/VolumeDriver.Path", "/VolumeDriver.Mount"} {
+			code, body := call(endpoint, `{"Name": "vol", "ID": "container-1"}`)
+			Expect(code).To(Equal(http.StatusInternalServerError), endpoint)
+			Expect(body["Err"]).To(ContainSubstring("image volume, which Docker cannot mount"), endpoint)
+		}
+		volume, err := cs.Volume(context.Background(), "images:vol")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volume.PublishedNodes).To(BeEmpty())
+
+		_, body := call("/VolumeDriver.List", `{}`)
+		Expect(body["Volumes"]).To(Equal([]interface{}{map[string]interface{}{"Name": "vol"}}))
+	})
+
+	It("refuses to mount an overlay clone, whose directory holds only its changes", func() {
+		code, _ := call("/VolumeDriver.Create", `{"Name": "base"}`)
+		Expect(code).To(Equal(http.StatusOK))
+		snap, err := cs.CreateSnapshot(context.Background(), &CreateSnapshotRequest{Name: "snap", SourceVolumeId: "default:base"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cs.CreateVolume(context.Background(), &CreateVolumeRequest{
+			Name:                "vol",
+			VolumeCapabilities:  []*VolumeCapability{{AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}}, AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_SINGLE_NODE_WRITER}}},
+			Parameters:          map[string]string{controller.CloneParameter: controller.CloneOverlay},
+			VolumeContentSource: &VolumeContentSource{Type: &VolumeContentSource_Snapshot{Snapshot: &VolumeContentSource_SnapshotSource{SnapshotId: snap.GetSnapshot().GetSnapshotId()}}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, endpoint := range []string{"/VolumeDriver.Path", "/VolumeDriver.Mount"} {
+			code, body := call(endpoint, `{"Name": "vol", "ID": "container-1"}`)
+			Expect(code).To(Equal(http.StatusInternalServerError), endpoint)
+			Expect(body["Err"]).To(ContainSubstring("overlay clone, which Docker cannot mount"), endpoint)
+		}
+
+		_, body := call("/VolumeDriver.Get", `{"Name": "vol"}`)
+		Expect(body["Volume"]).NotTo(HaveKey("Mountpoint"))
+	})
+
+	It("fails calls for a volume that does not exist", func() {
+		for _, endpoint := range []string{"/VolumeDriver.Get", "/VolumeDriver.Path", "/VolumeDriver.Mount", "/VolumeDriver.Remove"} {
+			code, body := call(endpoint, `{"Name": "nope", "ID": "container-1"}`)
+			Expect(code).To(Equal(http.StatusInternalServerError), endpoint)
+			Expect(body["Err"]).To(Equal(`Volume "nope" does not exist`), endpoint)
+		}
+	})
+})
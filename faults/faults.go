@@ -0,0 +1,284 @@
+// Package faults makes the plugin misbehave on purpose, so that a CO's error
+// handling can be exercised: chosen controller RPCs fail with given codes,
+// slow down or fail at random, and a registry write can crash the process
+// halfway. It is off unless the server is started with it.
+package faults
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/goshims/ioutilshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagerctx"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CrashExitCode is the status the process exits with on an injected crash.
+const CrashExitCode = 3
+
+// Manifest entries GetPluginInfo carries while fault injection is enabled.
+const (
+	ManifestEnabledKey = "fault-injection"
+	ManifestConfigKey  = "fault-injection-config"
+)
+
+const (
+	controllerService = "/csi.v1.Controller/"
+	getPluginInfo     = "/csi.v1.Identity/GetPluginInfo"
+)
+
+// Rule makes calls to a controller RPC slow or fail.
+type Rule struct {
+	// Method is the RPC the rule applies to, e.g. "CreateVolume", or "*" for every controller RPC.
+	Method string `json:"method"`
+	// Code is the gRPC code to fail with, e.g. "UNAVAILABLE"; empty only adds latency.
+	Code string `json:"code,omitempty"`
+	// Message is the error's message, "injected fault" if empty.
+	Message string `json:"message,omitempty"`
+	// Latency is added before the call runs or fails, e.g. "2s".
+	Latency string `json:"latency,omitempty"`
+	// Probability is the chance the rule applies to a call, between 0 and 1; 0 means always.
+	Probability float64 `json:"probability,omitempty"`
+}
+
+type Config struct {
+	// Rules are tried in order; the first that applies to a call wins.
+	Rules []Rule `json:"rules"`
+	// CrashOnRegistryWrite makes the Nth registry write from when the config
+	// is set stop halfway and exit the process; 0 never.
+	CrashOnRegistryWrite int `json:"crash_on_registry_write,omitempty"`
+}
+
+func ParseConfig(data []byte) (Config, error) {
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, err
+	}
+	return config, config.Validate()
+}
+
+func (c Config) Validate() error {
+	_, err := c.rules()
+	return err
+}
+
+// rules parses the rules into the form the interceptor matches on.
+func (c Config) rules() ([]rule, error) {
+	if c.CrashOnRegistryWrite < 0 {
+		return nil, fmt.Errorf("crash_on_registry_write must not be negative")
+	}
+
+	rules := []rule{}
+	for i, r := range c.Rules {
+		if r.Method == "" {
+			return nil, fmt.Errorf("rule %d: no method", i)
+		}
+		if r.Probability < 0 || r.Probability > 1 {
+			return nil, fmt.Errorf("rule %d: probability must be between 0 and 1", i)
+		}
+
+		parsed := rule{Rule: r, code: codes.OK}
+		if r.Code != "" {
+			if err := parsed.code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(r.Code)))); err != nil || parsed.code == codes.OK {
+				return nil, fmt.Errorf("rule %d: unknown code %q", i, r.Code)
+			}
+		}
+		if r.Latency != "" {
+			latency, err := time.ParseDuration(r.Latency)
+			if err != nil || latency < 0 {
+				return nil, fmt.Errorf("rule %d: invalid latency %q", i, r.Latency)
+			}
+			parsed.latency = latency
+		}
+		rules = append(rules, parsed)
+	}
+	return rules, nil
+}
+
+type rule struct {
+	Rule
+	code    codes.Code
+	latency time.Duration
+}
+
+func (r rule) matches(method string) bool {
+	return r.Method == "*" || r.Method == method
+}
+
+// Injector holds the faults in effect and injects them into the calls and
+// registry writes it is installed on.
+type Injector struct {
+	logger lager.Logger
+	os     osshim.Os
+
+	lock   sync.Mutex
+	config Config
+	rules  []rule
+	writes int
+}
+
+func NewInjector(logger lager.Logger, os osshim.Os, config Config) (*Injector, error) {
+	i := &Injector{logger: logger.Session("faults"), os: os}
+	if err := i.Set(config); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Config returns the faults in effect.
+func (i *Injector) Config() Config {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.config
+}
+
+// Set replaces the faults in effect and restarts the count of registry writes.
+func (i *Injector) Set(config Config) error {
+	rules, err := config.rules()
+	if err != nil {
+		return err
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.config = config
+	i.rules = rules
+	i.writes = 0
+	i.logger.Info("set", lager.Data{"config": config})
+	return nil
+}
+
+// Faults and SetFaults serve the admin commands.
+func (i *Injector) Faults(ctx context.Context) (*Config, error) {
+	config := i.Config()
+	return &config, nil
+}
+
+func (i *Injector) SetFaults(ctx context.Context, config *Config) error {
+	if err := i.Set(*config); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "Invalid faults: %s", err.Error())
+	}
+	return nil
+}
+
+// pick returns the first rule for method that applies to this call.
+func (i *Injector) pick(method string) (rule, bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, r := range i.rules {
+		if r.matches(method) && (r.Probability == 0 || rand.Float64() < r.Probability) {
+			return r, true
+		}
+	}
+	return rule{}, false
+}
+
+// UnaryServerInterceptor injects the rules into controller RPCs and reports
+// fault injection in GetPluginInfo's manifest.
+func (i *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if info.FullMethod == getPluginInfo {
+			resp, err := handler(ctx, req)
+			if err != nil {
+				return resp, err
+			}
+			return i.addManifest(resp.(*GetPluginInfoResponse)), nil
+		}
+		if !strings.HasPrefix(info.FullMethod, controllerService) {
+			return handler(ctx, req)
+		}
+
+		r, ok := i.pick(path.Base(info.FullMethod))
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		logger := lagerctx.FromContext(ctx).Session("fault-injection")
+		logger.Info("injecting", lager.Data{"rule": r.Rule})
+		if r.latency > 0 {
+			select {
+			case <-time.After(r.latency):
+			case <-ctx.Done():
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+		}
+		if r.code == codes.OK {
+			return handler(ctx, req)
+		}
+
+		message := r.Message
+		if message == "" {
+			message = "injected fault"
+		}
+		return nil, status.Error(r.code, message)
+	}
+}
+
+func (i *Injector) addManifest(resp *GetPluginInfoResponse) *GetPluginInfoResponse {
+	config, err := json.Marshal(i.Config())
+	if err != nil {
+		config = []byte(err.Error())
+	}
+
+	manifest := map[string]string{}
+	for k, v := range resp.GetManifest() {
+		manifest[k] = v
+	}
+	manifest[ManifestEnabledKey] = "enabled"
+	manifest[ManifestConfigKey] = string(config)
+
+	return &GetPluginInfoResponse{
+		Name:          resp.GetName(),
+		VendorVersion: resp.GetVendorVersion(),
+		Manifest:      manifest,
+	}
+}
+
+// Ioutil wraps the shim a file registry writes its state through, so that
+// CrashOnRegistryWrite can stop a write halfway.
+func (i *Injector) Ioutil(ioutil ioutilshim.Ioutil) ioutilshim.Ioutil {
+	return &crashingIoutil{Ioutil: ioutil, injector: i}
+}
+
+func (i *Injector) crashDue() bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.config.CrashOnRegistryWrite == 0 {
+		return false
+	}
+	i.writes++
+	return i.writes == i.config.CrashOnRegistryWrite
+}
+
+type crashingIoutil struct {
+	ioutilshim.Ioutil
+	injector *Injector
+}
+
+func (c *crashingIoutil) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	if !c.injector.crashDue() {
+		return c.Ioutil.WriteFile(filename, data, perm)
+	}
+
+	c.injector.logger.Info("crashing-during-registry-write", lager.Data{"path": filename})
+	if err := c.Ioutil.WriteFile(filename, data[:len(data)/2], perm); err != nil {
+		c.injector.logger.Error("partial-write-failed", err)
+	}
+	c.injector.os.Exit(CrashExitCode)
+	return fmt.Errorf("injected crash")
+}
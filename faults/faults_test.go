@@ -0,0 +1,197 @@
+package faults_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"code.cloudfoundry.org/goshims/ioutilshim/ioutil_fake"
+	"code.cloudfoundry.org/goshims/osshim/os_fake"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/faults"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Faults", func() {
+	var (
+		fakeOs   *os_fake.FakeOs
+		injector *faults.Injector
+		config   faults.Config
+		ctx      context.Context
+		calls    int
+	)
+
+	BeforeEach(func() {
+		fakeOs = &os_fake.FakeOs{}
+		config = faults.Config{}
+		ctx = context.Background()
+		calls = 0
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		injector, err = faults.NewInjector(lagertest.NewTestLogger("faults"), fakeOs, config)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	invoke := func(method string) (interface{}, error) {
+		return injector.UnaryServerInterceptor()(ctx, &CreateVolumeRequest{}, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				calls++
+				if method == "/csi.v1.Identity/GetPluginInfo" {
+					return &GetPluginInfoResponse{Name: "plugin", VendorVersion: "1.0"}, nil
+				}
+				return &CreateVolumeResponse{}, nil
+			})
+	}
+
+	Describe("ParseConfig", func() {
+		It("accepts codes by name in any case", func() {
+			config, err := faults.ParseConfig([]byte(`{"rules": [{"method": "CreateVolume", "code": "unavailable", "latency": "1s", "probability": 0.5}]}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Rules).To(HaveLen(1))
+		})
+
+		It("rejects invalid rules", func() {
+			for _, data := range []string{
+				`{"rules": [{"code": "UNAVAILABLE"}]}`,
+				`{"rules": [{"method": "CreateVolume", "code": "NOPE"}]}`,
+				`{"rules": [{"method": "CreateVolume", "code": "OK"}]}`,
+				`{"rules": [{"method": "CreateVolume", "latency": "soon"}]}`,
+				`{"rules": [{"method": "CreateVolume", "probability": 2}]}`,
+				`{"crash_on_registry_write": -1}`,
+			} {
+				_, err := faults.ParseConfig([]byte(data))
+				Expect(err).To(HaveOccurred(), data)
+			}
+		})
+	})
+
+	Context("with a rule for an RPC", func() {
+		BeforeEach(func() {
+			config.Rules = []faults.Rule{{Method: "CreateVolume", Code: "RESOURCE_EXHAUSTED", Message: "pool full"}}
+		})
+
+		It("fails that RPC with the code without calling it", func() {
+			_, err := invoke("/csi.v1.Controller/CreateVolume")
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			Expect(status.Convert(err).Message()).To(Equal("pool full"))
+			Expect(calls).To(Equal(0))
+		})
+
+		It("leaves other RPCs alone", func() {
+			_, err := invoke("/csi.v1.Controller/DeleteVolume")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal(1))
+		})
+
+		It("stops when the faults are cleared", func() {
+			Expect(injector.Set(faults.Config{})).To(Succeed())
+			_, err := invoke("/csi.v1.Controller/CreateVolume")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("with a rule for every RPC", func() {
+		BeforeEach(func() {
+			config.Rules = []faults.Rule{{Method: "*", Code: "UNAVAILABLE"}}
+		})
+
+		It("applies to the controller RPCs only", func() {
+			_, err := invoke("/csi.v1.Controller/ListVolumes")
+			Expect(status.Code(err)).To(Equal(codes.Unavailable))
+			_, err = invoke("/csi.v1.Identity/Probe")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("with a probability", func() {
+		BeforeEach(func() {
+			config.Rules = []faults.Rule{{Method: "CreateVolume", Code: "UNAVAILABLE", Probability: 0.5}}
+		})
+
+		It("fails about that share of the calls", func() {
+			failed := 0
+			for i := 0; i < 1000; i++ {
+				if _, err := invoke("/csi.v1.Controller/CreateVolume"); err != nil {
+					failed++
+				}
+			}
+			Expect(failed).To(BeNumerically("~", 500, 100))
+		})
+	})
+
+	Context("with latency", func() {
+		BeforeEach(func() {
+			config.Rules = []faults.Rule{{Method: "CreateVolume", Latency: "50ms"}}
+		})
+
+		It("delays the call and then runs it", func() {
+			start := time.Now()
+			_, err := invoke("/csi.v1.Controller/CreateVolume")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("gives up when the request is cancelled", func() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(ctx)
+			cancel()
+
+			_, err := invoke("/csi.v1.Controller/CreateVolume")
+			Expect(status.Code(err)).To(Equal(codes.Canceled))
+			Expect(calls).To(Equal(0))
+		})
+	})
+
+	It("reports itself in GetPluginInfo's manifest", func() {
+		config.Rules = []faults.Rule{{Method: "CreateVolume", Code: "UNAVAILABLE"}}
+		injector.Set(config)
+
+		resp, err := invoke("/csi.v1.Identity/GetPluginInfo")
+		Expect(err).NotTo(HaveOccurred())
+		info := resp.(*GetPluginInfoResponse)
+		Expect(info.GetName()).To(Equal("plugin"))
+		Expect(info.GetManifest()).To(HaveKeyWithValue(faults.ManifestEnabledKey, "enabled"))
+
+		var reported faults.Config
+		Expect(json.Unmarshal([]byte(info.GetManifest()[faults.ManifestConfigKey]), &reported)).To(Succeed())
+		Expect(reported).To(Equal(config))
+	})
+
+	Describe("registry writes", func() {
+		var fakeIoutil *ioutil_fake.FakeIoutil
+
+		BeforeEach(func() {
+			fakeIoutil = &ioutil_fake.FakeIoutil{}
+			config.CrashOnRegistryWrite = 2
+		})
+
+		It("crashes halfway through the chosen write", func() {
+			ioutil := injector.Ioutil(fakeIoutil)
+			Expect(ioutil.WriteFile("/state.json.tmp", []byte("first"), 0600)).To(Succeed())
+			Expect(fakeOs.ExitCallCount()).To(Equal(0))
+
+			Expect(ioutil.WriteFile("/state.json.tmp", []byte("second"), 0600)).NotTo(Succeed())
+			Expect(fakeOs.ExitCallCount()).To(Equal(1))
+			Expect(fakeOs.ExitArgsForCall(0)).To(Equal(faults.CrashExitCode))
+
+			_, data, _ := fakeIoutil.WriteFileArgsForCall(1)
+			Expect(string(data)).To(Equal("sec"))
+		})
+
+		It("counts the writes from when the faults are set", func() {
+			ioutil := injector.Ioutil(fakeIoutil)
+			Expect(ioutil.WriteFile("/state.json.tmp", []byte("first"), 0600)).To(Succeed())
+			Expect(injector.Set(config)).To(Succeed())
+			Expect(ioutil.WriteFile("/state.json.tmp", []byte("second"), 0600)).To(Succeed())
+			Expect(fakeOs.ExitCallCount()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,160 @@
+// Package interceptors holds the unary gRPC interceptors every CSI request
+// passes through: request ids, logging with secrets redacted, and panic
+// recovery.
+package interceptors
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagerctx"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDMetadataKey lets a client choose the id logged for its request.
+const RequestIDMetadataKey = "x-request-id"
+
+const redacted = "[REDACTED]"
+
+// Chain returns the interceptors in the order they must run: the request id
+// first so the others log with it, recovery last so a panic is logged and
+// counted like any other failed request.
+func Chain(logger lager.Logger) []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{
+		RequestID(logger),
+		Logging(),
+		Recovery(),
+	}
+}
+
+// AdminChain is Chain for the admin service, whose requests and responses
+// hold whole volume archives and the controller's state: it logs calls
+// without their bodies.
+func AdminChain(logger lager.Logger) []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{
+		RequestID(logger),
+		CallLogging(),
+		Recovery(),
+	}
+}
+
+// RequestID starts a lager session for each request, tagged with its method
+// and an id, and stores it in the context for handlers to use.
+func RequestID(logger lager.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		session := logger.Session("request", lager.Data{
+			"request_id": requestID(ctx),
+			"method":     path.Base(info.FullMethod),
+		})
+		return handler(lagerctx.NewContext(ctx, session), req)
+	}
+}
+
+func requestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDMetadataKey); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// Logging logs each request and its response or error, with their duration.
+// Secrets are redacted from the logged request.
+func Logging() grpc.UnaryServerInterceptor {
+	return logging(true)
+}
+
+// CallLogging logs each request's method, and its duration and status code,
+// but neither the request nor the response.
+func CallLogging() grpc.UnaryServerInterceptor {
+	return logging(false)
+}
+
+func logging(bodies bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		logger := lagerctx.FromContext(ctx)
+		data := lager.Data{"method": info.FullMethod}
+		if bodies {
+			data["request"] = Redact(req)
+		}
+		logger.Info("request", data)
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		data = lager.Data{
+			"method":   info.FullMethod,
+			"duration": time.Since(start).String(),
+			"code":     status.Code(err).String(),
+		}
+		if err != nil {
+			logger.Error("request-failed", err, data)
+		} else {
+			if bodies {
+				data["response"] = resp
+			}
+			logger.Info("response", data)
+		}
+		return resp, err
+	}
+}
+
+// Recovery turns a panicking handler into an Internal error.
+func Recovery() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				lagerctx.FromContext(ctx).Error("panic", fmt.Errorf("%v", r), lager.Data{
+					"method": info.FullMethod,
+					"stack":  string(debug.Stack()),
+				})
+				resp, err = nil, status.Errorf(codes.Internal, "Internal error handling %s", path.Base(info.FullMethod))
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// Redact returns a copy of a CSI request with the values of its Secrets
+// field replaced. Anything that is not a request carrying secrets is
+// returned as is.
+func Redact(req interface{}) interface{} {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return req
+	}
+
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return req
+	}
+	secrets := v.Elem().FieldByName("Secrets")
+	if !secrets.IsValid() || secrets.Kind() != reflect.Map || secrets.Len() == 0 {
+		return req
+	}
+
+	clone := proto.Clone(msg)
+	masked := map[string]string{}
+	for _, key := range secrets.MapKeys() {
+		masked[key.String()] = redacted
+	}
+	reflect.ValueOf(clone).Elem().FieldByName("Secrets").Set(reflect.ValueOf(masked))
+	return clone
+}
@@ -0,0 +1,13 @@
+package interceptors_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Interceptors Suite")
+}
@@ -0,0 +1,123 @@
+package interceptors_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/lager/lagerctx"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/interceptors"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Interceptors", func() {
+	var (
+		logger  *lagertest.TestLogger
+		ctx     context.Context
+		info    *grpc.UnaryServerInfo
+		handler grpc.UnaryHandler
+		request interface{}
+		chain   []grpc.UnaryServerInterceptor
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("interceptors")
+		ctx = context.Background()
+		info = &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+		request = &CreateVolumeRequest{Name: "vol", Secrets: map[string]string{"password": "hunter2"}}
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			lagerctx.FromContext(ctx).Info("handling")
+			return &CreateVolumeResponse{Volume: &Volume{VolumeId: "default:vol"}}, nil
+		}
+		chain = interceptors.Chain(logger)
+	})
+
+	invoke := func() (interface{}, error) {
+		h := handler
+		for i := len(chain) - 1; i >= 0; i-- {
+			interceptor, next := chain[i], h
+			h = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return h(ctx, request)
+	}
+
+	It("gives the handler a logger tagged with a request id and method", func() {
+		_, err := invoke()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(logger).To(gbytes.Say(`"request_id":"[0-9a-f]{16}"`))
+
+		var handled bool
+		for _, log := range logger.Logs() {
+			if log.Message == "interceptors.request.handling" {
+				handled = true
+				Expect(log.Data).To(HaveKeyWithValue("method", "CreateVolume"))
+				Expect(log.Data).To(HaveKey("request_id"))
+			}
+		}
+		Expect(handled).To(BeTrue())
+	})
+
+	It("uses the request id supplied in the metadata", func() {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(interceptors.RequestIDMetadataKey, "abc-123"))
+		_, err := invoke()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(logger).To(gbytes.Say(`"request_id":"abc-123"`))
+	})
+
+	It("logs the request with its secrets redacted, and the response with its duration", func() {
+		_, err := invoke()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(string(logger.Buffer().Contents())).NotTo(ContainSubstring("hunter2"))
+		Expect(logger).To(gbytes.Say(`"password":"\[REDACTED\]"`))
+		Expect(logger).To(gbytes.Say(`interceptors.request.response.*"duration"`))
+		Expect(request.(*CreateVolumeRequest).Secrets).To(HaveKeyWithValue("password", "hunter2"))
+	})
+
+	It("logs failed requests with their status code", func() {
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Errorf(codes.NotFound, "missing")
+		}
+		_, err := invoke()
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		Expect(logger).To(gbytes.Say(`request-failed.*"code":"NotFound"`))
+	})
+
+	It("logs admin calls without their requests and responses", func() {
+		chain = interceptors.AdminChain(logger)
+		_, err := invoke()
+		Expect(err).NotTo(HaveOccurred())
+
+		contents := string(logger.Buffer().Contents())
+		Expect(contents).NotTo(ContainSubstring("password"))
+		Expect(contents).NotTo(ContainSubstring("default:vol"))
+		Expect(logger).To(gbytes.Say(`interceptors.request.request.*"method":"/csi.v1.Controller/CreateVolume"`))
+		Expect(logger).To(gbytes.Say(`interceptors.request.response.*"duration"`))
+	})
+
+	It("recovers from a panicking handler with an Internal error", func() {
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic(errors.New("boom"))
+		}
+		resp, err := invoke()
+		Expect(resp).To(BeNil())
+		Expect(status.Code(err)).To(Equal(codes.Internal))
+		Expect(logger).To(gbytes.Say(`panic.*boom`))
+	})
+
+	Describe("Redact", func() {
+		It("leaves requests without secrets untouched", func() {
+			req := &ListVolumesRequest{MaxEntries: 3}
+			Expect(interceptors.Redact(req)).To(BeIdenticalTo(req))
+		})
+	})
+})
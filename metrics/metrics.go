@@ -0,0 +1,129 @@
+// Package metrics exports Prometheus metrics for the controller's gRPC
+// requests and for the state of its volumes and pools.
+package metrics
+
+import (
+	"path"
+	"time"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const namespace = "local_controller"
+
+//go:generate counterfeiter -o metricsfakes/fake_stats_source.go . StatsSource
+
+type StatsSource interface {
+	Stats() controller.Stats
+}
+
+type Metrics struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+}
+
+// New registers the request metrics and a collector that reads the
+// controller's stats on every scrape.
+func New(registerer prometheus.Registerer, source StatsSource) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_requests_total",
+			Help:      "CSI requests handled, by method and gRPC status code.",
+		}, []string{"method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Time taken to handle CSI requests, by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+	}
+
+	registerer.MustRegister(m.requests, m.latency, newStatsCollector(source))
+	return m
+}
+
+// UnaryServerInterceptor counts and times every request.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method := path.Base(info.FullMethod)
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		m.latency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(method, status.Code(err).String()).Inc()
+		return resp, err
+	}
+}
+
+type statsCollector struct {
+	source           StatsSource
+	volumes          *prometheus.Desc
+	snapshots        *prometheus.Desc
+	publishedVolumes *prometheus.Desc
+	orphanedVolumes  *prometheus.Desc
+	missingVolumes   *prometheus.Desc
+	poolUsed         *prometheus.Desc
+	poolFree         *prometheus.Desc
+	volumeUsedBytes  *prometheus.Desc
+	volumeUsedInodes *prometheus.Desc
+}
+
+func newStatsCollector(source StatsSource) prometheus.Collector {
+	return &statsCollector{
+		source: source,
+		volumes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "volumes"),
+			"Volumes known to the controller.", nil, nil),
+		snapshots: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "snapshots"),
+			"Snapshots known to the controller.", nil, nil),
+		publishedVolumes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "published_volumes"),
+			"Volumes published to at least one node.", nil, nil),
+		orphanedVolumes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "orphaned_volumes"),
+			"Volume directories without a registry entry, as of the last reconciliation.", nil, nil),
+		missingVolumes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "missing_volumes"),
+			"Recorded volumes whose directory is gone, as of the last reconciliation.", nil, nil),
+		poolUsed: prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "capacity_used_bytes"),
+			"Capacity recorded for the volumes in a pool.", []string{"pool"}, nil),
+		poolFree: prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "capacity_free_bytes"),
+			"Capacity still available in a pool.", []string{"pool"}, nil),
+		volumeUsedBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "volume", "used_bytes"),
+			"Bytes allocated to a volume's data, as of the last usage refresh.", []string{"volume_id"}, nil),
+		volumeUsedInodes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "volume", "used_inodes"),
+			"Inodes used by a volume's data, as of the last usage refresh.", []string{"volume_id"}, nil),
+	}
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.volumes
+	ch <- c.snapshots
+	ch <- c.publishedVolumes
+	ch <- c.orphanedVolumes
+	ch <- c.missingVolumes
+	ch <- c.poolUsed
+	ch <- c.poolFree
+	ch <- c.volumeUsedBytes
+	ch <- c.volumeUsedInodes
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.source.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.volumes, prometheus.GaugeValue, float64(stats.Volumes))
+	ch <- prometheus.MustNewConstMetric(c.snapshots, prometheus.GaugeValue, float64(stats.Snapshots))
+	ch <- prometheus.MustNewConstMetric(c.publishedVolumes, prometheus.GaugeValue, float64(stats.PublishedVolumes))
+	ch <- prometheus.MustNewConstMetric(c.orphanedVolumes, prometheus.GaugeValue, float64(stats.OrphanedVolumes))
+	ch <- prometheus.MustNewConstMetric(c.missingVolumes, prometheus.GaugeValue, float64(stats.MissingVolumes))
+	for _, pool := range stats.Pools {
+		ch <- prometheus.MustNewConstMetric(c.poolUsed, prometheus.GaugeValue, float64(pool.UsedBytes), pool.Name)
+		ch <- prometheus.MustNewConstMetric(c.poolFree, prometheus.GaugeValue, float64(pool.FreeBytes), pool.Name)
+	}
+	for _, usage := range stats.VolumeUsage {
+		ch <- prometheus.MustNewConstMetric(c.volumeUsedBytes, prometheus.GaugeValue, float64(usage.UsedBytes), usage.VolumeId)
+		ch <- prometheus.MustNewConstMetric(c.volumeUsedInodes, prometheus.GaugeValue, float64(usage.UsedInodes), usage.VolumeId)
+	}
+}
@@ -0,0 +1,117 @@
+package metrics_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/metrics"
+	"code.cloudfoundry.org/local-controller-plugin/metrics/metricsfakes"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		registry    *prometheus.Registry
+		statsSource *metricsfakes.FakeStatsSource
+		server      *grpc.Server
+		conn        *grpc.ClientConn
+		scraper     *httptest.Server
+	)
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+		statsSource = &metricsfakes.FakeStatsSource{}
+		statsSource.StatsReturns(controller.Stats{
+			Volumes:          3,
+			Snapshots:        1,
+			PublishedVolumes: 2,
+			OrphanedVolumes:  4,
+			MissingVolumes:   5,
+			Pools: []controller.PoolStats{
+				{Name: "fast", CapacityBytes: 100, UsedBytes: 60, FreeBytes: 40},
+			},
+			VolumeUsage: []controller.LocalVolumeUsage{
+				{VolumeId: "fast:vol", CapacityBytes: 60, UsedBytes: 4096, UsedInodes: 7},
+			},
+		})
+		m := metrics.New(registry, statsSource)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		server = grpc.NewServer(grpc.UnaryInterceptor(m.UnaryServerInterceptor()))
+		RegisterIdentityServer(server, &fakeIdentityServer{})
+		go server.Serve(listener)
+
+		conn, err = grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+		Expect(err).NotTo(HaveOccurred())
+
+		scraper = httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		server.Stop()
+		scraper.Close()
+	})
+
+	scrape := func() string {
+		resp, err := http.Get(scraper.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return string(body)
+	}
+
+	It("counts requests by method and status code", func() {
+		client := NewIdentityClient(conn)
+		_, err := client.GetPluginInfo(context.Background(), &GetPluginInfoRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Probe(context.Background(), &ProbeRequest{})
+		Expect(err).To(HaveOccurred())
+		_, err = client.Probe(context.Background(), &ProbeRequest{})
+		Expect(err).To(HaveOccurred())
+
+		body := scrape()
+		Expect(body).To(ContainSubstring(`local_controller_grpc_requests_total{code="OK",method="GetPluginInfo"} 1`))
+		Expect(body).To(ContainSubstring(`local_controller_grpc_requests_total{code="FailedPrecondition",method="Probe"} 2`))
+		Expect(body).To(ContainSubstring(`local_controller_grpc_request_duration_seconds_count{method="Probe"} 2`))
+	})
+
+	It("exports gauges from the controller stats", func() {
+		body := scrape()
+		Expect(body).To(ContainSubstring("local_controller_volumes 3"))
+		Expect(body).To(ContainSubstring("local_controller_snapshots 1"))
+		Expect(body).To(ContainSubstring("local_controller_published_volumes 2"))
+		Expect(body).To(ContainSubstring("local_controller_orphaned_volumes 4"))
+		Expect(body).To(ContainSubstring("local_controller_missing_volumes 5"))
+		Expect(body).To(ContainSubstring(`local_controller_pool_capacity_used_bytes{pool="fast"} 60`))
+		Expect(body).To(ContainSubstring(`local_controller_pool_capacity_free_bytes{pool="fast"} 40`))
+		Expect(body).To(ContainSubstring(`local_controller_volume_used_bytes{volume_id="fast:vol"} 4096`))
+		Expect(body).To(ContainSubstring(`local_controller_volume_used_inodes{volume_id="fast:vol"} 7`))
+	})
+})
+
+type fakeIdentityServer struct{}
+
+func (*fakeIdentityServer) GetPluginInfo(context.Context, *GetPluginInfoRequest) (*GetPluginInfoResponse, error) {
+	return &GetPluginInfoResponse{Name: "fake"}, nil
+}
+
+func (*fakeIdentityServer) GetPluginCapabilities(context.Context, *GetPluginCapabilitiesRequest) (*GetPluginCapabilitiesResponse, error) {
+	return &GetPluginCapabilitiesResponse{}, nil
+}
+
+func (*fakeIdentityServer) Probe(context.Context, *ProbeRequest) (*ProbeResponse, error) {
+	return nil, grpc.Errorf(codes.FailedPrecondition, "not healthy")
+}
@@ -0,0 +1,20 @@
+package node
+
+//go:generate counterfeiter -o nodefakes/fake_mounter.go . Mounter
+
+// Mounter makes and removes the bind mounts that publish a volume's
+// directory, and the loop and overlay mounts that stage image and overlay
+// volumes.
+type Mounter interface {
+	BindMount(source, target string, readOnly bool) error
+	// LoopMount attaches image to a free loop device and mounts its fsType
+	// filesystem on target. Unmounting target frees the device.
+	LoopMount(image, target, fsType string, readOnly bool) error
+	// GrowLoop refreshes the size of the loop device that image is attached
+	// to and target mounted from, and grows its filesystem to fill it.
+	GrowLoop(image, target string) error
+	// OverlayMount mounts an overlayfs with the given mount options on target.
+	OverlayMount(options, target string, readOnly bool) error
+	Unmount(target string) error
+	IsMounted(target string) (bool, error)
+}
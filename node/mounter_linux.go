@@ -0,0 +1,230 @@
+package node
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+type bindMounter struct{}
+
+// NewMounter returns a Mounter that calls mount(2) and reads the process's
+// mount table.
+func NewMounter() Mounter {
+	return &bindMounter{}
+}
+
+func (*bindMounter) BindMount(source, target string, readOnly bool) error {
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+		return err
+	}
+	if !readOnly {
+		return nil
+	}
+
+	// a bind mount keeps the source's flags until it is remounted
+	if err := syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+		syscall.Unmount(target, 0)
+		return err
+	}
+	return nil
+}
+
+const (
+	loopSetFd        = 0x4C00
+	loopClrFd        = 0x4C01
+	loopSetStatus64  = 0x4C04
+	loopSetCapacity  = 0x4C07
+	loopCtlGetFree   = 0x4C82
+	loFlagsAutoclear = 4
+
+	// loopAttempts bounds the retries when another process takes the free
+	// loop device first.
+	loopAttempts = 5
+)
+
+// loopInfo64 is struct loop_info64 from linux/loop.h.
+type loopInfo64 struct {
+	device, inode, rdevice, offset, sizeLimit  uint64
+	number, encryptType, encryptKeySize, flags uint32
+	fileName                                   [64]byte
+	cryptName                                  [64]byte
+	encryptKey                                 [32]byte
+	init                                       [2]uint64
+}
+
+func (*bindMounter) LoopMount(image, target, fsType string, readOnly bool) error {
+	device, err := attachLoop(image, readOnly)
+	if err != nil {
+		return err
+	}
+	// the device was set to clear itself, which it does once the mount goes
+	defer device.Close()
+
+	var flags uintptr
+	if readOnly {
+		flags = syscall.MS_RDONLY
+	}
+	return syscall.Mount(device.Name(), target, fsType, flags, "")
+}
+
+func (*bindMounter) OverlayMount(options, target string, readOnly bool) error {
+	var flags uintptr
+	if readOnly {
+		flags = syscall.MS_RDONLY
+	}
+	return syscall.Mount("overlay", target, "overlay", flags, options)
+}
+
+// attachLoop attaches image to a free loop device set to detach itself on
+// its last close, and returns the device opened.
+func attachLoop(image string, readOnly bool) (*os.File, error) {
+	mode := os.O_RDWR
+	if readOnly {
+		mode = os.O_RDONLY
+	}
+	file, err := os.OpenFile(image, mode, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	control, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer control.Close()
+
+	for attempt := 1; ; attempt++ {
+		n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, control.Fd(), loopCtlGetFree, 0)
+		if errno != 0 {
+			return nil, fmt.Errorf("finding a free loop device: %s", errno.Error())
+		}
+		device, err := os.OpenFile(fmt.Sprintf("/dev/loop%d", n), mode, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopSetFd, file.Fd())
+		if errno == syscall.EBUSY && attempt < loopAttempts {
+			device.Close()
+			continue
+		}
+		if errno != 0 {
+			device.Close()
+			return nil, fmt.Errorf("attaching %s to %s: %s", image, device.Name(), errno.Error())
+		}
+
+		info := loopInfo64{flags: loFlagsAutoclear}
+		copy(info.fileName[:len(info.fileName)-1], image)
+		if _, _, errno = syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopSetStatus64, uintptr(unsafe.Pointer(&info))); errno != 0 {
+			syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopClrFd, 0)
+			device.Close()
+			return nil, fmt.Errorf("setting up %s: %s", device.Name(), errno.Error())
+		}
+		return device, nil
+	}
+}
+
+func (*bindMounter) Unmount(target string) error {
+	return syscall.Unmount(target, 0)
+}
+
+// mountInfoEscapes undoes the octal escapes of /proc/self/mountinfo.
+var mountInfoEscapes = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+func (*bindMounter) IsMounted(target string) (bool, error) {
+	fields, err := mountEntry(target)
+	return fields != nil, err
+}
+
+// mountEntry returns the fields of the topmost line of /proc/self/mountinfo
+// mounted on target, or nil when nothing is.
+func mountEntry(target string) ([]string, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(target); err == nil {
+		target = resolved
+	} else if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entry []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// the fifth field is the mount point
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && mountInfoEscapes.Replace(fields[4]) == target {
+			entry = fields
+		}
+	}
+	return entry, scanner.Err()
+}
+
+func (*bindMounter) GrowLoop(image, target string) error {
+	fields, err := mountEntry(target)
+	if err != nil {
+		return err
+	}
+	// the optional fields end with a "-", followed by the filesystem type
+	// and the mount source
+	var fsType, device string
+	for i, field := range fields {
+		if field == "-" && i+2 < len(fields) {
+			fsType, device = fields[i+1], mountInfoEscapes.Replace(fields[i+2])
+			break
+		}
+	}
+	if !strings.HasPrefix(device, "/dev/loop") {
+		return fmt.Errorf("%s is not mounted from a loop device", target)
+	}
+
+	backing, err := ioutil.ReadFile(filepath.Join("/sys/block", filepath.Base(device), "loop", "backing_file"))
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(image); err == nil {
+		image = resolved
+	}
+	if strings.TrimSpace(string(backing)) != image {
+		return fmt.Errorf("%s is attached to %s rather than %s", device, strings.TrimSpace(string(backing)), image)
+	}
+
+	f, err := os.Open(device)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), loopSetCapacity, 0)
+	f.Close()
+	if errno != 0 {
+		return fmt.Errorf("refreshing the size of %s: %s", device, errno.Error())
+	}
+
+	var cmd *exec.Cmd
+	switch fsType {
+	case "ext4":
+		cmd = exec.Command("resize2fs", device)
+	case "xfs":
+		cmd = exec.Command("xfs_growfs", target)
+	default:
+		return fmt.Errorf("%s filesystems cannot be grown", fsType)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %s", cmd.Args[0], err.Error(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package node_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/node"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Loop mounting an image", func() {
+	var (
+		dir     string
+		image   string
+		target  string
+		mounter node.Mounter
+	)
+
+	BeforeEach(func() {
+		if os.Getuid() != 0 {
+			Skip("loop mounts need root")
+		}
+		if _, err := exec.LookPath("mkfs.ext4"); err != nil {
+			Skip("mkfs.ext4 is not installed")
+		}
+
+		var err error
+		dir, err = ioutil.TempDir("", "loop-mount")
+		Expect(err).NotTo(HaveOccurred())
+		image = filepath.Join(dir, controller.ImageFileName)
+		target = filepath.Join(dir, "mnt")
+		Expect(os.Mkdir(target, 0750)).To(Succeed())
+		Expect(controller.NewImages().Create(context.Background(), image, 16<<20, "ext4")).To(Succeed())
+		mounter = node.NewMounter()
+	})
+
+	AfterEach(func() {
+		if dir == "" {
+			return
+		}
+		syscall.Unmount(target, 0)
+		os.RemoveAll(dir)
+	})
+
+	It("mounts the image's filesystem until it is unmounted", func() {
+		if err := mounter.LoopMount(image, target, "ext4", false); err != nil {
+			Skip("cannot loop mount: " + err.Error())
+		}
+		Expect(mounter.IsMounted(target)).To(BeTrue())
+		Expect(filepath.Join(target, "lost+found")).To(BeADirectory())
+		Expect(ioutil.WriteFile(filepath.Join(target, "data"), []byte("hello"), 0640)).To(Succeed())
+
+		Expect(mounter.Unmount(target)).To(Succeed())
+		Expect(mounter.IsMounted(target)).To(BeFalse())
+		Expect(filepath.Join(target, "data")).NotTo(BeAnExistingFile())
+
+		Expect(mounter.LoopMount(image, target, "ext4", true)).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(target, "data"))).To(Equal([]byte("hello")))
+		err := ioutil.WriteFile(filepath.Join(target, "more"), []byte("hello"), 0640)
+		Expect(err.(*os.PathError).Err).To(Equal(syscall.EROFS))
+	})
+
+	It("grows the mounted filesystem once the image has grown", func() {
+		if _, err := exec.LookPath("resize2fs"); err != nil {
+			Skip("resize2fs is not installed")
+		}
+		if err := mounter.LoopMount(image, target, "ext4", false); err != nil {
+			Skip("cannot loop mount: " + err.Error())
+		}
+		size := func() uint64 {
+			var stat syscall.Statfs_t
+			ExpectWithOffset(1, syscall.Statfs(target, &stat)).To(Succeed())
+			return stat.Blocks * uint64(stat.Bsize)
+		}
+		before := size()
+		Expect(mounter.GrowLoop(filepath.Join(dir, "other.img"), target)).To(MatchError(ContainSubstring("rather than")))
+		Expect(mounter.GrowLoop(image, dir)).To(MatchError(ContainSubstring("not mounted from a loop device")))
+
+		Expect(controller.NewImages().Grow(context.Background(), image, 32<<20)).To(Succeed())
+		err := mounter.GrowLoop(image, target)
+		if err != nil && strings.Contains(err.Error(), "Permission denied to resize") {
+			// on-line resizing needs CAP_SYS_RESOURCE
+			Skip("cannot resize a mounted filesystem: " + err.Error())
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(size()).To(BeNumerically(">", before+(12<<20)))
+	})
+})
+
+var _ = Describe("Overlay mounting", func() {
+	var (
+		dir     string
+		lower   string
+		target  string
+		mounter node.Mounter
+	)
+
+	options := func(lowerdirs, volume string) string {
+		return "lowerdir=" + lowerdirs + ",upperdir=" + filepath.Join(volume, controller.OverlayUpperDir) + ",workdir=" + filepath.Join(volume, controller.OverlayWorkDir)
+	}
+	volume := func(name string) string {
+		path := filepath.Join(dir, name)
+		for _, sub := range []string{controller.OverlayUpperDir, controller.OverlayWorkDir} {
+			Expect(os.MkdirAll(filepath.Join(path, sub), 0755)).To(Succeed())
+		}
+		return path
+	}
+
+	BeforeEach(func() {
+		if os.Getuid() != 0 {
+			Skip("overlay mounts need root")
+		}
+
+		var err error
+		dir, err = ioutil.TempDir("", "overlay-mount")
+		Expect(err).NotTo(HaveOccurred())
+		lower = filepath.Join(dir, "lower")
+		target = filepath.Join(dir, "mnt")
+		Expect(os.MkdirAll(filepath.Join(lower, "dir"), 0755)).To(Succeed())
+		Expect(os.Mkdir(target, 0750)).To(Succeed())
+		for _, name := range []string{"kept", "deleted", "dir/hidden"} {
+			Expect(ioutil.WriteFile(filepath.Join(lower, name), []byte("lower"), 0644)).To(Succeed())
+		}
+		mounter = node.NewMounter()
+	})
+
+	AfterEach(func() {
+		if dir == "" {
+			return
+		}
+		syscall.Unmount(target, 0)
+		os.RemoveAll(dir)
+	})
+
+	It("keeps a clone's deletions when its upper directory is copied into a layer", func() {
+		clone := volume("clone")
+		if err := mounter.OverlayMount(options(lower, clone), target, false); err != nil {
+			Skip("cannot mount overlayfs: " + err.Error())
+		}
+		Expect(os.Remove(filepath.Join(target, "deleted"))).To(Succeed())
+		Expect(os.RemoveAll(filepath.Join(target, "dir"))).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(target, "dir"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(target, "kept"), []byte("upper"), 0644)).To(Succeed())
+		Expect(mounter.Unmount(target)).To(Succeed())
+
+		layer := filepath.Join(dir, "layer")
+		upper := filepath.Join(clone, controller.OverlayUpperDir)
+		Expect(controller.NewDirTree().Copy(context.Background(), upper, layer)).To(Succeed())
+
+		Expect(mounter.OverlayMount(options(layer+":"+lower, volume("again")), target, true)).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(target, "kept"))).To(Equal([]byte("upper")))
+		Expect(filepath.Join(target, "deleted")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(target, "dir")).To(BeADirectory())
+		Expect(filepath.Join(target, "dir", "hidden")).NotTo(BeAnExistingFile())
+		err := ioutil.WriteFile(filepath.Join(target, "more"), []byte("hello"), 0640)
+		Expect(err.(*os.PathError).Err).To(Equal(syscall.EROFS))
+	})
+})
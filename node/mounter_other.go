@@ -0,0 +1,25 @@
+//go:build !linux
+
+package node
+
+import "errors"
+
+var errUnsupported = errors.New("mounts are only supported on linux")
+
+type bindMounter struct{}
+
+// NewMounter returns a Mounter that fails, as mounts need linux.
+func NewMounter() Mounter {
+	return &bindMounter{}
+}
+
+func (*bindMounter) BindMount(source, target string, readOnly bool) error { return errUnsupported }
+func (*bindMounter) LoopMount(image, target, fsType string, readOnly bool) error {
+	return errUnsupported
+}
+func (*bindMounter) GrowLoop(image, target string) error { return errUnsupported }
+func (*bindMounter) OverlayMount(options, target string, readOnly bool) error {
+	return errUnsupported
+}
+func (*bindMounter) Unmount(target string) error           { return errUnsupported }
+func (*bindMounter) IsMounted(target string) (bool, error) { return false, errUnsupported }
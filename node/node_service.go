@@ -0,0 +1,384 @@
+// Package node serves the CSI Node service on the host that holds the
+// controller's pools. Staging bind-mounts a volume's directory onto the
+// staging path, or loop-mounts an image volume's image there, and publishing
+// bind-mounts the staging path onto the target.
+package node
+
+import (
+	"os"
+	"syscall"
+
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagerctx"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+//go:generate counterfeiter -o nodefakes/fake_volume_paths.go . VolumePaths
+
+// VolumePaths finds the directory holding a volume's data, and the image of
+// an image volume, and returns a volume's measured usage; the controller
+// implements it.
+type VolumePaths interface {
+	VolumePath(ctx context.Context, volId string) (string, error)
+	ImagePath(ctx context.Context, volId string) (string, error)
+	VolumeUsage(ctx context.Context, volId string) (*controller.LocalVolumeUsage, error)
+}
+
+type Config struct {
+	NodeId string
+	// Segments are the node's topology segments, as listed under the
+	// controller's Config.Nodes.
+	Segments map[string]string
+}
+
+type Node struct {
+	logger  lager.Logger
+	os      osshim.Os
+	mounter Mounter
+	volumes VolumePaths
+	config  Config
+}
+
+func NewNode(logger lager.Logger, os osshim.Os, mounter Mounter, volumes VolumePaths, config Config) *Node {
+	return &Node{
+		logger:  logger.Session("node"),
+		os:      os,
+		mounter: mounter,
+		volumes: volumes,
+		config:  config,
+	}
+}
+
+func (n *Node) session(ctx context.Context, task string) lager.Logger {
+	// lagerctx falls back to a logger with no session name when none was installed
+	if logger := lagerctx.FromContext(ctx); logger.SessionName() != "" {
+		return logger.Session(task)
+	}
+	return n.logger.Session(task)
+}
+
+func (n *Node) NodeStageVolume(ctx context.Context, in *NodeStageVolumeRequest) (*NodeStageVolumeResponse, error) {
+	logger := n.session(ctx, "stage-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+	if in.GetStagingTargetPath() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Staging target path not supplied")
+	}
+	if err := checkCapability(in.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+
+	if image := in.GetPublishContext()[controller.ImagePathKey]; image != "" {
+		fsType := in.GetPublishContext()[controller.FsTypeKey]
+		readOnly := isReadOnly(in.GetVolumeCapability().GetAccessMode().GetMode())
+		logger.Info("staging-image", lager.Data{"volume_id": in.GetVolumeId(), "image": image, "fs_type": fsType, "target": in.GetStagingTargetPath()})
+		if err := n.mount(logger, image, in.GetStagingTargetPath(), func() error {
+			return n.mounter.LoopMount(image, in.GetStagingTargetPath(), fsType, readOnly)
+		}); err != nil {
+			return nil, err
+		}
+		return &NodeStageVolumeResponse{}, nil
+	}
+
+	if options := in.GetPublishContext()[controller.OverlayOptionsKey]; options != "" {
+		readOnly := isReadOnly(in.GetVolumeCapability().GetAccessMode().GetMode())
+		logger.Info("staging-overlay", lager.Data{"volume_id": in.GetVolumeId(), "options": options, "target": in.GetStagingTargetPath()})
+		if err := n.mount(logger, "overlay", in.GetStagingTargetPath(), func() error {
+			return n.mounter.OverlayMount(options, in.GetStagingTargetPath(), readOnly)
+		}); err != nil {
+			return nil, err
+		}
+		return &NodeStageVolumeResponse{}, nil
+	}
+
+	source, err := n.volumes.VolumePath(ctx, in.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("staging-volume", lager.Data{"volume_id": in.GetVolumeId(), "source": source, "target": in.GetStagingTargetPath()})
+	if err := n.bindMount(logger, source, in.GetStagingTargetPath(), false); err != nil {
+		return nil, err
+	}
+	return &NodeStageVolumeResponse{}, nil
+}
+
+func (n *Node) NodeUnstageVolume(ctx context.Context, in *NodeUnstageVolumeRequest) (*NodeUnstageVolumeResponse, error) {
+	logger := n.session(ctx, "unstage-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+	if in.GetStagingTargetPath() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Staging target path not supplied")
+	}
+
+	// the staging path belongs to the CO, so it is left in place
+	if err := n.unmount(logger, in.GetStagingTargetPath()); err != nil {
+		return nil, err
+	}
+	return &NodeUnstageVolumeResponse{}, nil
+}
+
+func (n *Node) NodePublishVolume(ctx context.Context, in *NodePublishVolumeRequest) (*NodePublishVolumeResponse, error) {
+	logger := n.session(ctx, "publish-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+	if in.GetStagingTargetPath() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Staging target path not supplied")
+	}
+	if in.GetTargetPath() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Target path not supplied")
+	}
+	if err := checkCapability(in.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+
+	staged, err := n.mounter.IsMounted(in.GetStagingTargetPath())
+	if err != nil {
+		logger.Error("is-mounted-failed", err)
+		return nil, grpc.Errorf(codes.Internal, "Checking %q failed: %s", in.GetStagingTargetPath(), err.Error())
+	}
+	if !staged {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "Volume %q is not staged at %q", in.GetVolumeId(), in.GetStagingTargetPath())
+	}
+
+	readOnly := in.GetReadonly() || isReadOnly(in.GetVolumeCapability().GetAccessMode().GetMode())
+	logger.Info("publishing-volume", lager.Data{"volume_id": in.GetVolumeId(), "target": in.GetTargetPath(), "readonly": readOnly})
+	if err := n.bindMount(logger, in.GetStagingTargetPath(), in.GetTargetPath(), readOnly); err != nil {
+		return nil, err
+	}
+	return &NodePublishVolumeResponse{}, nil
+}
+
+func (n *Node) NodeUnpublishVolume(ctx context.Context, in *NodeUnpublishVolumeRequest) (*NodeUnpublishVolumeResponse, error) {
+	logger := n.session(ctx, "unpublish-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+	if in.GetTargetPath() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Target path not supplied")
+	}
+
+	if err := n.unmount(logger, in.GetTargetPath()); err != nil {
+		return nil, err
+	}
+	// publishing created the target, so unpublishing removes it
+	if err := n.os.Remove(in.GetTargetPath()); err != nil && !os.IsNotExist(err) {
+		logger.Error("remove-failed", err)
+		return nil, grpc.Errorf(codes.Internal, "Removing %q failed: %s", in.GetTargetPath(), err.Error())
+	}
+	return &NodeUnpublishVolumeResponse{}, nil
+}
+
+func (n *Node) NodeGetVolumeStats(ctx context.Context, in *NodeGetVolumeStatsRequest) (*NodeGetVolumeStatsResponse, error) {
+	logger := n.session(ctx, "get-volume-stats")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+	if in.GetVolumePath() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume path not supplied")
+	}
+
+	if _, err := n.os.Stat(in.GetVolumePath()); os.IsNotExist(err) {
+		return nil, grpc.Errorf(codes.NotFound, "Volume path %q does not exist", in.GetVolumePath())
+	}
+
+	image, err := n.volumes.ImagePath(ctx, in.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	if image == "" {
+		// a directory volume shares its pool's filesystem, whose figures
+		// are not the volume's; the controller measures it instead
+		usage, err := n.volumes.VolumeUsage(ctx, in.GetVolumeId())
+		if err != nil {
+			return nil, err
+		}
+		return &NodeGetVolumeStatsResponse{Usage: measuredUsage(usage)}, nil
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(in.GetVolumePath(), &stat); err != nil {
+		logger.Error("statfs-failed", err)
+		return nil, grpc.Errorf(codes.Internal, "Reading the filesystem of %q failed: %s", in.GetVolumePath(), err.Error())
+	}
+
+	// an image volume has a filesystem of its own
+	bsize := int64(stat.Bsize)
+	return &NodeGetVolumeStatsResponse{
+		Usage: []*VolumeUsage{
+			{
+				Unit:      VolumeUsage_BYTES,
+				Total:     int64(stat.Blocks) * bsize,
+				Available: int64(stat.Bavail) * bsize,
+				Used:      int64(stat.Blocks-stat.Bfree) * bsize,
+			},
+			{
+				Unit:      VolumeUsage_INODES,
+				Total:     int64(stat.Files),
+				Available: int64(stat.Ffree),
+				Used:      int64(stat.Files - stat.Ffree),
+			},
+		},
+	}, nil
+}
+
+// measuredUsage reports a directory volume's usage as the controller last
+// measured it, against its capacity if it has one, or nothing before the
+// first measurement.
+func measuredUsage(usage *controller.LocalVolumeUsage) []*VolumeUsage {
+	if usage == nil {
+		return nil
+	}
+	bytes := &VolumeUsage{Unit: VolumeUsage_BYTES, Used: usage.UsedBytes}
+	if usage.CapacityBytes > 0 {
+		bytes.Total = usage.CapacityBytes
+		if usage.UsedBytes < usage.CapacityBytes {
+			bytes.Available = usage.CapacityBytes - usage.UsedBytes
+		}
+	}
+	return []*VolumeUsage{bytes, {Unit: VolumeUsage_INODES, Used: usage.UsedInodes}}
+}
+
+// NodeExpandVolume grows the filesystem of an image volume, whose image the
+// controller has already grown, to fill it. Directory volumes grow in place.
+func (n *Node) NodeExpandVolume(ctx context.Context, in *NodeExpandVolumeRequest) (*NodeExpandVolumeResponse, error) {
+	logger := n.session(ctx, "expand-volume")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if in.GetVolumeId() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume id not supplied")
+	}
+	if in.GetVolumePath() == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Volume path not supplied")
+	}
+	if _, err := n.os.Stat(in.GetVolumePath()); os.IsNotExist(err) {
+		return nil, grpc.Errorf(codes.NotFound, "Volume path %q does not exist", in.GetVolumePath())
+	}
+
+	image, err := n.volumes.ImagePath(ctx, in.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	if image != "" {
+		logger.Info("growing-filesystem", lager.Data{"volume_id": in.GetVolumeId(), "image": image, "path": in.GetVolumePath()})
+		if err := n.mounter.GrowLoop(image, in.GetVolumePath()); err != nil {
+			logger.Error("grow-loop-failed", err)
+			return nil, grpc.Errorf(codes.Internal, "Growing the filesystem on %q failed: %s", in.GetVolumePath(), err.Error())
+		}
+	}
+	return &NodeExpandVolumeResponse{CapacityBytes: in.GetCapacityRange().GetRequiredBytes()}, nil
+}
+
+func (n *Node) NodeGetCapabilities(ctx context.Context, in *NodeGetCapabilitiesRequest) (*NodeGetCapabilitiesResponse, error) {
+	capability := func(t NodeServiceCapability_RPC_Type) *NodeServiceCapability {
+		return &NodeServiceCapability{Type: &NodeServiceCapability_Rpc{Rpc: &NodeServiceCapability_RPC{Type: t}}}
+	}
+	return &NodeGetCapabilitiesResponse{
+		Capabilities: []*NodeServiceCapability{
+			capability(NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME),
+			capability(NodeServiceCapability_RPC_GET_VOLUME_STATS),
+			capability(NodeServiceCapability_RPC_EXPAND_VOLUME),
+		},
+	}, nil
+}
+
+// NodeGetInfo reports the same segments the controller checks publishes
+// against, including the implicit controller.TopologyNodeKey.
+func (n *Node) NodeGetInfo(ctx context.Context, in *NodeGetInfoRequest) (*NodeGetInfoResponse, error) {
+	segments := map[string]string{controller.TopologyNodeKey: n.config.NodeId}
+	for k, v := range n.config.Segments {
+		segments[k] = v
+	}
+	return &NodeGetInfoResponse{
+		NodeId:             n.config.NodeId,
+		AccessibleTopology: &Topology{Segments: segments},
+	}, nil
+}
+
+// bindMount creates target and mounts source on it, unless something is
+// mounted there already.
+func (n *Node) bindMount(logger lager.Logger, source, target string, readOnly bool) error {
+	return n.mount(logger, source, target, func() error {
+		return n.mounter.BindMount(source, target, readOnly)
+	})
+}
+
+// mount creates target and runs mount to mount source on it, unless
+// something is mounted on target already.
+func (n *Node) mount(logger lager.Logger, source, target string, mount func() error) error {
+	mounted, err := n.mounter.IsMounted(target)
+	if err != nil {
+		logger.Error("is-mounted-failed", err)
+		return grpc.Errorf(codes.Internal, "Checking %q failed: %s", target, err.Error())
+	}
+	if mounted {
+		logger.Info("already-mounted", lager.Data{"target": target})
+		return nil
+	}
+
+	if err := n.os.MkdirAll(target, 0750); err != nil {
+		logger.Error("mkdir-failed", err)
+		return grpc.Errorf(codes.Internal, "Creating %q failed: %s", target, err.Error())
+	}
+	if err := mount(); err != nil {
+		logger.Error("mount-failed", err)
+		return grpc.Errorf(codes.Internal, "Mounting %q on %q failed: %s", source, target, err.Error())
+	}
+	return nil
+}
+
+func (n *Node) unmount(logger lager.Logger, target string) error {
+	mounted, err := n.mounter.IsMounted(target)
+	if err != nil {
+		logger.Error("is-mounted-failed", err)
+		return grpc.Errorf(codes.Internal, "Checking %q failed: %s", target, err.Error())
+	}
+	if !mounted {
+		logger.Info("not-mounted", lager.Data{"target": target})
+		return nil
+	}
+
+	if err := n.mounter.Unmount(target); err != nil {
+		logger.Error("unmount-failed", err)
+		return grpc.Errorf(codes.Internal, "Unmounting %q failed: %s", target, err.Error())
+	}
+	return nil
+}
+
+func checkCapability(capability *VolumeCapability) error {
+	if capability == nil {
+		return grpc.Errorf(codes.InvalidArgument, "Volume capability not supplied")
+	}
+	if capability.GetBlock() != nil {
+		return grpc.Errorf(codes.InvalidArgument, "Block volumes are not supported")
+	}
+	return nil
+}
+
+func isReadOnly(mode VolumeCapability_AccessMode_Mode) bool {
+	return mode == VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY || mode == VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
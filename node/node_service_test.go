@@ -0,0 +1,351 @@
+package node_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/node"
+	"code.cloudfoundry.org/local-controller-plugin/node/nodefakes"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Node", func() {
+	var (
+		root    string
+		mounter *nodefakes.FakeMounter
+		volumes *nodefakes.FakeVolumePaths
+		ns      *node.Node
+		ctx     context.Context
+		mounted map[string]bool
+
+		mountCapability *VolumeCapability
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "node")
+		Expect(err).NotTo(HaveOccurred())
+
+		mounted = map[string]bool{}
+		mounter = &nodefakes.FakeMounter{}
+		mounter.IsMountedStub = func(target string) (bool, error) { return mounted[target], nil }
+		mounter.BindMountStub = func(source, target string, readOnly bool) error {
+			mounted[target] = true
+			return nil
+		}
+		mounter.UnmountStub = func(target string) error {
+			delete(mounted, target)
+			return nil
+		}
+
+		volumes = &nodefakes.FakeVolumePaths{}
+		volumes.VolumePathReturns("/pool/_volumes/vol", nil)
+
+		ns = node.NewNode(lagertest.NewTestLogger("node"), &osshim.OsShim{}, mounter, volumes, node.Config{
+			NodeId:   "node-1",
+			Segments: map[string]string{"zone": "z1"},
+		})
+		ctx = context.Background()
+
+		mountCapability = &VolumeCapability{
+			AccessType: &VolumeCapability_Mount{Mount: &VolumeCapability_MountVolume{}},
+			AccessMode: &VolumeCapability_AccessMode{Mode: VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	expectCode := func(err error, code codes.Code) {
+		ExpectWithOffset(1, err).To(HaveOccurred())
+		ExpectWithOffset(1, status.Code(err)).To(Equal(code))
+	}
+
+	Describe("NodeStageVolume", func() {
+		It("bind mounts the volume's directory on the staging path", func() {
+			staging := filepath.Join(root, "staging")
+			_, err := ns.NodeStageVolume(ctx, &NodeStageVolumeRequest{VolumeId: "default:vol", StagingTargetPath: staging, VolumeCapability: mountCapability})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, volId := volumes.VolumePathArgsForCall(0)
+			Expect(volId).To(Equal("default:vol"))
+			Expect(staging).To(BeADirectory())
+			Expect(mounter.BindMountCallCount()).To(Equal(1))
+			source, target, readOnly := mounter.BindMountArgsForCall(0)
+			Expect(source).To(Equal("/pool/_volumes/vol"))
+			Expect(target).To(Equal(staging))
+			Expect(readOnly).To(BeFalse())
+		})
+
+		It("succeeds without mounting again when already staged", func() {
+			staging := filepath.Join(root, "staging")
+			mounted[staging] = true
+			_, err := ns.NodeStageVolume(ctx, &NodeStageVolumeRequest{VolumeId: "default:vol", StagingTargetPath: staging, VolumeCapability: mountCapability})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mounter.BindMountCallCount()).To(Equal(0))
+		})
+
+		It("loop mounts an image volume's image named by the publish context", func() {
+			staging := filepath.Join(root, "staging")
+			mountCapability.AccessMode.Mode = VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
+			_, err := ns.NodeStageVolume(ctx, &NodeStageVolumeRequest{
+				VolumeId:          "images:vol",
+				StagingTargetPath: staging,
+				VolumeCapability:  mountCapability,
+				PublishContext: map[string]string{
+					controller.ImagePathKey: "/pool/_volumes/vol/disk.img",
+					controller.FsTypeKey:    "xfs",
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(volumes.VolumePathCallCount()).To(Equal(0))
+			Expect(mounter.BindMountCallCount()).To(Equal(0))
+			Expect(staging).To(BeADirectory())
+			Expect(mounter.LoopMountCallCount()).To(Equal(1))
+			image, target, fsType, readOnly := mounter.LoopMountArgsForCall(0)
+			Expect(image).To(Equal("/pool/_volumes/vol/disk.img"))
+			Expect(target).To(Equal(staging))
+			Expect(fsType).To(Equal("xfs"))
+			Expect(readOnly).To(BeTrue())
+		})
+
+		It("mounts an overlay volume with the options in the publish context", func() {
+			staging := filepath.Join(root, "staging")
+			options := "lowerdir=/pool/_snapshots/base,upperdir=/pool/_volumes/vol/upper,workdir=/pool/_volumes/vol/work"
+			_, err := ns.NodeStageVolume(ctx, &NodeStageVolumeRequest{
+				VolumeId:          "default:vol",
+				StagingTargetPath: staging,
+				VolumeCapability:  mountCapability,
+				PublishContext:    map[string]string{controller.OverlayOptionsKey: options},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(volumes.VolumePathCallCount()).To(Equal(0))
+			Expect(mounter.BindMountCallCount()).To(Equal(0))
+			Expect(staging).To(BeADirectory())
+			Expect(mounter.OverlayMountCallCount()).To(Equal(1))
+			mountOptions, target, readOnly := mounter.OverlayMountArgsForCall(0)
+			Expect(mountOptions).To(Equal(options))
+			Expect(target).To(Equal(staging))
+			Expect(readOnly).To(BeFalse())
+		})
+
+		It("passes on the controller's error for an unknown volume", func() {
+			volumes.VolumePathReturns("", status.Errorf(codes.NotFound, "Volume %q does not exist", "default:nope"))
+			_, err := ns.NodeStageVolume(ctx, &NodeStageVolumeRequest{VolumeId: "default:nope", StagingTargetPath: filepath.Join(root, "staging"), VolumeCapability: mountCapability})
+			expectCode(err, codes.NotFound)
+		})
+
+		It("rejects block volumes and missing arguments", func() {
+			block := &VolumeCapability{
+				AccessType: &VolumeCapability_Block{Block: &VolumeCapability_BlockVolume{}},
+				AccessMode: mountCapability.AccessMode,
+			}
+			_, err := ns.NodeStageVolume(ctx, &NodeStageVolumeRequest{VolumeId: "default:vol", StagingTargetPath: root, VolumeCapability: block})
+			expectCode(err, codes.InvalidArgument)
+			_, err = ns.NodeStageVolume(ctx, &NodeStageVolumeRequest{VolumeId: "default:vol", VolumeCapability: mountCapability})
+			expectCode(err, codes.InvalidArgument)
+			_, err = ns.NodeStageVolume(ctx, &NodeStageVolumeRequest{VolumeId: "default:vol", StagingTargetPath: root})
+			expectCode(err, codes.InvalidArgument)
+		})
+
+		It("reports a failed mount as internal", func() {
+			mounter.BindMountStub = nil
+			mounter.BindMountReturns(errors.New("permission denied"))
+			_, err := ns.NodeStageVolume(ctx, &NodeStageVolumeRequest{VolumeId: "default:vol", StagingTargetPath: filepath.Join(root, "staging"), VolumeCapability: mountCapability})
+			expectCode(err, codes.Internal)
+			Expect(err.Error()).To(ContainSubstring("permission denied"))
+		})
+	})
+
+	Describe("NodeUnstageVolume", func() {
+		It("unmounts the staging path and leaves it in place", func() {
+			staging := filepath.Join(root, "staging")
+			Expect(os.Mkdir(staging, 0750)).To(Succeed())
+			mounted[staging] = true
+
+			_, err := ns.NodeUnstageVolume(ctx, &NodeUnstageVolumeRequest{VolumeId: "default:vol", StagingTargetPath: staging})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mounter.UnmountCallCount()).To(Equal(1))
+			Expect(staging).To(BeADirectory())
+
+			_, err = ns.NodeUnstageVolume(ctx, &NodeUnstageVolumeRequest{VolumeId: "default:vol", StagingTargetPath: staging})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mounter.UnmountCallCount()).To(Equal(1))
+		})
+	})
+
+	Describe("NodePublishVolume", func() {
+		var staging, target string
+
+		BeforeEach(func() {
+			staging = filepath.Join(root, "staging")
+			target = filepath.Join(root, "target")
+			mounted[staging] = true
+		})
+
+		It("bind mounts the staging path on the target", func() {
+			_, err := ns.NodePublishVolume(ctx, &NodePublishVolumeRequest{VolumeId: "default:vol", StagingTargetPath: staging, TargetPath: target, VolumeCapability: mountCapability})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(target).To(BeADirectory())
+			source, mountTarget, readOnly := mounter.BindMountArgsForCall(0)
+			Expect(source).To(Equal(staging))
+			Expect(mountTarget).To(Equal(target))
+			Expect(readOnly).To(BeFalse())
+		})
+
+		It("mounts read-only when asked to or for a reader-only access mode", func() {
+			_, err := ns.NodePublishVolume(ctx, &NodePublishVolumeRequest{VolumeId: "default:vol", StagingTargetPath: staging, TargetPath: target, VolumeCapability: mountCapability, Readonly: true})
+			Expect(err).NotTo(HaveOccurred())
+			_, _, readOnly := mounter.BindMountArgsForCall(0)
+			Expect(readOnly).To(BeTrue())
+
+			mountCapability.AccessMode.Mode = VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+			_, err = ns.NodePublishVolume(ctx, &NodePublishVolumeRequest{VolumeId: "default:vol", StagingTargetPath: staging, TargetPath: filepath.Join(root, "other"), VolumeCapability: mountCapability})
+			Expect(err).NotTo(HaveOccurred())
+			_, _, readOnly = mounter.BindMountArgsForCall(1)
+			Expect(readOnly).To(BeTrue())
+		})
+
+		It("fails when the volume is not staged", func() {
+			delete(mounted, staging)
+			_, err := ns.NodePublishVolume(ctx, &NodePublishVolumeRequest{VolumeId: "default:vol", StagingTargetPath: staging, TargetPath: target, VolumeCapability: mountCapability})
+			expectCode(err, codes.FailedPrecondition)
+		})
+
+		It("requires a staging path", func() {
+			_, err := ns.NodePublishVolume(ctx, &NodePublishVolumeRequest{VolumeId: "default:vol", TargetPath: target, VolumeCapability: mountCapability})
+			expectCode(err, codes.InvalidArgument)
+		})
+	})
+
+	Describe("NodeUnpublishVolume", func() {
+		It("unmounts and removes the target, and succeeds when repeated", func() {
+			target := filepath.Join(root, "target")
+			Expect(os.Mkdir(target, 0750)).To(Succeed())
+			mounted[target] = true
+
+			_, err := ns.NodeUnpublishVolume(ctx, &NodeUnpublishVolumeRequest{VolumeId: "default:vol", TargetPath: target})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mounter.UnmountArgsForCall(0)).To(Equal(target))
+			Expect(target).NotTo(BeAnExistingFile())
+
+			_, err = ns.NodeUnpublishVolume(ctx, &NodeUnpublishVolumeRequest{VolumeId: "default:vol", TargetPath: target})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mounter.UnmountCallCount()).To(Equal(1))
+		})
+	})
+
+	Describe("NodeGetVolumeStats", func() {
+		It("reports the bytes and inodes of an image volume's filesystem", func() {
+			volumes.ImagePathReturns("/pool/_volumes/vol/disk.img", nil)
+			resp, err := ns.NodeGetVolumeStats(ctx, &NodeGetVolumeStatsRequest{VolumeId: "images:vol", VolumePath: root})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetUsage()).To(HaveLen(2))
+			Expect(resp.GetUsage()[0].GetUnit()).To(Equal(VolumeUsage_BYTES))
+			Expect(resp.GetUsage()[0].GetTotal()).To(BeNumerically(">", 0))
+			Expect(resp.GetUsage()[1].GetUnit()).To(Equal(VolumeUsage_INODES))
+		})
+
+		It("reports a directory volume's measured usage against its capacity", func() {
+			volumes.VolumeUsageReturns(&controller.LocalVolumeUsage{VolumeId: "default:vol", CapacityBytes: 1000, UsedBytes: 400, UsedInodes: 7}, nil)
+			resp, err := ns.NodeGetVolumeStats(ctx, &NodeGetVolumeStatsRequest{VolumeId: "default:vol", VolumePath: root})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetUsage()).To(Equal([]*VolumeUsage{
+				{Unit: VolumeUsage_BYTES, Total: 1000, Available: 600, Used: 400},
+				{Unit: VolumeUsage_INODES, Used: 7},
+			}))
+			_, volId := volumes.VolumeUsageArgsForCall(0)
+			Expect(volId).To(Equal("default:vol"))
+		})
+
+		It("reports only the used figures of a directory volume without a capacity", func() {
+			volumes.VolumeUsageReturns(&controller.LocalVolumeUsage{VolumeId: "default:vol", UsedBytes: 400, UsedInodes: 7}, nil)
+			resp, err := ns.NodeGetVolumeStats(ctx, &NodeGetVolumeStatsRequest{VolumeId: "default:vol", VolumePath: root})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetUsage()).To(Equal([]*VolumeUsage{
+				{Unit: VolumeUsage_BYTES, Used: 400},
+				{Unit: VolumeUsage_INODES, Used: 7},
+			}))
+		})
+
+		It("reports nothing for a directory volume that has not been measured, rather than the pool's filesystem", func() {
+			resp, err := ns.NodeGetVolumeStats(ctx, &NodeGetVolumeStatsRequest{VolumeId: "default:vol", VolumePath: root})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetUsage()).To(BeEmpty())
+		})
+
+		It("fails with not found for an unknown volume", func() {
+			volumes.ImagePathReturns("", status.Errorf(codes.NotFound, "Volume %q does not exist", "default:nope"))
+			_, err := ns.NodeGetVolumeStats(ctx, &NodeGetVolumeStatsRequest{VolumeId: "default:nope", VolumePath: root})
+			expectCode(err, codes.NotFound)
+		})
+
+		It("fails with not found for a missing path", func() {
+			_, err := ns.NodeGetVolumeStats(ctx, &NodeGetVolumeStatsRequest{VolumeId: "default:vol", VolumePath: filepath.Join(root, "nope")})
+			expectCode(err, codes.NotFound)
+		})
+	})
+
+	Describe("NodeExpandVolume", func() {
+		It("grows the filesystem on an image volume's loop device", func() {
+			volumes.ImagePathReturns("/pool/_volumes/vol/disk.img", nil)
+			resp, err := ns.NodeExpandVolume(ctx, &NodeExpandVolumeRequest{VolumeId: "images:vol", VolumePath: root, CapacityRange: &CapacityRange{RequiredBytes: 8192}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetCapacityBytes()).To(Equal(int64(8192)))
+
+			Expect(mounter.GrowLoopCallCount()).To(Equal(1))
+			image, target := mounter.GrowLoopArgsForCall(0)
+			Expect(image).To(Equal("/pool/_volumes/vol/disk.img"))
+			Expect(target).To(Equal(root))
+			_, volId := volumes.ImagePathArgsForCall(0)
+			Expect(volId).To(Equal("images:vol"))
+		})
+
+		It("leaves directory volumes alone", func() {
+			_, err := ns.NodeExpandVolume(ctx, &NodeExpandVolumeRequest{VolumeId: "default:vol", VolumePath: root})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mounter.GrowLoopCallCount()).To(Equal(0))
+		})
+
+		It("reports a missing path as not found and a failed resize as internal", func() {
+			_, err := ns.NodeExpandVolume(ctx, &NodeExpandVolumeRequest{VolumeId: "images:vol", VolumePath: filepath.Join(root, "nope")})
+			expectCode(err, codes.NotFound)
+
+			volumes.ImagePathReturns("/pool/_volumes/vol/disk.img", nil)
+			mounter.GrowLoopReturns(errors.New("resize2fs: exit status 1"))
+			_, err = ns.NodeExpandVolume(ctx, &NodeExpandVolumeRequest{VolumeId: "images:vol", VolumePath: root})
+			expectCode(err, codes.Internal)
+		})
+	})
+
+	It("advertises staging, volume stats and expansion", func() {
+		resp, err := ns.NodeGetCapabilities(ctx, &NodeGetCapabilitiesRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		types := []NodeServiceCapability_RPC_Type{}
+		for _, c := range resp.GetCapabilities() {
+			types = append(types, c.GetRpc().GetType())
+		}
+		Expect(types).To(ConsistOf(NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME, NodeServiceCapability_RPC_GET_VOLUME_STATS, NodeServiceCapability_RPC_EXPAND_VOLUME))
+	})
+
+	It("reports its id and topology, including the implicit node segment", func() {
+		resp, err := ns.NodeGetInfo(ctx, &NodeGetInfoRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetNodeId()).To(Equal("node-1"))
+		Expect(resp.GetAccessibleTopology().GetSegments()).To(Equal(map[string]string{"zone": "z1", controller.TopologyNodeKey: "node-1"}))
+	})
+})
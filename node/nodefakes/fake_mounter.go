@@ -0,0 +1,502 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package nodefakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/local-controller-plugin/node"
+)
+
+type FakeMounter struct {
+	BindMountStub        func(string, string, bool) error
+	bindMountMutex       sync.RWMutex
+	bindMountArgsForCall []struct {
+		arg1 string
+		arg2 string
+		arg3 bool
+	}
+	bindMountReturns struct {
+		result1 error
+	}
+	bindMountReturnsOnCall map[int]struct {
+		result1 error
+	}
+	GrowLoopStub        func(string, string) error
+	growLoopMutex       sync.RWMutex
+	growLoopArgsForCall []struct {
+		arg1 string
+		arg2 string
+	}
+	growLoopReturns struct {
+		result1 error
+	}
+	growLoopReturnsOnCall map[int]struct {
+		result1 error
+	}
+	IsMountedStub        func(string) (bool, error)
+	isMountedMutex       sync.RWMutex
+	isMountedArgsForCall []struct {
+		arg1 string
+	}
+	isMountedReturns struct {
+		result1 bool
+		result2 error
+	}
+	isMountedReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	LoopMountStub        func(string, string, string, bool) error
+	loopMountMutex       sync.RWMutex
+	loopMountArgsForCall []struct {
+		arg1 string
+		arg2 string
+		arg3 string
+		arg4 bool
+	}
+	loopMountReturns struct {
+		result1 error
+	}
+	loopMountReturnsOnCall map[int]struct {
+		result1 error
+	}
+	OverlayMountStub        func(string, string, bool) error
+	overlayMountMutex       sync.RWMutex
+	overlayMountArgsForCall []struct {
+		arg1 string
+		arg2 string
+		arg3 bool
+	}
+	overlayMountReturns struct {
+		result1 error
+	}
+	overlayMountReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UnmountStub        func(string) error
+	unmountMutex       sync.RWMutex
+	unmountArgsForCall []struct {
+		arg1 string
+	}
+	unmountReturns struct {
+		result1 error
+	}
+	unmountReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeMounter) BindMount(arg1 string, arg2 string, arg3 bool) error {
+	fake.bindMountMutex.Lock()
+	ret, specificReturn := fake.bindMountReturnsOnCall[len(fake.bindMountArgsForCall)]
+	fake.bindMountArgsForCall = append(fake.bindMountArgsForCall, struct {
+		arg1 string
+		arg2 string
+		arg3 bool
+	}{arg1, arg2, arg3})
+	stub := fake.BindMountStub
+	fakeReturns := fake.bindMountReturns
+	fake.recordInvocation("BindMount", []interface{}{arg1, arg2, arg3})
+	fake.bindMountMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeMounter) BindMountCallCount() int {
+	fake.bindMountMutex.RLock()
+	defer fake.bindMountMutex.RUnlock()
+	return len(fake.bindMountArgsForCall)
+}
+
+func (fake *FakeMounter) BindMountCalls(stub func(string, string, bool) error) {
+	fake.bindMountMutex.Lock()
+	defer fake.bindMountMutex.Unlock()
+	fake.BindMountStub = stub
+}
+
+func (fake *FakeMounter) BindMountArgsForCall(i int) (string, string, bool) {
+	fake.bindMountMutex.RLock()
+	defer fake.bindMountMutex.RUnlock()
+	argsForCall := fake.bindMountArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeMounter) BindMountReturns(result1 error) {
+	fake.bindMountMutex.Lock()
+	defer fake.bindMountMutex.Unlock()
+	fake.BindMountStub = nil
+	fake.bindMountReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) BindMountReturnsOnCall(i int, result1 error) {
+	fake.bindMountMutex.Lock()
+	defer fake.bindMountMutex.Unlock()
+	fake.BindMountStub = nil
+	if fake.bindMountReturnsOnCall == nil {
+		fake.bindMountReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.bindMountReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) GrowLoop(arg1 string, arg2 string) error {
+	fake.growLoopMutex.Lock()
+	ret, specificReturn := fake.growLoopReturnsOnCall[len(fake.growLoopArgsForCall)]
+	fake.growLoopArgsForCall = append(fake.growLoopArgsForCall, struct {
+		arg1 string
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.GrowLoopStub
+	fakeReturns := fake.growLoopReturns
+	fake.recordInvocation("GrowLoop", []interface{}{arg1, arg2})
+	fake.growLoopMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeMounter) GrowLoopCallCount() int {
+	fake.growLoopMutex.RLock()
+	defer fake.growLoopMutex.RUnlock()
+	return len(fake.growLoopArgsForCall)
+}
+
+func (fake *FakeMounter) GrowLoopCalls(stub func(string, string) error) {
+	fake.growLoopMutex.Lock()
+	defer fake.growLoopMutex.Unlock()
+	fake.GrowLoopStub = stub
+}
+
+func (fake *FakeMounter) GrowLoopArgsForCall(i int) (string, string) {
+	fake.growLoopMutex.RLock()
+	defer fake.growLoopMutex.RUnlock()
+	argsForCall := fake.growLoopArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeMounter) GrowLoopReturns(result1 error) {
+	fake.growLoopMutex.Lock()
+	defer fake.growLoopMutex.Unlock()
+	fake.GrowLoopStub = nil
+	fake.growLoopReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) GrowLoopReturnsOnCall(i int, result1 error) {
+	fake.growLoopMutex.Lock()
+	defer fake.growLoopMutex.Unlock()
+	fake.GrowLoopStub = nil
+	if fake.growLoopReturnsOnCall == nil {
+		fake.growLoopReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.growLoopReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) IsMounted(arg1 string) (bool, error) {
+	fake.isMountedMutex.Lock()
+	ret, specificReturn := fake.isMountedReturnsOnCall[len(fake.isMountedArgsForCall)]
+	fake.isMountedArgsForCall = append(fake.isMountedArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.IsMountedStub
+	fakeReturns := fake.isMountedReturns
+	fake.recordInvocation("IsMounted", []interface{}{arg1})
+	fake.isMountedMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeMounter) IsMountedCallCount() int {
+	fake.isMountedMutex.RLock()
+	defer fake.isMountedMutex.RUnlock()
+	return len(fake.isMountedArgsForCall)
+}
+
+func (fake *FakeMounter) IsMountedCalls(stub func(string) (bool, error)) {
+	fake.isMountedMutex.Lock()
+	defer fake.isMountedMutex.Unlock()
+	fake.IsMountedStub = stub
+}
+
+func (fake *FakeMounter) IsMountedArgsForCall(i int) string {
+	fake.isMountedMutex.RLock()
+	defer fake.isMountedMutex.RUnlock()
+	argsForCall := fake.isMountedArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeMounter) IsMountedReturns(result1 bool, result2 error) {
+	fake.isMountedMutex.Lock()
+	defer fake.isMountedMutex.Unlock()
+	fake.IsMountedStub = nil
+	fake.isMountedReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeMounter) IsMountedReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.isMountedMutex.Lock()
+	defer fake.isMountedMutex.Unlock()
+	fake.IsMountedStub = nil
+	if fake.isMountedReturnsOnCall == nil {
+		fake.isMountedReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.isMountedReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeMounter) LoopMount(arg1 string, arg2 string, arg3 string, arg4 bool) error {
+	fake.loopMountMutex.Lock()
+	ret, specificReturn := fake.loopMountReturnsOnCall[len(fake.loopMountArgsForCall)]
+	fake.loopMountArgsForCall = append(fake.loopMountArgsForCall, struct {
+		arg1 string
+		arg2 string
+		arg3 string
+		arg4 bool
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.LoopMountStub
+	fakeReturns := fake.loopMountReturns
+	fake.recordInvocation("LoopMount", []interface{}{arg1, arg2, arg3, arg4})
+	fake.loopMountMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeMounter) LoopMountCallCount() int {
+	fake.loopMountMutex.RLock()
+	defer fake.loopMountMutex.RUnlock()
+	return len(fake.loopMountArgsForCall)
+}
+
+func (fake *FakeMounter) LoopMountCalls(stub func(string, string, string, bool) error) {
+	fake.loopMountMutex.Lock()
+	defer fake.loopMountMutex.Unlock()
+	fake.LoopMountStub = stub
+}
+
+func (fake *FakeMounter) LoopMountArgsForCall(i int) (string, string, string, bool) {
+	fake.loopMountMutex.RLock()
+	defer fake.loopMountMutex.RUnlock()
+	argsForCall := fake.loopMountArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeMounter) LoopMountReturns(result1 error) {
+	fake.loopMountMutex.Lock()
+	defer fake.loopMountMutex.Unlock()
+	fake.LoopMountStub = nil
+	fake.loopMountReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) LoopMountReturnsOnCall(i int, result1 error) {
+	fake.loopMountMutex.Lock()
+	defer fake.loopMountMutex.Unlock()
+	fake.LoopMountStub = nil
+	if fake.loopMountReturnsOnCall == nil {
+		fake.loopMountReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.loopMountReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) OverlayMount(arg1 string, arg2 string, arg3 bool) error {
+	fake.overlayMountMutex.Lock()
+	ret, specificReturn := fake.overlayMountReturnsOnCall[len(fake.overlayMountArgsForCall)]
+	fake.overlayMountArgsForCall = append(fake.overlayMountArgsForCall, struct {
+		arg1 string
+		arg2 string
+		arg3 bool
+	}{arg1, arg2, arg3})
+	stub := fake.OverlayMountStub
+	fakeReturns := fake.overlayMountReturns
+	fake.recordInvocation("OverlayMount", []interface{}{arg1, arg2, arg3})
+	fake.overlayMountMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeMounter) OverlayMountCallCount() int {
+	fake.overlayMountMutex.RLock()
+	defer fake.overlayMountMutex.RUnlock()
+	return len(fake.overlayMountArgsForCall)
+}
+
+func (fake *FakeMounter) OverlayMountCalls(stub func(string, string, bool) error) {
+	fake.overlayMountMutex.Lock()
+	defer fake.overlayMountMutex.Unlock()
+	fake.OverlayMountStub = stub
+}
+
+func (fake *FakeMounter) OverlayMountArgsForCall(i int) (string, string, bool) {
+	fake.overlayMountMutex.RLock()
+	defer fake.overlayMountMutex.RUnlock()
+	argsForCall := fake.overlayMountArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeMounter) OverlayMountReturns(result1 error) {
+	fake.overlayMountMutex.Lock()
+	defer fake.overlayMountMutex.Unlock()
+	fake.OverlayMountStub = nil
+	fake.overlayMountReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) OverlayMountReturnsOnCall(i int, result1 error) {
+	fake.overlayMountMutex.Lock()
+	defer fake.overlayMountMutex.Unlock()
+	fake.OverlayMountStub = nil
+	if fake.overlayMountReturnsOnCall == nil {
+		fake.overlayMountReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.overlayMountReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) Unmount(arg1 string) error {
+	fake.unmountMutex.Lock()
+	ret, specificReturn := fake.unmountReturnsOnCall[len(fake.unmountArgsForCall)]
+	fake.unmountArgsForCall = append(fake.unmountArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.UnmountStub
+	fakeReturns := fake.unmountReturns
+	fake.recordInvocation("Unmount", []interface{}{arg1})
+	fake.unmountMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeMounter) UnmountCallCount() int {
+	fake.unmountMutex.RLock()
+	defer fake.unmountMutex.RUnlock()
+	return len(fake.unmountArgsForCall)
+}
+
+func (fake *FakeMounter) UnmountCalls(stub func(string) error) {
+	fake.unmountMutex.Lock()
+	defer fake.unmountMutex.Unlock()
+	fake.UnmountStub = stub
+}
+
+func (fake *FakeMounter) UnmountArgsForCall(i int) string {
+	fake.unmountMutex.RLock()
+	defer fake.unmountMutex.RUnlock()
+	argsForCall := fake.unmountArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeMounter) UnmountReturns(result1 error) {
+	fake.unmountMutex.Lock()
+	defer fake.unmountMutex.Unlock()
+	fake.UnmountStub = nil
+	fake.unmountReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) UnmountReturnsOnCall(i int, result1 error) {
+	fake.unmountMutex.Lock()
+	defer fake.unmountMutex.Unlock()
+	fake.UnmountStub = nil
+	if fake.unmountReturnsOnCall == nil {
+		fake.unmountReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.unmountReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeMounter) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.bindMountMutex.RLock()
+	defer fake.bindMountMutex.RUnlock()
+	fake.growLoopMutex.RLock()
+	defer fake.growLoopMutex.RUnlock()
+	fake.isMountedMutex.RLock()
+	defer fake.isMountedMutex.RUnlock()
+	fake.loopMountMutex.RLock()
+	defer fake.loopMountMutex.RUnlock()
+	fake.overlayMountMutex.RLock()
+	defer fake.overlayMountMutex.RUnlock()
+	fake.unmountMutex.RLock()
+	defer fake.unmountMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeMounter) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ node.Mounter = new(FakeMounter)
@@ -0,0 +1,282 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package nodefakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/local-controller-plugin/controller"
+	"code.cloudfoundry.org/local-controller-plugin/node"
+	"golang.org/x/net/context"
+)
+
+type FakeVolumePaths struct {
+	ImagePathStub        func(context.Context, string) (string, error)
+	imagePathMutex       sync.RWMutex
+	imagePathArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	imagePathReturns struct {
+		result1 string
+		result2 error
+	}
+	imagePathReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	VolumePathStub        func(context.Context, string) (string, error)
+	volumePathMutex       sync.RWMutex
+	volumePathArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	volumePathReturns struct {
+		result1 string
+		result2 error
+	}
+	volumePathReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	VolumeUsageStub        func(context.Context, string) (*controller.LocalVolumeUsage, error)
+	volumeUsageMutex       sync.RWMutex
+	volumeUsageArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	volumeUsageReturns struct {
+		result1 *controller.LocalVolumeUsage
+		result2 error
+	}
+	volumeUsageReturnsOnCall map[int]struct {
+		result1 *controller.LocalVolumeUsage
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeVolumePaths) ImagePath(arg1 context.Context, arg2 string) (string, error) {
+	fake.imagePathMutex.Lock()
+	ret, specificReturn := fake.imagePathReturnsOnCall[len(fake.imagePathArgsForCall)]
+	fake.imagePathArgsForCall = append(fake.imagePathArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.ImagePathStub
+	fakeReturns := fake.imagePathReturns
+	fake.recordInvocation("ImagePath", []interface{}{arg1, arg2})
+	fake.imagePathMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeVolumePaths) ImagePathCallCount() int {
+	fake.imagePathMutex.RLock()
+	defer fake.imagePathMutex.RUnlock()
+	return len(fake.imagePathArgsForCall)
+}
+
+func (fake *FakeVolumePaths) ImagePathCalls(stub func(context.Context, string) (string, error)) {
+	fake.imagePathMutex.Lock()
+	defer fake.imagePathMutex.Unlock()
+	fake.ImagePathStub = stub
+}
+
+func (fake *FakeVolumePaths) ImagePathArgsForCall(i int) (context.Context, string) {
+	fake.imagePathMutex.RLock()
+	defer fake.imagePathMutex.RUnlock()
+	argsForCall := fake.imagePathArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeVolumePaths) ImagePathReturns(result1 string, result2 error) {
+	fake.imagePathMutex.Lock()
+	defer fake.imagePathMutex.Unlock()
+	fake.ImagePathStub = nil
+	fake.imagePathReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeVolumePaths) ImagePathReturnsOnCall(i int, result1 string, result2 error) {
+	fake.imagePathMutex.Lock()
+	defer fake.imagePathMutex.Unlock()
+	fake.ImagePathStub = nil
+	if fake.imagePathReturnsOnCall == nil {
+		fake.imagePathReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.imagePathReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeVolumePaths) VolumePath(arg1 context.Context, arg2 string) (string, error) {
+	fake.volumePathMutex.Lock()
+	ret, specificReturn := fake.volumePathReturnsOnCall[len(fake.volumePathArgsForCall)]
+	fake.volumePathArgsForCall = append(fake.volumePathArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.VolumePathStub
+	fakeReturns := fake.volumePathReturns
+	fake.recordInvocation("VolumePath", []interface{}{arg1, arg2})
+	fake.volumePathMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeVolumePaths) VolumePathCallCount() int {
+	fake.volumePathMutex.RLock()
+	defer fake.volumePathMutex.RUnlock()
+	return len(fake.volumePathArgsForCall)
+}
+
+func (fake *FakeVolumePaths) VolumePathCalls(stub func(context.Context, string) (string, error)) {
+	fake.volumePathMutex.Lock()
+	defer fake.volumePathMutex.Unlock()
+	fake.VolumePathStub = stub
+}
+
+func (fake *FakeVolumePaths) VolumePathArgsForCall(i int) (context.Context, string) {
+	fake.volumePathMutex.RLock()
+	defer fake.volumePathMutex.RUnlock()
+	argsForCall := fake.volumePathArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeVolumePaths) VolumePathReturns(result1 string, result2 error) {
+	fake.volumePathMutex.Lock()
+	defer fake.volumePathMutex.Unlock()
+	fake.VolumePathStub = nil
+	fake.volumePathReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeVolumePaths) VolumePathReturnsOnCall(i int, result1 string, result2 error) {
+	fake.volumePathMutex.Lock()
+	defer fake.volumePathMutex.Unlock()
+	fake.VolumePathStub = nil
+	if fake.volumePathReturnsOnCall == nil {
+		fake.volumePathReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.volumePathReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeVolumePaths) VolumeUsage(arg1 context.Context, arg2 string) (*controller.LocalVolumeUsage, error) {
+	fake.volumeUsageMutex.Lock()
+	ret, specificReturn := fake.volumeUsageReturnsOnCall[len(fake.volumeUsageArgsForCall)]
+	fake.volumeUsageArgsForCall = append(fake.volumeUsageArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.VolumeUsageStub
+	fakeReturns := fake.volumeUsageReturns
+	fake.recordInvocation("VolumeUsage", []interface{}{arg1, arg2})
+	fake.volumeUsageMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeVolumePaths) VolumeUsageCallCount() int {
+	fake.volumeUsageMutex.RLock()
+	defer fake.volumeUsageMutex.RUnlock()
+	return len(fake.volumeUsageArgsForCall)
+}
+
+func (fake *FakeVolumePaths) VolumeUsageCalls(stub func(context.Context, string) (*controller.LocalVolumeUsage, error)) {
+	fake.volumeUsageMutex.Lock()
+	defer fake.volumeUsageMutex.Unlock()
+	fake.VolumeUsageStub = stub
+}
+
+func (fake *FakeVolumePaths) VolumeUsageArgsForCall(i int) (context.Context, string) {
+	fake.volumeUsageMutex.RLock()
+	defer fake.volumeUsageMutex.RUnlock()
+	argsForCall := fake.volumeUsageArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeVolumePaths) VolumeUsageReturns(result1 *controller.LocalVolumeUsage, result2 error) {
+	fake.volumeUsageMutex.Lock()
+	defer fake.volumeUsageMutex.Unlock()
+	fake.VolumeUsageStub = nil
+	fake.volumeUsageReturns = struct {
+		result1 *controller.LocalVolumeUsage
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeVolumePaths) VolumeUsageReturnsOnCall(i int, result1 *controller.LocalVolumeUsage, result2 error) {
+	fake.volumeUsageMutex.Lock()
+	defer fake.volumeUsageMutex.Unlock()
+	fake.VolumeUsageStub = nil
+	if fake.volumeUsageReturnsOnCall == nil {
+		fake.volumeUsageReturnsOnCall = make(map[int]struct {
+			result1 *controller.LocalVolumeUsage
+			result2 error
+		})
+	}
+	fake.volumeUsageReturnsOnCall[i] = struct {
+		result1 *controller.LocalVolumeUsage
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeVolumePaths) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.imagePathMutex.RLock()
+	defer fake.imagePathMutex.RUnlock()
+	fake.volumePathMutex.RLock()
+	defer fake.volumePathMutex.RUnlock()
+	fake.volumeUsageMutex.RLock()
+	defer fake.volumeUsageMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeVolumePaths) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ node.VolumePaths = new(FakeVolumePaths)
@@ -14,6 +14,14 @@ go get -u "github.com/onsi/gomega"
 go get -u "github.com/onsi/gomega/types"
 echo "installing grpc..."
 go get -u "google.golang.org/grpc"
+echo "installing protobuf..."
+go get -u "github.com/golang/protobuf/ptypes/wrappers"
+echo "installing prometheus client..."
+go get -u "github.com/prometheus/client_golang/prometheus"
+echo "installing opentelemetry..."
+go get -u "go.opentelemetry.io/otel"
+go get -u "go.opentelemetry.io/otel/sdk"
+go get -u "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 echo "installing csi spec..."
 go get -u "github.com/paulcwarren/spec"
 
@@ -0,0 +1,107 @@
+// Package tracing sets up OpenTelemetry tracing for the controller: an OTLP
+// exporter and a gRPC interceptor that continues traces from the caller.
+package tracing
+
+import (
+	"path"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const ServiceName = "local-controller-plugin"
+
+const instrumentationName = "code.cloudfoundry.org/local-controller-plugin/tracing"
+
+// Setup installs a global tracer provider that exports spans over OTLP/gRPC
+// to endpoint. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, endpoint string, insecure bool) (func(context.Context) error, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := NewProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator())
+	return provider.Shutdown, nil
+}
+
+// NewProvider returns a tracer provider that identifies spans as coming from
+// this plugin. Tests pass an in-memory exporter with sdktrace.WithSyncer.
+func NewProvider(opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	res := resource.NewSchemaless(semconv.ServiceName(ServiceName))
+	return sdktrace.NewTracerProvider(append([]sdktrace.TracerProviderOption{sdktrace.WithResource(res)}, opts...)...)
+}
+
+func propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// UnaryServerInterceptor starts a server span for each request, continuing
+// the trace named in the incoming gRPC metadata if there is one.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			ctx = propagator().Extract(ctx, metadataCarrier(md))
+		}
+
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, info.FullMethod,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.RPCSystemGRPC,
+				semconv.RPCMethod(path.Base(info.FullMethod)),
+				semconv.RPCService(path.Dir(info.FullMethod)[1:]),
+			),
+		)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(code)))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, code.String())
+		}
+		return resp, err
+	}
+}
+
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
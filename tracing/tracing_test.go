@@ -0,0 +1,84 @@
+package tracing_test
+
+import (
+	"code.cloudfoundry.org/local-controller-plugin/tracing"
+	. "github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		exporter *tracetest.InMemoryExporter
+		ctx      context.Context
+		info     *grpc.UnaryServerInfo
+		handler  grpc.UnaryHandler
+		handled  trace.SpanContext
+	)
+
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(tracing.NewProvider(sdktrace.WithSyncer(exporter)))
+
+		ctx = context.Background()
+		info = &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			handled = trace.SpanContextFromContext(ctx)
+			return &CreateVolumeResponse{}, nil
+		}
+	})
+
+	invoke := func() error {
+		_, err := tracing.UnaryServerInterceptor()(ctx, &CreateVolumeRequest{Name: "vol"}, info, handler)
+		return err
+	}
+
+	It("records a server span for the request and passes it to the handler", func() {
+		Expect(invoke()).To(Succeed())
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name).To(Equal("/csi.v1.Controller/CreateVolume"))
+		Expect(spans[0].SpanKind).To(Equal(trace.SpanKindServer))
+		Expect(spans[0].Attributes).To(ContainElement(attribute.String("rpc.method", "CreateVolume")))
+		Expect(spans[0].Attributes).To(ContainElement(attribute.String("rpc.service", "csi.v1.Controller")))
+		Expect(spans[0].Parent.IsValid()).To(BeFalse())
+		Expect(handled.SpanID()).To(Equal(spans[0].SpanContext.SpanID()))
+	})
+
+	It("continues the trace named in the incoming metadata", func() {
+		traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("traceparent", traceparent))
+		Expect(invoke()).To(Succeed())
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].SpanContext.TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(spans[0].Parent.SpanID().String()).To(Equal("00f067aa0ba902b7"))
+		Expect(spans[0].Parent.IsRemote()).To(BeTrue())
+	})
+
+	It("marks the span as failed with the status code", func() {
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Errorf(codes.NotFound, "missing")
+		}
+		Expect(status.Code(invoke())).To(Equal(codes.NotFound))
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Status.Code).To(Equal(otelcodes.Error))
+		Expect(spans[0].Status.Description).To(Equal("NotFound"))
+		Expect(spans[0].Attributes).To(ContainElement(attribute.Int("rpc.grpc.status_code", int(codes.NotFound))))
+	})
+})